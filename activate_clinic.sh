@@ -11,6 +11,6 @@ fi
 echo "Activating clinic: $CLINIC_ID"
 
 docker exec dental_postgres psql -U postgres -d dental_marketplace -c \
-  "UPDATE clinics SET is_active = true WHERE id = '$CLINIC_ID';"
+  "UPDATE clinics SET is_active = true, status = 'approved' WHERE id = '$CLINIC_ID';"
 
 echo "Clinic activated!"
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/igorfazlyev/dm/internal/auth"
+	"github.com/igorfazlyev/dm/internal/database"
+	"github.com/igorfazlyev/dm/internal/rbac"
+	"github.com/igorfazlyev/dm/pkg/securetoken"
+	"gorm.io/gorm"
+)
+
+// bootstrapAdmin creates the first admin account, or promotes an existing
+// account, from the command line:
+//
+//	api bootstrap-admin -email admin@example.com
+//
+// It refuses to run once an active admin exists; from then on admins are
+// promoted through the admin API. The password is read from
+// BOOTSTRAP_ADMIN_PASSWORD, or generated and printed once.
+func bootstrapAdmin(args []string) error {
+	flags := flag.NewFlagSet("bootstrap-admin", flag.ContinueOnError)
+	email := flags.String("email", "", "email address of the admin")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	*email = strings.ToLower(strings.TrimSpace(*email))
+	if *email == "" {
+		return errors.New("-email is required")
+	}
+
+	var admins int64
+	if err := database.DB.Model(&database.User{}).
+		Where("role = ? AND is_active = ?", rbac.RoleAdmin, true).
+		Count(&admins).Error; err != nil {
+		return err
+	}
+	if admins > 0 {
+		return errors.New("an admin already exists; promote users through the admin API instead")
+	}
+
+	password := os.Getenv("BOOTSTRAP_ADMIN_PASSWORD")
+	generated := password == ""
+	if generated {
+		token, _, err := securetoken.Generate()
+		if err != nil {
+			return err
+		}
+		password = token
+	}
+
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		var user database.User
+		err := tx.Where("email = ?", *email).First(&user).Error
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			user = database.User{
+				Email:        *email,
+				PasswordHash: hash,
+				Role:         rbac.RoleAdmin,
+				IsActive:     true,
+				VerifiedAt:   &now,
+			}
+			if err := tx.Create(&user).Error; err != nil {
+				return err
+			}
+		case err != nil:
+			return err
+		default:
+			var members int64
+			if err := tx.Model(&database.ClinicMember{}).Where("user_id = ?", user.ID).Count(&members).Error; err != nil {
+				return err
+			}
+			if members > 0 {
+				return errors.New("the account is a member of a clinic")
+			}
+			if err := tx.Model(&user).Updates(map[string]interface{}{
+				"password_hash": hash,
+				"role":          rbac.RoleAdmin,
+				"is_active":     true,
+				"verified_at":   now,
+			}).Error; err != nil {
+				return err
+			}
+		}
+
+		return tx.Create(&database.AuditLog{
+			UserID:     &user.ID,
+			Action:     "bootstrap_admin",
+			EntityType: "user",
+			EntityID:   &user.ID,
+			Details:    map[string]any{"source": "cli"},
+		}).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Admin account ready: %s\n", *email)
+	if generated {
+		fmt.Printf("Generated password (shown once): %s\n", password)
+	}
+	return nil
+}
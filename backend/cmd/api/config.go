@@ -0,0 +1,43 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/igorfazlyev/dm/internal/config"
+)
+
+// configCommand inspects the effective configuration without connecting to
+// the database:
+//
+//	api config print [--redacted | --show-secrets]
+//	api config validate
+func configCommand(cfg *config.Config, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: config print [--redacted | --show-secrets] | config validate")
+	}
+
+	switch args[0] {
+	case "print":
+		flags := flag.NewFlagSet("config print", flag.ContinueOnError)
+		showSecrets := flags.Bool("show-secrets", false, "print secrets instead of masking them")
+		redacted := flags.Bool("redacted", false, "mask secrets, which is the default")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		if *redacted && *showSecrets {
+			return errors.New("--redacted and --show-secrets contradict each other")
+		}
+		return cfg.Print(os.Stdout, *showSecrets)
+	case "validate":
+		if err := cfg.Validate(); err != nil {
+			return err
+		}
+		fmt.Println("configuration is valid")
+		return nil
+	default:
+		return fmt.Errorf("unknown subcommand %q", args[0])
+	}
+}
@@ -1,37 +1,90 @@
 package main
 
 import (
+	"context"
 	"log"
+	"os"
+	"slices"
+	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
+	"github.com/igorfazlyev/dm/internal/admin"
+	"github.com/igorfazlyev/dm/internal/audit"
 	"github.com/igorfazlyev/dm/internal/auth"
 	"github.com/igorfazlyev/dm/internal/clinics"
 	"github.com/igorfazlyev/dm/internal/config"
 	"github.com/igorfazlyev/dm/internal/database"
+	"github.com/igorfazlyev/dm/internal/events"
+	"github.com/igorfazlyev/dm/internal/mailer"
 	"github.com/igorfazlyev/dm/internal/offers"
 	"github.com/igorfazlyev/dm/internal/orders"
 	"github.com/igorfazlyev/dm/internal/patients"
 	"github.com/igorfazlyev/dm/internal/plans"
+	"github.com/igorfazlyev/dm/internal/policy"
+	"github.com/igorfazlyev/dm/internal/ratelimit"
 	"github.com/igorfazlyev/dm/internal/rbac"
+	"github.com/igorfazlyev/dm/internal/repository"
+	"github.com/igorfazlyev/dm/internal/sessions"
+	"github.com/igorfazlyev/dm/internal/signingkeys"
+	"github.com/igorfazlyev/dm/internal/sms"
 	"github.com/igorfazlyev/dm/internal/studies"
 )
 
 func main() {
 	// Load configuration
-	cfg := config.Load()
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	// Printing the configuration works even when it would not validate, to
+	// help find the problem
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		if err := configCommand(cfg, os.Args[2:]); err != nil {
+			log.Fatalf("config: %v", err)
+		}
+		return
+	}
+
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("Invalid configuration:\n%v", err)
+	}
+
+	// Schema changes are only made by the migrate command
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := migrateCommand(cfg, os.Args[2:]); err != nil {
+			log.Fatalf("migrate: %v", err)
+		}
+		return
+	}
 
 	// Connect to database
 	if err := database.Connect(cfg); err != nil {
 		log.Fatalf("Failed to connect to database: %v", err)
 	}
 
-	// Run migrations
-	if err := database.AutoMigrate(); err != nil {
-		log.Fatalf("Failed to run migrations: %v", err)
+	// Serving against an older schema would fail in unpredictable places
+	if err := database.CheckSchema(); err != nil {
+		log.Fatalf("Refusing to start: %v", err)
 	}
 
-	log.Println("Database migrations completed successfully")
+	// Maintenance commands run against the database and exit
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "bootstrap-admin":
+			if err := bootstrapAdmin(os.Args[2:]); err != nil {
+				log.Fatalf("Failed to bootstrap admin: %v", err)
+			}
+		case "seed":
+			if err := seedCommand(cfg, os.Args[2:]); err != nil {
+				log.Fatalf("Failed to seed: %v", err)
+			}
+		default:
+			log.Fatalf("Unknown command %q", os.Args[1])
+		}
+		return
+	}
 
 	// Initialize Gin
 	if cfg.Server.Environment == "production" {
@@ -40,42 +93,165 @@ func main() {
 
 	router := gin.Default()
 
-	// CORS middleware
-	router.Use(cors.New(cors.Config{
-		AllowAllOrigins:  true,
-		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
-		ExposeHeaders:    []string{"Content-Length"},
+	// Client IPs feed rate limits, lockouts and audit records, so
+	// X-Forwarded-For is only believed from known proxies
+	if err := router.SetTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+		log.Fatalf("Invalid trusted proxies: %v", err)
+	}
+
+	// CORS middleware. Browsers refuse credentials with a wildcard origin, so
+	// "*" drops them.
+	corsConfig := cors.Config{
+		AllowOrigins: cfg.Server.AllowedOrigins,
+		AllowMethods: []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowHeaders: []string{
+			"Origin", "Content-Type", "Authorization", "If-Match",
+			"Tus-Resumable", "Upload-Length", "Upload-Offset", "Upload-Metadata", "Upload-Checksum",
+		},
+		ExposeHeaders: []string{
+			"Content-Length", "ETag", "Location",
+			"Tus-Resumable", "Tus-Version", "Tus-Extension", "Tus-Max-Size", "Tus-Checksum-Algorithm",
+			"Upload-Offset", "Upload-Length", "Upload-Metadata", "Upload-Expires",
+		},
 		AllowCredentials: true,
-	}))
+	}
+	if slices.Contains(cfg.Server.AllowedOrigins, "*") {
+		corsConfig.AllowOrigins = nil
+		corsConfig.AllowAllOrigins = true
+		corsConfig.AllowCredentials = false
+	}
+	router.Use(cors.New(corsConfig))
+
+	// JWT signing keys are loaded before serving and rotated in the background
+	signingKeys, err := signingkeys.NewManager(cfg.JWT)
+	if err != nil {
+		log.Fatalf("Failed to initialize JWT keys: %v", err)
+	}
+	if err := signingKeys.Sync(); err != nil {
+		log.Fatalf("Failed to load JWT signing keys: %v", err)
+	}
+	signingKeys.Start(time.Minute)
+	keyring := signingKeys.Keyring()
+
+	// Session revocation checks are cached briefly to keep them off the hot path
+	sessionStore := sessions.NewStore(30 * time.Second)
+
+	mailSender, err := mailer.New(cfg.Mail)
+	if err != nil {
+		log.Fatalf("Failed to initialize mailer: %v", err)
+	}
+
+	passkeys, err := auth.NewPasskeys(cfg.WebAuthn)
+	if err != nil {
+		log.Fatalf("Failed to initialize WebAuthn: %v", err)
+	}
+
+	smsSender, err := sms.New(cfg.SMS)
+	if err != nil {
+		log.Fatalf("Failed to initialize SMS sender: %v", err)
+	}
+
+	limiter, err := ratelimit.New(cfg.RateLimit)
+	if err != nil {
+		log.Fatalf("Failed to initialize rate limiter: %v", err)
+	}
+
+	// Handlers and the policy reach the database through the repositories
+	store := repository.NewGormStore(database.DB)
+	accessPolicy := policy.New(store)
+
+	// Domain events recorded with the changes they describe are delivered
+	// from the outbox in the background; notifications, webhooks and
+	// analytics subscribe here
+	dispatcher := events.NewDispatcher(database.DB)
+	for _, eventType := range events.Types() {
+		dispatcher.Subscribe("log", eventType, events.Log)
+	}
+	dispatcher.Start(context.Background(), 5*time.Second)
 
 	// Initialize handlers
-	authHandler := auth.NewHandler(cfg)
-	patientsHandler := patients.NewHandler()
-	studiesHandler := studies.NewHandler(cfg)
-	plansHandler := plans.NewHandler()
-	clinicsHandler := clinics.NewHandler()
-	offersHandler := offers.NewHandler()
-	ordersHandler := orders.NewHandler()
+	authHandler := auth.NewHandler(cfg, keyring, sessionStore, mailSender, passkeys, smsSender, store.Users, store.Logins)
+	patientsHandler := patients.NewHandler(store.Patients, store.Studies)
+	studiesHandler := studies.NewHandler(cfg, store.Studies, store.Patients, store.Clinics, accessPolicy)
+	plansHandler := plans.NewHandler(store.Plans, store.Studies, store.Clinics, accessPolicy)
+	clinicsHandler := clinics.NewHandler(cfg, mailSender, store.Users, store.Clinics, accessPolicy)
+	offersHandler := offers.NewHandler(store.Offers, store.Plans, store.Patients, store.Clinics, accessPolicy)
+	ordersHandler := orders.NewHandler(store.Orders, accessPolicy)
+	adminHandler := admin.NewHandler(sessionStore, authHandler, store.Users, store.Patients, store.Clinics, store.Audit, store.Logins)
+
+	// Resumable uploads left idle past their expiry are removed
+	studiesHandler.StartUploadExpiry(time.Hour)
 
 	// Public routes
 	public := router.Group("/api/v1")
 	{
-		public.POST("/auth/register", authHandler.Register)
-		public.POST("/auth/login", authHandler.Login)
+		// Per-IP limits on endpoints that check credentials or send messages
+		loginLimit := ratelimit.PerIP(limiter, "login", ratelimit.Limit{Burst: 10, Every: 6 * time.Second})
+		registerLimit := ratelimit.PerIP(limiter, "register", ratelimit.Limit{Burst: 5, Every: time.Minute})
+		otpLimit := ratelimit.PerIP(limiter, "otp", ratelimit.Limit{Burst: 5, Every: 30 * time.Second})
+		passwordLimit := ratelimit.PerIP(limiter, "password", ratelimit.Limit{Burst: 5, Every: time.Minute})
+
+		public.POST("/auth/register", registerLimit, authHandler.Register)
+		public.POST("/auth/login", loginLimit, authHandler.Login)
+		public.POST("/auth/login/mfa", loginLimit, authHandler.LoginMFA)
+		public.POST("/auth/otp/request", otpLimit, authHandler.RequestOTP)
+		public.POST("/auth/otp/verify", otpLimit, authHandler.VerifyOTP)
+		public.POST("/auth/webauthn/login/begin", authHandler.BeginPasskeyLogin)
+		public.POST("/auth/webauthn/login/finish", authHandler.FinishPasskeyLogin)
+		public.POST("/auth/refresh", authHandler.Refresh)
+		public.POST("/auth/password/forgot", passwordLimit, authHandler.ForgotPassword)
+		public.POST("/auth/password/reset", passwordLimit, authHandler.ResetPassword)
+		public.POST("/auth/verify-email", authHandler.VerifyEmail)
 		public.GET("/clinics", clinicsHandler.ListClinics)
 		public.GET("/clinics/:id", clinicsHandler.GetClinic)
 	}
 
 	// Protected routes
 	protected := router.Group("/api/v1")
-	protected.Use(rbac.AuthMiddleware(cfg))
+	protected.Use(rbac.AuthMiddleware(keyring, sessionStore), audit.ImpersonationTrail())
 	{
+		// Routes for people; clinic API keys only reach the clinic routes below
+		users := protected.Group("")
+		users.Use(rbac.RequireUser())
+
+		users.GET("/auth/me", authHandler.Me)
+
+		// Account security is only for the real user, never an impersonating admin
+		account := users.Group("")
+		account.Use(rbac.DenyImpersonation())
+
 		// Auth
-		protected.GET("/auth/me", authHandler.Me)
+		account.POST("/auth/logout", authHandler.Logout)
+		account.POST("/auth/logout-all", authHandler.LogoutAll)
+		account.GET("/auth/sessions", authHandler.ListSessions)
+		account.DELETE("/auth/sessions/:id", authHandler.RevokeSession)
+		account.POST("/auth/password/change", authHandler.ChangePassword)
+		account.POST("/auth/verify-email/resend", authHandler.ResendVerification)
+
+		// MFA
+		account.POST("/auth/mfa/setup", authHandler.SetupMFA)
+		account.POST("/auth/mfa/enable", authHandler.EnableMFA)
+		account.POST("/auth/mfa/disable", authHandler.DisableMFA)
+		account.POST("/auth/mfa/recovery-codes", authHandler.RegenerateRecoveryCodes)
+
+		// Passkeys
+		account.POST("/auth/webauthn/register/begin", authHandler.BeginPasskeyRegistration)
+		account.POST("/auth/webauthn/register/finish", authHandler.FinishPasskeyRegistration)
+		account.GET("/auth/webauthn/credentials", authHandler.ListPasskeys)
+		account.DELETE("/auth/webauthn/credentials/:id", authHandler.DeletePasskey)
+
+		// Everything below requires MFA when the user's role mandates it
+		app := users.Group("")
+		app.Use(rbac.RequireMFA())
+
+		// Actions that require a confirmed email address or phone number
+		verified := rbac.RequireVerifiedAccount()
+
+		// Actions only the real user may take, not an impersonating admin
+		noImpersonation := rbac.DenyImpersonation()
 
 		// Patient routes
-		patientRoutes := protected.Group("/patient")
+		patientRoutes := app.Group("/patient")
 		patientRoutes.Use(rbac.RequireRole(rbac.RolePatient))
 		{
 			patientRoutes.GET("/profile", patientsHandler.GetMyProfile)
@@ -83,8 +259,8 @@ func main() {
 			patientRoutes.PUT("/profile", patientsHandler.UpdateMyProfile)
 			patientRoutes.GET("/studies", patientsHandler.GetMyStudies)
 			patientRoutes.GET("/offer-requests", offersHandler.GetMyOfferRequests)
-			patientRoutes.POST("/offer-requests", offersHandler.CreateOfferRequest)
-			patientRoutes.POST("/offers/:id/accept", offersHandler.AcceptOffer)
+			patientRoutes.POST("/offer-requests", verified, offersHandler.CreateOfferRequest)
+			patientRoutes.POST("/offers/:id/accept", verified, noImpersonation, offersHandler.AcceptOffer)
 			patientRoutes.GET("/orders", ordersHandler.GetMyOrders)
 		}
 
@@ -98,56 +274,126 @@ func main() {
 		// }
 
 		// Study routes (patients and clinics)
-		studyRoutes := protected.Group("/studies")
+		studyRoutes := app.Group("/studies")
 		{
-			studyRoutes.POST("", rbac.RequireRole(rbac.RolePatient), studiesHandler.CreateStudy)
+			studyRoutes.POST("", rbac.RequireRole(rbac.RolePatient), verified, studiesHandler.CreateStudy)
 			studyRoutes.GET("/:id", studiesHandler.GetStudy)
 			studyRoutes.GET("/:id/status", rbac.RequireRole(rbac.RolePatient), studiesHandler.CheckStudyStatus)
-			studyRoutes.GET("/:id/pdf", rbac.RequireRole(rbac.RolePatient), studiesHandler.GetStudyPDF)
+			studyRoutes.GET("/:id/pdf", studiesHandler.GetStudyPDF)
+			studyRoutes.GET("/:id/results", studiesHandler.GetStudyResults)
+
+			// Sharing with clinics
+			studyRoutes.GET("/:id/grants", rbac.RequireRole(rbac.RolePatient), studiesHandler.ListStudyGrants)
+			studyRoutes.POST("/:id/grants", rbac.RequireRole(rbac.RolePatient), verified, noImpersonation, studiesHandler.ShareStudy)
+			studyRoutes.DELETE("/:id/grants/:grant_id", rbac.RequireRole(rbac.RolePatient), studiesHandler.RevokeStudyGrant)
+			studyRoutes.GET("/:id/access-log", rbac.RequireRole(rbac.RolePatient), studiesHandler.GetStudyAccessLog)
 
 			// DICOM upload routes
-			studyRoutes.POST("/:id/upload/init", rbac.RequireRole(rbac.RolePatient), studiesHandler.InitiateDICOMUpload)
-			studyRoutes.POST("/:id/upload", rbac.RequireRole(rbac.RolePatient), studiesHandler.UploadDICOMFile)
+			studyRoutes.POST("/:id/upload/init", rbac.RequireRole(rbac.RolePatient), verified, studiesHandler.InitiateDICOMUpload)
+			studyRoutes.POST("/:id/upload", rbac.RequireRole(rbac.RolePatient), verified, studiesHandler.UploadDICOMFile)
+
+			// Resumable (tus) uploads, created through POST /:id/upload
+			studyRoutes.OPTIONS("/:id/upload", studiesHandler.GetUploadOptions)
+			studyRoutes.HEAD("/:id/upload/:upload_id", rbac.RequireRole(rbac.RolePatient), studiesHandler.GetUploadOffset)
+			studyRoutes.PATCH("/:id/upload/:upload_id", rbac.RequireRole(rbac.RolePatient), verified, studiesHandler.PatchUpload)
+			studyRoutes.DELETE("/:id/upload/:upload_id", rbac.RequireRole(rbac.RolePatient), studiesHandler.DeleteUpload)
 		}
 
 		// Treatment plan routes
-		planRoutes := protected.Group("/plans")
+		planRoutes := app.Group("/plans")
 		{
 			planRoutes.POST("", plansHandler.CreatePlan)
 			planRoutes.GET("/:id", plansHandler.GetPlan)
 			planRoutes.GET("/:id/estimate", plansHandler.GetEstimate)
 			planRoutes.GET("/study/:study_id", plansHandler.GetPlansByStudy)
+			planRoutes.GET("/:id/annotations", plansHandler.GetAnnotations)
+			planRoutes.POST("/:id/annotations", rbac.RequireRole(rbac.RoleClinicDoctor), plansHandler.CreateAnnotation)
 		}
 
-		// Clinic routes
+		// Clinic routes, partly open to the clinic's API keys
 		clinicRoutes := protected.Group("/clinic")
-		clinicRoutes.Use(rbac.RequireRole(rbac.RoleClinicDoctor, rbac.RoleClinicManager))
+		clinicRoutes.Use(rbac.RequireMFA(), rbac.RequireRole(rbac.RoleClinicDoctor, rbac.RoleClinicManager, rbac.RoleClinicAPI))
 		{
-			clinicRoutes.POST("/profile", clinicsHandler.CreateClinic)
-			clinicRoutes.GET("/profile", clinicsHandler.GetMyClinic)
-			clinicRoutes.PUT("/profile", clinicsHandler.UpdateMyClinic)
-
-			// Pricelist
-			clinicRoutes.GET("/pricelist", clinicsHandler.GetMyPricelist)
-			clinicRoutes.POST("/pricelist", clinicsHandler.AddPriceItem)
-			clinicRoutes.DELETE("/pricelist/:id", clinicsHandler.DeletePriceItem)
-
-			// Offers
-			clinicRoutes.GET("/offers", offersHandler.GetMyOffers)
-			clinicRoutes.POST("/offers", offersHandler.CreateOffer)
-
-			// Orders
-			clinicRoutes.GET("/orders", ordersHandler.GetMyOrders)
-			clinicRoutes.PATCH("/orders/:id/status", ordersHandler.UpdateOrderStatus)
+			staff := rbac.RequireRole(rbac.RoleClinicDoctor, rbac.RoleClinicManager)
+			manager := rbac.RequireRole(rbac.RoleClinicManager)
+			managerOrKey := rbac.RequireRole(rbac.RoleClinicManager, rbac.RoleClinicAPI)
+			doctorOrKey := rbac.RequireRole(rbac.RoleClinicDoctor, rbac.RoleClinicAPI)
+
+			clinicRoutes.POST("/profile", manager, verified, clinicsHandler.CreateClinic)
+			clinicRoutes.GET("/profile", staff, clinicsHandler.GetMyClinic)
+			clinicRoutes.PUT("/profile", manager, clinicsHandler.UpdateMyClinic)
+
+			// Staff
+			clinicRoutes.GET("/members", staff, clinicsHandler.ListMembers)
+			clinicRoutes.DELETE("/members/:user_id", manager, clinicsHandler.RemoveMember)
+			clinicRoutes.GET("/invitations", manager, clinicsHandler.ListInvitations)
+			clinicRoutes.POST("/invitations", manager, verified, clinicsHandler.InviteStaff)
+			clinicRoutes.DELETE("/invitations/:id", manager, clinicsHandler.RevokeInvitation)
+			clinicRoutes.POST("/invitations/accept", staff, noImpersonation, clinicsHandler.AcceptInvitation)
+
+			// Pricelist (managers only)
+			clinicRoutes.GET("/pricelist", rbac.RequireScope(rbac.ScopePricelistRead), clinicsHandler.GetMyPricelist)
+			clinicRoutes.POST("/pricelist", managerOrKey, rbac.RequireScope(rbac.ScopePricelistWrite), verified, clinicsHandler.AddPriceItem)
+			clinicRoutes.DELETE("/pricelist/:id", managerOrKey, rbac.RequireScope(rbac.ScopePricelistWrite), clinicsHandler.DeletePriceItem)
+
+			// Studies patients have shared with the clinic
+			clinicRoutes.GET("/studies", staff, studiesHandler.ListSharedStudies)
+
+			// Offers (submitted by managers)
+			clinicRoutes.GET("/offers", staff, offersHandler.GetMyOffers)
+			clinicRoutes.POST("/offers", manager, verified, offersHandler.CreateOffer)
+
+			// Orders (treatment status is updated by doctors)
+			clinicRoutes.GET("/orders", rbac.RequireScope(rbac.ScopeOrdersRead), ordersHandler.GetMyOrders)
+			clinicRoutes.PATCH("/orders/:id/status", doctorOrKey, rbac.RequireScope(rbac.ScopeOrdersWrite), ordersHandler.UpdateOrderStatus)
+
+			// API keys for the clinic's own software
+			clinicRoutes.GET("/api-keys", manager, clinicsHandler.ListAPIKeys)
+			clinicRoutes.POST("/api-keys", manager, verified, noImpersonation, clinicsHandler.CreateAPIKey)
+			clinicRoutes.DELETE("/api-keys/:id", manager, clinicsHandler.RevokeAPIKey)
+		}
+
+		// Admin routes
+		adminRoutes := app.Group("/admin")
+		adminRoutes.Use(rbac.RequireRole(rbac.RoleAdmin))
+		{
+			adminRoutes.GET("/mfa-policies", adminHandler.ListMFAPolicies)
+			adminRoutes.PUT("/mfa-policies/:role", adminHandler.UpdateMFAPolicy)
+			adminRoutes.GET("/security/lockouts", adminHandler.ListLockouts)
+			adminRoutes.DELETE("/security/lockouts/:identifier", adminHandler.Unlock)
+			adminRoutes.GET("/security/login-attempts", adminHandler.ListLoginAttempts)
+
+			// User management
+			adminRoutes.GET("/users", adminHandler.ListUsers)
+			adminRoutes.GET("/users/:id", adminHandler.GetUser)
+			adminRoutes.POST("/users/:id/deactivate", adminHandler.DeactivateUser)
+			adminRoutes.POST("/users/:id/activate", adminHandler.ActivateUser)
+			adminRoutes.POST("/users/:id/reset-mfa", adminHandler.ResetUserMFA)
+			adminRoutes.POST("/users/:id/reset-password", adminHandler.ResetUserPassword)
+			adminRoutes.POST("/users/:id/promote", adminHandler.PromoteUser)
+			adminRoutes.POST("/users/:id/impersonate", adminHandler.ImpersonateUser)
+
+			// Clinic onboarding
+			adminRoutes.GET("/clinics", adminHandler.ListClinics)
+			adminRoutes.GET("/clinics/pending", adminHandler.ListPendingClinics)
+			adminRoutes.GET("/clinics/:id", adminHandler.GetClinic)
+			adminRoutes.POST("/clinics/:id/approve", adminHandler.ApproveClinic)
+			adminRoutes.POST("/clinics/:id/reject", adminHandler.RejectClinic)
+			adminRoutes.POST("/clinics/:id/suspend", adminHandler.SuspendClinic)
+			adminRoutes.POST("/clinics/:id/reactivate", adminHandler.ReactivateClinic)
+			adminRoutes.POST("/clinics/:id/notes", adminHandler.CreateClinicNote)
 		}
 
 		// Offer request routes (accessible by multiple roles)
-		protected.GET("/offer-requests/:id", offersHandler.GetOfferRequest)
+		app.GET("/offer-requests/:id", offersHandler.GetOfferRequest)
 
 		// Order routes (accessible by patient and clinic)
-		protected.GET("/orders/:id", ordersHandler.GetOrder)
+		app.GET("/orders/:id", ordersHandler.GetOrder)
 	}
 
+	// Public keys for verifying tokens
+	router.GET("/.well-known/jwks.json", authHandler.JWKS)
+
 	// Health check
 	router.GET("/health", func(c *gin.Context) {
 		c.JSON(200, gin.H{
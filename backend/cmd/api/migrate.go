@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/igorfazlyev/dm/internal/config"
+	"github.com/igorfazlyev/dm/internal/database"
+)
+
+// migrationsDir is where migrate create writes new migrations, relative to
+// the backend directory. They are embedded in the binary at build time.
+const migrationsDir = "internal/database/migrations"
+
+var migrationNamePattern = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// migrateCommand manages the database schema:
+//
+//	api migrate up
+//	api migrate down [-steps 1]
+//	api migrate status
+//	api migrate create add_clinic_rating
+func migrateCommand(cfg *config.Config, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: migrate up | down [-steps N] | status | create <name>")
+	}
+
+	switch args[0] {
+	case "create":
+		return createMigration(args[1:])
+	case "up", "down", "status":
+	default:
+		return fmt.Errorf("unknown subcommand %q", args[0])
+	}
+
+	if err := database.Connect(cfg); err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := database.MigrateUp()
+		for _, m := range applied {
+			fmt.Printf("applied %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			fmt.Println("schema is up to date")
+		}
+		return nil
+	case "down":
+		flags := flag.NewFlagSet("migrate down", flag.ContinueOnError)
+		steps := flags.Int("steps", 1, "number of migrations to revert")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		if *steps < 1 {
+			return errors.New("-steps must be at least 1")
+		}
+		reverted, err := database.MigrateDown(*steps)
+		for _, m := range reverted {
+			fmt.Printf("reverted %04d_%s\n", m.Version, m.Name)
+		}
+		return err
+	case "status":
+		states, err := database.MigrationStatus()
+		if err != nil {
+			return err
+		}
+		for _, state := range states {
+			applied := "pending"
+			if state.AppliedAt != nil {
+				applied = "applied " + state.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d_%-40s %s\n", state.Version, state.Name, applied)
+		}
+	}
+	return nil
+}
+
+// createMigration writes an empty up and down migration numbered after the
+// last one
+func createMigration(args []string) error {
+	if len(args) != 1 || !migrationNamePattern.MatchString(args[0]) {
+		return errors.New("usage: migrate create <name>, with a name of lowercase letters, digits and underscores")
+	}
+
+	entries, err := os.ReadDir(migrationsDir)
+	if err != nil {
+		return fmt.Errorf("run from the backend directory: %w", err)
+	}
+
+	last := 0
+	for _, entry := range entries {
+		prefix, _, _ := strings.Cut(entry.Name(), "_")
+		if version, err := strconv.Atoi(prefix); err == nil && version > last {
+			last = version
+		}
+	}
+
+	base := fmt.Sprintf("%04d_%s", last+1, args[0])
+	for _, direction := range []string{"up", "down"} {
+		path := filepath.Join(migrationsDir, base+"."+direction+".sql")
+		content := fmt.Sprintf("-- %s: %s\n", base, direction)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			return err
+		}
+		fmt.Println("created", path)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+
+	"github.com/igorfazlyev/dm/internal/config"
+	"github.com/igorfazlyev/dm/internal/database"
+	"github.com/igorfazlyev/dm/internal/seed"
+)
+
+// seedCommand fills the database with demo data:
+//
+//	api seed [-profile small|large] [-seed 1] [-reset] [-password demo-password]
+//
+// The same profile and seed always give the same data. Every account is at
+// @demo.local and signs in with the given password. It refuses to run in
+// production.
+func seedCommand(cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("seed", flag.ContinueOnError)
+	profileName := flags.String("profile", "small", "size of the data set: small or large")
+	seedValue := flags.Uint64("seed", 1, "random seed")
+	reset := flags.Bool("reset", false, "delete all data first")
+	password := flags.String("password", "demo-password", "password of every seeded account")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if cfg.Server.Environment == "production" {
+		return errors.New("refusing to seed a production database")
+	}
+	profile, ok := seed.Profiles[*profileName]
+	if !ok {
+		return fmt.Errorf("unknown profile %q, use small or large", *profileName)
+	}
+	if len(*password) < 8 {
+		return errors.New("-password must be at least 8 characters")
+	}
+
+	summary, err := seed.Run(database.DB, seed.Options{
+		Profile:  profile,
+		Seed:     *seedValue,
+		Password: *password,
+		Reset:    *reset,
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Seeded %s profile with seed %d:\n", *profileName, *seedValue)
+	fmt.Printf("  %d clinics with %d staff\n", summary.Clinics, summary.Staff)
+	fmt.Printf("  %d patients, %d studies, %d plans\n", summary.Patients, summary.Studies, summary.Plans)
+	fmt.Printf("  %d offer requests, %d offers, %d orders\n", summary.OfferRequests, summary.Offers, summary.Orders)
+	fmt.Println("Accounts:")
+	fmt.Printf("  admin@%s\n", seed.Domain)
+	fmt.Printf("  clinic01.manager@%s, clinic01.doctor1@%s, ...\n", seed.Domain, seed.Domain)
+	fmt.Printf("  patient001@%s, ...\n", seed.Domain)
+	fmt.Printf("Password: %s\n", *password)
+	return nil
+}
@@ -0,0 +1,209 @@
+package admin
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/igorfazlyev/dm/internal/audit"
+	"github.com/igorfazlyev/dm/internal/database"
+	"github.com/igorfazlyev/dm/internal/rbac"
+	"github.com/igorfazlyev/dm/internal/repository"
+)
+
+// clinicDecision is an admin action moving a clinic between statuses
+type clinicDecision struct {
+	action         string // Audit log action
+	from           []string
+	to             string
+	reasonRequired bool
+}
+
+var (
+	approveClinic    = clinicDecision{action: "approve_clinic", from: []string{"pending", "rejected"}, to: "approved"}
+	rejectClinic     = clinicDecision{action: "reject_clinic", from: []string{"pending"}, to: "rejected", reasonRequired: true}
+	suspendClinic    = clinicDecision{action: "suspend_clinic", from: []string{"approved"}, to: "suspended", reasonRequired: true}
+	reactivateClinic = clinicDecision{action: "reactivate_clinic", from: []string{"suspended"}, to: "approved"}
+)
+
+type ClinicDecisionRequest struct {
+	Reason string `json:"reason"`
+}
+
+type CreateClinicNoteRequest struct {
+	Body string `json:"body" binding:"required"`
+}
+
+// ListPendingClinics returns clinics awaiting approval, oldest first
+func (h *Handler) ListPendingClinics(c *gin.Context) {
+	clinics, err := h.clinics.Pending()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch clinics"})
+		return
+	}
+
+	c.JSON(http.StatusOK, clinics)
+}
+
+// ListClinics returns all clinics, optionally filtered by ?status=
+func (h *Handler) ListClinics(c *gin.Context) {
+	clinics, err := h.clinics.List(c.Query("status"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch clinics"})
+		return
+	}
+
+	c.JSON(http.StatusOK, clinics)
+}
+
+// GetClinic returns a clinic with its notes and decision history
+func (h *Handler) GetClinic(c *gin.Context) {
+	clinicID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid clinic ID"})
+		return
+	}
+
+	clinic, err := h.clinics.Get(clinicID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "clinic not found"})
+		return
+	}
+
+	notes, err := h.clinics.Notes(clinic.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch notes"})
+		return
+	}
+
+	history, err := h.audit.History("clinic", clinic.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch history"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"clinic":  clinic,
+		"notes":   notes,
+		"history": history,
+	})
+}
+
+// ApproveClinic lets a pending or previously rejected clinic make offers
+func (h *Handler) ApproveClinic(c *gin.Context) {
+	h.decideClinic(c, approveClinic)
+}
+
+// RejectClinic turns down a pending clinic, with a reason shown to it
+func (h *Handler) RejectClinic(c *gin.Context) {
+	h.decideClinic(c, rejectClinic)
+}
+
+// SuspendClinic stops an approved clinic from making offers
+func (h *Handler) SuspendClinic(c *gin.Context) {
+	h.decideClinic(c, suspendClinic)
+}
+
+// ReactivateClinic lifts a suspension
+func (h *Handler) ReactivateClinic(c *gin.Context) {
+	h.decideClinic(c, reactivateClinic)
+}
+
+func (h *Handler) decideClinic(c *gin.Context, decision clinicDecision) {
+	userID, _ := rbac.GetUserID(c)
+
+	clinicID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid clinic ID"})
+		return
+	}
+
+	var req ClinicDecisionRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if decision.reasonRequired && req.Reason == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "reason is required"})
+		return
+	}
+
+	clinic, err := h.clinics.Get(clinicID)
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "clinic not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update clinic"})
+		return
+	}
+
+	from := clinic.Status
+	if !slices.Contains(decision.from, from) {
+		c.JSON(http.StatusConflict, gin.H{"error": "clinic is " + from})
+		return
+	}
+
+	now := time.Now()
+	clinic.Status = decision.to
+	clinic.IsActive = decision.to == "approved"
+	clinic.StatusReason = req.Reason
+	clinic.ReviewedAt = &now
+	clinic.ReviewedByID = &userID
+
+	// Saving bumps the version, so managers' edits based on the old one fail,
+	// and fails itself if the clinic changed since it was read
+	entry := audit.Entry(c, decision.action, "clinic", clinic.ID, map[string]any{
+		"from":   from,
+		"to":     decision.to,
+		"reason": req.Reason,
+	})
+	err = h.clinics.Review(clinic, entry)
+	switch {
+	case err == nil:
+		c.JSON(http.StatusOK, clinic)
+	case errors.Is(err, repository.ErrConflict):
+		c.JSON(http.StatusConflict, gin.H{"error": "clinic changed, fetch it again"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update clinic"})
+	}
+}
+
+// CreateClinicNote adds an internal note to a clinic
+func (h *Handler) CreateClinicNote(c *gin.Context) {
+	userID, _ := rbac.GetUserID(c)
+
+	clinicID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid clinic ID"})
+		return
+	}
+
+	var req CreateClinicNoteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	clinic, err := h.clinics.Get(clinicID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "clinic not found"})
+		return
+	}
+
+	note := database.ClinicNote{
+		ClinicID: clinic.ID,
+		AuthorID: userID,
+		Body:     req.Body,
+	}
+	if err := h.clinics.AddNote(&note); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create note"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, note)
+}
@@ -0,0 +1,82 @@
+package admin
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/igorfazlyev/dm/internal/auth"
+	"github.com/igorfazlyev/dm/internal/database"
+	"github.com/igorfazlyev/dm/internal/rbac"
+	"github.com/igorfazlyev/dm/internal/repository"
+	"github.com/igorfazlyev/dm/internal/sessions"
+)
+
+type Handler struct {
+	sessions *sessions.Store
+	auth     *auth.Handler
+	users    repository.Users
+	patients repository.Patients
+	clinics  repository.Clinics
+	audit    repository.Audit
+	logins   repository.Logins
+}
+
+func NewHandler(sessionStore *sessions.Store, authHandler *auth.Handler, users repository.Users, patients repository.Patients, clinics repository.Clinics, auditLog repository.Audit, logins repository.Logins) *Handler {
+	return &Handler{sessions: sessionStore, auth: authHandler, users: users, patients: patients, clinics: clinics, audit: auditLog, logins: logins}
+}
+
+type UpdateMFAPolicyRequest struct {
+	Required *bool `json:"required" binding:"required"`
+}
+
+var knownRoles = map[string]bool{
+	rbac.RolePatient:       true,
+	rbac.RoleClinicDoctor:  true,
+	rbac.RoleClinicManager: true,
+	rbac.RoleAdmin:         true,
+}
+
+// ListMFAPolicies returns the MFA requirement of every role
+func (h *Handler) ListMFAPolicies(c *gin.Context) {
+	policies, err := h.logins.MFAPolicies()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch mfa policies"})
+		return
+	}
+
+	c.JSON(http.StatusOK, policies)
+}
+
+// UpdateMFAPolicy requires or stops requiring MFA for a role
+func (h *Handler) UpdateMFAPolicy(c *gin.Context) {
+	userID, _ := rbac.GetUserID(c)
+	role := c.Param("role")
+
+	if !knownRoles[role] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unknown role"})
+		return
+	}
+
+	var req UpdateMFAPolicyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	policy := database.MFAPolicy{
+		Role:        role,
+		Required:    *req.Required,
+		UpdatedByID: &userID,
+		UpdatedAt:   time.Now(),
+	}
+
+	if err := h.logins.SaveMFAPolicy(&policy); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update mfa policy"})
+		return
+	}
+
+	rbac.InvalidateMFAPolicies()
+
+	c.JSON(http.StatusOK, policy)
+}
@@ -0,0 +1,59 @@
+package admin
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/igorfazlyev/dm/internal/audit"
+	"github.com/igorfazlyev/dm/internal/rbac"
+)
+
+const impersonationTTL = 15 * time.Minute
+
+// ImpersonateUser issues a short-lived token to act as another user, so
+// support can see what they see. A reason is required and every request
+// made with the token is audited.
+func (h *Handler) ImpersonateUser(c *gin.Context) {
+	actorID, _ := rbac.GetUserID(c)
+	sessionID, _ := rbac.GetSessionID(c)
+
+	user, req, ok := h.loadTargetUser(c)
+	if !ok {
+		return
+	}
+
+	if req.Reason == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "reason is required"})
+		return
+	}
+	if user.Role == rbac.RoleAdmin {
+		c.JSON(http.StatusForbidden, gin.H{"error": "admins cannot be impersonated"})
+		return
+	}
+	if !user.IsActive {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "user is deactivated"})
+		return
+	}
+
+	token, err := h.auth.IssueImpersonationToken(user, actorID, sessionID, c.GetBool("mfa"), impersonationTTL)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to issue token"})
+		return
+	}
+
+	expiresAt := time.Now().Add(impersonationTTL)
+	if err := h.audit.Record(audit.Entry(c, "start_impersonation", "user", user.ID, map[string]any{
+		"reason":     req.Reason,
+		"expires_at": expiresAt,
+	})); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to issue token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"access_token": token,
+		"expires_at":   expiresAt,
+		"user":         user,
+	})
+}
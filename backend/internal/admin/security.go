@@ -0,0 +1,57 @@
+package admin
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/igorfazlyev/dm/internal/repository"
+)
+
+// ListLockouts returns accounts with recent failed logins, locked ones first
+func (h *Handler) ListLockouts(c *gin.Context) {
+	lockouts, err := h.logins.Lockouts(c.Query("locked") == "true", 200)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch lockouts"})
+		return
+	}
+
+	c.JSON(http.StatusOK, lockouts)
+}
+
+// Unlock clears the lockout and failure count of an account identifier
+func (h *Handler) Unlock(c *gin.Context) {
+	err := h.logins.ClearLockout(c.Param("identifier"))
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "lockout not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to unlock account"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "account unlocked"})
+}
+
+// ListLoginAttempts returns recent failed login attempts, optionally filtered
+// by identifier or IP address
+func (h *Handler) ListLoginAttempts(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit < 1 || limit > 1000 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 1000"})
+		return
+	}
+
+	attempts, err := h.logins.LoginAttempts(repository.LoginAttemptFilter{
+		Identifier: c.Query("identifier"),
+		IPAddress:  c.Query("ip"),
+	}, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch login attempts"})
+		return
+	}
+
+	c.JSON(http.StatusOK, attempts)
+}
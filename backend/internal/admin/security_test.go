@@ -0,0 +1,64 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/igorfazlyev/dm/internal/database"
+	"github.com/igorfazlyev/dm/internal/repository/memory"
+)
+
+func TestLockouts(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := memory.NewStore()
+	h := NewHandler(nil, nil, store.Users, store.Patients, store.Clinics, store.Audit, store.Logins)
+
+	// Five failures lock an identifier, fewer only count
+	fail := func(identifier string, times int) {
+		for i := 0; i < times; i++ {
+			store.Logins.RecordLoginFailure(&database.LoginAttempt{Identifier: identifier, Reason: "invalid_password"},
+				time.Hour, func(failed int) time.Duration { return time.Duration(failed/5) * time.Minute })
+		}
+	}
+	fail("counted@example.com", 2)
+	fail("locked@example.com", 5)
+
+	router := gin.New()
+	router.GET("/lockouts", h.ListLockouts)
+	router.DELETE("/lockouts/:identifier", h.Unlock)
+	list := func(query string) []string {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/lockouts"+query, nil))
+		var lockouts []database.LoginLockout
+		if err := json.Unmarshal(w.Body.Bytes(), &lockouts); err != nil {
+			t.Fatalf("%d %s: %v", w.Code, w.Body, err)
+		}
+		var identifiers []string
+		for _, l := range lockouts {
+			identifiers = append(identifiers, l.Identifier)
+		}
+		return identifiers
+	}
+
+	if got := list(""); len(got) != 2 || got[0] != "locked@example.com" {
+		t.Errorf("lockouts = %v, want the locked one first", got)
+	}
+	if got := list("?locked=true"); len(got) != 1 || got[0] != "locked@example.com" {
+		t.Errorf("locked = %v", got)
+	}
+
+	for _, want := range []int{http.StatusOK, http.StatusNotFound} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/lockouts/locked@example.com", nil))
+		if w.Code != want {
+			t.Errorf("unlock: status = %d, want %d", w.Code, want)
+		}
+	}
+	if got := list(""); len(got) != 1 || got[0] != "counted@example.com" {
+		t.Errorf("lockouts after unlock = %v", got)
+	}
+}
@@ -0,0 +1,259 @@
+package admin
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/igorfazlyev/dm/internal/audit"
+	"github.com/igorfazlyev/dm/internal/database"
+	"github.com/igorfazlyev/dm/internal/rbac"
+	"github.com/igorfazlyev/dm/internal/repository"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+)
+
+type UserActionRequest struct {
+	Reason string `json:"reason"`
+}
+
+// UserDetails is a user with the patient profile or clinic they belong to
+type UserDetails struct {
+	User       database.User          `json:"user"`
+	Membership *database.ClinicMember `json:"clinic_membership,omitempty"`
+}
+
+// ListUsers returns a page of users, optionally filtered by ?email= (partial
+// match on email or phone), ?role= and ?active=
+func (h *Handler) ListUsers(c *gin.Context) {
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "page must be a positive number"})
+		return
+	}
+	pageSize, err := strconv.Atoi(c.DefaultQuery("page_size", strconv.Itoa(defaultPageSize)))
+	if err != nil || pageSize < 1 || pageSize > maxPageSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "page_size must be between 1 and 200"})
+		return
+	}
+
+	filter := repository.UserFilter{Search: c.Query("email"), Role: c.Query("role")}
+	if active := c.Query("active"); active != "" {
+		isActive := active == "true"
+		filter.Active = &isActive
+	}
+
+	users, total, err := h.users.List(filter, page, pageSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch users"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"users":     users,
+		"total":     total,
+		"page":      page,
+		"page_size": pageSize,
+	})
+}
+
+// GetUser returns a user with their patient profile and clinic membership
+func (h *Handler) GetUser(c *gin.Context) {
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+		return
+	}
+
+	user, err := h.users.Get(userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+	details := UserDetails{User: *user}
+
+	patient, err := h.patients.FindByUserID(userID)
+	switch {
+	case err == nil:
+		details.User.Patient = patient
+	case !errors.Is(err, repository.ErrNotFound):
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch patient profile"})
+		return
+	}
+
+	member, err := h.clinics.Membership(userID)
+	switch {
+	case err == nil:
+		details.Membership = member
+	case !errors.Is(err, repository.ErrNotFound):
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch clinic membership"})
+		return
+	}
+
+	c.JSON(http.StatusOK, details)
+}
+
+// DeactivateUser blocks a user from signing in and ends their sessions
+func (h *Handler) DeactivateUser(c *gin.Context) {
+	h.setUserActive(c, false)
+}
+
+// ActivateUser lets a deactivated user sign in again
+func (h *Handler) ActivateUser(c *gin.Context) {
+	h.setUserActive(c, true)
+}
+
+func (h *Handler) setUserActive(c *gin.Context, active bool) {
+	user, req, ok := h.loadTargetUser(c)
+	if !ok {
+		return
+	}
+
+	action := "activate_user"
+	if !active {
+		action = "deactivate_user"
+	}
+
+	entry := audit.Entry(c, action, "user", user.ID, map[string]any{"reason": req.Reason})
+	if err := h.users.SetActive(user, active, entry); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update user"})
+		return
+	}
+
+	if !active {
+		if err := h.sessions.RevokeAllForUser(user.ID, uuid.Nil); err != nil {
+			log.Printf("Failed to revoke sessions of deactivated user %s: %v", user.ID, err)
+		}
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// ResetUserMFA removes a user's authenticator and recovery codes, for users
+// who lost their device. They are signed out everywhere.
+func (h *Handler) ResetUserMFA(c *gin.Context) {
+	user, req, ok := h.loadTargetUser(c)
+	if !ok {
+		return
+	}
+
+	if user.MFAEnabledAt == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "mfa is not enabled"})
+		return
+	}
+
+	entry := audit.Entry(c, "reset_user_mfa", "user", user.ID, map[string]any{"reason": req.Reason})
+	if err := h.users.ClearMFA(user.ID, entry); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to reset mfa"})
+		return
+	}
+
+	if err := h.sessions.RevokeAllForUser(user.ID, uuid.Nil); err != nil {
+		log.Printf("Failed to revoke sessions after mfa reset for user %s: %v", user.ID, err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "mfa reset"})
+}
+
+// ResetUserPassword emails a user a password reset link. Admins never see or
+// set the password themselves.
+func (h *Handler) ResetUserPassword(c *gin.Context) {
+	user, req, ok := h.loadTargetUser(c)
+	if !ok {
+		return
+	}
+
+	if user.Email == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "user has no email address"})
+		return
+	}
+
+	if err := h.audit.Record(audit.Entry(c, "reset_user_password", "user", user.ID, map[string]any{"reason": req.Reason})); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to reset password"})
+		return
+	}
+
+	if err := h.auth.SendPasswordReset(user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to reset password"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "password reset link sent"})
+}
+
+// PromoteUser makes a user an admin. Clinic staff must leave their clinic
+// first.
+func (h *Handler) PromoteUser(c *gin.Context) {
+	user, req, ok := h.loadTargetUser(c)
+	if !ok {
+		return
+	}
+
+	if user.Role == rbac.RoleAdmin {
+		c.JSON(http.StatusConflict, gin.H{"error": "user is already an admin"})
+		return
+	}
+
+	if _, err := h.clinics.Membership(user.ID); err == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "user is a member of a clinic"})
+		return
+	} else if !errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to promote user"})
+		return
+	}
+
+	entry := audit.Entry(c, "promote_user", "user", user.ID, map[string]any{
+		"from":   user.Role,
+		"to":     rbac.RoleAdmin,
+		"reason": req.Reason,
+	})
+	if err := h.users.SetRole(user, rbac.RoleAdmin, entry); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to promote user"})
+		return
+	}
+
+	// Tokens carry the role, so the user signs in again to get the new one
+	if err := h.sessions.RevokeAllForUser(user.ID, uuid.Nil); err != nil {
+		log.Printf("Failed to revoke sessions of promoted user %s: %v", user.ID, err)
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// loadTargetUser loads the user in the path and binds the optional reason.
+// Admins cannot act on their own account. It writes the error response
+// itself when it returns false.
+func (h *Handler) loadTargetUser(c *gin.Context) (*database.User, UserActionRequest, bool) {
+	var req UserActionRequest
+
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+		return nil, req, false
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, req, false
+	}
+
+	if currentID, _ := rbac.GetUserID(c); currentID == userID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "cannot change your own account"})
+		return nil, req, false
+	}
+
+	user, err := h.users.Get(userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return nil, req, false
+	}
+
+	return user, req, true
+}
@@ -0,0 +1,23 @@
+package audit
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/igorfazlyev/dm/internal/database"
+	"github.com/igorfazlyev/dm/internal/rbac"
+)
+
+// Entry describes an action of the current request for the audit log
+func Entry(c *gin.Context, action, entityType string, entityID uuid.UUID, details map[string]any) *database.AuditLog {
+	entry := &database.AuditLog{
+		Action:     action,
+		EntityType: entityType,
+		EntityID:   &entityID,
+		Details:    details,
+		IPAddress:  c.ClientIP(),
+	}
+	if userID, ok := rbac.GetUserID(c); ok {
+		entry.UserID = &userID
+	}
+	return entry
+}
@@ -0,0 +1,41 @@
+package audit
+
+import (
+	"log"
+
+	"github.com/gin-gonic/gin"
+	"github.com/igorfazlyev/dm/internal/database"
+	"github.com/igorfazlyev/dm/internal/rbac"
+)
+
+// ImpersonationTrail records every request made with an impersonation token,
+// under the admin's ID, once it has been handled
+func ImpersonationTrail() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		actorID, ok := rbac.GetActorID(c)
+		if !ok {
+			return
+		}
+		userID, _ := rbac.GetUserID(c)
+
+		entry := database.AuditLog{
+			UserID:     &actorID,
+			Action:     "impersonated_request",
+			EntityType: "user",
+			EntityID:   &userID,
+			Details: map[string]any{
+				"actor_id": actorID,
+				"user_id":  userID,
+				"method":   c.Request.Method,
+				"path":     c.Request.URL.Path,
+				"status":   c.Writer.Status(),
+			},
+			IPAddress: c.ClientIP(),
+		}
+		if err := database.DB.Create(&entry).Error; err != nil {
+			log.Printf("Failed to record impersonated request by %s as %s: %v", actorID, userID, err)
+		}
+	}
+}
@@ -1,21 +1,36 @@
 package auth
 
 import (
+	"fmt"
+	"log"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/go-webauthn/webauthn/webauthn"
 	"github.com/igorfazlyev/dm/internal/config"
 	"github.com/igorfazlyev/dm/internal/database"
+	"github.com/igorfazlyev/dm/internal/mailer"
+	"github.com/igorfazlyev/dm/internal/rbac"
+	"github.com/igorfazlyev/dm/internal/repository"
+	"github.com/igorfazlyev/dm/internal/sessions"
+	"github.com/igorfazlyev/dm/internal/sms"
 	jwtpkg "github.com/igorfazlyev/dm/pkg/jwt"
 	"golang.org/x/crypto/bcrypt"
 )
 
 type Handler struct {
-	cfg *config.Config
+	cfg      *config.Config
+	sessions *sessions.Store
+	mailer   mailer.Sender
+	passkeys *webauthn.WebAuthn
+	sms      sms.Sender
+	keys     *jwtpkg.Keyring
+	users    repository.Users
+	logins   repository.Logins
 }
 
-func NewHandler(cfg *config.Config) *Handler {
-	return &Handler{cfg: cfg}
+func NewHandler(cfg *config.Config, keys *jwtpkg.Keyring, sessionStore *sessions.Store, mailSender mailer.Sender, passkeys *webauthn.WebAuthn, smsSender sms.Sender, users repository.Users, logins repository.Logins) *Handler {
+	return &Handler{cfg: cfg, keys: keys, sessions: sessionStore, mailer: mailSender, passkeys: passkeys, sms: smsSender, users: users, logins: logins}
 }
 
 type RegisterRequest struct {
@@ -31,6 +46,11 @@ type LoginRequest struct {
 	Password string `json:"password" binding:"required"`
 }
 
+// Register creates an account and emails a link to confirm its address. The
+// response is the same whether or not the email is already registered, so it
+// cannot be used to find out who has an account; the owner of an existing
+// account is emailed instead. Clients sign in with the same credentials
+// afterwards.
 func (h *Handler) Register(c *gin.Context) {
 	var req RegisterRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -38,80 +58,64 @@ func (h *Handler) Register(c *gin.Context) {
 		return
 	}
 
-	// Check if user exists
-	var existingUser database.User
-	if err := database.DB.Where("email = ?", req.Email).First(&existingUser).Error; err == nil {
-		c.JSON(http.StatusConflict, gin.H{"error": "user already exists"})
-		return
-	}
+	response := gin.H{"message": "check your email to confirm your address"}
 
-	// Hash password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	// Hashed up front so both outcomes take as long
+	hashedPassword, err := HashPassword(req.Password)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to hash password"})
 		return
 	}
 
-	// Start transaction
-	tx := database.DB.Begin()
-	defer func() {
-		if r := recover(); r != nil {
-			tx.Rollback()
+	if existingUser, err := h.users.FindByEmail(req.Email); err == nil {
+		if existingUser.DeletedAt.Valid || !existingUser.IsActive {
+			c.JSON(http.StatusAccepted, response)
+			return
 		}
-	}()
+		h.sendAccountExistsEmail(existingUser)
+		c.JSON(http.StatusAccepted, response)
+		return
+	}
 
-	// Create user
 	user := database.User{
 		Email:        req.Email,
-		PasswordHash: string(hashedPassword),
+		PasswordHash: hashedPassword,
 		Role:         req.Role,
 		IsActive:     true,
 	}
-
-	if err := tx.Create(&user).Error; err != nil {
-		tx.Rollback()
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create user"})
-		return
+	// Patients get their profile with the account
+	var patient *database.Patient
+	if req.Role == rbac.RolePatient {
+		patient = &database.Patient{FirstName: req.FirstName, LastName: req.LastName}
 	}
-
-	// Create patient profile if role is patient
-	if req.Role == "patient" {
-		patient := database.Patient{
-			UserID:    user.ID,
-			FirstName: req.FirstName,
-			LastName:  req.LastName,
-		}
-		if err := tx.Create(&patient).Error; err != nil {
-			tx.Rollback()
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create patient profile"})
+	if err := h.users.Register(&user, patient); err != nil {
+		if _, lookupErr := h.users.FindByEmail(req.Email); lookupErr == nil {
+			// Registered by a concurrent request since the lookup
+			c.JSON(http.StatusAccepted, response)
 			return
 		}
-	}
-
-	// Commit transaction
-	if err := tx.Commit().Error; err != nil {
-		tx.Rollback()
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to complete registration"})
 		return
 	}
 
-	// Generate tokens (after successful commit)
-	tokens, err := jwtpkg.GenerateTokenPair(
-		user.ID,
-		user.Email,
-		user.Role,
-		h.cfg.JWT.Secret,
-		h.cfg.JWT.AccessTokenTTL,
-		h.cfg.JWT.RefreshTokenTTL,
-	)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate tokens"})
-		return
+	// Account stays restricted until the email address is confirmed
+	if err := h.sendVerificationEmail(&user); err != nil {
+		log.Printf("Failed to send verification email to user %s: %v", user.ID, err)
 	}
 
-	c.JSON(http.StatusCreated, gin.H{
-		"user":   user,
-		"tokens": tokens,
+	c.JSON(http.StatusAccepted, response)
+}
+
+// sendAccountExistsEmail tells the owner of an account that someone tried to
+// register its address again
+func (h *Handler) sendAccountExistsEmail(user *database.User) {
+	mailer.SendAsync(h.mailer, mailer.Message{
+		To:      user.Email,
+		Subject: "You already have an account",
+		Body: fmt.Sprintf("Someone tried to create a Dental Marketplace account with this email address, "+
+			"which already has one.\n\n"+
+			"If it was you, sign in at %s/login, where you can also reset your password.\n\n"+
+			"If it was not you, you can ignore this email.", h.cfg.Server.FrontendURL),
 	})
 }
 
@@ -122,33 +126,68 @@ func (h *Handler) Login(c *gin.Context) {
 		return
 	}
 
+	identifier := lockoutIdentifier(req.Email)
+	if wait := h.lockoutRemaining(identifier); wait > 0 {
+		h.recordLockedAttempt(c, identifier)
+		c.Header("Retry-After", fmt.Sprintf("%d", int(wait.Seconds())+1))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many failed login attempts, try again later"})
+		return
+	}
+
 	// Find user
-	var user database.User
-	if err := database.DB.Where("email = ?", req.Email).First(&user).Error; err != nil {
+	user, err := h.users.FindByEmail(req.Email)
+	if err != nil || user.DeletedAt.Valid {
+		// Spend the same time as a wrong password so accounts can't be probed
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(req.Password))
+		h.recordLoginFailure(c, identifier, nil, LoginFailureUnknownAccount)
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
 		return
 	}
 
 	// Check password
 	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)); err != nil {
+		h.recordLoginFailure(c, identifier, &user.ID, LoginFailureInvalidPassword)
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
 		return
 	}
 
+	// With MFA the failure count is reset only once the second factor passes,
+	// otherwise repeating the password would allow unlimited code guesses
+	if user.MFAEnabledAt == nil {
+		h.clearLockout(identifier)
+	}
+
 	if !user.IsActive {
 		c.JSON(http.StatusForbidden, gin.H{"error": "account is inactive"})
 		return
 	}
 
+	h.rehashIfOutdated(user, req.Password)
+
+	h.completeLogin(c, user)
+}
+
+// completeLogin finishes a login whose first factor has been verified: users
+// with MFA enabled get a challenge to complete with their second factor,
+// everyone else gets a token pair
+func (h *Handler) completeLogin(c *gin.Context, user *database.User) {
+	if user.MFAEnabledAt != nil {
+		challenge, err := jwtpkg.GenerateMFAChallenge(user.ID, user.Email, user.Role, h.keys, mfaChallengeTTL)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate tokens"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"mfa_required": true,
+			"mfa_token":    challenge,
+			"expires_in":   int(mfaChallengeTTL.Seconds()),
+		})
+		return
+	}
+
 	// Generate tokens
-	tokens, err := jwtpkg.GenerateTokenPair(
-		user.ID,
-		user.Email,
-		user.Role,
-		h.cfg.JWT.Secret,
-		h.cfg.JWT.AccessTokenTTL,
-		h.cfg.JWT.RefreshTokenTTL,
-	)
+	tokens, err := h.startSession(c, user, false)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate tokens"})
 		return
@@ -161,10 +200,10 @@ func (h *Handler) Login(c *gin.Context) {
 }
 
 func (h *Handler) Me(c *gin.Context) {
-	userID, _ := c.Get("user_id")
+	userID, _ := rbac.GetUserID(c)
 
-	var user database.User
-	if err := database.DB.Preload("Patient").Preload("Clinic").Where("id = ?", userID).First(&user).Error; err != nil {
+	user, err := h.users.Profile(userID)
+	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
 		return
 	}
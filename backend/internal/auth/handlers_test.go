@@ -0,0 +1,175 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/igorfazlyev/dm/internal/config"
+	"github.com/igorfazlyev/dm/internal/database"
+	"github.com/igorfazlyev/dm/internal/mailer"
+	"github.com/igorfazlyev/dm/internal/rbac"
+	"github.com/igorfazlyev/dm/internal/repository/memory"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// outbox collects the emails a handler sends in the background
+type outbox chan mailer.Message
+
+func (o outbox) Send(msg mailer.Message) error {
+	o <- msg
+	return nil
+}
+
+// next waits for the next email
+func (o outbox) next(t *testing.T) mailer.Message {
+	t.Helper()
+	select {
+	case msg := <-o:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("no email sent")
+		return mailer.Message{}
+	}
+}
+
+func newRegisterHandler(t *testing.T) (*Handler, *memory.Store, outbox) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	store := memory.NewStore()
+	mail := make(outbox, 10)
+	cfg := &config.Config{Server: config.ServerConfig{FrontendURL: "https://dm.example"}}
+	return NewHandler(cfg, nil, nil, mail, nil, nil, store.Users, store.Logins), store, mail
+}
+
+func register(h *Handler, req RegisterRequest) *httptest.ResponseRecorder {
+	router := gin.New()
+	router.POST("/auth/register", h.Register)
+
+	body, _ := json.Marshal(req)
+	r := httptest.NewRequest(http.MethodPost, "/auth/register", strings.NewReader(string(body)))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	return w
+}
+
+func TestRegisterCreatesPatient(t *testing.T) {
+	h, store, mail := newRegisterHandler(t)
+
+	w := register(h, RegisterRequest{
+		Email:     "new@example.com",
+		Password:  "correct horse",
+		Role:      rbac.RolePatient,
+		FirstName: "Ada",
+		LastName:  "Lovelace",
+	})
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want 202: %s", w.Code, w.Body)
+	}
+	if strings.Contains(w.Body.String(), "token") {
+		t.Errorf("response carries tokens: %s", w.Body)
+	}
+
+	user, err := store.Users.FindByEmail("new@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Role != rbac.RolePatient || !user.IsActive || user.VerifiedAt != nil {
+		t.Errorf("user = role %s, active %v, verified %v", user.Role, user.IsActive, user.VerifiedAt)
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte("correct horse")) != nil {
+		t.Error("password hash does not match the password")
+	}
+
+	patient, err := store.Patients.FindByUserID(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if patient.FirstName != "Ada" || patient.LastName != "Lovelace" {
+		t.Errorf("patient = %q %q", patient.FirstName, patient.LastName)
+	}
+
+	tokens := store.UserTokens(user.ID)
+	if len(tokens) != 1 || tokens[0].Purpose != TokenPurposeEmailVerification {
+		t.Fatalf("tokens = %+v, want one email verification token", tokens)
+	}
+	msg := mail.next(t)
+	if msg.To != "new@example.com" || !strings.Contains(msg.Body, "https://dm.example/verify-email?token=") {
+		t.Errorf("email = %+v", msg)
+	}
+}
+
+func TestRegisterStaffHasNoPatientProfile(t *testing.T) {
+	h, store, _ := newRegisterHandler(t)
+
+	w := register(h, RegisterRequest{Email: "doctor@example.com", Password: "correct horse", Role: rbac.RoleClinicDoctor})
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want 202: %s", w.Code, w.Body)
+	}
+
+	user, err := store.Users.FindByEmail("doctor@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Patients.FindByUserID(user.ID); err == nil {
+		t.Error("clinic doctor got a patient profile")
+	}
+}
+
+// An existing address gets the same response as a new one, so registering
+// cannot tell who has an account
+func TestRegisterHidesExistingAccounts(t *testing.T) {
+	tests := []struct {
+		name   string
+		user   database.User
+		notify bool
+	}{
+		{"active account", database.User{IsActive: true}, true},
+		{"deactivated account", database.User{IsActive: false}, false},
+		{"deleted account", database.User{IsActive: true, DeletedAt: gorm.DeletedAt{Time: time.Now(), Valid: true}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, store, mail := newRegisterHandler(t)
+
+			existing := tt.user
+			existing.Email = "taken@example.com"
+			existing.Role = rbac.RolePatient
+			existing.PasswordHash = "unchanged"
+			store.AddUser(&existing)
+
+			fresh := register(h, RegisterRequest{Email: "fresh@example.com", Password: "correct horse", Role: rbac.RolePatient})
+			mail.next(t) // The new account's verification email
+
+			w := register(h, RegisterRequest{Email: "taken@example.com", Password: "another pass", Role: rbac.RoleClinicManager})
+			if w.Code != fresh.Code || w.Body.String() != fresh.Body.String() {
+				t.Errorf("response = %d %s, want the new account's %d %s", w.Code, w.Body, fresh.Code, fresh.Body)
+			}
+
+			user, err := store.Users.FindByEmail("taken@example.com")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if user.ID != existing.ID || user.PasswordHash != "unchanged" || user.Role != rbac.RolePatient {
+				t.Errorf("existing account was changed: %+v", user)
+			}
+			if tokens := store.UserTokens(existing.ID); len(tokens) != 0 {
+				t.Errorf("issued %d tokens to the existing account", len(tokens))
+			}
+
+			if tt.notify {
+				msg := mail.next(t)
+				if msg.To != "taken@example.com" || msg.Subject != "You already have an account" {
+					t.Errorf("email = %+v", msg)
+				}
+			}
+		})
+	}
+}
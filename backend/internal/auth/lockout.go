@@ -0,0 +1,107 @@
+package auth
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/igorfazlyev/dm/internal/database"
+	"github.com/igorfazlyev/dm/internal/repository"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// An account is locked once it reaches lockoutThreshold consecutive
+	// failures; each further failure doubles the lock, up to lockoutMax
+	lockoutThreshold = 5
+	lockoutBase      = 30 * time.Second
+	lockoutMax       = time.Hour
+
+	// Failures older than this no longer count towards a lockout
+	lockoutWindow = 24 * time.Hour
+
+	LoginFailureInvalidPassword = "invalid_password"
+	LoginFailureUnknownAccount  = "unknown_account"
+	LoginFailureInvalidMFACode  = "invalid_mfa_code"
+	LoginFailureLocked          = "locked"
+)
+
+// dummyPasswordHash is compared against when the account does not exist, so
+// unknown and known emails take the same time to reject
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("not a real password"), passwordHashCost)
+
+// lockoutIdentifier returns the key lockouts are tracked under for an email
+func lockoutIdentifier(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// lockoutRemaining returns how long identifier stays locked. Errors are logged
+// and treated as not locked.
+func (h *Handler) lockoutRemaining(identifier string) time.Duration {
+	lockout, err := h.logins.Lockout(identifier)
+	if err != nil {
+		if !errors.Is(err, repository.ErrNotFound) {
+			log.Printf("Failed to check lockout for %s: %v", identifier, err)
+		}
+		return 0
+	}
+	if lockout.LockedUntil == nil {
+		return 0
+	}
+
+	return max(time.Until(*lockout.LockedUntil), 0)
+}
+
+// lockoutDuration returns the lock applied after failed consecutive failures
+func lockoutDuration(failed int) time.Duration {
+	if failed < lockoutThreshold {
+		return 0
+	}
+
+	d := lockoutBase
+	for i := lockoutThreshold; i < failed && d < lockoutMax; i++ {
+		d *= 2
+	}
+	if d > lockoutMax {
+		d = lockoutMax
+	}
+	return d
+}
+
+// recordLoginFailure counts a failed attempt against identifier, locking it
+// once the threshold is reached, and logs the attempt
+func (h *Handler) recordLoginFailure(c *gin.Context, identifier string, userID *uuid.UUID, reason string) {
+	err := h.logins.RecordLoginFailure(&database.LoginAttempt{
+		Identifier: identifier,
+		UserID:     userID,
+		Reason:     reason,
+		IPAddress:  c.ClientIP(),
+		UserAgent:  c.Request.UserAgent(),
+	}, lockoutWindow, lockoutDuration)
+	if err != nil {
+		log.Printf("Failed to record login failure for %s: %v", identifier, err)
+	}
+}
+
+// recordLockedAttempt logs an attempt rejected because of a lockout. It does
+// not extend the lock.
+func (h *Handler) recordLockedAttempt(c *gin.Context, identifier string) {
+	if err := h.logins.RecordLoginAttempt(&database.LoginAttempt{
+		Identifier: identifier,
+		Reason:     LoginFailureLocked,
+		IPAddress:  c.ClientIP(),
+		UserAgent:  c.Request.UserAgent(),
+	}); err != nil {
+		log.Printf("Failed to record login attempt for %s: %v", identifier, err)
+	}
+}
+
+// clearLockout resets the failure count after a successful login
+func (h *Handler) clearLockout(identifier string) {
+	if err := h.logins.ClearLockout(identifier); err != nil && !errors.Is(err, repository.ErrNotFound) {
+		log.Printf("Failed to clear lockout for %s: %v", identifier, err)
+	}
+}
@@ -0,0 +1,213 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/igorfazlyev/dm/internal/config"
+	"github.com/igorfazlyev/dm/internal/database"
+	"github.com/igorfazlyev/dm/internal/rbac"
+	"github.com/igorfazlyev/dm/internal/repository/memory"
+	"github.com/igorfazlyev/dm/internal/sessions"
+	jwtpkg "github.com/igorfazlyev/dm/pkg/jwt"
+	"github.com/igorfazlyev/dm/pkg/totp"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const testPassword = "correct horse"
+
+// loginFixture is a handler that signs tokens, with one account
+type loginFixture struct {
+	store   *memory.Store
+	handler *Handler
+	user    database.User
+}
+
+func newLoginFixture(t *testing.T) *loginFixture {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	// The lowest cost keeps the test fast; logins upgrade it once
+	hash, err := bcrypt.GenerateFromPassword([]byte(testPassword), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := memory.NewStore()
+	f := &loginFixture{store: store, user: database.User{
+		Email:        "patient@example.com",
+		PasswordHash: string(hash),
+		Role:         rbac.RolePatient,
+		IsActive:     true,
+	}}
+	store.AddUser(&f.user)
+
+	keys := jwtpkg.NewKeyring()
+	keys.SetKeys(jwtpkg.NewHMACKey("test", "test secret"), nil)
+	cfg := &config.Config{JWT: config.JWTConfig{AccessTokenTTL: time.Minute, RefreshTokenTTL: time.Hour}}
+	f.handler = NewHandler(cfg, keys, sessions.NewStore(time.Minute), make(outbox, 10), nil, nil, store.Users, store.Logins)
+	return f
+}
+
+// post sends body as JSON to a handler and decodes the response into out
+func post(t *testing.T, handler gin.HandlerFunc, body any, out any) int {
+	t.Helper()
+	router := gin.New()
+	router.POST("/", handler)
+
+	data, _ := json.Marshal(body)
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(string(data)))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+
+	if out != nil {
+		if err := json.Unmarshal(w.Body.Bytes(), out); err != nil {
+			t.Fatalf("response %d %s: %v", w.Code, w.Body, err)
+		}
+	}
+	return w.Code
+}
+
+// loginResponse is the body of a login: tokens, or an MFA challenge
+type loginResponse struct {
+	Tokens      *jwtpkg.TokenPair `json:"tokens"`
+	MFARequired bool              `json:"mfa_required"`
+	MFAToken    string            `json:"mfa_token"`
+}
+
+func (f *loginFixture) login(t *testing.T, password string) (int, loginResponse) {
+	t.Helper()
+	var resp loginResponse
+	code := post(t, f.handler.Login, LoginRequest{Email: f.user.Email, Password: password}, &resp)
+	return code, resp
+}
+
+func TestLoginLockout(t *testing.T) {
+	f := newLoginFixture(t)
+
+	for i := 0; i < lockoutThreshold; i++ {
+		if code, _ := f.login(t, "wrong password"); code != http.StatusUnauthorized {
+			t.Fatalf("failure %d: status = %d, want 401", i+1, code)
+		}
+	}
+	if code, _ := f.login(t, testPassword); code != http.StatusTooManyRequests {
+		t.Errorf("right password while locked: status = %d, want 429", code)
+	}
+
+	lockout, err := f.store.Logins.Lockout(f.user.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lockout.FailedCount != lockoutThreshold || lockout.LockedUntil == nil {
+		t.Errorf("lockout = %d failures, locked until %v", lockout.FailedCount, lockout.LockedUntil)
+	}
+
+	// Unlocked, the right password signs in and resets the count
+	f.store.Logins.ClearLockout(f.user.Email)
+	f.login(t, "wrong password")
+	if code, resp := f.login(t, testPassword); code != http.StatusOK || resp.Tokens == nil {
+		t.Fatalf("login: status = %d, tokens %v", code, resp.Tokens)
+	}
+	if _, err := f.store.Logins.Lockout(f.user.Email); err == nil {
+		t.Error("lockout kept after a successful login")
+	}
+
+	user, _ := f.store.Users.Get(f.user.ID)
+	if cost, _ := bcrypt.Cost([]byte(user.PasswordHash)); cost != passwordHashCost {
+		t.Errorf("password hash cost = %d, want it upgraded to %d", cost, passwordHashCost)
+	}
+}
+
+func TestLoginMFA(t *testing.T) {
+	f := newLoginFixture(t)
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	f.store.Users.Update(f.user.ID, map[string]any{"mfa_secret": secret, "mfa_enabled_at": now})
+	codes, hashes, err := generateRecoveryCodes(f.user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.store.Logins.ReplaceRecoveryCodes(f.user.ID, hashes)
+
+	code, challenge := f.login(t, testPassword)
+	if code != http.StatusOK || !challenge.MFARequired || challenge.Tokens != nil {
+		t.Fatalf("login: status = %d, %+v; want an MFA challenge", code, challenge)
+	}
+
+	totpCode, err := totp.Code(secret, totp.Step(now))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		req  LoginMFARequest
+		want int
+	}{
+		{"code", LoginMFARequest{Code: totpCode}, http.StatusOK},
+		{"same code again", LoginMFARequest{Code: totpCode}, http.StatusUnauthorized},
+		{"recovery code", LoginMFARequest{RecoveryCode: strings.ToUpper(codes[0])}, http.StatusOK},
+		{"same recovery code again", LoginMFARequest{RecoveryCode: codes[0]}, http.StatusUnauthorized},
+		{"unknown recovery code", LoginMFARequest{RecoveryCode: "aaaaa-aaaaa"}, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		tt.req.MFAToken = challenge.MFAToken
+		var resp loginResponse
+		if got := post(t, f.handler.LoginMFA, tt.req, &resp); got != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, got, tt.want)
+			continue
+		}
+		if tt.want == http.StatusOK && resp.Tokens == nil {
+			t.Errorf("%s: no tokens", tt.name)
+		}
+	}
+}
+
+func TestRefreshRotatesToken(t *testing.T) {
+	f := newLoginFixture(t)
+	code, resp := f.login(t, testPassword)
+	if code != http.StatusOK {
+		t.Fatalf("login: status = %d", code)
+	}
+
+	var refreshed loginResponse
+	if code := post(t, f.handler.Refresh, RefreshRequest{RefreshToken: resp.Tokens.RefreshToken}, &refreshed); code != http.StatusOK {
+		t.Fatalf("refresh: status = %d", code)
+	}
+
+	old, err := f.store.Logins.RefreshToken(f.refreshTokenID(t, resp.Tokens))
+	if err != nil {
+		t.Fatal(err)
+	}
+	nextID := f.refreshTokenID(t, refreshed.Tokens)
+	if old.RotatedAt == nil || old.ReplacedByID == nil || *old.ReplacedByID != nextID {
+		t.Errorf("old token = rotated %v, replaced by %v; want replaced by %s", old.RotatedAt, old.ReplacedByID, nextID)
+	}
+	next, err := f.store.Logins.RefreshToken(nextID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next.SessionID != old.SessionID || !next.Session.ExpiresAt.Equal(next.ExpiresAt) {
+		t.Errorf("new token = session %s expiring %v, want session %s extended to %v",
+			next.SessionID, next.Session.ExpiresAt, old.SessionID, next.ExpiresAt)
+	}
+}
+
+// refreshTokenID returns the ID of the refresh token of a pair, which is not
+// sent to clients but is its jti claim
+func (f *loginFixture) refreshTokenID(t *testing.T, tokens *jwtpkg.TokenPair) uuid.UUID {
+	t.Helper()
+	claims, err := jwtpkg.ValidateToken(tokens.RefreshToken, f.handler.keys, jwtpkg.TokenTypeRefresh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return uuid.MustParse(claims.ID)
+}
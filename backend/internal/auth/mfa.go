@@ -0,0 +1,374 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/igorfazlyev/dm/internal/audit"
+	"github.com/igorfazlyev/dm/internal/database"
+	"github.com/igorfazlyev/dm/internal/rbac"
+	"github.com/igorfazlyev/dm/internal/repository"
+	jwtpkg "github.com/igorfazlyev/dm/pkg/jwt"
+	"github.com/igorfazlyev/dm/pkg/securetoken"
+	"github.com/igorfazlyev/dm/pkg/totp"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	mfaIssuer         = "Dental Marketplace"
+	mfaChallengeTTL   = 5 * time.Minute
+	mfaClockSkew      = 1 // Accept codes one period either side of now
+	recoveryCodeCount = 10
+)
+
+var errInvalidMFACode = errors.New("invalid mfa code")
+
+type EnableMFARequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type DisableMFARequest struct {
+	Password string `json:"password" binding:"required"`
+	Code     string `json:"code" binding:"required"`
+}
+
+type RegenerateRecoveryCodesRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type LoginMFARequest struct {
+	MFAToken     string `json:"mfa_token" binding:"required"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// SetupMFA starts TOTP enrollment by generating a secret. MFA is not active
+// until the user confirms a code with EnableMFA.
+func (h *Handler) SetupMFA(c *gin.Context) {
+	userID, _ := rbac.GetUserID(c)
+
+	user, err := h.users.Get(userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+
+	if user.MFAEnabledAt != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "mfa is already enabled"})
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate secret"})
+		return
+	}
+
+	if err := h.users.Update(user.ID, map[string]any{"mfa_secret": secret}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start mfa setup"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"secret":           secret,
+		"provisioning_uri": totp.ProvisioningURI(secret, mfaIssuer, user.Email),
+	})
+}
+
+// EnableMFA confirms enrollment with a code from the authenticator app and
+// returns the one-time recovery codes
+func (h *Handler) EnableMFA(c *gin.Context) {
+	userID, _ := rbac.GetUserID(c)
+	sessionID, _ := rbac.GetSessionID(c)
+
+	var req EnableMFARequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var codes []string
+	err := func() error {
+		user, err := h.users.Get(userID)
+		if err != nil {
+			return err
+		}
+		if user.MFAEnabledAt != nil {
+			return errInvalidMFACode
+		}
+
+		if err := h.verifyTOTP(user, req.Code); err != nil {
+			return err
+		}
+
+		codes, err = h.enableMFA(user.ID, sessionID)
+		return err
+	}()
+	if errors.Is(err, errInvalidMFACode) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid code or mfa setup not started"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to enable mfa"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "mfa enabled",
+		"recovery_codes": codes,
+	})
+}
+
+// DisableMFA turns MFA off after checking both the password and a code.
+// Users whose role requires MFA cannot disable it.
+func (h *Handler) DisableMFA(c *gin.Context) {
+	userID, _ := rbac.GetUserID(c)
+
+	var req DisableMFARequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := h.users.Get(userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+
+	if user.MFAEnabledAt == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "mfa is not enabled"})
+		return
+	}
+
+	required, err := rbac.MFARequiredForRole(user.Role)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check mfa policy"})
+		return
+	}
+	if required {
+		c.JSON(http.StatusForbidden, gin.H{"error": "mfa is required for your role"})
+		return
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid password or code"})
+		return
+	}
+
+	err = h.verifyTOTP(user, req.Code)
+	if err == nil {
+		err = h.users.ClearMFA(user.ID, audit.Entry(c, "disable_mfa", "user", user.ID, nil))
+	}
+	if errors.Is(err, errInvalidMFACode) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid password or code"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to disable mfa"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "mfa disabled"})
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes with a new set
+func (h *Handler) RegenerateRecoveryCodes(c *gin.Context) {
+	userID, _ := rbac.GetUserID(c)
+
+	var req RegenerateRecoveryCodesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var codes []string
+	err := func() error {
+		user, err := h.users.Get(userID)
+		if err != nil {
+			return err
+		}
+		if user.MFAEnabledAt == nil {
+			return errInvalidMFACode
+		}
+		if err := h.verifyTOTP(user, req.Code); err != nil {
+			return err
+		}
+
+		var hashes []string
+		codes, hashes, err = generateRecoveryCodes(user.ID)
+		if err != nil {
+			return err
+		}
+		return h.logins.ReplaceRecoveryCodes(user.ID, hashes)
+	}()
+	if errors.Is(err, errInvalidMFACode) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid code or mfa not enabled"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to regenerate recovery codes"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+// LoginMFA completes a login started with a password by exchanging the MFA
+// challenge token and a TOTP or recovery code for a token pair
+func (h *Handler) LoginMFA(c *gin.Context) {
+	var req LoginMFARequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if (req.Code == "") == (req.RecoveryCode == "") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "provide either code or recovery_code"})
+		return
+	}
+
+	claims, err := jwtpkg.ValidateToken(req.MFAToken, h.keys, jwtpkg.TokenTypeMFAChallenge)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired mfa token"})
+		return
+	}
+
+	// Guesses at the second factor count towards the same lockout as
+	// passwords; phone-only accounts are tracked by user ID
+	identifier := lockoutIdentifier(claims.Email)
+	if identifier == "" {
+		identifier = claims.UserID.String()
+	}
+	if wait := h.lockoutRemaining(identifier); wait > 0 {
+		h.recordLockedAttempt(c, identifier)
+		c.Header("Retry-After", fmt.Sprintf("%d", int(wait.Seconds())+1))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many failed login attempts, try again later"})
+		return
+	}
+
+	var (
+		user   *database.User
+		tokens *jwtpkg.TokenPair
+	)
+	err = func() error {
+		user, err = h.users.Get(claims.UserID)
+		if err != nil || !user.IsActive || user.MFAEnabledAt == nil {
+			return errInvalidMFACode
+		}
+
+		if req.Code != "" {
+			err = h.verifyTOTP(user, req.Code)
+		} else {
+			err = h.useRecoveryCode(user.ID, req.RecoveryCode)
+		}
+		if err != nil {
+			return err
+		}
+
+		tokens, err = h.startSession(c, user, true)
+		return err
+	}()
+	if errors.Is(err, errInvalidMFACode) {
+		h.recordLoginFailure(c, identifier, &claims.UserID, LoginFailureInvalidMFACode)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid code"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate tokens"})
+		return
+	}
+
+	h.clearLockout(identifier)
+
+	c.JSON(http.StatusOK, gin.H{
+		"user":   user,
+		"tokens": tokens,
+	})
+}
+
+// verifyTOTP checks a code against the user's secret and records its time
+// step so the same code cannot be used twice
+func (h *Handler) verifyTOTP(user *database.User, code string) error {
+	if user.MFASecret == "" {
+		return errInvalidMFACode
+	}
+
+	step, ok := totp.Validate(user.MFASecret, code, time.Now(), mfaClockSkew)
+	if !ok || step <= user.MFALastStep {
+		return errInvalidMFACode
+	}
+
+	// Fails if the code, or a later one, was used since the user was read
+	err := h.logins.UseTOTPStep(user.ID, step)
+	if errors.Is(err, repository.ErrConflict) {
+		return errInvalidMFACode
+	}
+	if err != nil {
+		return err
+	}
+
+	user.MFALastStep = step
+	return nil
+}
+
+// useRecoveryCode consumes one of the user's unused recovery codes
+func (h *Handler) useRecoveryCode(userID uuid.UUID, code string) error {
+	err := h.logins.UseRecoveryCode(userID, hashRecoveryCode(userID, code))
+	if errors.Is(err, repository.ErrNotFound) {
+		return errInvalidMFACode
+	}
+	return err
+}
+
+// enableMFA turns on the user's MFA from the session that confirmed it,
+// returning the plain recovery codes to show to the user once
+func (h *Handler) enableMFA(userID, sessionID uuid.UUID) ([]string, error) {
+	codes, hashes, err := generateRecoveryCodes(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Fails if a concurrent request enabled it first
+	err = h.logins.EnableMFA(userID, sessionID, hashes)
+	if errors.Is(err, repository.ErrConflict) {
+		return nil, errInvalidMFACode
+	}
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// generateRecoveryCodes returns a new set of recovery codes for the user and
+// their hashes
+func generateRecoveryCodes(userID uuid.UUID) (codes, hashes []string, err error) {
+	codes = make([]string, 0, recoveryCodeCount)
+	hashes = make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		raw := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b))[:10]
+		code := raw[:5] + "-" + raw[5:]
+
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(userID, code))
+	}
+
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode hashes a normalized code, salted with the user ID
+func hashRecoveryCode(userID uuid.UUID, code string) string {
+	normalized := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	return securetoken.Hash(userID.String() + ":" + normalized)
+}
@@ -0,0 +1,219 @@
+package auth
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/igorfazlyev/dm/internal/database"
+	"github.com/igorfazlyev/dm/internal/rbac"
+	"github.com/igorfazlyev/dm/internal/repository"
+	"github.com/igorfazlyev/dm/pkg/securetoken"
+)
+
+const (
+	otpDigits      = 6
+	otpTTL         = 5 * time.Minute
+	otpMaxAttempts = 5
+
+	// Per-number throttling: one code per interval, a limited number per hour
+	otpResendInterval = time.Minute
+	otpHourlyLimit    = 5
+)
+
+var phonePattern = regexp.MustCompile(`^\+[1-9][0-9]{7,14}$`)
+
+type RequestOTPRequest struct {
+	Phone string `json:"phone" binding:"required"`
+}
+
+type VerifyOTPRequest struct {
+	Phone string `json:"phone" binding:"required"`
+	Code  string `json:"code" binding:"required"`
+}
+
+// normalizePhone strips formatting from a phone number and checks that the
+// result is in E.164 format
+func normalizePhone(phone string) (string, bool) {
+	var b strings.Builder
+	for i, r := range strings.TrimSpace(phone) {
+		switch {
+		case r >= '0' && r <= '9':
+			b.WriteRune(r)
+		case r == '+' && i == 0:
+			b.WriteRune(r)
+		case r == ' ' || r == '-' || r == '(' || r == ')' || r == '.':
+		default:
+			return "", false
+		}
+	}
+
+	normalized := b.String()
+	return normalized, phonePattern.MatchString(normalized)
+}
+
+// RequestOTP sends a one-time login code to a phone number
+func (h *Handler) RequestOTP(c *gin.Context) {
+	var req RequestOTPRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	phone, ok := normalizePhone(req.Phone)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "phone must be in international format, e.g. +79001234567"})
+		return
+	}
+
+	recent, err := h.logins.RecentOTPs(phone, time.Now().Add(-time.Hour))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to send code"})
+		return
+	}
+
+	if len(recent) >= otpHourlyLimit {
+		c.Header("Retry-After", fmt.Sprintf("%d", int(time.Until(recent[len(recent)-1].CreatedAt.Add(time.Hour)).Seconds())+1))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many codes requested for this number, try again later"})
+		return
+	}
+	if len(recent) > 0 {
+		if wait := otpResendInterval - time.Since(recent[0].CreatedAt); wait > 0 {
+			c.Header("Retry-After", fmt.Sprintf("%d", int(wait.Seconds())+1))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "please wait before requesting another code"})
+			return
+		}
+	}
+
+	code, err := generateOTP()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate code"})
+		return
+	}
+
+	// Only the latest code for a number is valid
+	err = h.logins.CreateOTP(&database.PhoneOTP{
+		Phone:     phone,
+		CodeHash:  hashOTP(phone, code),
+		ExpiresAt: time.Now().Add(otpTTL),
+		IPAddress: c.ClientIP(),
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to send code"})
+		return
+	}
+
+	body := fmt.Sprintf("Your Dental Marketplace code: %s. It expires in %d minutes.", code, int(otpTTL.Minutes()))
+	if err := h.sms.Send(phone, body); err != nil {
+		log.Printf("Failed to send login code to %s: %v", phone, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "failed to send code"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "code sent",
+		"expires_in": int(otpTTL.Seconds()),
+	})
+}
+
+// VerifyOTP logs in with a code from RequestOTP. A number that does not
+// belong to any account gets a new patient account without an email.
+func (h *Handler) VerifyOTP(c *gin.Context) {
+	var req VerifyOTPRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	phone, ok := normalizePhone(req.Phone)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "phone must be in international format, e.g. +79001234567"})
+		return
+	}
+
+	// A wrong code counts as an attempt even though the request is rejected
+	valid, err := h.logins.UseOTP(phone, hashOTP(phone, strings.TrimSpace(req.Code)), otpMaxAttempts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to verify code"})
+		return
+	}
+	if !valid {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired code"})
+		return
+	}
+
+	user, err := h.phoneUser(phone)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to log in"})
+		return
+	}
+
+	// Phone login is a patient feature; staff accounts must use their password
+	if user.Role != rbac.RolePatient {
+		c.JSON(http.StatusForbidden, gin.H{"error": "phone login is only available to patients"})
+		return
+	}
+	if !user.IsActive {
+		c.JSON(http.StatusForbidden, gin.H{"error": "account is inactive"})
+		return
+	}
+
+	h.completeLogin(c, user)
+}
+
+// phoneUser returns the account of a phone number that has just been
+// verified, creating a patient account for a new number
+func (h *Handler) phoneUser(phone string) (*database.User, error) {
+	user, err := h.users.FindByPhone(phone)
+	if err == nil {
+		if user.PhoneVerifiedAt == nil {
+			now := time.Now()
+			if err := h.users.Update(user.ID, map[string]any{"phone_verified_at": now}); err != nil {
+				return nil, err
+			}
+			user.PhoneVerifiedAt = &now
+		}
+		return user, nil
+	}
+	if !errors.Is(err, repository.ErrNotFound) {
+		return nil, err
+	}
+
+	now := time.Now()
+	user = &database.User{
+		Phone:           &phone,
+		Role:            rbac.RolePatient,
+		IsActive:        true,
+		PhoneVerifiedAt: &now,
+	}
+	if err := h.users.Register(user, &database.Patient{Phone: phone}); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// generateOTP returns a random numeric code of otpDigits digits
+func generateOTP() (string, error) {
+	max := big.NewInt(1)
+	for i := 0; i < otpDigits; i++ {
+		max.Mul(max, big.NewInt(10))
+	}
+
+	n, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%0*d", otpDigits, n), nil
+}
+
+// hashOTP binds the code to the number it was sent to
+func hashOTP(phone, code string) string {
+	return securetoken.Hash(phone + ":" + code)
+}
@@ -0,0 +1,207 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/igorfazlyev/dm/internal/database"
+	"github.com/igorfazlyev/dm/internal/mailer"
+	"github.com/igorfazlyev/dm/internal/rbac"
+	"github.com/igorfazlyev/dm/internal/repository"
+	"github.com/igorfazlyev/dm/pkg/securetoken"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// passwordHashCost is the bcrypt cost for new hashes. Hashes with a lower
+	// cost are upgraded on the next successful login.
+	passwordHashCost = 12
+
+	passwordResetTTL = time.Hour
+
+	TokenPurposePasswordReset = "password_reset"
+)
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+type ResetPasswordRequest struct {
+	Token       string `json:"token" binding:"required"`
+	NewPassword string `json:"new_password" binding:"required,min=8"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required,min=8"`
+}
+
+// HashPassword hashes a password for storage
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), passwordHashCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// rehashIfOutdated upgrades the stored hash of a user whose password has just
+// been verified if it was created with a lower bcrypt cost
+func (h *Handler) rehashIfOutdated(user *database.User, password string) {
+	cost, err := bcrypt.Cost([]byte(user.PasswordHash))
+	if err != nil || cost >= passwordHashCost {
+		return
+	}
+
+	hash, err := HashPassword(password)
+	if err != nil {
+		log.Printf("Failed to rehash password for user %s: %v", user.ID, err)
+		return
+	}
+
+	if err := h.users.Update(user.ID, map[string]any{"password_hash": hash}); err != nil {
+		log.Printf("Failed to store rehashed password for user %s: %v", user.ID, err)
+	}
+}
+
+// ForgotPassword emails a password reset link. The response is the same
+// whether or not the account exists.
+func (h *Handler) ForgotPassword(c *gin.Context) {
+	var req ForgotPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	response := gin.H{"message": "if an account with that email exists, a reset link has been sent"}
+
+	user, err := h.users.FindByEmail(req.Email)
+	if err != nil || user.DeletedAt.Valid || !user.IsActive {
+		c.JSON(http.StatusOK, response)
+		return
+	}
+
+	if err := h.SendPasswordReset(user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create reset token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// SendPasswordReset emails the user a single-use password reset link
+func (h *Handler) SendPasswordReset(user *database.User) error {
+	token, err := h.createUserToken(user, TokenPurposePasswordReset, passwordResetTTL)
+	if err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/reset-password?token=%s", h.cfg.Server.FrontendURL, token)
+	mailer.SendAsync(h.mailer, mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("We received a request to reset your password.\n\n"+
+			"Open the link below to choose a new one. It expires in %d minutes and can be used once.\n\n%s\n\n"+
+			"If you did not request this, you can ignore this email.", int(passwordResetTTL.Minutes()), link),
+	})
+	return nil
+}
+
+// ResetPassword sets a new password using a reset token and signs the user
+// out everywhere
+func (h *Handler) ResetPassword(c *gin.Context) {
+	var req ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	hash, err := HashPassword(req.NewPassword)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to hash password"})
+		return
+	}
+
+	userToken, err := h.users.UseToken(securetoken.Hash(req.Token), TokenPurposePasswordReset, map[string]any{"password_hash": hash})
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or expired reset token"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to reset password"})
+		return
+	}
+
+	if err := h.sessions.RevokeAllForUser(userToken.UserID, uuid.Nil); err != nil {
+		log.Printf("Failed to revoke sessions after password reset for user %s: %v", userToken.UserID, err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "password has been reset"})
+}
+
+// ChangePassword changes the current user's password and signs out all of
+// their other sessions
+func (h *Handler) ChangePassword(c *gin.Context) {
+	userID, _ := rbac.GetUserID(c)
+	sessionID, _ := rbac.GetSessionID(c)
+
+	var req ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := h.users.Get(userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.CurrentPassword)); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "current password is incorrect"})
+		return
+	}
+
+	hash, err := HashPassword(req.NewPassword)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to hash password"})
+		return
+	}
+
+	if err := h.users.Update(user.ID, map[string]any{"password_hash": hash}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to change password"})
+		return
+	}
+
+	if err := h.sessions.RevokeAllForUser(user.ID, sessionID); err != nil {
+		log.Printf("Failed to revoke sessions after password change for user %s: %v", user.ID, err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "password changed"})
+}
+
+// createUserToken issues a single-use token for the user, invalidating any
+// earlier unused tokens with the same purpose
+func (h *Handler) createUserToken(user *database.User, purpose string, ttl time.Duration) (string, error) {
+	token, hash, err := securetoken.Generate()
+	if err != nil {
+		return "", err
+	}
+
+	err = h.users.IssueToken(&database.UserToken{
+		UserID:    user.ID,
+		Purpose:   purpose,
+		TokenHash: hash,
+		ExpiresAt: time.Now().Add(ttl),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/igorfazlyev/dm/internal/rbac"
+	"github.com/igorfazlyev/dm/internal/sessions"
+)
+
+type SessionResponse struct {
+	ID         uuid.UUID `json:"id"`
+	UserAgent  string    `json:"user_agent"`
+	IPAddress  string    `json:"ip_address"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	Current    bool      `json:"current"`
+}
+
+// Logout revokes the session of the current token
+func (h *Handler) Logout(c *gin.Context) {
+	userID, _ := rbac.GetUserID(c)
+	sessionID, _ := rbac.GetSessionID(c)
+
+	if err := h.sessions.Revoke(userID, sessionID); err != nil && !errors.Is(err, sessions.ErrSessionNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to logout"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "logged out"})
+}
+
+// LogoutAll revokes every session of the current user, including this one
+func (h *Handler) LogoutAll(c *gin.Context) {
+	userID, _ := rbac.GetUserID(c)
+
+	if err := h.sessions.RevokeAllForUser(userID, uuid.Nil); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to logout"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "logged out from all devices"})
+}
+
+// ListSessions returns the current user's active sessions (devices)
+func (h *Handler) ListSessions(c *gin.Context) {
+	userID, _ := rbac.GetUserID(c)
+	currentID, _ := rbac.GetSessionID(c)
+
+	active, err := h.sessions.ListActive(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch sessions"})
+		return
+	}
+
+	response := make([]SessionResponse, 0, len(active))
+	for _, s := range active {
+		response = append(response, SessionResponse{
+			ID:         s.ID,
+			UserAgent:  s.UserAgent,
+			IPAddress:  s.IPAddress,
+			CreatedAt:  s.CreatedAt,
+			LastUsedAt: s.LastUsedAt,
+			Current:    s.ID == currentID,
+		})
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// RevokeSession revokes one of the current user's sessions
+func (h *Handler) RevokeSession(c *gin.Context) {
+	userID, _ := rbac.GetUserID(c)
+
+	sessionID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid session ID"})
+		return
+	}
+
+	if err := h.sessions.Revoke(userID, sessionID); err != nil {
+		if errors.Is(err, sessions.ErrSessionNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "session not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke session"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "session revoked"})
+}
@@ -0,0 +1,186 @@
+package auth
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/igorfazlyev/dm/internal/database"
+	"github.com/igorfazlyev/dm/internal/repository"
+	jwtpkg "github.com/igorfazlyev/dm/pkg/jwt"
+)
+
+var (
+	errInvalidRefreshToken = errors.New("invalid refresh token")
+	errRefreshTokenReused  = errors.New("refresh token reused")
+)
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+// signTokens signs a token pair for the user in a session. mfa marks whether
+// the session was authenticated with a second factor.
+func (h *Handler) signTokens(user *database.User, sessionID uuid.UUID, mfa bool) (*jwtpkg.TokenPair, error) {
+	return jwtpkg.GenerateTokenPair(
+		jwtpkg.Subject{
+			UserID:    user.ID,
+			Email:     user.Email,
+			Role:      user.Role,
+			SessionID: sessionID,
+			MFA:       mfa,
+		},
+		h.keys,
+		h.cfg.JWT.AccessTokenTTL,
+		h.cfg.JWT.RefreshTokenTTL,
+	)
+}
+
+// startSession signs a token pair for a new login of the user and stores its
+// session with the refresh token
+func (h *Handler) startSession(c *gin.Context, user *database.User, mfa bool) (*jwtpkg.TokenPair, error) {
+	sessionID := uuid.New()
+	tokens, err := h.signTokens(user, sessionID, mfa)
+	if err != nil {
+		return nil, err
+	}
+
+	session := database.Session{
+		ID:         sessionID,
+		UserID:     user.ID,
+		UserAgent:  c.Request.UserAgent(),
+		IPAddress:  c.ClientIP(),
+		MFA:        mfa,
+		LastUsedAt: time.Now(),
+		ExpiresAt:  tokens.RefreshExpiresAt,
+	}
+	if err := h.logins.StartSession(&session, refreshToken(tokens, user.ID, sessionID)); err != nil {
+		return nil, err
+	}
+	h.sessions.Started(&session)
+
+	return tokens, nil
+}
+
+// refreshToken is the record of the refresh token of a pair
+func refreshToken(tokens *jwtpkg.TokenPair, userID, sessionID uuid.UUID) *database.RefreshToken {
+	return &database.RefreshToken{
+		ID:        tokens.RefreshTokenID,
+		UserID:    userID,
+		SessionID: sessionID,
+		ExpiresAt: tokens.RefreshExpiresAt,
+	}
+}
+
+// IssueImpersonationToken signs an access token letting the admin actorID act
+// as user. It is bound to the admin's own session, so it dies with it, and
+// cannot be refreshed.
+func (h *Handler) IssueImpersonationToken(user *database.User, actorID, sessionID uuid.UUID, mfa bool, ttl time.Duration) (string, error) {
+	return jwtpkg.GenerateAccessToken(
+		jwtpkg.Subject{
+			UserID:    user.ID,
+			Email:     user.Email,
+			Role:      user.Role,
+			SessionID: sessionID,
+			MFA:       mfa,
+			Actor:     &jwtpkg.Actor{UserID: actorID},
+		},
+		h.keys,
+		ttl,
+	)
+}
+
+// Refresh exchanges a valid refresh token for a new token pair. Each refresh
+// token can be used once; presenting an already-rotated token revokes the
+// whole session, since it means the token has leaked.
+func (h *Handler) Refresh(c *gin.Context) {
+	var req RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	claims, err := jwtpkg.ValidateToken(req.RefreshToken, h.keys, jwtpkg.TokenTypeRefresh)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired refresh token"})
+		return
+	}
+
+	tokenID, err := uuid.Parse(claims.ID)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired refresh token"})
+		return
+	}
+
+	var (
+		user   *database.User
+		tokens *jwtpkg.TokenPair
+		stored *database.RefreshToken
+	)
+
+	err = func() error {
+		var err error
+		stored, err = h.logins.RefreshToken(tokenID)
+		if errors.Is(err, repository.ErrNotFound) {
+			return errInvalidRefreshToken
+		}
+		if err != nil {
+			return err
+		}
+
+		if stored.RotatedAt != nil {
+			return errRefreshTokenReused
+		}
+		if stored.RevokedAt != nil || stored.Session.RevokedAt != nil || time.Now().After(stored.ExpiresAt) {
+			return errInvalidRefreshToken
+		}
+
+		user, err = h.users.Get(stored.UserID)
+		if err != nil || !user.IsActive {
+			return errInvalidRefreshToken
+		}
+
+		tokens, err = h.signTokens(user, stored.SessionID, stored.Session.MFA)
+		if err != nil {
+			return err
+		}
+
+		// Fails if a concurrent refresh rotated the token first
+		err = h.logins.RotateRefreshToken(stored, refreshToken(tokens, user.ID, stored.SessionID), c.ClientIP())
+		if errors.Is(err, repository.ErrConflict) {
+			return errRefreshTokenReused
+		}
+		return err
+	}()
+
+	switch {
+	case errors.Is(err, errRefreshTokenReused):
+		log.Printf("Refresh token reuse detected for user %s, revoking session %s", stored.UserID, stored.SessionID)
+		if err := h.sessions.Revoke(stored.UserID, stored.SessionID); err != nil {
+			log.Printf("Failed to revoke session %s: %v", stored.SessionID, err)
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired refresh token"})
+		return
+	case errors.Is(err, errInvalidRefreshToken):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired refresh token"})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to refresh tokens"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"user":   user,
+		"tokens": tokens,
+	})
+}
+
+// JWKS publishes the public keys tokens are signed with so other services can
+// verify them
+func (h *Handler) JWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, h.keys.JWKS())
+}
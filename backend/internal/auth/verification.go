@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/igorfazlyev/dm/internal/database"
+	"github.com/igorfazlyev/dm/internal/mailer"
+	"github.com/igorfazlyev/dm/internal/rbac"
+	"github.com/igorfazlyev/dm/internal/repository"
+	"github.com/igorfazlyev/dm/pkg/securetoken"
+)
+
+const (
+	TokenPurposeEmailVerification = "email_verification"
+
+	emailVerificationTTL = 48 * time.Hour
+
+	// Resend throttling: one email per interval, a limited number per day
+	verificationResendInterval = time.Minute
+	verificationResendDaily    = 5
+)
+
+type VerifyEmailRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// sendVerificationEmail issues a fresh verification token and mails the link
+func (h *Handler) sendVerificationEmail(user *database.User) error {
+	token, err := h.createUserToken(user, TokenPurposeEmailVerification, emailVerificationTTL)
+	if err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/verify-email?token=%s", h.cfg.Server.FrontendURL, token)
+	mailer.SendAsync(h.mailer, mailer.Message{
+		To:      user.Email,
+		Subject: "Confirm your email address",
+		Body: fmt.Sprintf("Welcome to Dental Marketplace!\n\n"+
+			"Please confirm your email address by opening the link below. It expires in %d hours.\n\n%s",
+			int(emailVerificationTTL.Hours()), link),
+	})
+	return nil
+}
+
+// VerifyEmail confirms the email address using the token from the email
+func (h *Handler) VerifyEmail(c *gin.Context) {
+	var req VerifyEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	_, err := h.users.UseToken(securetoken.Hash(req.Token), TokenPurposeEmailVerification, map[string]any{"verified_at": time.Now()})
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or expired verification token"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to verify email"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "email verified"})
+}
+
+// ResendVerification sends a new verification email to the current user
+func (h *Handler) ResendVerification(c *gin.Context) {
+	userID, _ := rbac.GetUserID(c)
+
+	user, err := h.users.Get(userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+
+	if user.Email == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "account has no email address"})
+		return
+	}
+
+	if user.VerifiedAt != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "email is already verified"})
+		return
+	}
+
+	recent, err := h.users.RecentTokens(user.ID, TokenPurposeEmailVerification, time.Now().Add(-24*time.Hour))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to send verification email"})
+		return
+	}
+
+	if len(recent) >= verificationResendDaily {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many verification emails requested, try again tomorrow"})
+		return
+	}
+	if len(recent) > 0 {
+		if wait := verificationResendInterval - time.Since(recent[0].CreatedAt); wait > 0 {
+			c.Header("Retry-After", fmt.Sprintf("%d", int(wait.Seconds())+1))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "please wait before requesting another verification email"})
+			return
+		}
+	}
+
+	if err := h.sendVerificationEmail(user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to send verification email"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "verification email sent"})
+}
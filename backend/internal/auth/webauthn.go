@@ -0,0 +1,432 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
+	"github.com/igorfazlyev/dm/internal/config"
+	"github.com/igorfazlyev/dm/internal/database"
+	"github.com/igorfazlyev/dm/internal/rbac"
+	"github.com/igorfazlyev/dm/internal/repository"
+)
+
+const (
+	webauthnChallengeTTL = 5 * time.Minute
+
+	ceremonyRegistration = "registration"
+	ceremonyLogin        = "login"
+)
+
+var errInvalidChallenge = errors.New("invalid or expired challenge")
+
+type FinishPasskeyRegistrationRequest struct {
+	ChallengeID uuid.UUID       `json:"challenge_id" binding:"required"`
+	Name        string          `json:"name"`
+	Credential  json.RawMessage `json:"credential" binding:"required"` // PublicKeyCredential from navigator.credentials.create()
+}
+
+type BeginPasskeyLoginRequest struct {
+	Email string `json:"email"` // Optional; without it the browser offers discoverable passkeys
+}
+
+type FinishPasskeyLoginRequest struct {
+	ChallengeID uuid.UUID       `json:"challenge_id" binding:"required"`
+	Credential  json.RawMessage `json:"credential" binding:"required"` // PublicKeyCredential from navigator.credentials.get()
+}
+
+// NewPasskeys configures the WebAuthn relying party
+func NewPasskeys(cfg config.WebAuthnConfig) (*webauthn.WebAuthn, error) {
+	return webauthn.New(&webauthn.Config{
+		RPID:          cfg.RPID,
+		RPDisplayName: cfg.RPDisplayName,
+		RPOrigins:     cfg.RPOrigins,
+		AuthenticatorSelection: protocol.AuthenticatorSelection{
+			ResidentKey:      protocol.ResidentKeyRequirementPreferred,
+			UserVerification: protocol.VerificationRequired,
+		},
+	})
+}
+
+// passkeyUser adapts a user and their credentials to webauthn.User
+type passkeyUser struct {
+	user        *database.User
+	credentials []database.WebAuthnCredential
+}
+
+func (u *passkeyUser) WebAuthnID() []byte {
+	id := u.user.ID
+	return id[:]
+}
+
+func (u *passkeyUser) WebAuthnName() string {
+	if u.user.Email == "" && u.user.Phone != nil {
+		return *u.user.Phone
+	}
+	return u.user.Email
+}
+
+func (u *passkeyUser) WebAuthnDisplayName() string {
+	return u.WebAuthnName()
+}
+
+func (u *passkeyUser) WebAuthnCredentials() []webauthn.Credential {
+	credentials := make([]webauthn.Credential, 0, len(u.credentials))
+	for _, c := range u.credentials {
+		transports := make([]protocol.AuthenticatorTransport, 0, len(c.Transports))
+		for _, t := range c.Transports {
+			transports = append(transports, protocol.AuthenticatorTransport(t))
+		}
+
+		credentials = append(credentials, webauthn.Credential{
+			ID:              c.CredentialID,
+			PublicKey:       c.PublicKey,
+			AttestationType: c.AttestationType,
+			Transport:       transports,
+			Flags: webauthn.CredentialFlags{
+				UserPresent:    true,
+				UserVerified:   true,
+				BackupEligible: c.BackupEligible,
+				BackupState:    c.BackupState,
+			},
+			Authenticator: webauthn.Authenticator{
+				AAGUID:       c.AAGUID,
+				SignCount:    uint32(c.SignCount),
+				CloneWarning: c.CloneWarning,
+			},
+		})
+	}
+	return credentials
+}
+
+// loadPasskeyUser loads a user together with their registered passkeys
+func (h *Handler) loadPasskeyUser(userID uuid.UUID) (*passkeyUser, error) {
+	user, err := h.users.Get(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	credentials, err := h.logins.Passkeys(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &passkeyUser{user: user, credentials: credentials}, nil
+}
+
+// BeginPasskeyRegistration returns creation options for adding a passkey to
+// the current user's account
+func (h *Handler) BeginPasskeyRegistration(c *gin.Context) {
+	userID, _ := rbac.GetUserID(c)
+
+	pkUser, err := h.loadPasskeyUser(userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+
+	// Existing passkeys are excluded so the same authenticator is not registered twice
+	exclusions := webauthn.Credentials(pkUser.WebAuthnCredentials()).CredentialDescriptors()
+	options, session, err := h.passkeys.BeginRegistration(pkUser, webauthn.WithExclusions(exclusions))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start passkey registration"})
+		return
+	}
+
+	challengeID, err := h.saveChallenge(&userID, ceremonyRegistration, session)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start passkey registration"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"challenge_id": challengeID,
+		"options":      options,
+	})
+}
+
+// FinishPasskeyRegistration verifies the authenticator's response and stores
+// the new passkey
+func (h *Handler) FinishPasskeyRegistration(c *gin.Context) {
+	userID, _ := rbac.GetUserID(c)
+
+	var req FinishPasskeyRegistrationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	_, session, err := h.takeChallenge(req.ChallengeID, ceremonyRegistration, &userID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or expired challenge"})
+		return
+	}
+
+	parsed, err := protocol.ParseCredentialCreationResponseBytes(req.Credential)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid credential"})
+		return
+	}
+
+	pkUser, err := h.loadPasskeyUser(userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+
+	credential, err := h.passkeys.CreateCredential(pkUser, *session, parsed)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "passkey verification failed"})
+		return
+	}
+
+	transports := make([]string, 0, len(credential.Transport))
+	for _, t := range credential.Transport {
+		transports = append(transports, string(t))
+	}
+
+	name := req.Name
+	if name == "" {
+		name = "Passkey"
+	}
+
+	record := database.WebAuthnCredential{
+		UserID:          userID,
+		Name:            name,
+		CredentialID:    credential.ID,
+		PublicKey:       credential.PublicKey,
+		AttestationType: credential.AttestationType,
+		Transports:      transports,
+		AAGUID:          credential.Authenticator.AAGUID,
+		SignCount:       int64(credential.Authenticator.SignCount),
+		BackupEligible:  credential.Flags.BackupEligible,
+		BackupState:     credential.Flags.BackupState,
+	}
+	err = h.logins.AddPasskey(&record)
+	if errors.Is(err, repository.ErrConflict) {
+		c.JSON(http.StatusConflict, gin.H{"error": "passkey is already registered"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save passkey"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, record)
+}
+
+// ListPasskeys returns the current user's passkeys
+func (h *Handler) ListPasskeys(c *gin.Context) {
+	userID, _ := rbac.GetUserID(c)
+
+	credentials, err := h.logins.Passkeys(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch passkeys"})
+		return
+	}
+
+	c.JSON(http.StatusOK, credentials)
+}
+
+// DeletePasskey removes one of the current user's passkeys
+func (h *Handler) DeletePasskey(c *gin.Context) {
+	userID, _ := rbac.GetUserID(c)
+
+	credentialID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid passkey ID"})
+		return
+	}
+
+	err = h.logins.DeletePasskey(userID, credentialID)
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "passkey not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete passkey"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "passkey deleted"})
+}
+
+// BeginPasskeyLogin returns assertion options. With an email the options
+// list that account's passkeys; otherwise, or when the account is unknown,
+// the browser is asked for any discoverable passkey for this site.
+func (h *Handler) BeginPasskeyLogin(c *gin.Context) {
+	var req BeginPasskeyLoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var (
+		options *protocol.CredentialAssertion
+		session *webauthn.SessionData
+		userID  *uuid.UUID
+		err     error
+	)
+
+	if user := h.findPasskeyUser(req.Email); user != nil {
+		pkUser, loadErr := h.loadPasskeyUser(user.ID)
+		if loadErr == nil && len(pkUser.credentials) > 0 {
+			options, session, err = h.passkeys.BeginLogin(pkUser)
+			userID = &user.ID
+		}
+	}
+	if options == nil && err == nil {
+		options, session, err = h.passkeys.BeginDiscoverableLogin()
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start passkey login"})
+		return
+	}
+
+	challengeID, err := h.saveChallenge(userID, ceremonyLogin, session)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start passkey login"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"challenge_id": challengeID,
+		"options":      options,
+	})
+}
+
+// FinishPasskeyLogin verifies the assertion and issues a token pair. Passkey
+// logins require user verification, so they count as multi-factor.
+func (h *Handler) FinishPasskeyLogin(c *gin.Context) {
+	var req FinishPasskeyLoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	challenge, session, err := h.takeChallenge(req.ChallengeID, ceremonyLogin, nil)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired challenge"})
+		return
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBytes(req.Credential)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid credential"})
+		return
+	}
+
+	var (
+		pkUser     *passkeyUser
+		credential *webauthn.Credential
+	)
+	if challenge.UserID != nil {
+		pkUser, err = h.loadPasskeyUser(*challenge.UserID)
+		if err == nil {
+			credential, err = h.passkeys.ValidateLogin(pkUser, *session, parsed)
+		}
+	} else {
+		var user webauthn.User
+		user, credential, err = h.passkeys.ValidatePasskeyLogin(func(rawID, userHandle []byte) (webauthn.User, error) {
+			id, err := uuid.FromBytes(userHandle)
+			if err != nil {
+				return nil, err
+			}
+			return h.loadPasskeyUser(id)
+		}, *session, parsed)
+		if err == nil {
+			pkUser = user.(*passkeyUser)
+		}
+	}
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "passkey verification failed"})
+		return
+	}
+
+	if !pkUser.user.IsActive {
+		c.JSON(http.StatusForbidden, gin.H{"error": "account is inactive"})
+		return
+	}
+
+	if credential.Authenticator.CloneWarning {
+		log.Printf("Passkey sign counter went backwards for user %s, authenticator may be cloned", pkUser.user.ID)
+	}
+
+	if err := h.logins.UsePasskey(&database.WebAuthnCredential{
+		CredentialID: credential.ID,
+		SignCount:    int64(credential.Authenticator.SignCount),
+		CloneWarning: credential.Authenticator.CloneWarning,
+		BackupState:  credential.Flags.BackupState,
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate tokens"})
+		return
+	}
+
+	tokens, err := h.startSession(c, pkUser.user, true)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate tokens"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"user":   pkUser.user,
+		"tokens": tokens,
+	})
+}
+
+// findPasskeyUser returns the active account with an email, or nil
+func (h *Handler) findPasskeyUser(email string) *database.User {
+	if email == "" {
+		return nil
+	}
+	user, err := h.users.FindByEmail(email)
+	if err != nil || user.DeletedAt.Valid || !user.IsActive {
+		return nil
+	}
+	return user
+}
+
+// saveChallenge stores ceremony state until the matching finish call
+func (h *Handler) saveChallenge(userID *uuid.UUID, ceremony string, session *webauthn.SessionData) (uuid.UUID, error) {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	challenge := database.WebAuthnChallenge{
+		UserID:    userID,
+		Ceremony:  ceremony,
+		Data:      data,
+		ExpiresAt: time.Now().Add(webauthnChallengeTTL),
+	}
+	if err := h.logins.SaveChallenge(&challenge); err != nil {
+		return uuid.Nil, err
+	}
+
+	return challenge.ID, nil
+}
+
+// takeChallenge consumes a pending ceremony so its challenge cannot be
+// answered twice. A non-nil userID must match the user who started it.
+func (h *Handler) takeChallenge(id uuid.UUID, ceremony string, userID *uuid.UUID) (*database.WebAuthnChallenge, *webauthn.SessionData, error) {
+	challenge, err := h.logins.TakeChallenge(id, ceremony, userID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, nil, errInvalidChallenge
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var session webauthn.SessionData
+	if err := json.Unmarshal(challenge.Data, &session); err != nil {
+		return nil, nil, errInvalidChallenge
+	}
+
+	return challenge, &session, nil
+}
@@ -0,0 +1,121 @@
+package clinics
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/igorfazlyev/dm/internal/database"
+	"github.com/igorfazlyev/dm/internal/rbac"
+	"github.com/igorfazlyev/dm/internal/repository"
+)
+
+type CreateAPIKeyRequest struct {
+	Name          string   `json:"name" binding:"required"`
+	Scopes        []string `json:"scopes" binding:"required,min=1"`
+	ExpiresInDays int      `json:"expires_in_days"` // 0 means no expiry
+}
+
+// CreateAPIKey issues an API key for the manager's clinic. The key is only
+// returned in this response.
+func (h *Handler) CreateAPIKey(c *gin.Context) {
+	userID, _ := rbac.GetUserID(c)
+
+	var req CreateAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	for _, scope := range req.Scopes {
+		if !rbac.KnownScopes[scope] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unknown scope " + scope})
+			return
+		}
+	}
+	if req.ExpiresInDays < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expires_in_days must not be negative"})
+		return
+	}
+
+	clinic, err := h.policy.CurrentClinic(c)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "clinic not found"})
+		return
+	}
+
+	key, prefix, hash, err := rbac.GenerateAPIKey()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate API key"})
+		return
+	}
+
+	apiKey := database.APIKey{
+		ClinicID:    clinic.ID,
+		CreatedByID: userID,
+		Name:        req.Name,
+		Prefix:      prefix,
+		KeyHash:     hash,
+		Scopes:      req.Scopes,
+	}
+	if req.ExpiresInDays > 0 {
+		expiresAt := time.Now().AddDate(0, 0, req.ExpiresInDays)
+		apiKey.ExpiresAt = &expiresAt
+	}
+
+	if err := h.clinics.CreateAPIKey(&apiKey); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create API key"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"api_key": apiKey,
+		"key":     key,
+	})
+}
+
+// ListAPIKeys returns the API keys of the manager's clinic
+func (h *Handler) ListAPIKeys(c *gin.Context) {
+	clinic, err := h.policy.CurrentClinic(c)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "clinic not found"})
+		return
+	}
+
+	keys, err := h.clinics.APIKeys(clinic.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch API keys"})
+		return
+	}
+
+	c.JSON(http.StatusOK, keys)
+}
+
+// RevokeAPIKey permanently disables an API key
+func (h *Handler) RevokeAPIKey(c *gin.Context) {
+	keyID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid API key ID"})
+		return
+	}
+
+	clinic, err := h.policy.CurrentClinic(c)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "clinic not found"})
+		return
+	}
+
+	err = h.clinics.RevokeAPIKey(clinic.ID, keyID)
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke API key"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "API key revoked"})
+}
@@ -1,18 +1,31 @@
 package clinics
 
 import (
+	"errors"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/igorfazlyev/dm/internal/config"
 	"github.com/igorfazlyev/dm/internal/database"
+	"github.com/igorfazlyev/dm/internal/etag"
+	"github.com/igorfazlyev/dm/internal/mailer"
+	"github.com/igorfazlyev/dm/internal/policy"
 	"github.com/igorfazlyev/dm/internal/rbac"
+	"github.com/igorfazlyev/dm/internal/repository"
+	"github.com/igorfazlyev/dm/pkg/money"
 )
 
-type Handler struct{}
+type Handler struct {
+	cfg     *config.Config
+	mailer  mailer.Sender
+	users   repository.Users
+	clinics repository.Clinics
+	policy  *policy.Policy
+}
 
-func NewHandler() *Handler {
-	return &Handler{}
+func NewHandler(cfg *config.Config, mailSender mailer.Sender, users repository.Users, clinics repository.Clinics, accessPolicy *policy.Policy) *Handler {
+	return &Handler{cfg: cfg, mailer: mailSender, users: users, clinics: clinics, policy: accessPolicy}
 }
 
 type CreateClinicRequest struct {
@@ -27,6 +40,7 @@ type CreateClinicRequest struct {
 	Email           string `json:"email"`
 	Website         string `json:"website"`
 	PriceSegment    string `json:"price_segment"`
+	Currency        string `json:"currency"` // ISO 4217, RUB if not given
 }
 
 type UpdateClinicRequest struct {
@@ -49,13 +63,22 @@ func (h *Handler) CreateClinic(c *gin.Context) {
 		return
 	}
 
-	// Check if clinic already exists for this user
-	var existing database.Clinic
-	if err := database.DB.Where("user_id = ?", userID).First(&existing).Error; err == nil {
+	// Staff belong to one clinic only
+	if _, err := h.clinics.ForMember(userID); err == nil {
 		c.JSON(http.StatusConflict, gin.H{"error": "clinic profile already exists"})
 		return
 	}
 
+	currency := database.DefaultCurrency
+	if req.Currency != "" {
+		code, err := money.NormalizeCurrency(req.Currency)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		currency = code
+	}
+
 	clinic := database.Clinic{
 		UserID:          userID,
 		Name:            req.Name,
@@ -69,10 +92,12 @@ func (h *Handler) CreateClinic(c *gin.Context) {
 		Email:           req.Email,
 		Website:         req.Website,
 		PriceSegment:    req.PriceSegment,
+		Currency:        currency,
 		IsActive:        false, // Requires admin approval
+		Status:          "pending",
 	}
 
-	if err := database.DB.Create(&clinic).Error; err != nil {
+	if err := h.clinics.Create(&clinic, userID); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create clinic"})
 		return
 	}
@@ -80,34 +105,35 @@ func (h *Handler) CreateClinic(c *gin.Context) {
 	c.JSON(http.StatusCreated, clinic)
 }
 
-// GetMyClinic returns the current user's clinic
+// GetMyClinic returns the clinic the current user works for
 func (h *Handler) GetMyClinic(c *gin.Context) {
-	userID, _ := rbac.GetUserID(c)
-
-	var clinic database.Clinic
-	if err := database.DB.Where("user_id = ?", userID).First(&clinic).Error; err != nil {
+	clinic, err := h.policy.CurrentClinic(c)
+	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "clinic not found"})
 		return
 	}
 
+	etag.Set(c, clinic.Version)
 	c.JSON(http.StatusOK, clinic)
 }
 
-// UpdateMyClinic updates the current user's clinic
+// UpdateMyClinic updates the clinic the current user works for. Send the
+// ETag of the clinic as If-Match to avoid overwriting someone else's changes.
 func (h *Handler) UpdateMyClinic(c *gin.Context) {
-	userID, _ := rbac.GetUserID(c)
-
 	var req UpdateClinicRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	var clinic database.Clinic
-	if err := database.DB.Where("user_id = ?", userID).First(&clinic).Error; err != nil {
+	clinic, err := h.policy.CurrentClinic(c)
+	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "clinic not found"})
 		return
 	}
+	if !etag.Check(c, clinic.Version) {
+		return
+	}
 
 	// Update fields
 	if req.Name != "" {
@@ -132,34 +158,26 @@ func (h *Handler) UpdateMyClinic(c *gin.Context) {
 		clinic.PriceSegment = req.PriceSegment
 	}
 
-	if err := database.DB.Save(&clinic).Error; err != nil {
+	if err := h.clinics.Save(clinic); errors.Is(err, repository.ErrConflict) {
+		etag.Conflict(c)
+		return
+	} else if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update clinic"})
 		return
 	}
 
+	etag.Set(c, clinic.Version)
 	c.JSON(http.StatusOK, clinic)
 }
 
 // ListClinics returns all active clinics (with filters)
 func (h *Handler) ListClinics(c *gin.Context) {
-	city := c.Query("city")
-	district := c.Query("district")
-	priceSegment := c.Query("price_segment")
-
-	query := database.DB.Where("is_active = ?", true)
-
-	if city != "" {
-		query = query.Where("city = ?", city)
-	}
-	if district != "" {
-		query = query.Where("district = ?", district)
-	}
-	if priceSegment != "" {
-		query = query.Where("price_segment = ?", priceSegment)
-	}
-
-	var clinics []database.Clinic
-	if err := query.Find(&clinics).Error; err != nil {
+	clinics, err := h.clinics.ListActive(repository.ClinicFilter{
+		City:         c.Query("city"),
+		District:     c.Query("district"),
+		PriceSegment: c.Query("price_segment"),
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch clinics"})
 		return
 	}
@@ -176,8 +194,8 @@ func (h *Handler) GetClinic(c *gin.Context) {
 		return
 	}
 
-	var clinic database.Clinic
-	if err := database.DB.Where("id = ?", clinicID).First(&clinic).Error; err != nil {
+	clinic, err := h.clinics.Get(clinicID)
+	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "clinic not found"})
 		return
 	}
@@ -188,17 +206,16 @@ func (h *Handler) GetClinic(c *gin.Context) {
 // Pricelist handlers
 
 type AddPriceItemRequest struct {
-	Specialty     string  `json:"specialty" binding:"required"`
-	ProcedureCode string  `json:"procedure_code"`
-	ProcedureName string  `json:"procedure_name" binding:"required"`
-	PriceFrom     float64 `json:"price_from" binding:"required"`
-	PriceTo       float64 `json:"price_to"`
+	Specialty     string        `json:"specialty" binding:"required"`
+	ProcedureCode string        `json:"procedure_code"`
+	ProcedureName string        `json:"procedure_name" binding:"required"`
+	PriceFrom     money.Decimal `json:"price_from"`
+	PriceTo       money.Decimal `json:"price_to"`
+	Currency      string        `json:"currency"` // Defaults to the clinic's
 }
 
 // AddPriceItem adds an item to clinic's pricelist
 func (h *Handler) AddPriceItem(c *gin.Context) {
-	userID, _ := rbac.GetUserID(c)
-
 	var req AddPriceItemRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -206,23 +223,42 @@ func (h *Handler) AddPriceItem(c *gin.Context) {
 	}
 
 	// Get clinic
-	var clinic database.Clinic
-	if err := database.DB.Where("user_id = ?", userID).First(&clinic).Error; err != nil {
+	clinic, err := h.policy.CurrentClinic(c)
+	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "clinic not found"})
 		return
 	}
 
+	priceFrom, err := clinic.Price(req.PriceFrom, req.Currency)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	priceTo, err := clinic.Price(req.PriceTo, req.Currency)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if priceFrom.Sign() <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "price_from must be positive"})
+		return
+	}
+	if !priceTo.IsZero() && priceTo.Amount.Cmp(priceFrom.Amount) < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "price_to must not be less than price_from"})
+		return
+	}
+
 	priceItem := database.PriceListItem{
 		ClinicID:      clinic.ID,
 		Specialty:     req.Specialty,
 		ProcedureCode: req.ProcedureCode,
 		ProcedureName: req.ProcedureName,
-		PriceFrom:     req.PriceFrom,
-		PriceTo:       req.PriceTo,
+		PriceFrom:     priceFrom,
+		PriceTo:       priceTo,
 		IsActive:      true,
 	}
 
-	if err := database.DB.Create(&priceItem).Error; err != nil {
+	if err := h.clinics.AddPriceItem(&priceItem); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to add price item"})
 		return
 	}
@@ -232,18 +268,14 @@ func (h *Handler) AddPriceItem(c *gin.Context) {
 
 // GetMyPricelist returns the current clinic's pricelist
 func (h *Handler) GetMyPricelist(c *gin.Context) {
-	userID, _ := rbac.GetUserID(c)
-
-	var clinic database.Clinic
-	if err := database.DB.Where("user_id = ?", userID).First(&clinic).Error; err != nil {
+	clinic, err := h.policy.CurrentClinic(c)
+	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "clinic not found"})
 		return
 	}
 
-	var priceList []database.PriceListItem
-	if err := database.DB.Where("clinic_id = ? AND is_active = ?", clinic.ID, true).
-		Order("specialty, procedure_name").
-		Find(&priceList).Error; err != nil {
+	priceList, err := h.clinics.PriceList(clinic.ID)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch pricelist"})
 		return
 	}
@@ -253,7 +285,6 @@ func (h *Handler) GetMyPricelist(c *gin.Context) {
 
 // DeletePriceItem soft deletes a price item
 func (h *Handler) DeletePriceItem(c *gin.Context) {
-	userID, _ := rbac.GetUserID(c)
 	itemIDParam := c.Param("id")
 
 	itemID, err := uuid.Parse(itemIDParam)
@@ -263,26 +294,21 @@ func (h *Handler) DeletePriceItem(c *gin.Context) {
 	}
 
 	// Get clinic
-	var clinic database.Clinic
-	if err := database.DB.Where("user_id = ?", userID).First(&clinic).Error; err != nil {
+	clinic, err := h.policy.CurrentClinic(c)
+	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "clinic not found"})
 		return
 	}
 
 	// Verify ownership and deactivate
-	result := database.DB.Model(&database.PriceListItem{}).
-		Where("id = ? AND clinic_id = ?", itemID, clinic.ID).
-		Update("is_active", false)
-
-	if result.Error != nil {
+	if err := h.clinics.DeactivatePriceItem(clinic.ID, itemID); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "item not found"})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete item"})
 		return
 	}
 
-	if result.RowsAffected == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "item not found"})
-		return
-	}
-
 	c.JSON(http.StatusOK, gin.H{"message": "price item deleted"})
 }
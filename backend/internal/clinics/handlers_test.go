@@ -0,0 +1,163 @@
+package clinics
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/igorfazlyev/dm/internal/config"
+	"github.com/igorfazlyev/dm/internal/database"
+	"github.com/igorfazlyev/dm/internal/etag"
+	"github.com/igorfazlyev/dm/internal/policy"
+	"github.com/igorfazlyev/dm/internal/rbac"
+	"github.com/igorfazlyev/dm/internal/repository/memory"
+)
+
+// clinicFixture is a clinic with a manager and the handler serving it
+type clinicFixture struct {
+	store   *memory.Store
+	handler *Handler
+	clinic  database.Clinic
+	manager uuid.UUID
+}
+
+func newClinicFixture(t *testing.T) *clinicFixture {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	store := memory.NewStore()
+	f := &clinicFixture{store: store}
+
+	manager := database.User{Email: "manager@example.com", Role: rbac.RoleClinicManager, IsActive: true}
+	store.AddUser(&manager)
+	f.manager = manager.ID
+
+	f.clinic = database.Clinic{Name: "Smile", Address: "1 Main St", Phone: "+70000000000", Status: "approved", IsActive: true}
+	if err := store.Clinics.Create(&f.clinic, manager.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	f.handler = NewHandler(&config.Config{}, nil, store.Users, store.Clinics, policy.New(&store.Store))
+	return f
+}
+
+// serve sends a request for target to handler, routed at route, as the user
+// with role
+func serve(handler gin.HandlerFunc, method, route, target string, userID uuid.UUID, role string, body any, header http.Header) *httptest.ResponseRecorder {
+	router := gin.New()
+	router.Handle(method, route, func(c *gin.Context) {
+		c.Set("user_id", userID)
+		c.Set("user_role", role)
+	}, handler)
+
+	payload, _ := json.Marshal(body)
+	req := httptest.NewRequest(method, target, strings.NewReader(string(payload)))
+	req.Header.Set("Content-Type", "application/json")
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestUpdateMyClinic(t *testing.T) {
+	tests := []struct {
+		name    string
+		ifMatch func(version int) string
+		status  int
+		updated bool
+	}{
+		{"current version", func(v int) string { return etag.Format(v) }, http.StatusOK, true},
+		{"no If-Match", func(int) string { return "" }, http.StatusOK, true},
+		{"one of several tags", func(v int) string { return `"99", ` + etag.Format(v) }, http.StatusOK, true},
+		{"stale version", func(v int) string { return etag.Format(v - 1) }, http.StatusConflict, false},
+		{"weak tag", func(v int) string { return "W/" + etag.Format(v) }, http.StatusConflict, false},
+		{"malformed", func(int) string { return "1" }, http.StatusBadRequest, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newClinicFixture(t)
+			version := f.clinic.Version
+
+			header := http.Header{}
+			if tag := tt.ifMatch(version); tag != "" {
+				header.Set("If-Match", tag)
+			}
+			w := serve(f.handler.UpdateMyClinic, http.MethodPut, "/clinics/me", "/clinics/me", f.manager, rbac.RoleClinicManager,
+				UpdateClinicRequest{Name: "Smile Plus", Website: "https://smile.example"}, header)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+
+			stored, err := f.store.Clinics.Get(f.clinic.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.updated {
+				if stored.Name != "Smile" || stored.Version != version {
+					t.Errorf("clinic changed to %q at version %d", stored.Name, stored.Version)
+				}
+				return
+			}
+
+			if stored.Name != "Smile Plus" || stored.Website != "https://smile.example" || stored.Address != "1 Main St" {
+				t.Errorf("stored clinic = %q %q %q", stored.Name, stored.Website, stored.Address)
+			}
+			if stored.Version != version+1 {
+				t.Errorf("version = %d, want %d", stored.Version, version+1)
+			}
+			if got := w.Header().Get("ETag"); got != etag.Format(stored.Version) {
+				t.Errorf("ETag = %s, want %s", got, etag.Format(stored.Version))
+			}
+		})
+	}
+}
+
+func TestUpdateMyClinicWithoutClinic(t *testing.T) {
+	f := newClinicFixture(t)
+
+	doctor := database.User{Email: "doctor@example.com", Role: rbac.RoleClinicDoctor, IsActive: true}
+	f.store.AddUser(&doctor)
+
+	w := serve(f.handler.UpdateMyClinic, http.MethodPut, "/clinics/me", "/clinics/me", doctor.ID, rbac.RoleClinicDoctor,
+		UpdateClinicRequest{Name: "Hijacked"}, nil)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("status = %d, want 404: %s", w.Code, w.Body)
+	}
+	if stored, _ := f.store.Clinics.Get(f.clinic.ID); stored.Name != "Smile" {
+		t.Errorf("clinic renamed to %q", stored.Name)
+	}
+}
+
+func TestRemoveMemberKeepsAManager(t *testing.T) {
+	f := newClinicFixture(t)
+
+	doctor := database.User{Email: "doctor@example.com", Role: rbac.RoleClinicDoctor, IsActive: true}
+	f.store.AddUser(&doctor)
+	f.store.AddClinicMember(&database.ClinicMember{ClinicID: f.clinic.ID, UserID: doctor.ID, Role: rbac.RoleClinicDoctor})
+
+	remove := func(userID uuid.UUID) int {
+		return serve(f.handler.RemoveMember, http.MethodDelete, "/clinics/me/members/:user_id",
+			"/clinics/me/members/"+userID.String(), f.manager, rbac.RoleClinicManager, nil, nil).Code
+	}
+
+	if code := remove(f.manager); code != http.StatusConflict {
+		t.Errorf("removing the only manager: status %d, want 409", code)
+	}
+	if code := remove(uuid.New()); code != http.StatusNotFound {
+		t.Errorf("removing a stranger: status %d, want 404", code)
+	}
+	if code := remove(doctor.ID); code != http.StatusOK {
+		t.Errorf("removing the doctor: status %d, want 200", code)
+	}
+
+	members, _ := f.store.Clinics.Members(f.clinic.ID)
+	if len(members) != 1 || members[0].UserID != f.manager {
+		t.Errorf("members = %+v, want the manager only", members)
+	}
+}
@@ -0,0 +1,244 @@
+package clinics
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/igorfazlyev/dm/internal/database"
+	"github.com/igorfazlyev/dm/internal/mailer"
+	"github.com/igorfazlyev/dm/internal/rbac"
+	"github.com/igorfazlyev/dm/internal/repository"
+	"github.com/igorfazlyev/dm/pkg/securetoken"
+)
+
+const invitationTTL = 7 * 24 * time.Hour
+
+var (
+	errInvalidInvitation = errors.New("invalid or expired invitation")
+	errAlreadyMember     = errors.New("already a member of a clinic")
+)
+
+type InviteStaffRequest struct {
+	Email string `json:"email" binding:"required,email"`
+	Role  string `json:"role" binding:"required,oneof=clinic_doctor clinic_manager"`
+}
+
+type AcceptInvitationRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// ListMembers returns the staff of the current user's clinic
+func (h *Handler) ListMembers(c *gin.Context) {
+	clinic, err := h.policy.CurrentClinic(c)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "clinic not found"})
+		return
+	}
+
+	members, err := h.clinics.Members(clinic.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch members"})
+		return
+	}
+
+	c.JSON(http.StatusOK, members)
+}
+
+// RemoveMember takes a staff member out of the clinic. The last manager
+// cannot be removed.
+func (h *Handler) RemoveMember(c *gin.Context) {
+	memberUserID, err := uuid.Parse(c.Param("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+		return
+	}
+
+	clinic, err := h.policy.CurrentClinic(c)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "clinic not found"})
+		return
+	}
+
+	err = h.clinics.RemoveMember(clinic.ID, memberUserID)
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "member not found"})
+		return
+	}
+	if errors.Is(err, repository.ErrLastManager) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to remove member"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "member removed"})
+}
+
+// InviteStaff emails an invitation to join the current user's clinic
+func (h *Handler) InviteStaff(c *gin.Context) {
+	userID, _ := rbac.GetUserID(c)
+
+	var req InviteStaffRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	clinic, err := h.policy.CurrentClinic(c)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "clinic not found"})
+		return
+	}
+
+	token, hash, err := securetoken.Generate()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create invitation"})
+		return
+	}
+
+	invitation := database.ClinicInvitation{
+		ClinicID:    clinic.ID,
+		Email:       strings.ToLower(req.Email),
+		Role:        req.Role,
+		TokenHash:   hash,
+		InvitedByID: userID,
+		ExpiresAt:   time.Now().Add(invitationTTL),
+	}
+
+	// A new invitation replaces any pending one for the same address
+	if err := h.clinics.Invite(&invitation); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create invitation"})
+		return
+	}
+
+	link := fmt.Sprintf("%s/clinic/invitations/accept?token=%s", h.cfg.Server.FrontendURL, token)
+	mailer.SendAsync(h.mailer, mailer.Message{
+		To:      invitation.Email,
+		Subject: fmt.Sprintf("Join %s on Dental Marketplace", clinic.Name),
+		Body: fmt.Sprintf("You have been invited to join %s on Dental Marketplace.\n\n"+
+			"Sign in or register with this email address, then open the link below. It expires in %d days.\n\n%s",
+			clinic.Name, int(invitationTTL.Hours()/24), link),
+	})
+
+	c.JSON(http.StatusCreated, invitation)
+}
+
+// ListInvitations returns the pending invitations of the current user's clinic
+func (h *Handler) ListInvitations(c *gin.Context) {
+	clinic, err := h.policy.CurrentClinic(c)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "clinic not found"})
+		return
+	}
+
+	invitations, err := h.clinics.Invitations(clinic.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch invitations"})
+		return
+	}
+
+	c.JSON(http.StatusOK, invitations)
+}
+
+// RevokeInvitation cancels a pending invitation
+func (h *Handler) RevokeInvitation(c *gin.Context) {
+	invitationID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid invitation ID"})
+		return
+	}
+
+	clinic, err := h.policy.CurrentClinic(c)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "clinic not found"})
+		return
+	}
+
+	err = h.clinics.RevokeInvitation(clinic.ID, invitationID)
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "invitation not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke invitation"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "invitation revoked"})
+}
+
+// AcceptInvitation adds the current user to the inviting clinic. The account
+// must use the invited email address and role.
+func (h *Handler) AcceptInvitation(c *gin.Context) {
+	userID, _ := rbac.GetUserID(c)
+
+	var req AcceptInvitationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	member, err := h.acceptInvitation(userID, req.Token)
+	if errors.Is(err, errInvalidInvitation) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, errAlreadyMember) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to accept invitation"})
+		return
+	}
+
+	c.JSON(http.StatusOK, member)
+}
+
+// acceptInvitation adds the user to the staff of the clinic that sent the
+// token
+func (h *Handler) acceptInvitation(userID uuid.UUID, token string) (*database.ClinicMember, error) {
+	invitation, err := h.clinics.Invitation(securetoken.Hash(token))
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, errInvalidInvitation
+	}
+	if err != nil {
+		return nil, err
+	}
+	if invitation.AcceptedAt != nil || invitation.RevokedAt != nil || time.Now().After(invitation.ExpiresAt) {
+		return nil, errInvalidInvitation
+	}
+
+	user, err := h.users.Get(userID)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.EqualFold(user.Email, invitation.Email) || user.Role != invitation.Role {
+		return nil, errInvalidInvitation
+	}
+
+	if _, err := h.clinics.Membership(userID); err == nil {
+		return nil, errAlreadyMember
+	} else if !errors.Is(err, repository.ErrNotFound) {
+		return nil, err
+	}
+
+	member := &database.ClinicMember{
+		ClinicID: invitation.ClinicID,
+		UserID:   userID,
+		Role:     invitation.Role,
+	}
+	// Another request may have used the invitation since it was read
+	err = h.clinics.AcceptInvitation(invitation, member)
+	if errors.Is(err, repository.ErrConflict) {
+		return nil, errInvalidInvitation
+	}
+	return member, err
+}
@@ -1,8 +1,11 @@
 package config
 
 import (
+	"errors"
+	"fmt"
 	"os"
-	"strconv"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/joho/godotenv"
@@ -13,13 +16,23 @@ type Config struct {
 	Database  DatabaseConfig
 	JWT       JWTConfig
 	Diagnocat DiagnocatConfig
+	Mail      MailConfig
+	WebAuthn  WebAuthnConfig
+	SMS       SMSConfig
+	RateLimit RateLimitConfig
+
+	sources map[string]string // Where each setting's value came from, by key
 }
 
 type ServerConfig struct {
 	Port            string
-	Environment     string
-	AllowedOrigins  []string
+	Environment     string   // development, staging, production
+	AllowedOrigins  []string // CORS origins; "*" allows any origin without credentials
+	TrustedProxies  []string // IPs or CIDRs of proxies whose X-Forwarded-For is believed; none by default
+	FrontendURL     string   // Base URL for links sent to users
 	MaxUploadSizeMB int64
+	UploadDir       string        // Where unfinished resumable uploads are kept; shared between instances
+	UploadExpiry    time.Duration // How long a resumable upload may sit idle before it is removed
 }
 
 type DatabaseConfig struct {
@@ -32,51 +45,176 @@ type DatabaseConfig struct {
 }
 
 type JWTConfig struct {
-	Secret          string
-	AccessTokenTTL  time.Duration
-	RefreshTokenTTL time.Duration
+	Secret              string        // HS256 secret; with an asymmetric algorithm only used to verify tokens from before the switch
+	Algorithm           string        // HS256, RS256, EdDSA
+	KeyRotationInterval time.Duration // How long an asymmetric key signs before it is replaced
+	AccessTokenTTL      time.Duration
+	RefreshTokenTTL     time.Duration
 }
 
 type DiagnocatConfig struct {
-	APIKey string
+	BaseURL  string
+	APIKey   string // Preferred; otherwise Email and Password are exchanged for a token
+	Email    string
+	Password string
 }
 
-func Load() *Config {
+type WebAuthnConfig struct {
+	RPID          string // Relying party ID, the site's domain without scheme or port
+	RPDisplayName string
+	RPOrigins     []string // Origins allowed to perform ceremonies
+}
+
+type RateLimitConfig struct {
+	Store string // memory, postgres
+}
+
+type SMSConfig struct {
+	Driver       string // log, http
+	From         string // Sender name or number, if the gateway supports it
+	GatewayURL   string
+	GatewayToken string
+}
+
+type MailConfig struct {
+	Driver       string // smtp, file
+	From         string
+	SMTPHost     string
+	SMTPPort     int
+	SMTPUsername string
+	SMTPPassword string
+	OutboxDir    string // Used by the file driver
+}
+
+// setting is one configuration value with its file key, environment
+// variable and default
+type setting struct {
+	key    string // Dotted key in the config file
+	env    string
+	def    string
+	secret bool // Also read from the file named by <env>_FILE, and redacted when printed
+	value  value
+}
+
+func (c *Config) settings() []setting {
+	return []setting{
+		{key: "server.port", env: "PORT", def: "8080", value: (*stringValue)(&c.Server.Port)},
+		{key: "server.environment", env: "ENVIRONMENT", def: "development", value: (*stringValue)(&c.Server.Environment)},
+		{key: "server.frontend_url", env: "FRONTEND_URL", def: "http://localhost:3000", value: (*stringValue)(&c.Server.FrontendURL)},
+		{key: "server.allowed_origins", env: "ALLOWED_ORIGINS", value: (*listValue)(&c.Server.AllowedOrigins)},
+		{key: "server.trusted_proxies", env: "TRUSTED_PROXIES", value: (*listValue)(&c.Server.TrustedProxies)},
+		{key: "server.max_upload_size_mb", env: "MAX_UPLOAD_SIZE_MB", def: "500", value: (*int64Value)(&c.Server.MaxUploadSizeMB)},
+		{key: "server.upload_dir", env: "UPLOAD_DIR", def: filepath.Join(os.TempDir(), "dm-uploads"), value: (*stringValue)(&c.Server.UploadDir)},
+		{key: "server.upload_expiry", env: "UPLOAD_EXPIRY", def: "24h", value: (*durationValue)(&c.Server.UploadExpiry)},
+
+		{key: "database.host", env: "DB_HOST", def: "localhost", value: (*stringValue)(&c.Database.Host)},
+		{key: "database.port", env: "DB_PORT", def: "5432", value: (*intValue)(&c.Database.Port)},
+		{key: "database.user", env: "DB_USER", def: "postgres", value: (*stringValue)(&c.Database.User)},
+		{key: "database.password", env: "DB_PASSWORD", def: "postgres", secret: true, value: (*stringValue)(&c.Database.Password)},
+		{key: "database.name", env: "DB_NAME", def: "dental_marketplace", value: (*stringValue)(&c.Database.DBName)},
+		{key: "database.sslmode", env: "DB_SSLMODE", def: "disable", value: (*stringValue)(&c.Database.SSLMode)},
+
+		{key: "jwt.secret", env: "JWT_SECRET", def: defaultJWTSecret, secret: true, value: (*stringValue)(&c.JWT.Secret)},
+		{key: "jwt.algorithm", env: "JWT_ALGORITHM", def: "RS256", value: (*stringValue)(&c.JWT.Algorithm)},
+		{key: "jwt.key_rotation_interval", env: "JWT_KEY_ROTATION_INTERVAL", def: "720h", value: (*durationValue)(&c.JWT.KeyRotationInterval)},
+		{key: "jwt.access_token_ttl", env: "JWT_ACCESS_TOKEN_TTL", def: "15m", value: (*durationValue)(&c.JWT.AccessTokenTTL)},
+		{key: "jwt.refresh_token_ttl", env: "JWT_REFRESH_TOKEN_TTL", def: "168h", value: (*durationValue)(&c.JWT.RefreshTokenTTL)},
+
+		{key: "diagnocat.base_url", env: "DIAGNOCAT_API_URL", def: "https://app2.diagnocat.ru/partner-api", value: (*stringValue)(&c.Diagnocat.BaseURL)},
+		{key: "diagnocat.api_key", env: "DIAGNOCAT_API_KEY", secret: true, value: (*stringValue)(&c.Diagnocat.APIKey)},
+		{key: "diagnocat.email", env: "DIAGNOCAT_EMAIL", value: (*stringValue)(&c.Diagnocat.Email)},
+		{key: "diagnocat.password", env: "DIAGNOCAT_PASSWORD", secret: true, value: (*stringValue)(&c.Diagnocat.Password)},
+
+		{key: "mail.driver", env: "MAIL_DRIVER", def: "file", value: (*stringValue)(&c.Mail.Driver)},
+		{key: "mail.from", env: "MAIL_FROM", def: "Dental Marketplace <no-reply@localhost>", value: (*stringValue)(&c.Mail.From)},
+		{key: "mail.outbox_dir", env: "MAIL_OUTBOX_DIR", def: "tmp/outbox", value: (*stringValue)(&c.Mail.OutboxDir)},
+		{key: "mail.smtp_host", env: "SMTP_HOST", value: (*stringValue)(&c.Mail.SMTPHost)},
+		{key: "mail.smtp_port", env: "SMTP_PORT", def: "587", value: (*intValue)(&c.Mail.SMTPPort)},
+		{key: "mail.smtp_username", env: "SMTP_USERNAME", value: (*stringValue)(&c.Mail.SMTPUsername)},
+		{key: "mail.smtp_password", env: "SMTP_PASSWORD", secret: true, value: (*stringValue)(&c.Mail.SMTPPassword)},
+
+		{key: "rate_limit.store", env: "RATE_LIMIT_STORE", def: "memory", value: (*stringValue)(&c.RateLimit.Store)},
+
+		{key: "sms.driver", env: "SMS_DRIVER", def: "log", value: (*stringValue)(&c.SMS.Driver)},
+		{key: "sms.from", env: "SMS_FROM", value: (*stringValue)(&c.SMS.From)},
+		{key: "sms.gateway_url", env: "SMS_GATEWAY_URL", value: (*stringValue)(&c.SMS.GatewayURL)},
+		{key: "sms.gateway_token", env: "SMS_GATEWAY_TOKEN", secret: true, value: (*stringValue)(&c.SMS.GatewayToken)},
+
+		{key: "webauthn.rp_id", env: "WEBAUTHN_RP_ID", def: "localhost", value: (*stringValue)(&c.WebAuthn.RPID)},
+		{key: "webauthn.rp_name", env: "WEBAUTHN_RP_NAME", def: "Dental Marketplace", value: (*stringValue)(&c.WebAuthn.RPDisplayName)},
+		{key: "webauthn.rp_origins", env: "WEBAUTHN_RP_ORIGINS", value: (*listValue)(&c.WebAuthn.RPOrigins)},
+	}
+}
+
+// Load builds the configuration in layers: defaults, then the YAML or TOML
+// file named by CONFIG_FILE, then environment variables. Secrets can also be
+// read from the file named by their variable with a _FILE suffix, for
+// mounted secrets. Load only reports values that cannot be parsed; call
+// Validate to check the result makes sense.
+func Load() (*Config, error) {
 	// Load .env file if exists (for local dev)
 	godotenv.Load()
 
-	dbPort, _ := strconv.Atoi(getEnv("DB_PORT", "5432"))
-	maxUploadMB, _ := strconv.ParseInt(getEnv("MAX_UPLOAD_SIZE_MB", "500"), 10, 64)
-
-	return &Config{
-		Server: ServerConfig{
-			Port:            getEnv("PORT", "8080"),
-			Environment:     getEnv("ENVIRONMENT", "development"),
-			AllowedOrigins:  []string{getEnv("FRONTEND_URL", "http://localhost:3000")},
-			MaxUploadSizeMB: maxUploadMB,
-		},
-		Database: DatabaseConfig{
-			Host:     getEnv("DB_HOST", "localhost"),
-			Port:     dbPort,
-			User:     getEnv("DB_USER", "postgres"),
-			Password: getEnv("DB_PASSWORD", "postgres"),
-			DBName:   getEnv("DB_NAME", "dental_marketplace"),
-			SSLMode:  getEnv("DB_SSLMODE", "disable"),
-		},
-		JWT: JWTConfig{
-			Secret:          getEnv("JWT_SECRET", "change-me-in-production"),
-			AccessTokenTTL:  24 * time.Hour,
-			RefreshTokenTTL: 7 * 24 * time.Hour,
-		},
-		Diagnocat: DiagnocatConfig{
-			APIKey: os.Getenv("DIAGNOCAT_API_KEY"),
-		},
+	cfg := &Config{sources: map[string]string{}}
+	settings := cfg.settings()
+
+	values := make(map[string]string, len(settings))
+	for _, s := range settings {
+		values[s.key] = s.def
+		cfg.sources[s.key] = "default"
+	}
+
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		fileValues, err := readFile(path)
+		if err != nil {
+			return nil, err
+		}
+		known := make(map[string]bool, len(settings))
+		for _, s := range settings {
+			known[s.key] = true
+		}
+		for key, value := range fileValues {
+			if !known[key] {
+				return nil, fmt.Errorf("%s: unknown setting %q", path, key)
+			}
+			values[key] = value
+			cfg.sources[key] = path
+		}
+	}
+
+	var errs []error
+	for _, s := range settings {
+		if value := os.Getenv(s.env); value != "" {
+			values[s.key] = value
+			cfg.sources[s.key] = "env " + s.env
+		}
+		if path := os.Getenv(s.env + "_FILE"); path != "" && s.secret {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s_FILE: %w", s.env, err))
+				continue
+			}
+			values[s.key] = strings.TrimRight(string(data), "\r\n")
+			cfg.sources[s.key] = "file " + path
+		}
+	}
+
+	for _, s := range settings {
+		if err := s.value.Set(values[s.key]); err != nil {
+			errs = append(errs, fmt.Errorf("%s (%s): %w", s.key, cfg.sources[s.key], err))
+		}
+	}
+
+	// Both default to the frontend, which is where browsers call from
+	if len(cfg.Server.AllowedOrigins) == 0 {
+		cfg.Server.AllowedOrigins = []string{cfg.Server.FrontendURL}
+	}
+	if len(cfg.WebAuthn.RPOrigins) == 0 {
+		cfg.WebAuthn.RPOrigins = []string{cfg.Server.FrontendURL}
 	}
-}
 
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
 	}
-	return defaultValue
+	return cfg, nil
 }
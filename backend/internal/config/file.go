@@ -0,0 +1,73 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/goccy/go-yaml"
+	"github.com/pelletier/go-toml/v2"
+)
+
+// readFile parses a YAML or TOML config file into settings keyed by their
+// dotted path, so that
+//
+//	server:
+//	  port: 8080
+//
+// becomes "server.port" = "8080". Lists are joined with commas.
+func readFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var tree map[string]any
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &tree)
+	case ".toml":
+		err = toml.Unmarshal(data, &tree)
+	default:
+		return nil, fmt.Errorf("unsupported config file type %q, use .yaml, .yml or .toml", ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	values := map[string]string{}
+	if err := flatten(values, "", tree); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return values, nil
+}
+
+func flatten(values map[string]string, prefix string, tree map[string]any) error {
+	for key, node := range tree {
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+
+		switch node := node.(type) {
+		case map[string]any:
+			if err := flatten(values, key, node); err != nil {
+				return err
+			}
+		case []any:
+			items := make([]string, len(node))
+			for i, item := range node {
+				if _, nested := item.(map[string]any); nested {
+					return fmt.Errorf("%s: lists may only hold plain values", key)
+				}
+				items[i] = fmt.Sprint(item)
+			}
+			values[key] = strings.Join(items, ",")
+		case nil:
+			values[key] = ""
+		default:
+			values[key] = fmt.Sprint(node)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,21 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+)
+
+// Print writes the effective value of every setting and where it came from.
+// Secrets are masked unless showSecrets is set.
+func (c *Config) Print(w io.Writer, showSecrets bool) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, s := range c.settings() {
+		value := s.value.String()
+		if !showSecrets && s.secret && value != "" {
+			value = "[redacted]"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t(%s)\n", s.key, value, c.sources[s.key])
+	}
+	return tw.Flush()
+}
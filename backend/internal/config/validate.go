@@ -0,0 +1,141 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// defaultJWTSecret is only good enough for local development
+const defaultJWTSecret = "change-me-in-production"
+
+// placeholderSecrets are values copied from examples that must not be used
+var placeholderSecrets = []string{
+	defaultJWTSecret,
+	"your-super-secret-jwt-key-change-in-production",
+}
+
+// HasPlaceholderSecret reports whether the secret is one published in the
+// examples, which anyone could sign tokens with
+func (c JWTConfig) HasPlaceholderSecret() bool {
+	return slices.Contains(placeholderSecrets, c.Secret)
+}
+
+// minJWTSecretLength is the shortest HS256 secret accepted in production
+const minJWTSecretLength = 32
+
+// Validate reports every problem with the configuration at once. Production
+// additionally refuses development defaults that would be unsafe there.
+func (c *Config) Validate() error {
+	var errs []error
+	fail := func(key, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s: %s", key, fmt.Sprintf(format, args...)))
+	}
+
+	if !slices.Contains([]string{"development", "staging", "production"}, c.Server.Environment) {
+		fail("server.environment", "must be development, staging or production, got %q", c.Server.Environment)
+	}
+	if port, err := strconv.Atoi(c.Server.Port); err != nil || port < 1 || port > 65535 {
+		fail("server.port", "must be a port number, got %q", c.Server.Port)
+	}
+	if !strings.HasPrefix(c.Server.FrontendURL, "http://") && !strings.HasPrefix(c.Server.FrontendURL, "https://") {
+		fail("server.frontend_url", "must be an http or https URL")
+	}
+	for _, origin := range c.Server.AllowedOrigins {
+		if origin != "*" && !strings.HasPrefix(origin, "http://") && !strings.HasPrefix(origin, "https://") {
+			fail("server.allowed_origins", "%q must be * or an http or https origin", origin)
+		}
+	}
+	for _, proxy := range c.Server.TrustedProxies {
+		if _, err := netip.ParsePrefix(proxy); err != nil {
+			if _, err := netip.ParseAddr(proxy); err != nil {
+				fail("server.trusted_proxies", "%q must be an IP address or CIDR", proxy)
+			}
+		}
+	}
+	if c.Server.MaxUploadSizeMB < 1 {
+		fail("server.max_upload_size_mb", "must be positive")
+	}
+	if c.Server.UploadDir == "" {
+		fail("server.upload_dir", "is required")
+	}
+	if c.Server.UploadExpiry <= 0 {
+		fail("server.upload_expiry", "must be positive")
+	}
+
+	if c.Database.Port < 1 || c.Database.Port > 65535 {
+		fail("database.port", "must be a port number, got %d", c.Database.Port)
+	}
+
+	if !slices.Contains([]string{"HS256", "RS256", "EdDSA"}, c.JWT.Algorithm) {
+		fail("jwt.algorithm", "must be HS256, RS256 or EdDSA, got %q", c.JWT.Algorithm)
+	}
+	if c.JWT.Secret == "" {
+		fail("jwt.secret", "is required")
+	}
+	if c.JWT.KeyRotationInterval <= 0 {
+		fail("jwt.key_rotation_interval", "must be positive")
+	}
+	if c.JWT.AccessTokenTTL <= 0 {
+		fail("jwt.access_token_ttl", "must be positive")
+	}
+	if c.JWT.RefreshTokenTTL <= c.JWT.AccessTokenTTL {
+		fail("jwt.refresh_token_ttl", "must be longer than the access token TTL")
+	}
+
+	switch c.Mail.Driver {
+	case "smtp":
+		if c.Mail.SMTPHost == "" {
+			fail("mail.smtp_host", "is required by the smtp driver")
+		}
+		if c.Mail.SMTPPort < 1 || c.Mail.SMTPPort > 65535 {
+			fail("mail.smtp_port", "must be a port number, got %d", c.Mail.SMTPPort)
+		}
+	case "file":
+		if c.Mail.OutboxDir == "" {
+			fail("mail.outbox_dir", "is required by the file driver")
+		}
+	default:
+		fail("mail.driver", "must be smtp or file, got %q", c.Mail.Driver)
+	}
+
+	switch c.SMS.Driver {
+	case "log":
+	case "http":
+		if c.SMS.GatewayURL == "" {
+			fail("sms.gateway_url", "is required by the http driver")
+		}
+	default:
+		fail("sms.driver", "must be log or http, got %q", c.SMS.Driver)
+	}
+
+	if !slices.Contains([]string{"memory", "postgres"}, c.RateLimit.Store) {
+		fail("rate_limit.store", "must be memory or postgres, got %q", c.RateLimit.Store)
+	}
+
+	if c.WebAuthn.RPID == "" {
+		fail("webauthn.rp_id", "is required")
+	}
+
+	if c.Server.Environment == "production" {
+		// The secret signs with HS256 and verifies legacy tokens for a while
+		// after switching away from it, so a guessable one lets anyone forge
+		// tokens
+		if c.JWT.HasPlaceholderSecret() {
+			fail("jwt.secret", "must be changed from the example value in production")
+		} else if len(c.JWT.Secret) < minJWTSecretLength {
+			fail("jwt.secret", "must be at least %d characters in production", minJWTSecretLength)
+		}
+		if slices.Contains(c.Server.AllowedOrigins, "*") {
+			fail("server.allowed_origins", "must list the allowed origins in production, not *")
+		}
+		if c.Diagnocat.APIKey == "" && (c.Diagnocat.Email == "" || c.Diagnocat.Password == "") {
+			fail("diagnocat", "api_key, or email and password, are required in production")
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -39,21 +39,3 @@ func Connect(cfg *config.Config) error {
 	log.Println("Database connected successfully")
 	return nil
 }
-
-func AutoMigrate() error {
-	return DB.AutoMigrate(
-		&User{},
-		&Patient{},
-		&Study{},
-		&PlanVersion{},
-		&PlanItem{},
-		&Clinic{},
-		&PriceListItem{},
-		&OfferRequest{},
-		&Offer{},
-		&Order{},
-		&Slot{},
-		&JobQueue{},
-		&AuditLog{},
-	)
-}
@@ -0,0 +1,246 @@
+package database
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Migrations are pairs of SQL files named <version>_<name>.up.sql and
+// <version>_<name>.down.sql, applied in version order. Each runs in its own
+// transaction. The models do not create or change tables; every model change
+// needs a migration.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+var migrationName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// migrationLock serializes migrators across instances through a Postgres
+// advisory lock
+const migrationLock = 7203948571
+
+// ErrSchemaBehind means migrations embedded in the binary are not applied
+var ErrSchemaBehind = errors.New("database schema is behind")
+
+// Migration is one embedded schema change
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationState is a migration and when it was applied, if it was
+type MigrationState struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// SchemaMigration records an applied migration
+type SchemaMigration struct {
+	Version   int       `gorm:"primaryKey;autoIncrement:false"`
+	Name      string    `gorm:"not null"`
+	AppliedAt time.Time `gorm:"not null"`
+}
+
+// Migrations returns the embedded migrations in version order
+func Migrations() ([]Migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		match := migrationName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("migration %s is not named <version>_<name>.up.sql or .down.sql", entry.Name())
+		}
+
+		version, _ := strconv.Atoi(match[1])
+		data, err := migrationFiles.ReadFile("migrations/" + entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has two names, %s and %s", version, m.Name, match[2])
+		}
+		if match[3] == "up" {
+			m.Up = string(data)
+		} else {
+			m.Down = string(data)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s needs both an up and a down file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// MigrationStatus returns every embedded migration with when it was applied
+func MigrationStatus() ([]MigrationState, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+
+	applied, err := appliedMigrations(DB)
+	if err != nil {
+		return nil, err
+	}
+
+	states := make([]MigrationState, len(migrations))
+	for i, m := range migrations {
+		states[i].Migration = m
+		if record, ok := applied[m.Version]; ok {
+			states[i].AppliedAt = &record.AppliedAt
+		}
+	}
+	return states, nil
+}
+
+// CheckSchema returns ErrSchemaBehind if any embedded migration has not been
+// applied. A schema ahead of the binary is accepted so older instances keep
+// serving during a rolling deploy.
+func CheckSchema() error {
+	states, err := MigrationStatus()
+	if err != nil {
+		return err
+	}
+
+	var pending int
+	for _, state := range states {
+		if state.AppliedAt == nil {
+			pending++
+		}
+	}
+	if pending > 0 {
+		return fmt.Errorf("%w: %d pending migrations, run the migrate up command", ErrSchemaBehind, pending)
+	}
+	return nil
+}
+
+// MigrateUp applies every pending migration and returns those it applied
+func MigrateUp() ([]Migration, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for _, m := range migrations {
+		ran, err := runMigration(m, true)
+		if err != nil {
+			return done, fmt.Errorf("migration %d_%s: %w", m.Version, m.Name, err)
+		}
+		if ran {
+			done = append(done, m)
+		}
+	}
+	return done, nil
+}
+
+// MigrateDown reverts the last steps applied migrations and returns those it
+// reverted
+func MigrateDown(steps int) ([]Migration, error) {
+	states, err := MigrationStatus()
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for i := len(states) - 1; i >= 0 && len(done) < steps; i-- {
+		if states[i].AppliedAt == nil {
+			continue
+		}
+		m := states[i].Migration
+		ran, err := runMigration(m, false)
+		if err != nil {
+			return done, fmt.Errorf("migration %d_%s: %w", m.Version, m.Name, err)
+		}
+		if ran {
+			done = append(done, m)
+		}
+	}
+	return done, nil
+}
+
+// runMigration applies or reverts m unless another migrator got there first
+func runMigration(m Migration, up bool) (bool, error) {
+	ran := false
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", migrationLock).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+			version bigint PRIMARY KEY,
+			name text NOT NULL,
+			applied_at timestamptz NOT NULL
+		)`).Error; err != nil {
+			return err
+		}
+
+		applied, err := appliedMigrations(tx)
+		if err != nil {
+			return err
+		}
+		if _, ok := applied[m.Version]; ok == up {
+			return nil
+		}
+
+		if up {
+			if err := tx.Exec(m.Up).Error; err != nil {
+				return err
+			}
+			ran = true
+			return tx.Create(&SchemaMigration{Version: m.Version, Name: m.Name, AppliedAt: time.Now()}).Error
+		}
+
+		if err := tx.Exec(m.Down).Error; err != nil {
+			return err
+		}
+		ran = true
+		return tx.Delete(&SchemaMigration{}, m.Version).Error
+	})
+	return ran, err
+}
+
+// appliedMigrations returns the applied migrations by version. Nothing is
+// applied until the first migration creates schema_migrations.
+func appliedMigrations(db *gorm.DB) (map[int]SchemaMigration, error) {
+	if !db.Migrator().HasTable(&SchemaMigration{}) {
+		return map[int]SchemaMigration{}, nil
+	}
+
+	var records []SchemaMigration
+	if err := db.Find(&records).Error; err != nil {
+		return nil, err
+	}
+
+	applied := make(map[int]SchemaMigration, len(records))
+	for _, record := range records {
+		applied[record.Version] = record
+	}
+	return applied, nil
+}
@@ -0,0 +1,32 @@
+DROP TABLE IF EXISTS audit_logs;
+DROP TABLE IF EXISTS job_queues;
+DROP TABLE IF EXISTS slots;
+DROP TABLE IF EXISTS orders;
+DROP TABLE IF EXISTS offers;
+DROP TABLE IF EXISTS offer_requests;
+DROP TABLE IF EXISTS study_access_logs;
+DROP TABLE IF EXISTS study_grants;
+DROP TABLE IF EXISTS plan_annotations;
+DROP TABLE IF EXISTS plan_items;
+DROP TABLE IF EXISTS plan_versions;
+DROP TABLE IF EXISTS studies;
+DROP TABLE IF EXISTS patients;
+DROP TABLE IF EXISTS price_list_items;
+DROP TABLE IF EXISTS api_keys;
+DROP TABLE IF EXISTS clinic_invitations;
+DROP TABLE IF EXISTS clinic_notes;
+DROP TABLE IF EXISTS clinic_members;
+DROP TABLE IF EXISTS clinics;
+DROP TABLE IF EXISTS login_attempts;
+DROP TABLE IF EXISTS login_lockouts;
+DROP TABLE IF EXISTS rate_limit_buckets;
+DROP TABLE IF EXISTS signing_keys;
+DROP TABLE IF EXISTS phone_otps;
+DROP TABLE IF EXISTS web_authn_challenges;
+DROP TABLE IF EXISTS web_authn_credentials;
+DROP TABLE IF EXISTS mfa_policies;
+DROP TABLE IF EXISTS mfa_recovery_codes;
+DROP TABLE IF EXISTS user_tokens;
+DROP TABLE IF EXISTS refresh_tokens;
+DROP TABLE IF EXISTS sessions;
+DROP TABLE IF EXISTS users;
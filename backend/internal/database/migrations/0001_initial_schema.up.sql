@@ -0,0 +1,571 @@
+-- Schema as created by GORM's AutoMigrate before versioned migrations. It
+-- only creates what is missing, so databases AutoMigrate already set up can
+-- apply it without changes. Tables AutoMigrate created lack the columns added
+-- since, which are added before anything indexes or reads them.
+
+CREATE TABLE IF NOT EXISTS users (
+    id uuid DEFAULT gen_random_uuid(),
+    email text NOT NULL,
+    phone text,
+    password_hash text NOT NULL,
+    role text NOT NULL,
+    is_active boolean DEFAULT true,
+    verified_at timestamptz,
+    phone_verified_at timestamptz,
+    mfa_secret text,
+    mfa_enabled_at timestamptz,
+    mfa_last_step bigint,
+    created_at timestamptz,
+    updated_at timestamptz,
+    deleted_at timestamptz,
+    PRIMARY KEY (id)
+);
+-- Accounts created before email verification existed count as verified;
+-- ones created since that never verified stay unverified
+DO $$
+BEGIN
+    IF NOT EXISTS (
+        SELECT 1 FROM information_schema.columns
+        WHERE table_schema = current_schema() AND table_name = 'users' AND column_name = 'verified_at'
+    ) THEN
+        ALTER TABLE users ADD COLUMN verified_at timestamptz;
+        UPDATE users SET verified_at = created_at;
+    END IF;
+END $$;
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS phone text,
+    ADD COLUMN IF NOT EXISTS phone_verified_at timestamptz,
+    ADD COLUMN IF NOT EXISTS mfa_secret text,
+    ADD COLUMN IF NOT EXISTS mfa_enabled_at timestamptz,
+    ADD COLUMN IF NOT EXISTS mfa_last_step bigint;
+CREATE INDEX IF NOT EXISTS idx_users_deleted_at ON users (deleted_at);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email_present ON users (email) WHERE email <> '';
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_phone ON users (phone);
+CREATE INDEX IF NOT EXISTS idx_users_role ON users (role);
+
+CREATE TABLE IF NOT EXISTS sessions (
+    id uuid,
+    user_id uuid NOT NULL,
+    user_agent text,
+    ip_address text,
+    mfa boolean DEFAULT false,
+    last_used_at timestamptz NOT NULL,
+    expires_at timestamptz NOT NULL,
+    revoked_at timestamptz,
+    created_at timestamptz,
+    PRIMARY KEY (id),
+    CONSTRAINT fk_sessions_user FOREIGN KEY (user_id) REFERENCES users(id)
+);
+CREATE INDEX IF NOT EXISTS idx_sessions_expires_at ON sessions (expires_at);
+CREATE INDEX IF NOT EXISTS idx_sessions_revoked_at ON sessions (revoked_at);
+CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions (user_id);
+
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+    id uuid,
+    user_id uuid NOT NULL,
+    session_id uuid NOT NULL,
+    expires_at timestamptz NOT NULL,
+    rotated_at timestamptz,
+    replaced_by_id uuid,
+    revoked_at timestamptz,
+    created_at timestamptz,
+    PRIMARY KEY (id),
+    CONSTRAINT fk_refresh_tokens_user FOREIGN KEY (user_id) REFERENCES users(id),
+    CONSTRAINT fk_refresh_tokens_session FOREIGN KEY (session_id) REFERENCES sessions(id)
+);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_session_id ON refresh_tokens (session_id);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_user_id ON refresh_tokens (user_id);
+
+CREATE TABLE IF NOT EXISTS user_tokens (
+    id uuid DEFAULT gen_random_uuid(),
+    user_id uuid NOT NULL,
+    purpose text NOT NULL,
+    token_hash text NOT NULL,
+    expires_at timestamptz NOT NULL,
+    used_at timestamptz,
+    created_at timestamptz,
+    PRIMARY KEY (id),
+    CONSTRAINT fk_user_tokens_user FOREIGN KEY (user_id) REFERENCES users(id)
+);
+CREATE INDEX IF NOT EXISTS idx_user_tokens_purpose ON user_tokens (purpose);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_user_tokens_token_hash ON user_tokens (token_hash);
+CREATE INDEX IF NOT EXISTS idx_user_tokens_user_id ON user_tokens (user_id);
+
+CREATE TABLE IF NOT EXISTS mfa_recovery_codes (
+    id uuid DEFAULT gen_random_uuid(),
+    user_id uuid NOT NULL,
+    code_hash text NOT NULL,
+    used_at timestamptz,
+    created_at timestamptz,
+    PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS idx_mfa_recovery_codes_user_id ON mfa_recovery_codes (user_id);
+
+CREATE TABLE IF NOT EXISTS mfa_policies (
+    role text,
+    required boolean NOT NULL DEFAULT false,
+    updated_by_id uuid,
+    updated_at timestamptz,
+    PRIMARY KEY (role)
+);
+
+CREATE TABLE IF NOT EXISTS web_authn_credentials (
+    id uuid DEFAULT gen_random_uuid(),
+    user_id uuid NOT NULL,
+    name text,
+    credential_id bytea NOT NULL,
+    public_key bytea NOT NULL,
+    attestation_type text,
+    transports jsonb,
+    aa_guid bytea,
+    sign_count bigint NOT NULL DEFAULT 0,
+    clone_warning boolean DEFAULT false,
+    backup_eligible boolean DEFAULT false,
+    backup_state boolean DEFAULT false,
+    last_used_at timestamptz,
+    created_at timestamptz,
+    PRIMARY KEY (id),
+    CONSTRAINT fk_web_authn_credentials_user FOREIGN KEY (user_id) REFERENCES users(id)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_web_authn_credentials_credential_id ON web_authn_credentials (credential_id);
+CREATE INDEX IF NOT EXISTS idx_web_authn_credentials_user_id ON web_authn_credentials (user_id);
+
+CREATE TABLE IF NOT EXISTS web_authn_challenges (
+    id uuid DEFAULT gen_random_uuid(),
+    user_id uuid,
+    ceremony text NOT NULL,
+    data bytea NOT NULL,
+    expires_at timestamptz NOT NULL,
+    created_at timestamptz,
+    PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS idx_web_authn_challenges_expires_at ON web_authn_challenges (expires_at);
+CREATE INDEX IF NOT EXISTS idx_web_authn_challenges_user_id ON web_authn_challenges (user_id);
+
+CREATE TABLE IF NOT EXISTS phone_otps (
+    id uuid DEFAULT gen_random_uuid(),
+    phone text NOT NULL,
+    code_hash text NOT NULL,
+    attempts bigint NOT NULL DEFAULT 0,
+    expires_at timestamptz NOT NULL,
+    consumed_at timestamptz,
+    ip_address text,
+    created_at timestamptz,
+    PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS idx_phone_otps_created_at ON phone_otps (created_at);
+CREATE INDEX IF NOT EXISTS idx_phone_otps_phone ON phone_otps (phone);
+
+CREATE TABLE IF NOT EXISTS signing_keys (
+    id text,
+    algorithm text NOT NULL,
+    private_key bytea NOT NULL,
+    activates_at timestamptz NOT NULL,
+    retires_at timestamptz NOT NULL,
+    expires_at timestamptz NOT NULL,
+    created_at timestamptz,
+    PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS idx_signing_keys_expires_at ON signing_keys (expires_at);
+
+CREATE TABLE IF NOT EXISTS rate_limit_buckets (
+    "key" text,
+    tokens decimal NOT NULL,
+    updated_at timestamptz NOT NULL,
+    expires_at timestamptz,
+    PRIMARY KEY ("key")
+);
+CREATE INDEX IF NOT EXISTS idx_rate_limit_buckets_expires_at ON rate_limit_buckets (expires_at);
+
+CREATE TABLE IF NOT EXISTS login_lockouts (
+    identifier text,
+    failed_count bigint NOT NULL DEFAULT 0,
+    locked_until timestamptz,
+    last_failed_at timestamptz,
+    updated_at timestamptz,
+    PRIMARY KEY (identifier)
+);
+CREATE INDEX IF NOT EXISTS idx_login_lockouts_locked_until ON login_lockouts (locked_until);
+
+CREATE TABLE IF NOT EXISTS login_attempts (
+    id uuid DEFAULT gen_random_uuid(),
+    identifier text NOT NULL,
+    user_id uuid,
+    reason text NOT NULL,
+    ip_address text,
+    user_agent text,
+    created_at timestamptz,
+    PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS idx_login_attempts_created_at ON login_attempts (created_at);
+CREATE INDEX IF NOT EXISTS idx_login_attempts_identifier ON login_attempts (identifier);
+CREATE INDEX IF NOT EXISTS idx_login_attempts_user_id ON login_attempts (user_id);
+
+CREATE TABLE IF NOT EXISTS clinics (
+    id uuid DEFAULT gen_random_uuid(),
+    user_id uuid,
+    name text NOT NULL,
+    legal_name text,
+    license_number text,
+    year_established bigint,
+    city text NOT NULL,
+    district text,
+    address text,
+    phone text,
+    email text,
+    website text,
+    price_segment text,
+    is_active boolean DEFAULT false,
+    status text NOT NULL DEFAULT 'pending',
+    status_reason text,
+    reviewed_at timestamptz,
+    reviewed_by_id uuid,
+    created_at timestamptz,
+    updated_at timestamptz,
+    deleted_at timestamptz,
+    PRIMARY KEY (id),
+    CONSTRAINT fk_users_clinic FOREIGN KEY (user_id) REFERENCES users(id)
+);
+ALTER TABLE clinics
+    ADD COLUMN IF NOT EXISTS status text NOT NULL DEFAULT 'pending',
+    ADD COLUMN IF NOT EXISTS status_reason text,
+    ADD COLUMN IF NOT EXISTS reviewed_at timestamptz,
+    ADD COLUMN IF NOT EXISTS reviewed_by_id uuid;
+CREATE INDEX IF NOT EXISTS idx_clinics_city ON clinics (city);
+CREATE INDEX IF NOT EXISTS idx_clinics_deleted_at ON clinics (deleted_at);
+CREATE INDEX IF NOT EXISTS idx_clinics_district ON clinics (district);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_clinics_license_number ON clinics (license_number);
+CREATE INDEX IF NOT EXISTS idx_clinics_status ON clinics (status);
+CREATE INDEX IF NOT EXISTS idx_clinics_user_id ON clinics (user_id);
+
+CREATE TABLE IF NOT EXISTS clinic_members (
+    id uuid DEFAULT gen_random_uuid(),
+    clinic_id uuid NOT NULL,
+    user_id uuid NOT NULL,
+    role text NOT NULL,
+    created_at timestamptz,
+    PRIMARY KEY (id),
+    CONSTRAINT fk_clinic_members_clinic FOREIGN KEY (clinic_id) REFERENCES clinics(id),
+    CONSTRAINT fk_clinic_members_user FOREIGN KEY (user_id) REFERENCES users(id)
+);
+CREATE INDEX IF NOT EXISTS idx_clinic_members_clinic_id ON clinic_members (clinic_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_clinic_members_user_id ON clinic_members (user_id);
+
+CREATE TABLE IF NOT EXISTS clinic_notes (
+    id uuid DEFAULT gen_random_uuid(),
+    clinic_id uuid NOT NULL,
+    author_id uuid NOT NULL,
+    body text NOT NULL,
+    created_at timestamptz,
+    PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS idx_clinic_notes_clinic_id ON clinic_notes (clinic_id);
+
+CREATE TABLE IF NOT EXISTS clinic_invitations (
+    id uuid DEFAULT gen_random_uuid(),
+    clinic_id uuid NOT NULL,
+    email text NOT NULL,
+    role text NOT NULL,
+    token_hash text NOT NULL,
+    invited_by_id uuid NOT NULL,
+    expires_at timestamptz NOT NULL,
+    accepted_at timestamptz,
+    revoked_at timestamptz,
+    created_at timestamptz,
+    PRIMARY KEY (id),
+    CONSTRAINT fk_clinic_invitations_clinic FOREIGN KEY (clinic_id) REFERENCES clinics(id)
+);
+CREATE INDEX IF NOT EXISTS idx_clinic_invitations_clinic_id ON clinic_invitations (clinic_id);
+CREATE INDEX IF NOT EXISTS idx_clinic_invitations_email ON clinic_invitations (email);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_clinic_invitations_token_hash ON clinic_invitations (token_hash);
+
+CREATE TABLE IF NOT EXISTS api_keys (
+    id uuid DEFAULT gen_random_uuid(),
+    clinic_id uuid NOT NULL,
+    created_by_id uuid NOT NULL,
+    name text NOT NULL,
+    prefix text NOT NULL,
+    key_hash text NOT NULL,
+    scopes jsonb,
+    last_used_at timestamptz,
+    last_used_ip text,
+    expires_at timestamptz,
+    revoked_at timestamptz,
+    created_at timestamptz,
+    PRIMARY KEY (id),
+    CONSTRAINT fk_api_keys_clinic FOREIGN KEY (clinic_id) REFERENCES clinics(id)
+);
+CREATE INDEX IF NOT EXISTS idx_api_keys_clinic_id ON api_keys (clinic_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_api_keys_key_hash ON api_keys (key_hash);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_api_keys_prefix ON api_keys (prefix);
+
+CREATE TABLE IF NOT EXISTS price_list_items (
+    id uuid DEFAULT gen_random_uuid(),
+    clinic_id uuid NOT NULL,
+    specialty text NOT NULL,
+    procedure_code text,
+    procedure_name text NOT NULL,
+    price_from decimal,
+    price_to decimal,
+    is_active boolean DEFAULT true,
+    created_at timestamptz,
+    updated_at timestamptz,
+    PRIMARY KEY (id),
+    CONSTRAINT fk_clinics_price_list_items FOREIGN KEY (clinic_id) REFERENCES clinics(id)
+);
+CREATE INDEX IF NOT EXISTS idx_price_list_items_clinic_id ON price_list_items (clinic_id);
+CREATE INDEX IF NOT EXISTS idx_price_list_items_procedure_code ON price_list_items (procedure_code);
+CREATE INDEX IF NOT EXISTS idx_price_list_items_specialty ON price_list_items (specialty);
+
+CREATE TABLE IF NOT EXISTS patients (
+    id uuid DEFAULT gen_random_uuid(),
+    user_id uuid,
+    first_name text NOT NULL,
+    last_name text NOT NULL,
+    date_of_birth timestamptz,
+    phone text,
+    diagnocat_patient_id text,
+    preferred_city text,
+    preferred_district text,
+    preferred_price_segment text,
+    created_at timestamptz,
+    updated_at timestamptz,
+    deleted_at timestamptz,
+    PRIMARY KEY (id),
+    CONSTRAINT fk_users_patient FOREIGN KEY (user_id) REFERENCES users(id)
+);
+CREATE INDEX IF NOT EXISTS idx_patients_deleted_at ON patients (deleted_at);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_patients_diagnocat_patient_id ON patients (diagnocat_patient_id);
+CREATE INDEX IF NOT EXISTS idx_patients_user_id ON patients (user_id);
+
+CREATE TABLE IF NOT EXISTS studies (
+    id uuid DEFAULT gen_random_uuid(),
+    patient_id uuid NOT NULL,
+    diagnocat_study_uid text,
+    diagnocat_session_id text,
+    diagnocat_report_url text,
+    status text NOT NULL DEFAULT 'created',
+    modality text,
+    study_date text,
+    uploaded_at timestamptz,
+    completed_at timestamptz,
+    error_message text,
+    diagnocat_result_json jsonb,
+    diagnocat_report_pdf bytea,
+    created_at timestamptz,
+    updated_at timestamptz,
+    deleted_at timestamptz,
+    PRIMARY KEY (id),
+    CONSTRAINT fk_patients_studies FOREIGN KEY (patient_id) REFERENCES patients(id)
+);
+CREATE INDEX IF NOT EXISTS idx_studies_deleted_at ON studies (deleted_at);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_studies_diagnocat_study_uid ON studies (diagnocat_study_uid);
+CREATE INDEX IF NOT EXISTS idx_studies_patient_id ON studies (patient_id);
+CREATE INDEX IF NOT EXISTS idx_studies_status ON studies (status);
+
+CREATE TABLE IF NOT EXISTS plan_versions (
+    id uuid DEFAULT gen_random_uuid(),
+    study_id uuid NOT NULL,
+    version bigint NOT NULL,
+    source text DEFAULT 'diagnocat',
+    created_at timestamptz,
+    PRIMARY KEY (id),
+    CONSTRAINT fk_studies_plan_versions FOREIGN KEY (study_id) REFERENCES studies(id)
+);
+CREATE INDEX IF NOT EXISTS idx_plan_versions_study_id ON plan_versions (study_id);
+
+CREATE TABLE IF NOT EXISTS plan_items (
+    id uuid DEFAULT gen_random_uuid(),
+    plan_version_id uuid NOT NULL,
+    tooth_number bigint,
+    specialty text NOT NULL,
+    procedure_code text,
+    procedure_name text NOT NULL,
+    diagnosis text,
+    quantity bigint DEFAULT 1,
+    notes text,
+    metadata jsonb,
+    created_at timestamptz,
+    PRIMARY KEY (id),
+    CONSTRAINT fk_plan_versions_plan_items FOREIGN KEY (plan_version_id) REFERENCES plan_versions(id),
+    CONSTRAINT chk_plan_items_tooth_number CHECK (tooth_number >= 11 AND tooth_number <= 48)
+);
+CREATE INDEX IF NOT EXISTS idx_plan_items_plan_version_id ON plan_items (plan_version_id);
+CREATE INDEX IF NOT EXISTS idx_plan_items_specialty ON plan_items (specialty);
+
+CREATE TABLE IF NOT EXISTS plan_annotations (
+    id uuid DEFAULT gen_random_uuid(),
+    plan_version_id uuid NOT NULL,
+    plan_item_id uuid,
+    clinic_id uuid NOT NULL,
+    author_id uuid NOT NULL,
+    body text NOT NULL,
+    created_at timestamptz,
+    PRIMARY KEY (id),
+    CONSTRAINT fk_plan_annotations_clinic FOREIGN KEY (clinic_id) REFERENCES clinics(id)
+);
+CREATE INDEX IF NOT EXISTS idx_plan_annotations_clinic_id ON plan_annotations (clinic_id);
+CREATE INDEX IF NOT EXISTS idx_plan_annotations_plan_version_id ON plan_annotations (plan_version_id);
+
+CREATE TABLE IF NOT EXISTS study_grants (
+    id uuid DEFAULT gen_random_uuid(),
+    study_id uuid NOT NULL,
+    patient_id uuid NOT NULL,
+    clinic_id uuid NOT NULL,
+    offer_request_id uuid,
+    scopes jsonb,
+    expires_at timestamptz NOT NULL,
+    revoked_at timestamptz,
+    created_at timestamptz,
+    PRIMARY KEY (id),
+    CONSTRAINT fk_study_grants_clinic FOREIGN KEY (clinic_id) REFERENCES clinics(id)
+);
+CREATE INDEX IF NOT EXISTS idx_study_grants_clinic_id ON study_grants (clinic_id);
+CREATE INDEX IF NOT EXISTS idx_study_grants_offer_request_id ON study_grants (offer_request_id);
+CREATE INDEX IF NOT EXISTS idx_study_grants_patient_id ON study_grants (patient_id);
+CREATE INDEX IF NOT EXISTS idx_study_grants_study_id ON study_grants (study_id);
+
+CREATE TABLE IF NOT EXISTS study_access_logs (
+    id uuid DEFAULT gen_random_uuid(),
+    grant_id uuid NOT NULL,
+    study_id uuid NOT NULL,
+    clinic_id uuid NOT NULL,
+    user_id uuid NOT NULL,
+    action text NOT NULL,
+    ip_address text,
+    created_at timestamptz,
+    PRIMARY KEY (id),
+    CONSTRAINT fk_study_access_logs_clinic FOREIGN KEY (clinic_id) REFERENCES clinics(id)
+);
+CREATE INDEX IF NOT EXISTS idx_study_access_logs_created_at ON study_access_logs (created_at);
+CREATE INDEX IF NOT EXISTS idx_study_access_logs_grant_id ON study_access_logs (grant_id);
+CREATE INDEX IF NOT EXISTS idx_study_access_logs_study_id ON study_access_logs (study_id);
+
+CREATE TABLE IF NOT EXISTS offer_requests (
+    id uuid DEFAULT gen_random_uuid(),
+    patient_id uuid NOT NULL,
+    plan_version_id uuid NOT NULL,
+    selected_item_ids jsonb,
+    preferred_city text,
+    preferred_district text,
+    price_segment text,
+    status text DEFAULT 'open',
+    created_at timestamptz,
+    updated_at timestamptz,
+    PRIMARY KEY (id),
+    CONSTRAINT fk_offer_requests_patient FOREIGN KEY (patient_id) REFERENCES patients(id),
+    CONSTRAINT fk_offer_requests_plan_version FOREIGN KEY (plan_version_id) REFERENCES plan_versions(id)
+);
+CREATE INDEX IF NOT EXISTS idx_offer_requests_patient_id ON offer_requests (patient_id);
+CREATE INDEX IF NOT EXISTS idx_offer_requests_plan_version_id ON offer_requests (plan_version_id);
+
+CREATE TABLE IF NOT EXISTS offers (
+    id uuid DEFAULT gen_random_uuid(),
+    offer_request_id uuid NOT NULL,
+    clinic_id uuid NOT NULL,
+    total_price decimal NOT NULL,
+    discount_percent decimal,
+    has_installment boolean DEFAULT false,
+    installment_terms text,
+    special_offer text,
+    estimated_days bigint,
+    status text DEFAULT 'pending',
+    created_at timestamptz,
+    updated_at timestamptz,
+    PRIMARY KEY (id),
+    CONSTRAINT fk_offer_requests_offers FOREIGN KEY (offer_request_id) REFERENCES offer_requests(id),
+    CONSTRAINT fk_clinics_offers FOREIGN KEY (clinic_id) REFERENCES clinics(id)
+);
+CREATE INDEX IF NOT EXISTS idx_offers_clinic_id ON offers (clinic_id);
+CREATE INDEX IF NOT EXISTS idx_offers_offer_request_id ON offers (offer_request_id);
+
+CREATE TABLE IF NOT EXISTS orders (
+    id uuid DEFAULT gen_random_uuid(),
+    offer_id uuid NOT NULL,
+    patient_id uuid NOT NULL,
+    clinic_id uuid NOT NULL,
+    status text NOT NULL DEFAULT 'new',
+    consultation_date timestamptz,
+    treatment_started timestamptz,
+    treatment_completed timestamptz,
+    cancellation_reason text,
+    created_at timestamptz,
+    updated_at timestamptz,
+    PRIMARY KEY (id),
+    CONSTRAINT fk_orders_patient FOREIGN KEY (patient_id) REFERENCES patients(id),
+    CONSTRAINT fk_orders_clinic FOREIGN KEY (clinic_id) REFERENCES clinics(id),
+    CONSTRAINT fk_offers_orders FOREIGN KEY (offer_id) REFERENCES offers(id)
+);
+CREATE INDEX IF NOT EXISTS idx_orders_clinic_id ON orders (clinic_id);
+CREATE INDEX IF NOT EXISTS idx_orders_offer_id ON orders (offer_id);
+CREATE INDEX IF NOT EXISTS idx_orders_patient_id ON orders (patient_id);
+
+CREATE TABLE IF NOT EXISTS slots (
+    id uuid DEFAULT gen_random_uuid(),
+    clinic_id uuid NOT NULL,
+    doctor_name text,
+    specialty text,
+    start_time timestamptz NOT NULL,
+    end_time timestamptz NOT NULL,
+    is_available boolean DEFAULT true,
+    order_id uuid,
+    created_at timestamptz,
+    updated_at timestamptz,
+    PRIMARY KEY (id),
+    CONSTRAINT fk_orders_slots FOREIGN KEY (order_id) REFERENCES orders(id),
+    CONSTRAINT fk_slots_clinic FOREIGN KEY (clinic_id) REFERENCES clinics(id)
+);
+CREATE INDEX IF NOT EXISTS idx_slots_clinic_id ON slots (clinic_id);
+CREATE INDEX IF NOT EXISTS idx_slots_order_id ON slots (order_id);
+CREATE INDEX IF NOT EXISTS idx_slots_start_time ON slots (start_time);
+
+CREATE TABLE IF NOT EXISTS job_queues (
+    id uuid DEFAULT gen_random_uuid(),
+    job_type text NOT NULL,
+    payload jsonb NOT NULL,
+    status text NOT NULL DEFAULT 'pending',
+    attempts bigint DEFAULT 0,
+    max_attempts bigint DEFAULT 3,
+    error text,
+    scheduled_at timestamptz NOT NULL,
+    started_at timestamptz,
+    completed_at timestamptz,
+    created_at timestamptz,
+    updated_at timestamptz,
+    PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS idx_job_queues_job_type ON job_queues (job_type);
+CREATE INDEX IF NOT EXISTS idx_job_queues_scheduled_at ON job_queues (scheduled_at);
+CREATE INDEX IF NOT EXISTS idx_job_queues_status ON job_queues (status);
+
+CREATE TABLE IF NOT EXISTS audit_logs (
+    id uuid DEFAULT gen_random_uuid(),
+    user_id uuid,
+    action text NOT NULL,
+    entity_type text NOT NULL,
+    entity_id uuid,
+    details jsonb,
+    ip_address text,
+    created_at timestamptz,
+    PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS idx_audit_entity ON audit_logs (entity_type,entity_id);
+CREATE INDEX IF NOT EXISTS idx_audit_logs_action ON audit_logs (action);
+CREATE INDEX IF NOT EXISTS idx_audit_logs_created_at ON audit_logs (created_at);
+CREATE INDEX IF NOT EXISTS idx_audit_logs_user_id ON audit_logs (user_id);
+
+-- Email uniqueness moved to a partial index so phone-only accounts can have
+-- an empty email
+DROP INDEX IF EXISTS idx_users_email;
+
+-- Clinics approved before approval statuses existed only had is_active
+UPDATE clinics SET status = 'approved' WHERE is_active AND status = 'pending';
+
+-- Clinics created before multi-user clinics were owned by a single account;
+-- make that account a member
+INSERT INTO clinic_members (clinic_id, user_id, role, created_at)
+SELECT clinics.id, clinics.user_id, users.role, NOW()
+FROM clinics
+JOIN users ON users.id = clinics.user_id
+WHERE clinics.deleted_at IS NULL
+ON CONFLICT (user_id) DO NOTHING;
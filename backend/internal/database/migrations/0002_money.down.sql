@@ -0,0 +1,21 @@
+ALTER TABLE orders DROP COLUMN total_amount, DROP COLUMN total_currency;
+
+ALTER TABLE offers
+    DROP COLUMN total_price_currency,
+    ALTER COLUMN total_price_amount TYPE decimal,
+    ALTER COLUMN discount_percent DROP DEFAULT,
+    ALTER COLUMN discount_percent DROP NOT NULL,
+    ALTER COLUMN discount_percent TYPE decimal;
+ALTER TABLE offers RENAME COLUMN total_price_amount TO total_price;
+
+ALTER TABLE price_list_items
+    DROP COLUMN price_from_currency,
+    DROP COLUMN price_to_currency,
+    ALTER COLUMN price_from_amount DROP NOT NULL,
+    ALTER COLUMN price_from_amount TYPE decimal,
+    ALTER COLUMN price_to_amount DROP NOT NULL,
+    ALTER COLUMN price_to_amount TYPE decimal;
+ALTER TABLE price_list_items RENAME COLUMN price_from_amount TO price_from;
+ALTER TABLE price_list_items RENAME COLUMN price_to_amount TO price_to;
+
+ALTER TABLE clinics DROP COLUMN currency;
@@ -0,0 +1,42 @@
+-- Prices become exact amounts with a currency. Existing prices were entered
+-- in rubles as floats; they are rounded to kopecks.
+
+ALTER TABLE clinics ADD COLUMN currency char(3) NOT NULL DEFAULT 'RUB';
+
+ALTER TABLE price_list_items RENAME COLUMN price_from TO price_from_amount;
+ALTER TABLE price_list_items RENAME COLUMN price_to TO price_to_amount;
+ALTER TABLE price_list_items
+    ALTER COLUMN price_from_amount TYPE numeric(18,4) USING round(coalesce(price_from_amount, 0), 2),
+    ALTER COLUMN price_from_amount SET NOT NULL,
+    ALTER COLUMN price_to_amount TYPE numeric(18,4) USING round(coalesce(price_to_amount, 0), 2),
+    ALTER COLUMN price_to_amount SET NOT NULL,
+    ADD COLUMN price_from_currency char(3),
+    ADD COLUMN price_to_currency char(3);
+UPDATE price_list_items SET price_from_currency = 'RUB', price_to_currency = 'RUB';
+ALTER TABLE price_list_items
+    ALTER COLUMN price_from_currency SET NOT NULL,
+    ALTER COLUMN price_to_currency SET NOT NULL;
+
+ALTER TABLE offers RENAME COLUMN total_price TO total_price_amount;
+ALTER TABLE offers
+    ALTER COLUMN total_price_amount TYPE numeric(18,4) USING round(total_price_amount, 2),
+    ALTER COLUMN discount_percent TYPE numeric(7,4) USING round(coalesce(discount_percent, 0), 4),
+    ALTER COLUMN discount_percent SET NOT NULL,
+    ALTER COLUMN discount_percent SET DEFAULT 0,
+    ADD COLUMN total_price_currency char(3);
+UPDATE offers SET total_price_currency = 'RUB';
+ALTER TABLE offers ALTER COLUMN total_price_currency SET NOT NULL;
+
+-- Orders keep the price they were accepted at: the offer's, less its
+-- discount, with halves rounded away from zero like the application does
+ALTER TABLE orders
+    ADD COLUMN total_amount numeric(18,4),
+    ADD COLUMN total_currency char(3);
+UPDATE orders SET
+    total_amount = round(offers.total_price_amount * (100 - offers.discount_percent) / 100, 2),
+    total_currency = offers.total_price_currency
+FROM offers WHERE offers.id = orders.offer_id;
+UPDATE orders SET total_amount = 0, total_currency = 'RUB' WHERE total_amount IS NULL;
+ALTER TABLE orders
+    ALTER COLUMN total_amount SET NOT NULL,
+    ALTER COLUMN total_currency SET NOT NULL;
@@ -0,0 +1,4 @@
+ALTER TABLE orders DROP COLUMN version;
+ALTER TABLE offers DROP COLUMN version;
+ALTER TABLE offer_requests DROP COLUMN version;
+ALTER TABLE clinics DROP COLUMN version;
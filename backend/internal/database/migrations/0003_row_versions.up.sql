@@ -0,0 +1,6 @@
+-- Version counters for optimistic locking: every update bumps the version,
+-- and an update based on an older version changes nothing
+ALTER TABLE clinics ADD COLUMN version bigint NOT NULL DEFAULT 1;
+ALTER TABLE offer_requests ADD COLUMN version bigint NOT NULL DEFAULT 1;
+ALTER TABLE offers ADD COLUMN version bigint NOT NULL DEFAULT 1;
+ALTER TABLE orders ADD COLUMN version bigint NOT NULL DEFAULT 1;
@@ -0,0 +1,22 @@
+CREATE TABLE outbox_events (
+    id uuid DEFAULT gen_random_uuid(),
+    type text NOT NULL,
+    payload jsonb NOT NULL,
+    attempts bigint NOT NULL DEFAULT 0,
+    last_error text,
+    next_attempt_at timestamptz NOT NULL,
+    processed_at timestamptz,
+    created_at timestamptz NOT NULL,
+    PRIMARY KEY (id)
+);
+CREATE INDEX idx_outbox_events_type ON outbox_events (type);
+-- The dispatcher only ever looks for unprocessed events
+CREATE INDEX idx_outbox_events_pending ON outbox_events (next_attempt_at) WHERE processed_at IS NULL;
+
+CREATE TABLE outbox_deliveries (
+    event_id uuid NOT NULL,
+    subscriber text NOT NULL,
+    processed_at timestamptz NOT NULL,
+    PRIMARY KEY (event_id, subscriber),
+    CONSTRAINT fk_outbox_deliveries_event FOREIGN KEY (event_id) REFERENCES outbox_events(id) ON DELETE CASCADE
+);
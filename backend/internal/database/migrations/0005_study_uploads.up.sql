@@ -0,0 +1,18 @@
+-- Resumable (tus) uploads of study files; the bytes live on disk
+CREATE TABLE study_uploads (
+    id uuid DEFAULT gen_random_uuid(),
+    study_id uuid NOT NULL,
+    length bigint NOT NULL,
+    "offset" bigint NOT NULL DEFAULT 0,
+    metadata text,
+    filename text,
+    checksum text,
+    expires_at timestamptz NOT NULL,
+    completed_at timestamptz,
+    created_at timestamptz,
+    updated_at timestamptz,
+    PRIMARY KEY (id),
+    CONSTRAINT fk_study_uploads_study FOREIGN KEY (study_id) REFERENCES studies(id) ON DELETE CASCADE
+);
+CREATE INDEX idx_study_uploads_study_id ON study_uploads (study_id);
+CREATE INDEX idx_study_uploads_expires_at ON study_uploads (expires_at);
@@ -0,0 +1,4 @@
+ALTER TABLE studies DROP COLUMN image_kind;
+ALTER TABLE studies DROP COLUMN manufacturer;
+ALTER TABLE studies DROP COLUMN series_count;
+ALTER TABLE studies DROP COLUMN study_instance_uid;
@@ -0,0 +1,7 @@
+-- What the DICOM headers of an uploaded study say about it
+ALTER TABLE studies ADD COLUMN study_instance_uid text;
+ALTER TABLE studies ADD COLUMN series_count bigint NOT NULL DEFAULT 0;
+ALTER TABLE studies ADD COLUMN manufacturer text;
+ALTER TABLE studies ADD COLUMN image_kind text;
+CREATE INDEX idx_studies_study_instance_uid ON studies (study_instance_uid);
+CREATE INDEX idx_studies_image_kind ON studies (image_kind);
@@ -1,28 +1,211 @@
 package database
 
 import (
+	"fmt"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/igorfazlyev/dm/pkg/money"
 	"gorm.io/gorm"
 )
 
 // User represents system users with role-based access
 type User struct {
-	ID           uuid.UUID      `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
-	Email        string         `gorm:"uniqueIndex;not null" json:"email"`
-	PasswordHash string         `gorm:"not null" json:"-"`
-	Role         string         `gorm:"not null;index" json:"role"` // patient, clinic_doctor, clinic_manager, admin
-	IsActive     bool           `gorm:"default:true" json:"is_active"`
-	CreatedAt    time.Time      `json:"created_at"`
-	UpdatedAt    time.Time      `json:"updated_at"`
-	DeletedAt    gorm.DeletedAt `gorm:"index" json:"-"`
+	ID              uuid.UUID      `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	Email           string         `gorm:"uniqueIndex:idx_users_email_present,where:email <> '';not null" json:"email"` // Empty for phone-only accounts
+	Phone           *string        `gorm:"uniqueIndex" json:"phone,omitempty"`                                          // E.164, set once verified by OTP
+	PasswordHash    string         `gorm:"not null" json:"-"`
+	Role            string         `gorm:"not null;index" json:"role"` // patient, clinic_doctor, clinic_manager, admin
+	IsActive        bool           `gorm:"default:true" json:"is_active"`
+	VerifiedAt      *time.Time     `json:"verified_at"` // Email ownership confirmed
+	PhoneVerifiedAt *time.Time     `json:"phone_verified_at"`
+	MFASecret       string         `json:"-"` // TOTP secret, pending until MFAEnabledAt is set
+	MFAEnabledAt    *time.Time     `json:"mfa_enabled_at"`
+	MFALastStep     int64          `json:"-"` // Last accepted TOTP time step, prevents code replay
+	CreatedAt       time.Time      `json:"created_at"`
+	UpdatedAt       time.Time      `json:"updated_at"`
+	DeletedAt       gorm.DeletedAt `gorm:"index" json:"-"`
 
 	// Relationships
 	Patient *Patient `gorm:"foreignKey:UserID" json:"patient,omitempty"`
 	Clinic  *Clinic  `gorm:"foreignKey:UserID" json:"clinic,omitempty"`
 }
 
+// Session represents one login of a user on a device. Tokens carry the
+// session ID in their sid claim so they can be revoked server-side.
+type Session struct {
+	ID         uuid.UUID  `gorm:"type:uuid;primary_key" json:"id"`
+	UserID     uuid.UUID  `gorm:"type:uuid;not null;index" json:"user_id"`
+	UserAgent  string     `json:"user_agent"`
+	IPAddress  string     `json:"ip_address"`
+	MFA        bool       `gorm:"default:false" json:"mfa"`     // Authenticated with a second factor
+	LastUsedAt time.Time  `gorm:"not null" json:"last_used_at"` // Last token refresh
+	ExpiresAt  time.Time  `gorm:"not null;index" json:"expires_at"`
+	RevokedAt  *time.Time `gorm:"index" json:"revoked_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+
+	// Relationships
+	User User `gorm:"foreignKey:UserID" json:"-"`
+}
+
+// RefreshToken tracks an issued refresh token so it can be rotated once and
+// reuse of an already-rotated token can be detected
+type RefreshToken struct {
+	ID           uuid.UUID  `gorm:"type:uuid;primary_key" json:"id"` // jti claim of the refresh token
+	UserID       uuid.UUID  `gorm:"type:uuid;not null;index" json:"user_id"`
+	SessionID    uuid.UUID  `gorm:"type:uuid;not null;index" json:"session_id"` // Token family: all tokens rotated from one login
+	ExpiresAt    time.Time  `gorm:"not null" json:"expires_at"`
+	RotatedAt    *time.Time `json:"rotated_at"`
+	ReplacedByID *uuid.UUID `gorm:"type:uuid" json:"replaced_by_id,omitempty"`
+	RevokedAt    *time.Time `json:"revoked_at"`
+	CreatedAt    time.Time  `json:"created_at"`
+
+	// Relationships
+	User    User    `gorm:"foreignKey:UserID" json:"-"`
+	Session Session `gorm:"foreignKey:SessionID" json:"-"`
+}
+
+// UserToken is a single-use, expiring token sent to a user out of band
+// (password reset links and the like). Only the hash of the token is stored.
+type UserToken struct {
+	ID        uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	UserID    uuid.UUID  `gorm:"type:uuid;not null;index" json:"user_id"`
+	Purpose   string     `gorm:"not null;index" json:"purpose"` // password_reset, email_verification
+	TokenHash string     `gorm:"uniqueIndex;not null" json:"-"`
+	ExpiresAt time.Time  `gorm:"not null" json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
+
+	// Relationships
+	User User `gorm:"foreignKey:UserID" json:"-"`
+}
+
+// MFARecoveryCode is a one-time code that can replace a TOTP code when the
+// user has lost their authenticator
+type MFARecoveryCode struct {
+	ID        uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	UserID    uuid.UUID  `gorm:"type:uuid;not null;index" json:"user_id"`
+	CodeHash  string     `gorm:"not null" json:"-"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// MFAPolicy marks roles whose users must use multi-factor authentication
+type MFAPolicy struct {
+	Role        string     `gorm:"primary_key" json:"role"`
+	Required    bool       `gorm:"not null;default:false" json:"required"`
+	UpdatedByID *uuid.UUID `gorm:"type:uuid" json:"updated_by_id,omitempty"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// WebAuthnCredential is a passkey registered by a user
+type WebAuthnCredential struct {
+	ID              uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	UserID          uuid.UUID  `gorm:"type:uuid;not null;index" json:"user_id"`
+	Name            string     `json:"name"` // User-chosen label, e.g. "iPhone"
+	CredentialID    []byte     `gorm:"uniqueIndex;not null" json:"-"`
+	PublicKey       []byte     `gorm:"not null" json:"-"`
+	AttestationType string     `json:"attestation_type"`
+	Transports      []string   `gorm:"type:jsonb;serializer:json" json:"transports"`
+	AAGUID          []byte     `json:"-"`
+	SignCount       int64      `gorm:"not null;default:0" json:"-"`
+	CloneWarning    bool       `gorm:"default:false" json:"clone_warning"`
+	BackupEligible  bool       `gorm:"default:false" json:"backup_eligible"`
+	BackupState     bool       `gorm:"default:false" json:"backup_state"`
+	LastUsedAt      *time.Time `json:"last_used_at"`
+	CreatedAt       time.Time  `json:"created_at"`
+
+	// Relationships
+	User User `gorm:"foreignKey:UserID" json:"-"`
+}
+
+// WebAuthnChallenge holds the server-side state of a registration or login
+// ceremony between its begin and finish calls
+type WebAuthnChallenge struct {
+	ID        uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	UserID    *uuid.UUID `gorm:"type:uuid;index"` // Null for discoverable logins
+	Ceremony  string     `gorm:"not null"`        // registration, login
+	Data      []byte     `gorm:"not null"`        // JSON-encoded webauthn.SessionData
+	ExpiresAt time.Time  `gorm:"not null;index"`
+	CreatedAt time.Time
+}
+
+// PhoneOTP is a one-time login code sent by SMS. Only the hash of the code
+// is stored.
+type PhoneOTP struct {
+	ID         uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	Phone      string     `gorm:"not null;index"`
+	CodeHash   string     `gorm:"not null"`
+	Attempts   int        `gorm:"not null;default:0"`
+	ExpiresAt  time.Time  `gorm:"not null"`
+	ConsumedAt *time.Time // Set when used, superseded or locked after too many attempts
+	IPAddress  string
+	CreatedAt  time.Time `gorm:"index"`
+}
+
+// APIKey lets a clinic's own software call the API without a user login.
+// Only the hash of the key is stored; the prefix identifies it in listings.
+type APIKey struct {
+	ID          uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	ClinicID    uuid.UUID  `gorm:"type:uuid;not null;index" json:"clinic_id"`
+	CreatedByID uuid.UUID  `gorm:"type:uuid;not null" json:"created_by_id"`
+	Name        string     `gorm:"not null" json:"name"`
+	Prefix      string     `gorm:"not null;uniqueIndex" json:"prefix"`
+	KeyHash     string     `gorm:"not null;uniqueIndex" json:"-"`
+	Scopes      []string   `gorm:"type:jsonb;serializer:json" json:"scopes"` // pricelist:read, pricelist:write, orders:read, orders:write
+	LastUsedAt  *time.Time `json:"last_used_at"`
+	LastUsedIP  string     `json:"last_used_ip"`
+	ExpiresAt   *time.Time `json:"expires_at"`
+	RevokedAt   *time.Time `json:"revoked_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+
+	// Relationships
+	Clinic Clinic `gorm:"foreignKey:ClinicID" json:"-"`
+}
+
+// SigningKey is an asymmetric JWT signing key. Keys are published in the JWKS
+// before they start signing and stay there until every token they signed has
+// expired.
+type SigningKey struct {
+	ID          string    `gorm:"primaryKey" json:"id"` // kid header
+	Algorithm   string    `gorm:"not null" json:"algorithm"`
+	PrivateKey  []byte    `gorm:"not null" json:"-"` // PKCS#8 PEM
+	ActivatesAt time.Time `gorm:"not null" json:"activates_at"`
+	RetiresAt   time.Time `gorm:"not null" json:"retires_at"` // Stops signing new tokens
+	ExpiresAt   time.Time `gorm:"not null;index" json:"expires_at"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// RateLimitBucket is a token bucket of the Postgres rate limiter store
+type RateLimitBucket struct {
+	Key       string    `gorm:"primaryKey"`
+	Tokens    float64   `gorm:"not null"`
+	UpdatedAt time.Time `gorm:"not null"`
+	ExpiresAt time.Time `gorm:"index"` // Bucket is full again and can be deleted
+}
+
+// LoginLockout tracks consecutive failed logins for an account identifier.
+// Identifiers are not checked against existing accounts so lockouts behave the
+// same whether or not the account exists.
+type LoginLockout struct {
+	Identifier   string     `gorm:"primaryKey" json:"identifier"` // Lowercased email
+	FailedCount  int        `gorm:"not null;default:0" json:"failed_count"`
+	LockedUntil  *time.Time `gorm:"index" json:"locked_until"`
+	LastFailedAt time.Time  `json:"last_failed_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
+
+// LoginAttempt records a failed or blocked login for review by admins
+type LoginAttempt struct {
+	ID         uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	Identifier string     `gorm:"not null;index" json:"identifier"`
+	UserID     *uuid.UUID `gorm:"type:uuid;index" json:"user_id,omitempty"`
+	Reason     string     `gorm:"not null" json:"reason"` // invalid_password, unknown_account, invalid_mfa_code, locked
+	IPAddress  string     `json:"ip_address"`
+	UserAgent  string     `json:"user_agent"`
+	CreatedAt  time.Time  `gorm:"index" json:"created_at"`
+}
+
 // Patient represents a patient in the system
 type Patient struct {
 	ID                    uuid.UUID      `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
@@ -44,50 +227,50 @@ type Patient struct {
 	Studies []Study `gorm:"foreignKey:PatientID" json:"studies,omitempty"`
 }
 
-// Study represents a DICOM study (one imaging session)
-// type Study struct {
-// 	ID                  uuid.UUID      `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
-// 	PatientID           uuid.UUID      `gorm:"type:uuid;not null;index" json:"patient_id"`
-// 	DiagnocatStudyUID   string         `gorm:"uniqueIndex" json:"diagnocat_study_uid,omitempty"`
-// 	Status              string         `gorm:"not null;index;default:'created'" json:"status"` // created, uploading, uploaded, processing, completed, failed
-// 	Modality            string         `json:"modality"`                                       // CBCT, CT, etc.
-// 	StudyDate           *time.Time     `json:"study_date"`
-// 	UploadedAt          *time.Time     `json:"uploaded_at"`
-// 	CompletedAt         *time.Time     `json:"completed_at"`
-// 	ErrorMessage        string         `json:"error_message,omitempty"`
-// 	DiagnocatResultJSON map[string]any `gorm:"type:jsonb" json:"diagnocat_result,omitempty"`
-// 	DiagnocatReportPDF  []byte         `json:"-"` // Store PDF binary
-// 	CreatedAt           time.Time      `json:"created_at"`
-// 	UpdatedAt           time.Time      `json:"updated_at"`
-
-// 	// Relationships
-// 	Patient      Patient       `gorm:"foreignKey:PatientID" json:"patient,omitempty"`
-// 	PlanVersions []PlanVersion `gorm:"foreignKey:StudyID" json:"plan_versions,omitempty"`
-// }
 // Study represents a DICOM study (one imaging session)
 type Study struct {
-	ID                   uuid.UUID      `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
-	PatientID            uuid.UUID      `gorm:"type:uuid;not null;index" json:"patient_id"`
-	DiagnocatStudyUID    *string        `gorm:"uniqueIndex" json:"diagnocat_study_uid,omitempty"`
-	DiagnocatSessionID   *string        `json:"diagnocat_session_id,omitempty"`    // Upload session ID
-	DiagnocatReportURL   *string        `json:"diagnocat_report_url,omitempty"`    // PDF URL from Diagnocat
-	Status               string         `gorm:"not null;index;default:'created'" json:"status"` // created, uploading, processing, completed, failed
-	Modality             string         `json:"modality"`                                        // CBCT, CT, etc.
-	StudyDate            *string        `json:"study_date"`                                      // Changed from *time.Time to *string for flexibility
-	UploadedAt           *time.Time     `json:"uploaded_at"`
-	CompletedAt          *time.Time     `json:"completed_at"`
-	ErrorMessage         string         `json:"error_message,omitempty"`
-	DiagnocatResultJSON  map[string]any `gorm:"type:jsonb" json:"diagnocat_result,omitempty"`
-	DiagnocatReportPDF   []byte         `json:"-"` // Store PDF binary (optional, can be large)
-	CreatedAt            time.Time      `json:"created_at"`
-	UpdatedAt            time.Time      `json:"updated_at"`
-	DeletedAt            gorm.DeletedAt `gorm:"index" json:"-"`
+	ID                  uuid.UUID      `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	PatientID           uuid.UUID      `gorm:"type:uuid;not null;index" json:"patient_id"`
+	DiagnocatStudyUID   *string        `gorm:"uniqueIndex" json:"diagnocat_study_uid,omitempty"`
+	DiagnocatSessionID  *string        `json:"diagnocat_session_id,omitempty"`                 // Upload session ID
+	DiagnocatReportURL  *string        `json:"diagnocat_report_url,omitempty"`                 // PDF URL from Diagnocat
+	Status              string         `gorm:"not null;index;default:'created'" json:"status"` // created, uploading, processing, completed, failed
+	Modality            string         `json:"modality"`                                       // From the DICOM headers on upload: CT (also CBCT), PX, etc.
+	StudyDate           *string        `json:"study_date"`                                     // Changed from *time.Time to *string for flexibility
+	StudyInstanceUID    *string        `gorm:"index" json:"study_instance_uid,omitempty"`
+	SeriesCount         int            `gorm:"not null;default:0" json:"series_count"`
+	Manufacturer        string         `json:"manufacturer,omitempty"`
+	ImageKind           string         `gorm:"index" json:"image_kind,omitempty"` // volume (CT/CBCT), panoramic or other
+	UploadedAt          *time.Time     `json:"uploaded_at"`
+	CompletedAt         *time.Time     `json:"completed_at"`
+	ErrorMessage        string         `json:"error_message,omitempty"`
+	DiagnocatResultJSON map[string]any `gorm:"type:jsonb" json:"diagnocat_result,omitempty"`
+	DiagnocatReportPDF  []byte         `json:"-"` // Store PDF binary (optional, can be large)
+	CreatedAt           time.Time      `json:"created_at"`
+	UpdatedAt           time.Time      `json:"updated_at"`
+	DeletedAt           gorm.DeletedAt `gorm:"index" json:"-"`
 
 	// Relationships
 	Patient      Patient       `gorm:"foreignKey:PatientID" json:"patient,omitempty"`
 	PlanVersions []PlanVersion `gorm:"foreignKey:StudyID" json:"plan_versions,omitempty"`
 }
 
+// StudyUpload is a resumable upload of a study's DICOM file. Its bytes are
+// kept in the upload directory until the upload completes and the file is
+// handed to Diagnocat.
+type StudyUpload struct {
+	ID          uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	StudyID     uuid.UUID  `gorm:"type:uuid;not null;index" json:"study_id"`
+	Length      int64      `gorm:"not null" json:"length"`
+	Offset      int64      `gorm:"not null;default:0" json:"offset"`
+	Metadata    string     `json:"-"` // Upload-Metadata header as the client sent it
+	Filename    string     `json:"filename"`
+	Checksum    string     `json:"-"` // "<algorithm> <base64 digest>" of the whole file, if the client sent one
+	ExpiresAt   time.Time  `gorm:"not null;index" json:"expires_at"`
+	CompletedAt *time.Time `json:"completed_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
 
 // PlanVersion represents an immutable snapshot of a treatment plan
 type PlanVersion struct {
@@ -120,10 +303,13 @@ type PlanItem struct {
 	PlanVersion PlanVersion `gorm:"foreignKey:PlanVersionID" json:"-"`
 }
 
+// DefaultCurrency is the currency of clinics that do not choose one
+const DefaultCurrency = "RUB"
+
 // Clinic represents a dental clinic
 type Clinic struct {
 	ID              uuid.UUID      `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
-	UserID          uuid.UUID      `gorm:"type:uuid;index" json:"user_id"` // Manager account
+	UserID          uuid.UUID      `gorm:"type:uuid;index" json:"user_id"` // Manager who created the clinic; staff are in ClinicMember
 	Name            string         `gorm:"not null" json:"name"`
 	LegalName       string         `json:"legal_name"`
 	LicenseNumber   string         `gorm:"uniqueIndex" json:"license_number"`
@@ -134,8 +320,14 @@ type Clinic struct {
 	Phone           string         `json:"phone"`
 	Email           string         `json:"email"`
 	Website         string         `json:"website"`
-	PriceSegment    string         `json:"price_segment"`                  // economy, business, premium
-	IsActive        bool           `gorm:"default:false" json:"is_active"` // Requires admin approval
+	PriceSegment    string         `json:"price_segment"`                                       // economy, business, premium
+	Currency        string         `gorm:"type:char(3);not null;default:'RUB'" json:"currency"` // ISO 4217; prices and offers are in it
+	IsActive        bool           `gorm:"default:false" json:"is_active"`                      // Requires admin approval
+	Status          string         `gorm:"not null;default:'pending';index" json:"status"`      // pending, approved, rejected, suspended
+	StatusReason    string         `json:"status_reason,omitempty"`                             // Shown to the clinic on rejection or suspension
+	ReviewedAt      *time.Time     `json:"reviewed_at,omitempty"`
+	ReviewedByID    *uuid.UUID     `gorm:"type:uuid" json:"reviewed_by_id,omitempty"`
+	Version         int            `gorm:"not null;default:1" json:"version"` // Bumped by every update; a write based on an older version fails
 	CreatedAt       time.Time      `json:"created_at"`
 	UpdatedAt       time.Time      `json:"updated_at"`
 	DeletedAt       gorm.DeletedAt `gorm:"index" json:"-"`
@@ -145,23 +337,127 @@ type Clinic struct {
 	Offers         []Offer         `gorm:"foreignKey:ClinicID" json:"offers,omitempty"`
 }
 
+// ClinicNote is an admin's internal note on a clinic, never shown to it
+type ClinicNote struct {
+	ID        uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	ClinicID  uuid.UUID `gorm:"type:uuid;not null;index" json:"clinic_id"`
+	AuthorID  uuid.UUID `gorm:"type:uuid;not null" json:"author_id"`
+	Body      string    `gorm:"type:text;not null" json:"body"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ClinicMember links a staff account to the clinic it works for. An account
+// belongs to at most one clinic.
+type ClinicMember struct {
+	ID        uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	ClinicID  uuid.UUID `gorm:"type:uuid;not null;index" json:"clinic_id"`
+	UserID    uuid.UUID `gorm:"type:uuid;not null;uniqueIndex" json:"user_id"`
+	Role      string    `gorm:"not null" json:"role"` // clinic_doctor, clinic_manager
+	CreatedAt time.Time `json:"created_at"`
+
+	// Relationships
+	Clinic Clinic `gorm:"foreignKey:ClinicID" json:"-"`
+	User   User   `gorm:"foreignKey:UserID" json:"user,omitempty"`
+}
+
+// ClinicInvitation is an emailed invitation to join a clinic's staff. Only
+// the hash of the token is stored.
+type ClinicInvitation struct {
+	ID          uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	ClinicID    uuid.UUID  `gorm:"type:uuid;not null;index" json:"clinic_id"`
+	Email       string     `gorm:"not null;index" json:"email"`
+	Role        string     `gorm:"not null" json:"role"` // clinic_doctor, clinic_manager
+	TokenHash   string     `gorm:"not null;uniqueIndex" json:"-"`
+	InvitedByID uuid.UUID  `gorm:"type:uuid;not null" json:"invited_by_id"`
+	ExpiresAt   time.Time  `gorm:"not null" json:"expires_at"`
+	AcceptedAt  *time.Time `json:"accepted_at"`
+	RevokedAt   *time.Time `json:"revoked_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+
+	// Relationships
+	Clinic Clinic `gorm:"foreignKey:ClinicID" json:"-"`
+}
+
+// Price returns amount in the clinic's currency. Clinics quote in their own
+// currency only, so another currency is an error; none means the clinic's.
+func (c *Clinic) Price(amount money.Decimal, currency string) (money.Money, error) {
+	if currency != "" {
+		code, err := money.NormalizeCurrency(currency)
+		if err != nil {
+			return money.Money{}, err
+		}
+		if code != c.Currency {
+			return money.Money{}, fmt.Errorf("prices must be in the clinic's currency, %s", c.Currency)
+		}
+	}
+	return money.New(amount, c.Currency)
+}
+
 // PriceListItem represents a single service price in clinic's pricelist
 type PriceListItem struct {
-	ID            uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
-	ClinicID      uuid.UUID `gorm:"type:uuid;not null;index" json:"clinic_id"`
-	Specialty     string    `gorm:"not null;index" json:"specialty"`
-	ProcedureCode string    `gorm:"index" json:"procedure_code"`
-	ProcedureName string    `gorm:"not null" json:"procedure_name"`
-	PriceFrom     float64   `json:"price_from"` // Minimum price
-	PriceTo       float64   `json:"price_to"`   // Maximum price (optional range)
-	IsActive      bool      `gorm:"default:true" json:"is_active"`
-	CreatedAt     time.Time `json:"created_at"`
-	UpdatedAt     time.Time `json:"updated_at"`
+	ID            uuid.UUID   `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	ClinicID      uuid.UUID   `gorm:"type:uuid;not null;index" json:"clinic_id"`
+	Specialty     string      `gorm:"not null;index" json:"specialty"`
+	ProcedureCode string      `gorm:"index" json:"procedure_code"`
+	ProcedureName string      `gorm:"not null" json:"procedure_name"`
+	PriceFrom     money.Money `gorm:"embedded;embeddedPrefix:price_from_" json:"price_from"` // Minimum price, in the clinic's currency
+	PriceTo       money.Money `gorm:"embedded;embeddedPrefix:price_to_" json:"price_to"`     // Maximum price (optional range)
+	IsActive      bool        `gorm:"default:true" json:"is_active"`
+	CreatedAt     time.Time   `json:"created_at"`
+	UpdatedAt     time.Time   `json:"updated_at"`
 
 	// Relationships
 	Clinic Clinic `gorm:"foreignKey:ClinicID" json:"-"`
 }
 
+// PlanAnnotation is a doctor's note on a treatment plan, optionally about a
+// single item. Annotations are visible to the patient and to the author's
+// clinic.
+type PlanAnnotation struct {
+	ID            uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	PlanVersionID uuid.UUID  `gorm:"type:uuid;not null;index" json:"plan_version_id"`
+	PlanItemID    *uuid.UUID `gorm:"type:uuid" json:"plan_item_id,omitempty"`
+	ClinicID      uuid.UUID  `gorm:"type:uuid;not null;index" json:"clinic_id"`
+	AuthorID      uuid.UUID  `gorm:"type:uuid;not null" json:"author_id"`
+	Body          string     `gorm:"type:text;not null" json:"body"`
+	CreatedAt     time.Time  `json:"created_at"`
+
+	// Relationships
+	Clinic Clinic `gorm:"foreignKey:ClinicID" json:"clinic,omitempty"`
+}
+
+// StudyGrant lets a clinic see a patient's study until it expires or the
+// patient revokes it. Grants made for an offer request record it.
+type StudyGrant struct {
+	ID             uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	StudyID        uuid.UUID  `gorm:"type:uuid;not null;index" json:"study_id"`
+	PatientID      uuid.UUID  `gorm:"type:uuid;not null;index" json:"patient_id"`
+	ClinicID       uuid.UUID  `gorm:"type:uuid;not null;index" json:"clinic_id"`
+	OfferRequestID *uuid.UUID `gorm:"type:uuid;index" json:"offer_request_id,omitempty"`
+	Scopes         []string   `gorm:"type:jsonb;serializer:json" json:"scopes"` // read, read_report, read_results
+	ExpiresAt      time.Time  `gorm:"not null" json:"expires_at"`
+	RevokedAt      *time.Time `json:"revoked_at"`
+	CreatedAt      time.Time  `json:"created_at"`
+
+	// Relationships
+	Clinic Clinic `gorm:"foreignKey:ClinicID" json:"clinic,omitempty"`
+}
+
+// StudyAccessLog records each time a clinic used a grant
+type StudyAccessLog struct {
+	ID        uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	GrantID   uuid.UUID `gorm:"type:uuid;not null;index" json:"grant_id"`
+	StudyID   uuid.UUID `gorm:"type:uuid;not null;index" json:"study_id"`
+	ClinicID  uuid.UUID `gorm:"type:uuid;not null" json:"clinic_id"`
+	UserID    uuid.UUID `gorm:"type:uuid;not null" json:"user_id"`
+	Action    string    `gorm:"not null" json:"action"`
+	IPAddress string    `json:"ip_address"`
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+
+	// Relationships
+	Clinic Clinic `gorm:"foreignKey:ClinicID" json:"clinic,omitempty"`
+}
+
 // OfferRequest represents a patient's request for offers from clinics
 type OfferRequest struct {
 	ID            uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
@@ -175,6 +471,7 @@ type OfferRequest struct {
 	PreferredDistrict string    `json:"preferred_district"`
 	PriceSegment      string    `json:"price_segment"`
 	Status            string    `gorm:"default:'open'" json:"status"` // open, closed
+	Version           int       `gorm:"not null;default:1" json:"version"`
 	CreatedAt         time.Time `json:"created_at"`
 	UpdatedAt         time.Time `json:"updated_at"`
 
@@ -186,18 +483,19 @@ type OfferRequest struct {
 
 // Offer represents a clinic's proposal for a treatment plan
 type Offer struct {
-	ID               uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
-	OfferRequestID   uuid.UUID `gorm:"type:uuid;not null;index" json:"offer_request_id"`
-	ClinicID         uuid.UUID `gorm:"type:uuid;not null;index" json:"clinic_id"`
-	TotalPrice       float64   `gorm:"not null" json:"total_price"`
-	DiscountPercent  float64   `json:"discount_percent"`
-	HasInstallment   bool      `gorm:"default:false" json:"has_installment"`
-	InstallmentTerms string    `json:"installment_terms"`
-	SpecialOffer     string    `json:"special_offer"`
-	EstimatedDays    int       `json:"estimated_days"`
-	Status           string    `gorm:"default:'pending'" json:"status"` // pending, accepted, rejected
-	CreatedAt        time.Time `json:"created_at"`
-	UpdatedAt        time.Time `json:"updated_at"`
+	ID               uuid.UUID     `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	OfferRequestID   uuid.UUID     `gorm:"type:uuid;not null;index" json:"offer_request_id"`
+	ClinicID         uuid.UUID     `gorm:"type:uuid;not null;index" json:"clinic_id"`
+	TotalPrice       money.Money   `gorm:"embedded;embeddedPrefix:total_price_" json:"total_price"` // Before the discount, in the clinic's currency
+	DiscountPercent  money.Decimal `gorm:"type:numeric(7,4)" json:"discount_percent"`
+	HasInstallment   bool          `gorm:"default:false" json:"has_installment"`
+	InstallmentTerms string        `json:"installment_terms"`
+	SpecialOffer     string        `json:"special_offer"`
+	EstimatedDays    int           `json:"estimated_days"`
+	Status           string        `gorm:"default:'pending'" json:"status"` // pending, accepted, rejected
+	Version          int           `gorm:"not null;default:1" json:"version"`
+	CreatedAt        time.Time     `json:"created_at"`
+	UpdatedAt        time.Time     `json:"updated_at"`
 
 	// Relationships
 	OfferRequest OfferRequest `gorm:"foreignKey:OfferRequestID" json:"offer_request,omitempty"`
@@ -205,19 +503,27 @@ type Offer struct {
 	Orders       []Order      `gorm:"foreignKey:OfferID" json:"orders,omitempty"`
 }
 
+// FinalPrice is the total price after the discount, rounded to the currency's
+// minor unit
+func (o *Offer) FinalPrice() (money.Money, error) {
+	return o.TotalPrice.Discount(o.DiscountPercent)
+}
+
 // Order represents an accepted offer and tracks treatment progress
 type Order struct {
-	ID                 uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
-	OfferID            uuid.UUID  `gorm:"type:uuid;not null;index" json:"offer_id"`
-	PatientID          uuid.UUID  `gorm:"type:uuid;not null;index" json:"patient_id"`
-	ClinicID           uuid.UUID  `gorm:"type:uuid;not null;index" json:"clinic_id"`
-	Status             string     `gorm:"not null;default:'new'" json:"status"` // new, consultation_scheduled, in_progress, completed, cancelled
-	ConsultationDate   *time.Time `json:"consultation_date"`
-	TreatmentStarted   *time.Time `json:"treatment_started"`
-	TreatmentCompleted *time.Time `json:"treatment_completed"`
-	CancellationReason string     `json:"cancellation_reason"`
-	CreatedAt          time.Time  `json:"created_at"`
-	UpdatedAt          time.Time  `json:"updated_at"`
+	ID                 uuid.UUID   `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	OfferID            uuid.UUID   `gorm:"type:uuid;not null;index" json:"offer_id"`
+	PatientID          uuid.UUID   `gorm:"type:uuid;not null;index" json:"patient_id"`
+	ClinicID           uuid.UUID   `gorm:"type:uuid;not null;index" json:"clinic_id"`
+	Status             string      `gorm:"not null;default:'new'" json:"status"` // new, consultation_scheduled, in_progress, completed, cancelled
+	ConsultationDate   *time.Time  `json:"consultation_date"`
+	TreatmentStarted   *time.Time  `json:"treatment_started"`
+	TreatmentCompleted *time.Time  `json:"treatment_completed"`
+	CancellationReason string      `json:"cancellation_reason"`
+	Total              money.Money `gorm:"embedded;embeddedPrefix:total_" json:"total"` // The accepted offer's price after its discount
+	Version            int         `gorm:"not null;default:1" json:"version"`
+	CreatedAt          time.Time   `json:"created_at"`
+	UpdatedAt          time.Time   `json:"updated_at"`
 
 	// Relationships
 	Offer   Offer   `gorm:"foreignKey:OfferID" json:"offer,omitempty"`
@@ -260,14 +566,35 @@ type JobQueue struct {
 	UpdatedAt   time.Time
 }
 
+// OutboxEvent is a domain event, written in the transaction of the change it
+// describes and delivered to subscribers afterwards
+type OutboxEvent struct {
+	ID            uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	Type          string     `gorm:"not null;index"`
+	Payload       string     `gorm:"type:jsonb;not null"`
+	Attempts      int        `gorm:"not null;default:0"`
+	LastError     string     `gorm:"type:text"`
+	NextAttemptAt time.Time  `gorm:"not null"`
+	ProcessedAt   *time.Time // Set once every subscriber has handled it
+	CreatedAt     time.Time  `gorm:"not null"`
+}
+
+// OutboxDelivery records that a subscriber handled an event, so retries of
+// the event skip it
+type OutboxDelivery struct {
+	EventID     uuid.UUID `gorm:"type:uuid;primaryKey"`
+	Subscriber  string    `gorm:"primaryKey"`
+	ProcessedAt time.Time `gorm:"not null"`
+}
+
 // AuditLog for tracking all important actions
 type AuditLog struct {
-	ID         uuid.UUID      `gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
-	UserID     *uuid.UUID     `gorm:"type:uuid;index"`
-	Action     string         `gorm:"not null;index"` // create_study, upload_file, create_offer, etc.
-	EntityType string         `gorm:"not null"`       // study, offer, order, etc.
-	EntityID   *uuid.UUID     `gorm:"type:uuid"`
-	Details    map[string]any `gorm:"type:jsonb"`
-	IPAddress  string
-	CreatedAt  time.Time `gorm:"index"`
+	ID         uuid.UUID      `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	UserID     *uuid.UUID     `gorm:"type:uuid;index" json:"user_id"`
+	Action     string         `gorm:"not null;index" json:"action"`                       // create_study, upload_file, create_offer, etc.
+	EntityType string         `gorm:"not null;index:idx_audit_entity" json:"entity_type"` // study, offer, order, etc.
+	EntityID   *uuid.UUID     `gorm:"type:uuid;index:idx_audit_entity" json:"entity_id"`
+	Details    map[string]any `gorm:"type:jsonb;serializer:json" json:"details,omitempty"`
+	IPAddress  string         `json:"ip_address"`
+	CreatedAt  time.Time      `gorm:"index" json:"created_at"`
 }
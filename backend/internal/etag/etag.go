@@ -0,0 +1,57 @@
+// Package etag turns row versions into entity tags, so clients can make
+// conditional updates: they send back the ETag they read as If-Match, and a
+// write to a row that changed since fails with 409 Conflict instead of
+// silently overwriting it.
+package etag
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Format returns the entity tag of a row version
+func Format(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// Set sends the entity tag of a row version
+func Set(c *gin.Context, version int) {
+	c.Header("ETag", Format(version))
+}
+
+// Check reports whether the request's If-Match allows writing a row at
+// version. Requests without If-Match, or with *, may write any version. It
+// writes the error response itself when it returns false.
+func Check(c *gin.Context, version int) bool {
+	header := strings.TrimSpace(c.GetHeader("If-Match"))
+	if header == "" || header == "*" {
+		return true
+	}
+
+	current := Format(version)
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		// If-Match compares strongly, so weak tags never match
+		if strings.HasPrefix(tag, "W/") {
+			continue
+		}
+		if len(tag) < 2 || !strings.HasPrefix(tag, `"`) || !strings.HasSuffix(tag, `"`) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid If-Match header"})
+			return false
+		}
+		if tag == current {
+			return true
+		}
+	}
+
+	Conflict(c)
+	return false
+}
+
+// Conflict responds that the row changed since the client read it
+func Conflict(c *gin.Context) {
+	c.JSON(http.StatusConflict, gin.H{"error": "modified by someone else, fetch it again"})
+}
@@ -0,0 +1,225 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/igorfazlyev/dm/internal/database"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	batchSize  = 100
+	lease      = 5 * time.Minute // How long a dispatcher has to deliver a batch it claimed
+	maxBackoff = time.Hour
+)
+
+// Delivery is an event handed to a subscriber. Delivery is at least once: a
+// subscriber may see the same event again, with the same ID, if the
+// dispatcher failed to record that it was handled.
+type Delivery struct {
+	ID         uuid.UUID
+	OccurredAt time.Time
+	Event      Event
+}
+
+// Handler handles an event. An error, or a panic, has the event delivered
+// to the subscriber again later.
+type Handler func(ctx context.Context, delivery Delivery) error
+
+type subscriber struct {
+	name   string
+	handle Handler
+}
+
+// Dispatcher delivers outbox events to the subscribers registered with it.
+// Instances sharing a database split the work: each event is leased to one
+// dispatcher at a time.
+type Dispatcher struct {
+	db          *gorm.DB
+	mu          sync.RWMutex
+	subscribers map[string][]subscriber // By event type
+}
+
+func NewDispatcher(db *gorm.DB) *Dispatcher {
+	return &Dispatcher{db: db, subscribers: map[string][]subscriber{}}
+}
+
+// Subscribe registers handle for events of eventType. The name records which
+// subscribers have handled an event, so it must be unique and stay the same
+// across releases.
+func (d *Dispatcher) Subscribe(name, eventType string, handle Handler) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.subscribers[eventType] = append(d.subscribers[eventType], subscriber{name: name, handle: handle})
+}
+
+// On registers a handler for events of type T
+func On[T Event](d *Dispatcher, name string, handle func(ctx context.Context, id uuid.UUID, event T) error) {
+	var zero T
+	d.Subscribe(name, zero.EventType(), func(ctx context.Context, delivery Delivery) error {
+		return handle(ctx, delivery.ID, delivery.Event.(T))
+	})
+}
+
+// Start dispatches every interval in the background until ctx is done
+func (d *Dispatcher) Start(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			// Keep going while there is a backlog
+			for {
+				n, err := d.Dispatch(ctx)
+				if err != nil {
+					log.Printf("Failed to dispatch events: %v", err)
+				}
+				if err != nil || n < batchSize || ctx.Err() != nil {
+					break
+				}
+			}
+		}
+	}()
+}
+
+// Dispatch delivers a batch of the events that are due and returns how many
+// it attempted. The batch is claimed for a lease by moving its next attempt
+// ahead, so handlers run outside any transaction while other dispatchers skip
+// the events. Handlers that outlast the lease may see the event again.
+func (d *Dispatcher) Dispatch(ctx context.Context) (int, error) {
+	batch, leasedUntil, err := d.claim(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var errs []error
+	for i := range batch {
+		errs = append(errs, d.deliver(ctx, &batch[i], leasedUntil))
+	}
+	return len(batch), errors.Join(errs...)
+}
+
+// claim locks a batch of due events and leases them to this dispatcher
+func (d *Dispatcher) claim(ctx context.Context) ([]database.OutboxEvent, time.Time, error) {
+	var batch []database.OutboxEvent
+	// Postgres keeps microseconds, and deliver matches the lease exactly
+	leasedUntil := time.Now().Add(lease).Truncate(time.Microsecond)
+	err := d.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("processed_at IS NULL AND next_attempt_at <= ?", time.Now()).
+			Order("created_at").
+			Limit(batchSize).
+			Find(&batch).Error; err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+
+		ids := make([]uuid.UUID, len(batch))
+		for i := range batch {
+			ids[i] = batch[i].ID
+		}
+		return tx.Model(&database.OutboxEvent{}).
+			Where("id IN ?", ids).
+			Update("next_attempt_at", leasedUntil).Error
+	})
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return batch, leasedUntil, nil
+}
+
+// deliver hands an event to the subscribers that have not handled it yet,
+// recording each delivery as it succeeds, and schedules a retry if any of
+// them fail. Nothing is recorded for the event if its lease ran out and
+// another dispatcher claimed it.
+func (d *Dispatcher) deliver(ctx context.Context, row *database.OutboxEvent, leasedUntil time.Time) error {
+	db := d.db.WithContext(ctx)
+
+	var handled []string
+	if err := db.Model(&database.OutboxDelivery{}).
+		Where("event_id = ?", row.ID).
+		Pluck("subscriber", &handled).Error; err != nil {
+		return err
+	}
+
+	event, failed := decode(row.Type, row.Payload)
+	if failed == nil {
+		delivery := Delivery{ID: row.ID, OccurredAt: row.CreatedAt, Event: event}
+
+		d.mu.RLock()
+		subscribers := d.subscribers[row.Type]
+		d.mu.RUnlock()
+
+		for _, s := range subscribers {
+			if slices.Contains(handled, s.name) {
+				continue
+			}
+			if err := call(ctx, s.handle, delivery); err != nil {
+				failed = errors.Join(failed, fmt.Errorf("%s: %w", s.name, err))
+				continue
+			}
+			// A dispatcher that took over after the lease may have
+			// recorded it already
+			if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&database.OutboxDelivery{
+				EventID:     row.ID,
+				Subscriber:  s.name,
+				ProcessedAt: time.Now(),
+			}).Error; err != nil {
+				return err
+			}
+		}
+	}
+
+	now := time.Now()
+	updates := map[string]any{"processed_at": now}
+	if failed != nil {
+		log.Printf("Failed to deliver event %s (%s): %v", row.ID, row.Type, failed)
+		updates = map[string]any{
+			"attempts":        row.Attempts + 1,
+			"last_error":      failed.Error(),
+			"next_attempt_at": now.Add(backoff(row.Attempts + 1)),
+		}
+	}
+	return db.Model(&database.OutboxEvent{}).
+		Where("id = ? AND next_attempt_at = ?", row.ID, leasedUntil).
+		Updates(updates).Error
+}
+
+// call runs a handler, turning a panic into an error
+func call(ctx context.Context, handle Handler, delivery Delivery) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return handle(ctx, delivery)
+}
+
+// backoff doubles the wait after each failed attempt, from 10 seconds up to
+// an hour
+func backoff(attempts int) time.Duration {
+	wait := 10 * time.Second << min(attempts-1, 10)
+	return min(wait, maxBackoff)
+}
+
+// Log is a subscriber that writes events to the log
+func Log(_ context.Context, delivery Delivery) error {
+	log.Printf("Event %s %s: %+v", delivery.Event.EventType(), delivery.ID, delivery.Event)
+	return nil
+}
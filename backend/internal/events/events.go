@@ -0,0 +1,108 @@
+// Package events publishes domain events through a transactional outbox.
+// Changes record their events with Record in the same transaction, so an
+// event exists exactly when its change does; a Dispatcher then delivers them
+// to the subscribers registered in this process.
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/igorfazlyev/dm/internal/database"
+	"github.com/igorfazlyev/dm/pkg/money"
+	"gorm.io/gorm"
+)
+
+// Event is a domain event. Its type names it in the outbox and to
+// subscribers, so it must not change once events of it are stored.
+type Event interface {
+	EventType() string
+}
+
+const (
+	TypeOfferAccepted      = "offer.accepted"
+	TypeOrderStatusChanged = "order.status_changed"
+	TypeStudyCompleted     = "study.completed"
+)
+
+// OfferAccepted is a patient accepting a clinic's offer, which places an
+// order
+type OfferAccepted struct {
+	OfferID        uuid.UUID   `json:"offer_id"`
+	OfferRequestID uuid.UUID   `json:"offer_request_id"`
+	OrderID        uuid.UUID   `json:"order_id"`
+	PatientID      uuid.UUID   `json:"patient_id"`
+	ClinicID       uuid.UUID   `json:"clinic_id"`
+	Total          money.Money `json:"total"`
+}
+
+func (OfferAccepted) EventType() string { return TypeOfferAccepted }
+
+// OrderStatusChanged is a clinic moving an order along
+type OrderStatusChanged struct {
+	OrderID   uuid.UUID `json:"order_id"`
+	PatientID uuid.UUID `json:"patient_id"`
+	ClinicID  uuid.UUID `json:"clinic_id"`
+	From      string    `json:"from"`
+	To        string    `json:"to"`
+}
+
+func (OrderStatusChanged) EventType() string { return TypeOrderStatusChanged }
+
+// StudyCompleted is the AI analysis of a study finishing
+type StudyCompleted struct {
+	StudyID   uuid.UUID `json:"study_id"`
+	PatientID uuid.UUID `json:"patient_id"`
+}
+
+func (StudyCompleted) EventType() string { return TypeStudyCompleted }
+
+// decoders read the payload of each event type back into its struct
+var decoders = map[string]func(payload []byte) (Event, error){
+	TypeOfferAccepted:      decoder[OfferAccepted](),
+	TypeOrderStatusChanged: decoder[OrderStatusChanged](),
+	TypeStudyCompleted:     decoder[StudyCompleted](),
+}
+
+func decoder[T Event]() func([]byte) (Event, error) {
+	return func(payload []byte) (Event, error) {
+		var event T
+		err := json.Unmarshal(payload, &event)
+		return event, err
+	}
+}
+
+// Types returns every event type
+func Types() []string {
+	return []string{TypeOfferAccepted, TypeOrderStatusChanged, TypeStudyCompleted}
+}
+
+func decode(eventType, payload string) (Event, error) {
+	decode, ok := decoders[eventType]
+	if !ok {
+		return nil, fmt.Errorf("unknown event type %q", eventType)
+	}
+	return decode([]byte(payload))
+}
+
+// Record writes an event to the outbox. Pass the transaction making the
+// change so the event is kept only if the change is.
+func Record(db *gorm.DB, event Event) error {
+	if _, ok := decoders[event.EventType()]; !ok {
+		return fmt.Errorf("unknown event type %q", event.EventType())
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	return db.Create(&database.OutboxEvent{
+		Type:          event.EventType(),
+		Payload:       string(payload),
+		NextAttemptAt: now,
+		CreatedAt:     now,
+	}).Error
+}
@@ -0,0 +1,42 @@
+package mailer
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/igorfazlyev/dm/internal/config"
+)
+
+// Message is a plain-text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Sender delivers emails
+type Sender interface {
+	Send(msg Message) error
+}
+
+// New returns the sender selected by cfg.Driver
+func New(cfg config.MailConfig) (Sender, error) {
+	switch cfg.Driver {
+	case "smtp":
+		return NewSMTPSender(cfg), nil
+	case "file", "":
+		return NewFileOutbox(cfg.OutboxDir, cfg.From)
+	default:
+		return nil, fmt.Errorf("unknown mail driver %q", cfg.Driver)
+	}
+}
+
+// SendAsync delivers an email in the background so response timing does not
+// depend on the mail server. Failures are only logged.
+func SendAsync(sender Sender, msg Message) {
+	go func() {
+		if err := sender.Send(msg); err != nil {
+			log.Printf("Failed to send email %q to %s: %v", msg.Subject, msg.To, err)
+		}
+	}()
+}
@@ -0,0 +1,41 @@
+package mailer
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+var unsafeFileChars = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+// FileOutbox writes every email as an .eml file into a directory instead of
+// sending it, for local development and testing
+type FileOutbox struct {
+	dir  string
+	from string
+}
+
+func NewFileOutbox(dir, from string) (*FileOutbox, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create mail outbox %s: %w", dir, err)
+	}
+	return &FileOutbox{dir: dir, from: from}, nil
+}
+
+func (o *FileOutbox) Send(msg Message) error {
+	name := fmt.Sprintf("%s_%s.eml",
+		time.Now().UTC().Format("20060102T150405.000000000"),
+		unsafeFileChars.ReplaceAllString(msg.To, "_"),
+	)
+	path := filepath.Join(o.dir, name)
+
+	if err := os.WriteFile(path, formatMessage(o.from, msg), 0o644); err != nil {
+		return fmt.Errorf("failed to write email to outbox: %w", err)
+	}
+
+	log.Printf("📧 Email to %s written to %s", msg.To, path)
+	return nil
+}
@@ -1,18 +1,30 @@
 package offers
 
 import (
+	"errors"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/igorfazlyev/dm/internal/database"
+	"github.com/igorfazlyev/dm/internal/etag"
+	"github.com/igorfazlyev/dm/internal/policy"
 	"github.com/igorfazlyev/dm/internal/rbac"
+	"github.com/igorfazlyev/dm/internal/repository"
+	"github.com/igorfazlyev/dm/pkg/money"
 )
 
-type Handler struct{}
+type Handler struct {
+	offers   repository.Offers
+	plans    repository.Plans
+	patients repository.Patients
+	clinics  repository.Clinics
+	policy   *policy.Policy
+}
 
-func NewHandler() *Handler {
-	return &Handler{}
+func NewHandler(offers repository.Offers, plans repository.Plans, patients repository.Patients, clinics repository.Clinics, accessPolicy *policy.Policy) *Handler {
+	return &Handler{offers: offers, plans: plans, patients: patients, clinics: clinics, policy: accessPolicy}
 }
 
 type CreateOfferRequestReq struct {
@@ -21,8 +33,12 @@ type CreateOfferRequestReq struct {
 	PreferredCity     string    `json:"preferred_city"`
 	PreferredDistrict string    `json:"preferred_district"`
 	PriceSegment      string    `json:"price_segment"`
+	ShareStudy        bool      `json:"share_study"` // Grant matching clinics access to the study
 }
 
+// Grants made when an offer request shares its study
+const offerRequestGrantTTL = 30 * 24 * time.Hour
+
 // CreateOfferRequest creates a request for offers from clinics
 func (h *Handler) CreateOfferRequest(c *gin.Context) {
 	userID, _ := rbac.GetUserID(c)
@@ -34,21 +50,20 @@ func (h *Handler) CreateOfferRequest(c *gin.Context) {
 	}
 
 	// Get patient
-	var patient database.Patient
-	if err := database.DB.Where("user_id = ?", userID).First(&patient).Error; err != nil {
+	patient, err := h.patients.FindByUserID(userID)
+	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "patient not found"})
 		return
 	}
 
 	// Verify plan exists and belongs to patient
-	var planVersion database.PlanVersion
-	if err := database.DB.Preload("Study").Where("id = ?", req.PlanVersionID).First(&planVersion).Error; err != nil {
+	planVersion, err := h.plans.Get(req.PlanVersionID)
+	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "plan not found"})
 		return
 	}
 
-	if planVersion.Study.PatientID != patient.ID {
-		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+	if !h.policy.Authorize(c, policy.ActionCreate, policy.NewOfferRequest(planVersion)) {
 		return
 	}
 
@@ -63,7 +78,16 @@ func (h *Handler) CreateOfferRequest(c *gin.Context) {
 		Status:            "open",
 	}
 
-	if err := database.DB.Create(&offerRequest).Error; err != nil {
+	var grants []database.StudyGrant
+	if req.ShareStudy {
+		grants, err = h.matchingClinicGrants(&offerRequest, planVersion.StudyID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create offer request"})
+			return
+		}
+	}
+
+	if err := h.offers.CreateRequest(&offerRequest, grants); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create offer request"})
 		return
 	}
@@ -71,21 +95,47 @@ func (h *Handler) CreateOfferRequest(c *gin.Context) {
 	c.JSON(http.StatusCreated, offerRequest)
 }
 
+// matchingClinicGrants shares the study with every active clinic matching the
+// request's location and price preferences. The grants are stored with the
+// request.
+func (h *Handler) matchingClinicGrants(offerRequest *database.OfferRequest, studyID uuid.UUID) ([]database.StudyGrant, error) {
+	clinics, err := h.clinics.ListActive(repository.ClinicFilter{
+		City:         offerRequest.PreferredCity,
+		District:     offerRequest.PreferredDistrict,
+		PriceSegment: offerRequest.PriceSegment,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	scopes := []string{string(policy.ActionRead), string(policy.ActionReadReport), string(policy.ActionReadResults)}
+	expiresAt := time.Now().Add(offerRequestGrantTTL)
+
+	grants := make([]database.StudyGrant, 0, len(clinics))
+	for _, clinic := range clinics {
+		grants = append(grants, database.StudyGrant{
+			StudyID:   studyID,
+			PatientID: offerRequest.PatientID,
+			ClinicID:  clinic.ID,
+			Scopes:    scopes,
+			ExpiresAt: expiresAt,
+		})
+	}
+	return grants, nil
+}
+
 // GetMyOfferRequests returns all offer requests for the current patient
 func (h *Handler) GetMyOfferRequests(c *gin.Context) {
 	userID, _ := rbac.GetUserID(c)
 
-	var patient database.Patient
-	if err := database.DB.Where("user_id = ?", userID).First(&patient).Error; err != nil {
+	patient, err := h.patients.FindByUserID(userID)
+	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "patient not found"})
 		return
 	}
 
-	var offerRequests []database.OfferRequest
-	if err := database.DB.Preload("PlanVersion.PlanItems").Preload("Offers.Clinic").
-		Where("patient_id = ?", patient.ID).
-		Order("created_at DESC").
-		Find(&offerRequests).Error; err != nil {
+	offerRequests, err := h.offers.RequestsByPatient(patient.ID)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch offer requests"})
 		return
 	}
@@ -93,10 +143,9 @@ func (h *Handler) GetMyOfferRequests(c *gin.Context) {
 	c.JSON(http.StatusOK, offerRequests)
 }
 
-// GetOfferRequest returns a single offer request with all offers
+// GetOfferRequest returns a single offer request with its offers. Clinic
+// staff only see their own clinic's offer.
 func (h *Handler) GetOfferRequest(c *gin.Context) {
-	userID, _ := rbac.GetUserID(c)
-	userRole, _ := rbac.GetUserRole(c)
 	requestIDParam := c.Param("id")
 
 	requestID, err := uuid.Parse(requestIDParam)
@@ -105,26 +154,27 @@ func (h *Handler) GetOfferRequest(c *gin.Context) {
 		return
 	}
 
-	var offerRequest database.OfferRequest
-	query := database.DB.Preload("PlanVersion.PlanItems").Preload("Offers.Clinic")
-
-	if err := query.Where("id = ?", requestID).First(&offerRequest).Error; err != nil {
+	offerRequest, err := h.offers.GetRequest(requestID)
+	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "offer request not found"})
 		return
 	}
 
-	// Check access
-	if userRole == rbac.RolePatient {
-		var patient database.Patient
-		if err := database.DB.Where("user_id = ?", userID).First(&patient).Error; err != nil {
-			c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
-			return
-		}
+	resource, err := h.policy.OfferRequest(offerRequest)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch offer request"})
+		return
+	}
+	if !h.policy.Authorize(c, policy.ActionRead, resource) {
+		return
+	}
 
-		if offerRequest.PatientID != patient.ID {
-			c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
-			return
-		}
+	// Clinics see their own offer, not their competitors'
+	subject, _ := h.policy.SubjectFromContext(c)
+	offerRequest, err = h.offers.RequestDetails(requestID, subject.ClinicID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch offer request"})
+		return
 	}
 
 	c.JSON(http.StatusOK, offerRequest)
@@ -133,19 +183,18 @@ func (h *Handler) GetOfferRequest(c *gin.Context) {
 // Clinic creates an offer
 
 type CreateOfferReq struct {
-	OfferRequestID   uuid.UUID `json:"offer_request_id" binding:"required"`
-	TotalPrice       float64   `json:"total_price" binding:"required"`
-	DiscountPercent  float64   `json:"discount_percent"`
-	HasInstallment   bool      `json:"has_installment"`
-	InstallmentTerms string    `json:"installment_terms"`
-	SpecialOffer     string    `json:"special_offer"`
-	EstimatedDays    int       `json:"estimated_days"`
+	OfferRequestID   uuid.UUID     `json:"offer_request_id" binding:"required"`
+	TotalPrice       money.Decimal `json:"total_price"`
+	DiscountPercent  money.Decimal `json:"discount_percent"`
+	Currency         string        `json:"currency"` // Defaults to the clinic's
+	HasInstallment   bool          `json:"has_installment"`
+	InstallmentTerms string        `json:"installment_terms"`
+	SpecialOffer     string        `json:"special_offer"`
+	EstimatedDays    int           `json:"estimated_days"`
 }
 
 // CreateOffer allows a clinic to submit an offer
 func (h *Handler) CreateOffer(c *gin.Context) {
-	userID, _ := rbac.GetUserID(c)
-
 	var req CreateOfferReq
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -153,8 +202,8 @@ func (h *Handler) CreateOffer(c *gin.Context) {
 	}
 
 	// Get clinic
-	var clinic database.Clinic
-	if err := database.DB.Where("user_id = ?", userID).First(&clinic).Error; err != nil {
+	clinic, err := h.policy.CurrentClinic(c)
+	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "clinic not found"})
 		return
 	}
@@ -165,8 +214,8 @@ func (h *Handler) CreateOffer(c *gin.Context) {
 	}
 
 	// Verify offer request exists
-	var offerRequest database.OfferRequest
-	if err := database.DB.Where("id = ?", req.OfferRequestID).First(&offerRequest).Error; err != nil {
+	offerRequest, err := h.offers.GetRequest(req.OfferRequestID)
+	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "offer request not found"})
 		return
 	}
@@ -176,11 +225,34 @@ func (h *Handler) CreateOffer(c *gin.Context) {
 		return
 	}
 
+	resource, err := h.policy.OfferRequest(offerRequest)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create offer"})
+		return
+	}
+	if !h.policy.Authorize(c, policy.ActionOffer, resource) {
+		return
+	}
+
+	totalPrice, err := clinic.Price(req.TotalPrice, req.Currency)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if totalPrice.Sign() <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "total_price must be positive"})
+		return
+	}
+	if req.DiscountPercent.Sign() < 0 || req.DiscountPercent.Cmp(money.MustParseDecimal("100")) >= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "discount_percent must be at least 0 and less than 100"})
+		return
+	}
+
 	// Create offer
 	offer := database.Offer{
 		OfferRequestID:   req.OfferRequestID,
 		ClinicID:         clinic.ID,
-		TotalPrice:       req.TotalPrice,
+		TotalPrice:       totalPrice,
 		DiscountPercent:  req.DiscountPercent,
 		HasInstallment:   req.HasInstallment,
 		InstallmentTerms: req.InstallmentTerms,
@@ -189,7 +261,7 @@ func (h *Handler) CreateOffer(c *gin.Context) {
 		Status:           "pending",
 	}
 
-	if err := database.DB.Create(&offer).Error; err != nil {
+	if err := h.offers.Create(&offer); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create offer"})
 		return
 	}
@@ -199,19 +271,14 @@ func (h *Handler) CreateOffer(c *gin.Context) {
 
 // GetMyOffers returns all offers created by the current clinic
 func (h *Handler) GetMyOffers(c *gin.Context) {
-	userID, _ := rbac.GetUserID(c)
-
-	var clinic database.Clinic
-	if err := database.DB.Where("user_id = ?", userID).First(&clinic).Error; err != nil {
+	clinic, err := h.policy.CurrentClinic(c)
+	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "clinic not found"})
 		return
 	}
 
-	var offers []database.Offer
-	if err := database.DB.Preload("OfferRequest.PlanVersion.PlanItems").
-		Where("clinic_id = ?", clinic.ID).
-		Order("created_at DESC").
-		Find(&offers).Error; err != nil {
+	offers, err := h.offers.ListByClinic(clinic.ID)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch offers"})
 		return
 	}
@@ -230,21 +297,24 @@ func (h *Handler) AcceptOffer(c *gin.Context) {
 		return
 	}
 
-	var patient database.Patient
-	if err := database.DB.Where("user_id = ?", userID).First(&patient).Error; err != nil {
+	patient, err := h.patients.FindByUserID(userID)
+	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "patient not found"})
 		return
 	}
 
-	var offer database.Offer
-	if err := database.DB.Preload("OfferRequest").Where("id = ?", offerID).First(&offer).Error; err != nil {
+	offer, err := h.offers.Get(offerID)
+	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "offer not found"})
 		return
 	}
 
-	// Verify patient owns the offer request
-	if offer.OfferRequest.PatientID != patient.ID {
-		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+	resource, err := h.policy.Offer(offer)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to accept offer"})
+		return
+	}
+	if !h.policy.Authorize(c, policy.ActionAccept, resource) {
 		return
 	}
 
@@ -252,32 +322,32 @@ func (h *Handler) AcceptOffer(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "offer already processed"})
 		return
 	}
+	if !etag.Check(c, offer.Version) {
+		return
+	}
 
-	// Start transaction
-	tx := database.DB.Begin()
-
-	// Update offer status
-	offer.Status = "accepted"
-	if err := tx.Save(&offer).Error; err != nil {
-		tx.Rollback()
+	total, err := offer.FinalPrice()
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to accept offer"})
 		return
 	}
 
-	// Create order
+	// The chosen clinic keeps its access; the others lose what the request gave them
 	order := database.Order{
-		OfferID:   offer.ID,
 		PatientID: patient.ID,
 		ClinicID:  offer.ClinicID,
 		Status:    "new",
+		Total:     total,
 	}
-	if err := tx.Create(&order).Error; err != nil {
-		tx.Rollback()
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create order"})
+	if err := h.offers.Accept(offer, &order); errors.Is(err, repository.ErrConflict) {
+		c.JSON(http.StatusConflict, gin.H{"error": "offer or its request changed, fetch them again"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to accept offer"})
 		return
 	}
 
-	tx.Commit()
+	etag.Set(c, offer.Version)
 
 	c.JSON(http.StatusOK, gin.H{
 		"message": "offer accepted",
@@ -0,0 +1,198 @@
+package offers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/igorfazlyev/dm/internal/database"
+	"github.com/igorfazlyev/dm/internal/etag"
+	"github.com/igorfazlyev/dm/internal/events"
+	"github.com/igorfazlyev/dm/internal/policy"
+	"github.com/igorfazlyev/dm/internal/rbac"
+	"github.com/igorfazlyev/dm/internal/repository"
+	"github.com/igorfazlyev/dm/internal/repository/memory"
+	"github.com/igorfazlyev/dm/pkg/money"
+)
+
+// acceptFixture is an open offer request with offers from two clinics, each
+// granted access to the study by the request
+type acceptFixture struct {
+	store       *memory.Store
+	handler     *Handler
+	userID      uuid.UUID
+	patient     database.Patient
+	request     database.OfferRequest
+	offer       database.Offer // The one accepted
+	rival       database.Offer
+	rivalClinic uuid.UUID
+}
+
+func newAcceptFixture(t *testing.T) *acceptFixture {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	store := memory.NewStore()
+	f := &acceptFixture{store: store}
+
+	user := database.User{Email: "patient@example.com", Role: rbac.RolePatient, IsActive: true}
+	store.AddUser(&user)
+	f.userID = user.ID
+
+	f.patient = database.Patient{UserID: user.ID}
+	if err := store.Patients.Create(&f.patient); err != nil {
+		t.Fatal(err)
+	}
+
+	chosen := database.Clinic{Name: "Chosen", IsActive: true, Status: "approved"}
+	rival := database.Clinic{Name: "Rival", IsActive: true, Status: "approved"}
+	store.AddClinic(&chosen)
+	store.AddClinic(&rival)
+	f.rivalClinic = rival.ID
+
+	studyID := uuid.New()
+	expires := time.Now().Add(24 * time.Hour)
+	f.request = database.OfferRequest{PatientID: f.patient.ID, PlanVersionID: uuid.New(), Status: "open"}
+	grants := []database.StudyGrant{
+		{StudyID: studyID, ClinicID: chosen.ID, Scopes: []string{"read"}, ExpiresAt: expires},
+		{StudyID: studyID, ClinicID: rival.ID, Scopes: []string{"read"}, ExpiresAt: expires},
+	}
+	if err := store.Offers.CreateRequest(&f.request, grants); err != nil {
+		t.Fatal(err)
+	}
+
+	f.offer = database.Offer{
+		OfferRequestID:  f.request.ID,
+		ClinicID:        chosen.ID,
+		TotalPrice:      money.MustParse("1000.00", "RUB"),
+		DiscountPercent: money.MustParseDecimal("10"),
+		Status:          "pending",
+	}
+	f.rival = database.Offer{
+		OfferRequestID: f.request.ID,
+		ClinicID:       rival.ID,
+		TotalPrice:     money.MustParse("1200.00", "RUB"),
+		Status:         "pending",
+	}
+	for _, offer := range []*database.Offer{&f.offer, &f.rival} {
+		if err := store.Offers.Create(offer); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f.handler = NewHandler(store.Offers, store.Plans, store.Patients, store.Clinics, policy.New(&store.Store))
+	return f
+}
+
+// accept posts to AcceptOffer as the user with role
+func (f *acceptFixture) accept(offerID, userID uuid.UUID, role, ifMatch string) *httptest.ResponseRecorder {
+	router := gin.New()
+	router.POST("/offers/:id/accept", func(c *gin.Context) {
+		c.Set("user_id", userID)
+		c.Set("user_role", role)
+	}, f.handler.AcceptOffer)
+
+	req := httptest.NewRequest(http.MethodPost, "/offers/"+offerID.String()+"/accept", nil)
+	if ifMatch != "" {
+		req.Header.Set("If-Match", ifMatch)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestAcceptOffer(t *testing.T) {
+	f := newAcceptFixture(t)
+
+	w := f.accept(f.offer.ID, f.userID, rbac.RolePatient, etag.Format(f.offer.Version))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+	}
+	if got, want := w.Header().Get("ETag"), etag.Format(f.offer.Version+1); got != want {
+		t.Errorf("ETag = %s, want %s", got, want)
+	}
+
+	offer, _ := f.store.Offers.Get(f.offer.ID)
+	rival, _ := f.store.Offers.Get(f.rival.ID)
+	request, _ := f.store.Offers.GetRequest(f.request.ID)
+	if offer.Status != "accepted" || rival.Status != "rejected" || request.Status != "closed" {
+		t.Errorf("offer %s, rival %s, request %s; want accepted, rejected, closed",
+			offer.Status, rival.Status, request.Status)
+	}
+
+	orders, err := f.store.Orders.List(repository.OrderFilter{PatientID: f.patient.ID})
+	if err != nil || len(orders) != 1 {
+		t.Fatalf("orders = %v, %v; want one", orders, err)
+	}
+	order := orders[0]
+	if order.OfferID != f.offer.ID || order.ClinicID != f.offer.ClinicID || order.Status != "new" {
+		t.Errorf("order = %+v", order)
+	}
+	if want := money.MustParse("900.00", "RUB"); order.Total != want {
+		t.Errorf("order total = %v, want %v", order.Total, want)
+	}
+
+	grants, _ := f.store.Studies.ClinicGrants(f.rivalClinic)
+	if len(grants) != 0 {
+		t.Errorf("rival clinic kept %d grants", len(grants))
+	}
+	grants, _ = f.store.Studies.ClinicGrants(f.offer.ClinicID)
+	if len(grants) != 1 {
+		t.Errorf("chosen clinic has %d grants, want 1", len(grants))
+	}
+
+	published := f.store.Events()
+	if len(published) != 1 {
+		t.Fatalf("published %d events, want 1", len(published))
+	}
+	if e, ok := published[0].(events.OfferAccepted); !ok || e.OrderID != order.ID {
+		t.Errorf("event = %#v, want OfferAccepted for the order", published[0])
+	}
+}
+
+func TestAcceptOfferRejections(t *testing.T) {
+	stranger := uuid.New()
+
+	tests := []struct {
+		name   string
+		run    func(t *testing.T, f *acceptFixture) *httptest.ResponseRecorder
+		status int
+	}{
+		{"stale If-Match", func(t *testing.T, f *acceptFixture) *httptest.ResponseRecorder {
+			return f.accept(f.offer.ID, f.userID, rbac.RolePatient, etag.Format(f.offer.Version+1))
+		}, http.StatusConflict},
+		{"other patient", func(t *testing.T, f *acceptFixture) *httptest.ResponseRecorder {
+			other := database.Patient{UserID: stranger}
+			if err := f.store.Patients.Create(&other); err != nil {
+				t.Fatal(err)
+			}
+			return f.accept(f.offer.ID, stranger, rbac.RolePatient, "")
+		}, http.StatusForbidden},
+		{"unknown offer", func(t *testing.T, f *acceptFixture) *httptest.ResponseRecorder {
+			return f.accept(uuid.New(), f.userID, rbac.RolePatient, "")
+		}, http.StatusNotFound},
+		{"second offer of a request", func(t *testing.T, f *acceptFixture) *httptest.ResponseRecorder {
+			if w := f.accept(f.rival.ID, f.userID, rbac.RolePatient, ""); w.Code != http.StatusOK {
+				t.Fatalf("first accept: %d %s", w.Code, w.Body)
+			}
+			return f.accept(f.offer.ID, f.userID, rbac.RolePatient, "")
+		}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newAcceptFixture(t)
+			w := tt.run(t, f)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+
+			offer, _ := f.store.Offers.Get(f.offer.ID)
+			if offer.Status == "accepted" {
+				t.Error("offer was accepted")
+			}
+		})
+	}
+}
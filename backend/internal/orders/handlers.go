@@ -1,48 +1,51 @@
 package orders
 
 import (
+	"errors"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
-	"github.com/igorfazlyev/dm/internal/database"
+	"github.com/igorfazlyev/dm/internal/etag"
+	"github.com/igorfazlyev/dm/internal/policy"
 	"github.com/igorfazlyev/dm/internal/rbac"
+	"github.com/igorfazlyev/dm/internal/repository"
 )
 
-type Handler struct{}
+type Handler struct {
+	orders repository.Orders
+	policy *policy.Policy
+}
 
-func NewHandler() *Handler {
-	return &Handler{}
+func NewHandler(orders repository.Orders, accessPolicy *policy.Policy) *Handler {
+	return &Handler{orders: orders, policy: accessPolicy}
 }
 
 // GetMyOrders returns orders for the current user (patient or clinic)
 func (h *Handler) GetMyOrders(c *gin.Context) {
-	userID, _ := rbac.GetUserID(c)
-	userRole, _ := rbac.GetUserRole(c)
-
-	var orders []database.Order
-	query := database.DB.Preload("Offer.OfferRequest.PlanVersion.PlanItems").
-		Preload("Patient").
-		Preload("Clinic").
-		Order("created_at DESC")
-
-	if userRole == rbac.RolePatient {
-		var patient database.Patient
-		if err := database.DB.Where("user_id = ?", userID).First(&patient).Error; err != nil {
+	subject, err := h.policy.SubjectFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch orders"})
+		return
+	}
+
+	var filter repository.OrderFilter
+	if subject.Role == rbac.RolePatient {
+		if subject.PatientID == uuid.Nil {
 			c.JSON(http.StatusNotFound, gin.H{"error": "patient not found"})
 			return
 		}
-		query = query.Where("patient_id = ?", patient.ID)
-	} else if userRole == rbac.RoleClinicDoctor || userRole == rbac.RoleClinicManager {
-		var clinic database.Clinic
-		if err := database.DB.Where("user_id = ?", userID).First(&clinic).Error; err != nil {
+		filter.PatientID = subject.PatientID
+	} else if subject.Role == rbac.RoleClinicDoctor || subject.Role == rbac.RoleClinicManager || subject.Role == rbac.RoleClinicAPI {
+		if subject.ClinicID == uuid.Nil {
 			c.JSON(http.StatusNotFound, gin.H{"error": "clinic not found"})
 			return
 		}
-		query = query.Where("clinic_id = ?", clinic.ID)
+		filter.ClinicID = subject.ClinicID
 	}
 
-	if err := query.Find(&orders).Error; err != nil {
+	orders, err := h.orders.List(filter)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch orders"})
 		return
 	}
@@ -52,8 +55,6 @@ func (h *Handler) GetMyOrders(c *gin.Context) {
 
 // GetOrder returns a single order by ID
 func (h *Handler) GetOrder(c *gin.Context) {
-	userID, _ := rbac.GetUserID(c)
-	userRole, _ := rbac.GetUserRole(c)
 	orderIDParam := c.Param("id")
 
 	orderID, err := uuid.Parse(orderIDParam)
@@ -62,40 +63,17 @@ func (h *Handler) GetOrder(c *gin.Context) {
 		return
 	}
 
-	var order database.Order
-	query := database.DB.Preload("Offer.OfferRequest.PlanVersion.PlanItems").
-		Preload("Patient").
-		Preload("Clinic").
-		Preload("Slots")
-
-	if err := query.Where("id = ?", orderID).First(&order).Error; err != nil {
+	order, err := h.orders.Details(orderID)
+	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "order not found"})
 		return
 	}
 
-	// Check access
-	if userRole == rbac.RolePatient {
-		var patient database.Patient
-		if err := database.DB.Where("user_id = ?", userID).First(&patient).Error; err != nil {
-			c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
-			return
-		}
-		if order.PatientID != patient.ID {
-			c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
-			return
-		}
-	} else if userRole == rbac.RoleClinicDoctor || userRole == rbac.RoleClinicManager {
-		var clinic database.Clinic
-		if err := database.DB.Where("user_id = ?", userID).First(&clinic).Error; err != nil {
-			c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
-			return
-		}
-		if order.ClinicID != clinic.ID {
-			c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
-			return
-		}
+	if !h.policy.Authorize(c, policy.ActionRead, policy.Order(order)) {
+		return
 	}
 
+	etag.Set(c, order.Version)
 	c.JSON(http.StatusOK, order)
 }
 
@@ -105,7 +83,6 @@ type UpdateOrderStatusReq struct {
 
 // UpdateOrderStatus updates order status (clinic only)
 func (h *Handler) UpdateOrderStatus(c *gin.Context) {
-	userID, _ := rbac.GetUserID(c)
 	orderIDParam := c.Param("id")
 
 	orderID, err := uuid.Parse(orderIDParam)
@@ -120,25 +97,27 @@ func (h *Handler) UpdateOrderStatus(c *gin.Context) {
 		return
 	}
 
-	// Get clinic
-	var clinic database.Clinic
-	if err := database.DB.Where("user_id = ?", userID).First(&clinic).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "clinic not found"})
+	order, err := h.orders.Get(orderID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "order not found"})
 		return
 	}
 
-	// Get order
-	var order database.Order
-	if err := database.DB.Where("id = ? AND clinic_id = ?", orderID, clinic.ID).First(&order).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "order not found"})
+	if !h.policy.Authorize(c, policy.ActionUpdateStatus, policy.Order(order)) {
+		return
+	}
+	if !etag.Check(c, order.Version) {
 		return
 	}
 
-	order.Status = req.Status
-	if err := database.DB.Save(&order).Error; err != nil {
+	if err := h.orders.UpdateStatus(order, req.Status); errors.Is(err, repository.ErrConflict) {
+		etag.Conflict(c)
+		return
+	} else if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update order"})
 		return
 	}
 
+	etag.Set(c, order.Version)
 	c.JSON(http.StatusOK, order)
 }
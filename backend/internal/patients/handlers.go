@@ -8,12 +8,16 @@ import (
 	"github.com/google/uuid"
 	"github.com/igorfazlyev/dm/internal/database"
 	"github.com/igorfazlyev/dm/internal/rbac"
+	"github.com/igorfazlyev/dm/internal/repository"
 )
 
-type Handler struct{}
+type Handler struct {
+	patients repository.Patients
+	studies  repository.Studies
+}
 
-func NewHandler() *Handler {
-	return &Handler{}
+func NewHandler(patients repository.Patients, studies repository.Studies) *Handler {
+	return &Handler{patients: patients, studies: studies}
 }
 
 type CreatePatientRequest struct {
@@ -39,8 +43,8 @@ type UpdatePatientRequest struct {
 func (h *Handler) GetMyProfile(c *gin.Context) {
 	userID, _ := rbac.GetUserID(c)
 
-	var patient database.Patient
-	if err := database.DB.Where("user_id = ?", userID).First(&patient).Error; err != nil {
+	patient, err := h.patients.FindByUserID(userID)
+	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "patient profile not found"})
 		return
 	}
@@ -70,19 +74,18 @@ func (h *Handler) CreateMyProfile(c *gin.Context) {
 		return
 	}
 
-	// Check if profile already exists
-	var existingPatient database.Patient
-	if err := database.DB.Where("user_id = ?", userID).First(&existingPatient).Error; err == nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "profile already exists, use PUT to update"})
-		return
-	}
-
 	userUUID, err := uuid.Parse(userID)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"})
 		return
 	}
 
+	// Check if profile already exists
+	if _, err := h.patients.FindByUserID(userUUID); err == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "profile already exists, use PUT to update"})
+		return
+	}
+
 	patient := database.Patient{
 		UserID:                userUUID,
 		FirstName:             req.FirstName,
@@ -94,7 +97,7 @@ func (h *Handler) CreateMyProfile(c *gin.Context) {
 		PreferredPriceSegment: req.PreferredPriceSegment,
 	}
 
-	if err := database.DB.Create(&patient).Error; err != nil {
+	if err := h.patients.Create(&patient); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create profile"})
 		return
 	}
@@ -112,8 +115,8 @@ func (h *Handler) UpdateMyProfile(c *gin.Context) {
 		return
 	}
 
-	var patient database.Patient
-	if err := database.DB.Where("user_id = ?", userID).First(&patient).Error; err != nil {
+	patient, err := h.patients.FindByUserID(userID)
+	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "patient profile not found"})
 		return
 	}
@@ -138,7 +141,7 @@ func (h *Handler) UpdateMyProfile(c *gin.Context) {
 		patient.PreferredPriceSegment = req.PreferredPriceSegment
 	}
 
-	if err := database.DB.Save(&patient).Error; err != nil {
+	if err := h.patients.Save(patient); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update profile"})
 		return
 	}
@@ -150,16 +153,14 @@ func (h *Handler) UpdateMyProfile(c *gin.Context) {
 func (h *Handler) GetMyStudies(c *gin.Context) {
 	userID, _ := rbac.GetUserID(c)
 
-	var patient database.Patient
-	if err := database.DB.Where("user_id = ?", userID).First(&patient).Error; err != nil {
+	patient, err := h.patients.FindByUserID(userID)
+	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "patient not found"})
 		return
 	}
 
-	var studies []database.Study
-	if err := database.DB.Where("patient_id = ?", patient.ID).
-		Order("created_at DESC").
-		Find(&studies).Error; err != nil {
+	studies, err := h.studies.ListByPatient(patient.ID)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch studies"})
 		return
 	}
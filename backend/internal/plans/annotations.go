@@ -0,0 +1,80 @@
+package plans
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/igorfazlyev/dm/internal/database"
+	"github.com/igorfazlyev/dm/internal/policy"
+	"github.com/igorfazlyev/dm/internal/rbac"
+)
+
+type CreateAnnotationRequest struct {
+	PlanItemID *uuid.UUID `json:"plan_item_id"`
+	Body       string     `json:"body" binding:"required"`
+}
+
+// CreateAnnotation adds a doctor's note to a plan that has been sent to
+// clinics for offers
+func (h *Handler) CreateAnnotation(c *gin.Context) {
+	userID, _ := rbac.GetUserID(c)
+
+	var req CreateAnnotationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	subject, err := h.policy.SubjectFromContext(c)
+	if err != nil || subject.ClinicID == uuid.Nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "clinic not found"})
+		return
+	}
+
+	// Clinics only see plans that patients have requested offers for
+	planVersion, ok := h.loadPlan(c, policy.ActionAnnotate)
+	if !ok {
+		return
+	}
+
+	if req.PlanItemID != nil {
+		if _, err := h.plans.GetItem(planVersion.ID, *req.PlanItemID); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "plan item not found in this plan"})
+			return
+		}
+	}
+
+	annotation := database.PlanAnnotation{
+		PlanVersionID: planVersion.ID,
+		PlanItemID:    req.PlanItemID,
+		ClinicID:      subject.ClinicID,
+		AuthorID:      userID,
+		Body:          req.Body,
+	}
+
+	if err := h.plans.CreateAnnotation(&annotation); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create annotation"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, annotation)
+}
+
+// GetAnnotations returns the annotations of a plan. Patients see every
+// clinic's notes on their plan, clinic staff only their own clinic's.
+func (h *Handler) GetAnnotations(c *gin.Context) {
+	planVersion, ok := h.loadPlan(c, policy.ActionRead)
+	if !ok {
+		return
+	}
+
+	subject, _ := h.policy.SubjectFromContext(c)
+	annotations, err := h.plans.Annotations(planVersion.ID, subject.ClinicID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch annotations"})
+		return
+	}
+
+	c.JSON(http.StatusOK, annotations)
+}
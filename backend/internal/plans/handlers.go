@@ -6,13 +6,20 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/igorfazlyev/dm/internal/database"
-	"github.com/igorfazlyev/dm/internal/rbac"
+	"github.com/igorfazlyev/dm/internal/policy"
+	"github.com/igorfazlyev/dm/internal/repository"
+	"github.com/igorfazlyev/dm/pkg/money"
 )
 
-type Handler struct{}
+type Handler struct {
+	plans   repository.Plans
+	studies repository.Studies
+	clinics repository.Clinics
+	policy  *policy.Policy
+}
 
-func NewHandler() *Handler {
-	return &Handler{}
+func NewHandler(plans repository.Plans, studies repository.Studies, clinics repository.Clinics, accessPolicy *policy.Policy) *Handler {
+	return &Handler{plans: plans, studies: studies, clinics: clinics, policy: accessPolicy}
 }
 
 type CreatePlanRequest struct {
@@ -34,9 +41,6 @@ type PlanItemData struct {
 
 // CreatePlan creates a new treatment plan version
 func (h *Handler) CreatePlan(c *gin.Context) {
-	userID, _ := rbac.GetUserID(c)
-	userRole, _ := rbac.GetUserRole(c)
-
 	var req CreatePlanRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -44,43 +48,23 @@ func (h *Handler) CreatePlan(c *gin.Context) {
 	}
 
 	// Verify study exists and user has access
-	var study database.Study
-	query := database.DB
-
-	if userRole == rbac.RolePatient {
-		var patient database.Patient
-		if err := database.DB.Where("user_id = ?", userID).First(&patient).Error; err != nil {
-			c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
-			return
-		}
-		query = query.Where("patient_id = ?", patient.ID)
-	}
-
-	if err := query.Where("id = ?", req.StudyID).First(&study).Error; err != nil {
+	study, err := h.studies.Get(req.StudyID)
+	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "study not found"})
 		return
 	}
 
-	// Get next version number
-	var count int64
-	database.DB.Model(&database.PlanVersion{}).Where("study_id = ?", req.StudyID).Count(&count)
+	if !h.policy.Authorize(c, policy.ActionCreate, policy.NewPlan(study)) {
+		return
+	}
 
-	// Create plan version
 	planVersion := database.PlanVersion{
 		StudyID: req.StudyID,
-		Version: int(count) + 1,
 		Source:  req.Source,
 	}
 
-	if err := database.DB.Create(&planVersion).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create plan version"})
-		return
-	}
-
-	// Create plan items
 	for _, itemData := range req.Items {
 		item := database.PlanItem{
-			PlanVersionID: planVersion.ID,
 			ToothNumber:   itemData.ToothNumber,
 			Specialty:     itemData.Specialty,
 			ProcedureCode: itemData.ProcedureCode,
@@ -95,59 +79,57 @@ func (h *Handler) CreatePlan(c *gin.Context) {
 			item.Quantity = 1
 		}
 
-		if err := database.DB.Create(&item).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create plan item"})
-			return
-		}
+		planVersion.PlanItems = append(planVersion.PlanItems, item)
 	}
 
-	// Reload with items
-	database.DB.Preload("PlanItems").First(&planVersion, planVersion.ID)
+	// The version number is assigned on create
+	if err := h.plans.Create(&planVersion); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create plan version"})
+		return
+	}
 
 	c.JSON(http.StatusCreated, planVersion)
 }
 
 // GetPlan returns a treatment plan by ID
 func (h *Handler) GetPlan(c *gin.Context) {
-	userID, _ := rbac.GetUserID(c)
-	userRole, _ := rbac.GetUserRole(c)
-	planIDParam := c.Param("id")
+	planVersion, ok := h.loadPlan(c, policy.ActionRead)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, planVersion)
+}
 
-	planID, err := uuid.Parse(planIDParam)
+// loadPlan fetches the plan in the path with its items and study and
+// authorizes action on it. It writes the error response itself when it
+// returns false.
+func (h *Handler) loadPlan(c *gin.Context, action policy.Action) (*database.PlanVersion, bool) {
+	planID, err := uuid.Parse(c.Param("id"))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid plan ID"})
-		return
+		return nil, false
 	}
 
-	var planVersion database.PlanVersion
-	query := database.DB.Preload("PlanItems").Preload("Study.Patient")
-
-	if err := query.Where("id = ?", planID).First(&planVersion).Error; err != nil {
+	planVersion, err := h.plans.Get(planID)
+	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "plan not found"})
-		return
+		return nil, false
 	}
 
-	// Check access for patients
-	if userRole == rbac.RolePatient {
-		var patient database.Patient
-		if err := database.DB.Where("user_id = ?", userID).First(&patient).Error; err != nil {
-			c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
-			return
-		}
-
-		if planVersion.Study.PatientID != patient.ID {
-			c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
-			return
-		}
+	resource, err := h.policy.Plan(planVersion)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch plan"})
+		return nil, false
 	}
-
-	c.JSON(http.StatusOK, planVersion)
+	if !h.policy.Authorize(c, action, resource) {
+		return nil, false
+	}
+	return planVersion, true
 }
 
 // GetPlansByStudy returns all plan versions for a study
 func (h *Handler) GetPlansByStudy(c *gin.Context) {
-	userID, _ := rbac.GetUserID(c)
-	userRole, _ := rbac.GetUserRole(c)
 	studyIDParam := c.Param("study_id")
 
 	studyID, err := uuid.Parse(studyIDParam)
@@ -157,29 +139,24 @@ func (h *Handler) GetPlansByStudy(c *gin.Context) {
 	}
 
 	// Verify study access
-	var study database.Study
-	query := database.DB
-
-	if userRole == rbac.RolePatient {
-		var patient database.Patient
-		if err := database.DB.Where("user_id = ?", userID).First(&patient).Error; err != nil {
-			c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
-			return
-		}
-		query = query.Where("patient_id = ?", patient.ID)
+	study, err := h.studies.Get(studyID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "study not found"})
+		return
 	}
 
-	if err := query.Where("id = ?", studyID).First(&study).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "study not found"})
+	resource, err := h.policy.Study(study)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch study"})
+		return
+	}
+	if !h.policy.Authorize(c, policy.ActionRead, resource) {
 		return
 	}
 
 	// Get plan versions
-	var planVersions []database.PlanVersion
-	if err := database.DB.Preload("PlanItems").
-		Where("study_id = ?", studyID).
-		Order("version DESC").
-		Find(&planVersions).Error; err != nil {
+	planVersions, err := h.plans.ListByStudy(studyID)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch plans"})
 		return
 	}
@@ -187,41 +164,95 @@ func (h *Handler) GetPlansByStudy(c *gin.Context) {
 	c.JSON(http.StatusOK, planVersions)
 }
 
-// GetEstimate calculates price estimates by specialty
+// SpecialtyEstimate is what a plan's items of one specialty cost across
+// active clinics' price lists. Priced counts the items some clinic lists a
+// price for; the others are left out of Min and Max.
+type SpecialtyEstimate struct {
+	Count  int         `json:"count"`
+	Priced int         `json:"priced"`
+	Min    money.Money `json:"min"`
+	Max    money.Money `json:"max"`
+}
+
+// add counts quantity of an item priced within price into the estimate
+func (e *SpecialtyEstimate) add(price repository.PriceRange, quantity int) error {
+	lowest, err := price.Min.MulInt(quantity)
+	if err != nil {
+		return err
+	}
+	highest, err := price.Max.MulInt(quantity)
+	if err != nil {
+		return err
+	}
+	// Every range is in the estimate's currency, so only the range can fail
+	if e.Min, err = e.Min.Add(lowest); err != nil {
+		return err
+	}
+	if e.Max, err = e.Max.Add(highest); err != nil {
+		return err
+	}
+	e.Priced++
+	return nil
+}
+
+// GetEstimate estimates a plan's price by specialty from the price lists of
+// active clinics, in ?currency= (RUB by default)
 func (h *Handler) GetEstimate(c *gin.Context) {
-	planIDParam := c.Param("id")
-	planID, err := uuid.Parse(planIDParam)
+	currency, err := money.NormalizeCurrency(c.DefaultQuery("currency", database.DefaultCurrency))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid plan ID"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	var planVersion database.PlanVersion
-	if err := database.DB.Preload("PlanItems").Where("id = ?", planID).First(&planVersion).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "plan not found"})
+	planVersion, ok := h.loadPlan(c, policy.ActionRead)
+	if !ok {
+		return
+	}
+
+	var codes []string
+	for _, item := range planVersion.PlanItems {
+		if item.ProcedureCode != "" {
+			codes = append(codes, item.ProcedureCode)
+		}
+	}
+	prices, err := h.clinics.PriceRanges(codes, currency)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch prices"})
 		return
 	}
 
 	// Group by specialty
-	estimates := map[string]map[string]float64{
-		"therapy":      {"min": 0, "max": 0, "count": 0},
-		"orthopedics":  {"min": 0, "max": 0, "count": 0},
-		"surgery":      {"min": 0, "max": 0, "count": 0},
-		"hygiene":      {"min": 0, "max": 0, "count": 0},
-		"periodontics": {"min": 0, "max": 0, "count": 0},
+	estimates := map[string]*SpecialtyEstimate{}
+	for _, specialty := range []string{"therapy", "orthopedics", "surgery", "hygiene", "periodontics"} {
+		estimates[specialty] = &SpecialtyEstimate{Min: money.Zero(currency), Max: money.Zero(currency)}
 	}
+	total := SpecialtyEstimate{Min: money.Zero(currency), Max: money.Zero(currency)}
 
 	for _, item := range planVersion.PlanItems {
-		if specialty, exists := estimates[item.Specialty]; exists {
-			specialty["count"]++
-			// This is placeholder - real implementation would look up prices
-			// For now, just count items
+		estimate, exists := estimates[item.Specialty]
+		if !exists {
+			continue
+		}
+		estimate.Count++
+		total.Count++
+
+		price, priced := prices[item.ProcedureCode]
+		if !priced {
+			continue
+		}
+		quantity := max(item.Quantity, 1)
+		for _, e := range []*SpecialtyEstimate{estimate, &total} {
+			if err := e.add(price, quantity); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to estimate prices"})
+				return
+			}
 		}
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"plan_id":   planID,
+		"plan_id":   planVersion.ID,
+		"currency":  currency,
 		"estimates": estimates,
-		"note":      "Estimates require clinic pricelist integration",
+		"total":     total,
 	})
 }
@@ -0,0 +1,110 @@
+package policy
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/igorfazlyev/dm/internal/database"
+	"github.com/igorfazlyev/dm/internal/rbac"
+	"github.com/igorfazlyev/dm/internal/repository"
+)
+
+const subjectKey = "policy_subject"
+
+// SubjectFromContext builds the subject of the current request, looking up
+// the patient profile or clinic the user acts for. The result is cached on
+// the request.
+func (p *Policy) SubjectFromContext(c *gin.Context) (Subject, error) {
+	if cached, ok := c.Get(subjectKey); ok {
+		return cached.(Subject), nil
+	}
+
+	userID, _ := rbac.GetUserID(c)
+	role, _ := rbac.GetUserRole(c)
+	subject := Subject{UserID: userID, Role: role}
+
+	switch role {
+	case rbac.RolePatient:
+		patient, err := p.store.Patients.FindByUserID(userID)
+		if err != nil && !errors.Is(err, repository.ErrNotFound) {
+			return Subject{}, err
+		}
+		if patient != nil {
+			subject.PatientID = patient.ID
+		}
+	case rbac.RoleClinicAPI:
+		subject.ClinicID, _ = rbac.GetClinicID(c)
+	case rbac.RoleClinicDoctor, rbac.RoleClinicManager:
+		// Staff without a clinic yet simply match no clinic rules, and so do
+		// the staff of a suspended clinic: they keep their accounts but lose
+		// its grants, offers, orders and settings until it is reactivated.
+		// A pending clinic's staff still set it up for approval.
+		clinic, err := p.store.Clinics.ForMember(userID)
+		if err != nil && !errors.Is(err, repository.ErrNotFound) {
+			return Subject{}, err
+		}
+		if clinic != nil && clinic.Status != "suspended" {
+			subject.ClinicID = clinic.ID
+		}
+	}
+
+	c.Set(subjectKey, subject)
+	return subject, nil
+}
+
+// CurrentClinic returns the clinic the request acts for: the API key's clinic,
+// or the clinic the staff member belongs to. It returns
+// repository.ErrNotFound when the subject acts for no clinic.
+func (p *Policy) CurrentClinic(c *gin.Context) (*database.Clinic, error) {
+	subject, err := p.SubjectFromContext(c)
+	if err != nil {
+		return nil, err
+	}
+	if subject.ClinicID == uuid.Nil {
+		return nil, repository.ErrNotFound
+	}
+	return p.store.Clinics.Get(subject.ClinicID)
+}
+
+// Authorize checks the current request against the policy. On denial it logs
+// the decision and responds with 403, and the handler must return.
+func (p *Policy) Authorize(c *gin.Context, action Action, resource Resource) bool {
+	subject, err := p.SubjectFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check permissions"})
+		return false
+	}
+
+	if Can(subject, action, resource) {
+		if grant := resource.grantFor(subject, action); grant != nil {
+			p.recordGrantAccess(c, subject, action, resource, grant)
+		}
+		return true
+	}
+
+	log.Printf("policy: denied %s on %s %s to user=%s role=%s clinic=%s (%s %s)",
+		action, resource.Type, resource.ID, subject.UserID, subject.Role, subject.ClinicID,
+		c.Request.Method, c.Request.URL.Path)
+	c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+	return false
+}
+
+// recordGrantAccess logs a clinic's use of a patient grant. A failure to
+// record does not block the request.
+func (p *Policy) recordGrantAccess(c *gin.Context, subject Subject, action Action, resource Resource, grant *Grant) {
+	entry := database.StudyAccessLog{
+		GrantID:   grant.ID,
+		StudyID:   resource.ID,
+		ClinicID:  subject.ClinicID,
+		UserID:    subject.UserID,
+		Action:    string(action),
+		IPAddress: c.ClientIP(),
+	}
+	if err := p.store.Studies.LogAccess(&entry); err != nil {
+		log.Printf("policy: failed to record %s on %s %s under grant %s: %v",
+			action, resource.Type, resource.ID, grant.ID, err)
+	}
+}
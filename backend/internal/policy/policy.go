@@ -0,0 +1,223 @@
+package policy
+
+import (
+	"github.com/google/uuid"
+	"github.com/igorfazlyev/dm/internal/rbac"
+)
+
+// Action is something a subject does to a resource
+type Action string
+
+const (
+	ActionRead         Action = "read"
+	ActionCreate       Action = "create"
+	ActionReadReport   Action = "read_report"  // A study's AI report PDF
+	ActionReadResults  Action = "read_results" // A study's AI analysis JSON
+	ActionShare        Action = "share"        // Grant clinics access to a study
+	ActionUpload       Action = "upload"
+	ActionAnnotate     Action = "annotate"
+	ActionOffer        Action = "offer" // Submit an offer on an offer request
+	ActionAccept       Action = "accept"
+	ActionUpdateStatus Action = "update_status"
+)
+
+// Resource types
+const (
+	TypeStudy        = "study"
+	TypePlan         = "plan"
+	TypeOfferRequest = "offer_request"
+	TypeOffer        = "offer"
+	TypeOrder        = "order"
+)
+
+// Subject is who is acting. PatientID is set for patients with a profile,
+// ClinicID for clinic staff and clinic API keys.
+type Subject struct {
+	UserID    uuid.UUID
+	Role      string
+	PatientID uuid.UUID
+	ClinicID  uuid.UUID
+}
+
+// Resource describes what is being accessed in the terms rules need: the
+// patient it belongs to and the clinics involved with it
+type Resource struct {
+	Type      string
+	ID        uuid.UUID
+	PatientID uuid.UUID
+	ClinicIDs []uuid.UUID // Clinics with an offer or order on the resource
+	Listed    bool        // Published to all clinics through an open offer request
+	Grants    []Grant     // Active patient grants to clinics
+}
+
+// Grant is a clinic's access to a resource under a patient grant
+type Grant struct {
+	ID       uuid.UUID
+	ClinicID uuid.UUID
+	Actions  []Action
+}
+
+// GrantScopes are the actions a patient can grant a clinic on a study
+var GrantScopes = map[Action]bool{
+	ActionRead:        true,
+	ActionReadReport:  true,
+	ActionReadResults: true,
+}
+
+// grantFor returns the grant allowing subject's clinic action, if any
+func (r Resource) grantFor(s Subject, action Action) *Grant {
+	if s.ClinicID == uuid.Nil {
+		return nil
+	}
+	for i, g := range r.Grants {
+		if g.ClinicID != s.ClinicID {
+			continue
+		}
+		for _, a := range g.Actions {
+			if a == action {
+				return &r.Grants[i]
+			}
+		}
+	}
+	return nil
+}
+
+// condition narrows a rule to subjects related to the resource
+type condition func(Subject, Resource) bool
+
+// rule allows subjects with one of roles, if when is nil or holds
+type rule struct {
+	roles []string
+	when  condition
+}
+
+func isOwner(s Subject, r Resource) bool {
+	return s.PatientID != uuid.Nil && s.PatientID == r.PatientID
+}
+
+func isInvolvedClinic(s Subject, r Resource) bool {
+	if s.ClinicID == uuid.Nil {
+		return false
+	}
+	for _, id := range r.ClinicIDs {
+		if id == s.ClinicID {
+			return true
+		}
+	}
+	return false
+}
+
+func isListed(s Subject, r Resource) bool {
+	return s.ClinicID != uuid.Nil && r.Listed
+}
+
+// granted matches clinics the patient has granted action
+func granted(action Action) condition {
+	return func(s Subject, r Resource) bool {
+		return r.grantFor(s, action) != nil
+	}
+}
+
+func anyOf(conditions ...condition) condition {
+	return func(s Subject, r Resource) bool {
+		for _, cond := range conditions {
+			if cond(s, r) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+var (
+	patient     = []string{rbac.RolePatient}
+	admin       = []string{rbac.RoleAdmin}
+	staff       = []string{rbac.RoleClinicDoctor, rbac.RoleClinicManager}
+	doctor      = []string{rbac.RoleClinicDoctor}
+	manager     = []string{rbac.RoleClinicManager}
+	staffOrKey  = []string{rbac.RoleClinicDoctor, rbac.RoleClinicManager, rbac.RoleClinicAPI}
+	doctorOrKey = []string{rbac.RoleClinicDoctor, rbac.RoleClinicAPI}
+)
+
+// rules is the policy table: resource type, then action, then the rules any
+// of which allows the action. Anything not listed is denied.
+var rules = map[string]map[Action][]rule{
+	TypeStudy: {
+		ActionRead: {
+			{roles: patient, when: isOwner},
+			{roles: staff, when: granted(ActionRead)},
+			{roles: admin},
+		},
+		ActionReadReport: {
+			{roles: patient, when: isOwner},
+			{roles: staff, when: granted(ActionReadReport)},
+			{roles: admin},
+		},
+		ActionReadResults: {
+			{roles: patient, when: isOwner},
+			{roles: staff, when: granted(ActionReadResults)},
+			{roles: admin},
+		},
+		ActionShare:  {{roles: patient, when: isOwner}},
+		ActionUpload: {{roles: patient, when: isOwner}},
+	},
+	TypePlan: {
+		ActionRead: {
+			{roles: patient, when: isOwner},
+			{roles: staff, when: anyOf(isListed, isInvolvedClinic)},
+			{roles: admin},
+		},
+		ActionCreate: {
+			{roles: patient, when: isOwner},
+			{roles: admin},
+		},
+		ActionAnnotate: {{roles: doctor, when: anyOf(isListed, isInvolvedClinic)}},
+	},
+	TypeOfferRequest: {
+		ActionRead: {
+			{roles: patient, when: isOwner},
+			{roles: staff, when: anyOf(isListed, isInvolvedClinic)},
+			{roles: admin},
+		},
+		ActionCreate: {{roles: patient, when: isOwner}},
+		ActionOffer:  {{roles: manager, when: isListed}},
+	},
+	TypeOffer: {
+		ActionRead: {
+			{roles: patient, when: isOwner},
+			{roles: staff, when: isInvolvedClinic},
+			{roles: admin},
+		},
+		ActionAccept: {{roles: patient, when: isOwner}},
+	},
+	TypeOrder: {
+		ActionRead: {
+			{roles: patient, when: isOwner},
+			{roles: staffOrKey, when: isInvolvedClinic},
+			{roles: admin},
+		},
+		ActionUpdateStatus: {{roles: doctorOrKey, when: isInvolvedClinic}},
+	},
+}
+
+// Can reports whether subject may perform action on resource
+func Can(subject Subject, action Action, resource Resource) bool {
+	for _, r := range rules[resource.Type][action] {
+		if !hasRole(r.roles, subject.Role) {
+			continue
+		}
+		if r.when == nil || r.when(subject, resource) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasRole(roles []string, role string) bool {
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,222 @@
+package policy
+
+import (
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/igorfazlyev/dm/internal/database"
+	"github.com/igorfazlyev/dm/internal/rbac"
+	"github.com/igorfazlyev/dm/internal/repository/memory"
+)
+
+var (
+	ownerPatient   = uuid.New()
+	grantedClinic  = uuid.New()
+	involvedClinic = uuid.New()
+	listedClinic   = uuid.New()
+	strangerClinic = uuid.New()
+)
+
+// actor is a subject and whether the resource it acts on is listed. Only the
+// listed clinic sees listed resources, so every other relationship is tested
+// on its own.
+type actor struct {
+	subject Subject
+	listed  bool
+}
+
+var actors = map[string]actor{
+	"owner":                   {subject: Subject{UserID: uuid.New(), Role: rbac.RolePatient, PatientID: ownerPatient}},
+	"other patient":           {subject: Subject{UserID: uuid.New(), Role: rbac.RolePatient, PatientID: uuid.New()}},
+	"patient without profile": {subject: Subject{UserID: uuid.New(), Role: rbac.RolePatient}},
+	"admin":                   {subject: Subject{UserID: uuid.New(), Role: rbac.RoleAdmin}},
+	"granted doctor":          {subject: Subject{UserID: uuid.New(), Role: rbac.RoleClinicDoctor, ClinicID: grantedClinic}},
+	"granted manager":         {subject: Subject{UserID: uuid.New(), Role: rbac.RoleClinicManager, ClinicID: grantedClinic}},
+	"granted key":             {subject: Subject{Role: rbac.RoleClinicAPI, ClinicID: grantedClinic}},
+	"involved doctor":         {subject: Subject{UserID: uuid.New(), Role: rbac.RoleClinicDoctor, ClinicID: involvedClinic}},
+	"involved manager":        {subject: Subject{UserID: uuid.New(), Role: rbac.RoleClinicManager, ClinicID: involvedClinic}},
+	"involved key":            {subject: Subject{Role: rbac.RoleClinicAPI, ClinicID: involvedClinic}},
+	"listed doctor":           {subject: Subject{UserID: uuid.New(), Role: rbac.RoleClinicDoctor, ClinicID: listedClinic}, listed: true},
+	"listed manager":          {subject: Subject{UserID: uuid.New(), Role: rbac.RoleClinicManager, ClinicID: listedClinic}, listed: true},
+	"listed key":              {subject: Subject{Role: rbac.RoleClinicAPI, ClinicID: listedClinic}, listed: true},
+	"stranger doctor":         {subject: Subject{UserID: uuid.New(), Role: rbac.RoleClinicDoctor, ClinicID: strangerClinic}},
+	"stranger manager":        {subject: Subject{UserID: uuid.New(), Role: rbac.RoleClinicManager, ClinicID: strangerClinic}},
+	"staff without clinic":    {subject: Subject{UserID: uuid.New(), Role: rbac.RoleClinicDoctor}, listed: true},
+}
+
+// resource is a resource of the owner's that the involved clinic has an offer
+// or order on and the granted clinic may read, with its report but not its
+// results
+func resource(resourceType string, listed bool) Resource {
+	return Resource{
+		Type:      resourceType,
+		ID:        uuid.New(),
+		PatientID: ownerPatient,
+		ClinicIDs: []uuid.UUID{involvedClinic},
+		Listed:    listed,
+		Grants: []Grant{
+			{ID: uuid.New(), ClinicID: grantedClinic, Actions: []Action{ActionRead, ActionReadReport}},
+		},
+	}
+}
+
+func TestCan(t *testing.T) {
+	var (
+		owner         = []string{"owner"}
+		ownerOrAdmin  = []string{"owner", "admin"}
+		grantedStaff  = []string{"owner", "admin", "granted doctor", "granted manager"}
+		involvedStaff = []string{"owner", "admin", "involved doctor", "involved manager"}
+		audience      = []string{"owner", "admin", "involved doctor", "involved manager", "listed doctor", "listed manager"}
+	)
+
+	// allowed lists who may do each action; every other actor is denied
+	type canTest struct {
+		resourceType string
+		action       Action
+		allowed      []string
+	}
+	tests := []canTest{
+		{TypeStudy, ActionRead, grantedStaff},
+		{TypeStudy, ActionReadReport, grantedStaff},
+		{TypeStudy, ActionReadResults, ownerOrAdmin},
+		{TypeStudy, ActionShare, owner},
+		{TypeStudy, ActionUpload, owner},
+		{TypeStudy, ActionAccept, nil},
+
+		{TypePlan, ActionRead, audience},
+		{TypePlan, ActionCreate, ownerOrAdmin},
+		{TypePlan, ActionAnnotate, []string{"involved doctor", "listed doctor"}},
+		{TypePlan, ActionShare, nil},
+
+		{TypeOfferRequest, ActionRead, audience},
+		{TypeOfferRequest, ActionCreate, owner},
+		{TypeOfferRequest, ActionOffer, []string{"listed manager"}},
+
+		{TypeOffer, ActionRead, involvedStaff},
+		{TypeOffer, ActionAccept, owner},
+
+		{TypeOrder, ActionRead, append(slices.Clone(involvedStaff), "involved key")},
+		{TypeOrder, ActionUpdateStatus, []string{"involved doctor", "involved key"}},
+
+		{"unknown", ActionRead, nil},
+	}
+
+	for _, tt := range tests {
+		for name, a := range actors {
+			t.Run(tt.resourceType+"/"+string(tt.action)+"/"+name, func(t *testing.T) {
+				want := slices.Contains(tt.allowed, name)
+				if got := Can(a.subject, tt.action, resource(tt.resourceType, a.listed)); got != want {
+					t.Errorf("Can = %v, want %v", got, want)
+				}
+			})
+		}
+	}
+
+	// Every rule in the table is covered above
+	for resourceType, actions := range rules {
+		for action := range actions {
+			covered := slices.ContainsFunc(tests, func(tt canTest) bool {
+				return tt.resourceType == resourceType && tt.action == action
+			})
+			if !covered {
+				t.Errorf("no test for %s %s", resourceType, action)
+			}
+		}
+	}
+}
+
+// Only active grants give clinics access: expired and revoked ones are left
+// out of the study resource
+func TestStudyGrants(t *testing.T) {
+	store := memory.NewStore()
+	p := New(&store.Store)
+
+	study := database.Study{PatientID: ownerPatient, Status: "completed"}
+	if err := store.Studies.Create(&study); err != nil {
+		t.Fatal(err)
+	}
+
+	expiredClinic, revokedClinic := uuid.New(), uuid.New()
+	now := time.Now()
+	grants := []database.StudyGrant{
+		{ClinicID: grantedClinic, ExpiresAt: now.Add(time.Hour)},
+		{ClinicID: expiredClinic, ExpiresAt: now.Add(-time.Minute)},
+		{ClinicID: revokedClinic, ExpiresAt: now.Add(time.Hour), RevokedAt: &now},
+	}
+	for i := range grants {
+		grants[i].StudyID = study.ID
+		grants[i].PatientID = ownerPatient
+		grants[i].Scopes = []string{string(ActionRead), string(ActionReadReport), string(ActionReadResults)}
+		if err := store.Studies.SaveGrant(&grants[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	studyResource, err := p.Study(&study)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		clinicID uuid.UUID
+		want     bool
+	}{
+		{"active grant", grantedClinic, true},
+		{"expired grant", expiredClinic, false},
+		{"revoked grant", revokedClinic, false},
+		{"no grant", strangerClinic, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			subject := Subject{UserID: uuid.New(), Role: rbac.RoleClinicDoctor, ClinicID: tt.clinicID}
+			for _, action := range []Action{ActionRead, ActionReadReport, ActionReadResults} {
+				if got := Can(subject, action, studyResource); got != tt.want {
+					t.Errorf("Can(%s) = %v, want %v", action, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestSubjectClinic(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := memory.NewStore()
+	p := New(&store.Store)
+
+	tests := []struct {
+		status string
+		acts   bool // Whether the staff act for the clinic
+	}{
+		{"approved", true},
+		{"pending", true},
+		{"suspended", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.status, func(t *testing.T) {
+			clinic := database.Clinic{Name: tt.status, Status: tt.status, IsActive: tt.status == "approved"}
+			store.AddClinic(&clinic)
+			doctor := uuid.New()
+			store.AddClinicMember(&database.ClinicMember{ClinicID: clinic.ID, UserID: doctor, Role: rbac.RoleClinicDoctor})
+
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Set("user_id", doctor)
+			c.Set("user_role", rbac.RoleClinicDoctor)
+			subject, err := p.SubjectFromContext(c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := uuid.Nil
+			if tt.acts {
+				want = clinic.ID
+			}
+			if subject.ClinicID != want {
+				t.Errorf("subject clinic = %s, want %s", subject.ClinicID, want)
+			}
+		})
+	}
+}
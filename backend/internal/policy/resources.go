@@ -0,0 +1,119 @@
+package policy
+
+import (
+	"github.com/google/uuid"
+	"github.com/igorfazlyev/dm/internal/database"
+	"github.com/igorfazlyev/dm/internal/repository"
+)
+
+// Policy decides requests by the rules, looking up subjects and resources in
+// a store
+type Policy struct {
+	store *repository.Store
+}
+
+// New returns a policy looking subjects and resources up in store
+func New(store *repository.Store) *Policy {
+	return &Policy{store: store}
+}
+
+// Study describes a study with the grants the patient has made on it
+func (p *Policy) Study(study *database.Study) (Resource, error) {
+	grants, err := p.store.Studies.ActiveGrants(study.ID)
+	if err != nil {
+		return Resource{}, err
+	}
+
+	resource := Resource{Type: TypeStudy, ID: study.ID, PatientID: study.PatientID}
+	for _, g := range grants {
+		grant := Grant{ID: g.ID, ClinicID: g.ClinicID}
+		for _, scope := range g.Scopes {
+			grant.Actions = append(grant.Actions, Action(scope))
+		}
+		resource.Grants = append(resource.Grants, grant)
+	}
+	return resource, nil
+}
+
+// NewPlan describes a plan about to be created for study
+func NewPlan(study *database.Study) Resource {
+	return Resource{Type: TypePlan, PatientID: study.PatientID}
+}
+
+// Plan describes a plan version. A plan is listed while one of its offer
+// requests is open, and clinics that made offers on it stay involved after.
+func (p *Policy) Plan(plan *database.PlanVersion) (Resource, error) {
+	patientID := plan.Study.PatientID
+	if plan.Study.ID == uuid.Nil {
+		study, err := p.store.Studies.Get(plan.StudyID)
+		if err != nil {
+			return Resource{}, err
+		}
+		patientID = study.PatientID
+	}
+
+	audience, err := p.store.Plans.Audience(plan.ID)
+	if err != nil {
+		return Resource{}, err
+	}
+
+	return Resource{
+		Type:      TypePlan,
+		ID:        plan.ID,
+		PatientID: patientID,
+		ClinicIDs: audience.ClinicIDs,
+		Listed:    audience.Listed,
+	}, nil
+}
+
+// NewOfferRequest describes an offer request about to be created for plan,
+// which must have its Study loaded
+func NewOfferRequest(plan *database.PlanVersion) Resource {
+	return Resource{Type: TypeOfferRequest, PatientID: plan.Study.PatientID}
+}
+
+// OfferRequest describes an offer request. Open requests are listed to all
+// clinics, and clinics that made offers on it are involved.
+func (p *Policy) OfferRequest(request *database.OfferRequest) (Resource, error) {
+	clinicIDs, err := p.store.Offers.RequestClinicIDs(request.ID)
+	if err != nil {
+		return Resource{}, err
+	}
+
+	return Resource{
+		Type:      TypeOfferRequest,
+		ID:        request.ID,
+		PatientID: request.PatientID,
+		ClinicIDs: clinicIDs,
+		Listed:    request.Status == "open",
+	}, nil
+}
+
+// Offer describes an offer, which belongs to the patient of its request
+func (p *Policy) Offer(offer *database.Offer) (Resource, error) {
+	patientID := offer.OfferRequest.PatientID
+	if offer.OfferRequest.ID == uuid.Nil {
+		request, err := p.store.Offers.GetRequest(offer.OfferRequestID)
+		if err != nil {
+			return Resource{}, err
+		}
+		patientID = request.PatientID
+	}
+
+	return Resource{
+		Type:      TypeOffer,
+		ID:        offer.ID,
+		PatientID: patientID,
+		ClinicIDs: []uuid.UUID{offer.ClinicID},
+	}, nil
+}
+
+// Order describes an order
+func Order(order *database.Order) Resource {
+	return Resource{
+		Type:      TypeOrder,
+		ID:        order.ID,
+		PatientID: order.PatientID,
+		ClinicIDs: []uuid.UUID{order.ClinicID},
+	}
+}
@@ -0,0 +1,65 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps buckets in process memory. Limits are per instance, so
+// use PostgresStore when running more than one.
+type MemoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	full    time.Time // When the bucket will be full again and can be dropped
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		buckets:   make(map[string]*bucket),
+		lastSweep: time.Now(),
+	}
+}
+
+func (s *MemoryStore) Take(key string, limit Limit) (bool, time.Duration, error) {
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sweep(now)
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), updated: now}
+		s.buckets[key] = b
+	}
+
+	tokens := refill(b.tokens, b.updated, now, limit)
+	tokens, allowed, retryAfter := take(tokens, limit)
+
+	b.tokens = tokens
+	b.updated = now
+	b.full = now.Add(time.Duration((float64(limit.Burst) - tokens) * float64(limit.Every)))
+
+	return allowed, retryAfter, nil
+}
+
+// sweep drops full buckets, which behave the same as missing ones
+func (s *MemoryStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < time.Minute {
+		return
+	}
+	s.lastSweep = now
+
+	for key, b := range s.buckets {
+		if now.After(b.full) {
+			delete(s.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package ratelimit
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/igorfazlyev/dm/internal/database"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// PostgresStore keeps buckets in the rate_limit_buckets table so limits are
+// shared by all instances
+type PostgresStore struct {
+	db *gorm.DB
+
+	mu          sync.Mutex
+	lastCleanup time.Time
+}
+
+func NewPostgresStore(db *gorm.DB) *PostgresStore {
+	return &PostgresStore{db: db, lastCleanup: time.Now()}
+}
+
+func (s *PostgresStore) Take(key string, limit Limit) (bool, time.Duration, error) {
+	s.maybeCleanup()
+
+	var (
+		allowed    bool
+		retryAfter time.Duration
+	)
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+
+		// Make sure the row exists so it can be locked
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&database.RateLimitBucket{
+			Key:       key,
+			Tokens:    float64(limit.Burst),
+			UpdatedAt: now,
+		}).Error; err != nil {
+			return err
+		}
+
+		var b database.RateLimitBucket
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("key = ?", key).First(&b).Error; err != nil {
+			return err
+		}
+
+		var tokens float64
+		tokens, allowed, retryAfter = take(refill(b.Tokens, b.UpdatedAt, now, limit), limit)
+
+		return tx.Model(&b).Updates(map[string]interface{}{
+			"tokens":     tokens,
+			"updated_at": now,
+			"expires_at": now.Add(time.Duration((float64(limit.Burst) - tokens) * float64(limit.Every))),
+		}).Error
+	})
+	if err != nil {
+		return false, 0, err
+	}
+
+	return allowed, retryAfter, nil
+}
+
+// maybeCleanup deletes buckets that have refilled completely, at most once
+// every few minutes
+func (s *PostgresStore) maybeCleanup() {
+	s.mu.Lock()
+	if time.Since(s.lastCleanup) < 5*time.Minute {
+		s.mu.Unlock()
+		return
+	}
+	s.lastCleanup = time.Now()
+	s.mu.Unlock()
+
+	go func() {
+		if err := s.db.Where("expires_at < ?", time.Now()).Delete(&database.RateLimitBucket{}).Error; err != nil {
+			log.Printf("Failed to clean up rate limit buckets: %v", err)
+		}
+	}()
+}
@@ -0,0 +1,79 @@
+package ratelimit
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/igorfazlyev/dm/internal/config"
+	"github.com/igorfazlyev/dm/internal/database"
+)
+
+// Limit describes a token bucket: Burst requests at once, refilled at one
+// token every Every
+type Limit struct {
+	Burst int
+	Every time.Duration
+}
+
+// Store keeps token buckets by key. Take removes one token from the bucket
+// and reports whether one was available; if not, retryAfter is the time until
+// the next token.
+type Store interface {
+	Take(key string, limit Limit) (allowed bool, retryAfter time.Duration, err error)
+}
+
+// New returns the store selected by cfg.Store
+func New(cfg config.RateLimitConfig) (Store, error) {
+	switch cfg.Store {
+	case "memory", "":
+		return NewMemoryStore(), nil
+	case "postgres":
+		return NewPostgresStore(database.DB), nil
+	default:
+		return nil, fmt.Errorf("unknown rate limit store %q", cfg.Store)
+	}
+}
+
+// PerIP limits requests from each client IP. name separates the buckets of
+// different routes sharing one store.
+func PerIP(store Store, name string, limit Limit) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		allowed, retryAfter, err := store.Take(name+":"+c.ClientIP(), limit)
+		if err != nil {
+			// Fail open: a broken limiter must not take authentication down
+			log.Printf("Rate limiter error for %s: %v", name, err)
+			c.Next()
+			return
+		}
+
+		if !allowed {
+			c.Header("Retry-After", fmt.Sprintf("%d", int(math.Ceil(retryAfter.Seconds()))))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many requests, try again later"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// refill returns the token count of a bucket that held tokens at last
+func refill(tokens float64, last, now time.Time, limit Limit) float64 {
+	elapsed := now.Sub(last)
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	return math.Min(float64(limit.Burst), tokens+float64(elapsed)/float64(limit.Every))
+}
+
+// take removes one token if available
+func take(tokens float64, limit Limit) (float64, bool, time.Duration) {
+	if tokens >= 1 {
+		return tokens - 1, true, 0
+	}
+	return tokens, false, time.Duration((1 - tokens) * float64(limit.Every))
+}
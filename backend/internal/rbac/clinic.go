@@ -0,0 +1,15 @@
+package rbac
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// GetClinicID extracts the clinic of an API key from context
+func GetClinicID(c *gin.Context) (uuid.UUID, bool) {
+	clinicID, exists := c.Get("clinic_id")
+	if !exists {
+		return uuid.Nil, false
+	}
+	return clinicID.(uuid.UUID), true
+}
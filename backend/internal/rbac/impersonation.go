@@ -0,0 +1,36 @@
+package rbac
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// GetActorID returns the admin acting through an impersonation token
+func GetActorID(c *gin.Context) (uuid.UUID, bool) {
+	actorID, exists := c.Get("actor_id")
+	if !exists {
+		return uuid.Nil, false
+	}
+	return actorID.(uuid.UUID), true
+}
+
+// IsImpersonating reports whether an admin is acting as the current user
+func IsImpersonating(c *gin.Context) bool {
+	_, ok := GetActorID(c)
+	return ok
+}
+
+// DenyImpersonation blocks actions only the real user may take, such as
+// changing credentials or accepting an offer
+func DenyImpersonation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if IsImpersonating(c) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "not allowed while impersonating"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,89 @@
+package rbac
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/igorfazlyev/dm/internal/database"
+)
+
+const mfaPolicyCacheTTL = time.Minute
+
+// mfaPolicies caches which roles require MFA
+var mfaPolicies struct {
+	sync.RWMutex
+	required map[string]bool
+	loadedAt time.Time
+}
+
+// MFARequiredForRole reports whether users with the role must use MFA
+func MFARequiredForRole(role string) (bool, error) {
+	mfaPolicies.RLock()
+	if mfaPolicies.required != nil && time.Since(mfaPolicies.loadedAt) < mfaPolicyCacheTTL {
+		required := mfaPolicies.required[role]
+		mfaPolicies.RUnlock()
+		return required, nil
+	}
+	mfaPolicies.RUnlock()
+
+	var policies []database.MFAPolicy
+	if err := database.DB.Find(&policies).Error; err != nil {
+		return false, err
+	}
+
+	required := make(map[string]bool, len(policies))
+	for _, p := range policies {
+		required[p.Role] = p.Required
+	}
+
+	mfaPolicies.Lock()
+	mfaPolicies.required = required
+	mfaPolicies.loadedAt = time.Now()
+	mfaPolicies.Unlock()
+
+	return required[role], nil
+}
+
+// InvalidateMFAPolicies drops the cached policies after they change
+func InvalidateMFAPolicies() {
+	mfaPolicies.Lock()
+	mfaPolicies.required = nil
+	mfaPolicies.Unlock()
+}
+
+// RequireMFA blocks tokens without a second factor when the user's role
+// requires MFA. Routes for enrolling in MFA must not sit behind it.
+func RequireMFA() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if HasMFA(c) {
+			c.Next()
+			return
+		}
+
+		role, _ := GetUserRole(c)
+		required, err := MFARequiredForRole(role)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check mfa policy"})
+			c.Abort()
+			return
+		}
+
+		if required {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": "multi-factor authentication is required for your role",
+				"code":  "mfa_required",
+			})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// HasMFA reports whether the current token was issued after a second factor
+func HasMFA(c *gin.Context) bool {
+	return c.GetBool("mfa")
+}
@@ -6,7 +6,8 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
-	"github.com/igorfazlyev/dm/internal/config"
+	"github.com/igorfazlyev/dm/internal/database"
+	"github.com/igorfazlyev/dm/internal/sessions"
 	jwtpkg "github.com/igorfazlyev/dm/pkg/jwt"
 )
 
@@ -17,8 +18,20 @@ const (
 	RoleAdmin         = "admin"
 )
 
-func AuthMiddleware(cfg *config.Config) gin.HandlerFunc {
+// AuthMiddleware authenticates requests with a bearer access token or a
+// clinic API key
+func AuthMiddleware(keys *jwtpkg.Keyring, sessionStore *sessions.Store) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		if apiKey, ok := apiKeyFromRequest(c); ok {
+			if !authenticateAPIKey(c, apiKey) {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or revoked API key"})
+				c.Abort()
+				return
+			}
+			c.Next()
+			return
+		}
+
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "missing authorization header"})
@@ -33,17 +46,40 @@ func AuthMiddleware(cfg *config.Config) gin.HandlerFunc {
 			return
 		}
 
-		claims, err := jwtpkg.ValidateToken(tokenString, cfg.JWT.Secret)
+		claims, err := jwtpkg.ValidateToken(tokenString, keys, jwtpkg.TokenTypeAccess)
 		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired token"})
 			c.Abort()
 			return
 		}
 
+		// Reject tokens of revoked sessions (logout, deactivated user, password
+		// change). Impersonation tokens ride on the admin's own session.
+		owner := claims.UserID
+		if claims.Actor != nil {
+			owner = claims.Actor.UserID
+		}
+		active, err := sessionStore.IsActive(claims.SessionID, owner)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to verify session"})
+			c.Abort()
+			return
+		}
+		if !active {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "session has been revoked"})
+			c.Abort()
+			return
+		}
+
 		// Set claims in context for downstream handlers
 		c.Set("user_id", claims.UserID)
 		c.Set("user_email", claims.Email)
 		c.Set("user_role", claims.Role)
+		c.Set("session_id", claims.SessionID)
+		c.Set("mfa", claims.MFA)
+		if claims.Actor != nil {
+			c.Set("actor_id", claims.Actor.UserID)
+		}
 
 		c.Next()
 	}
@@ -71,6 +107,42 @@ func RequireRole(roles ...string) gin.HandlerFunc {
 	}
 }
 
+// RequireVerifiedAccount blocks users who have confirmed neither an email
+// address nor a phone number
+func RequireVerifiedAccount() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// API keys can only be created by verified clinic managers
+		if IsAPIKey(c) {
+			c.Next()
+			return
+		}
+
+		userID, exists := GetUserID(c)
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			c.Abort()
+			return
+		}
+
+		var count int64
+		if err := database.DB.Model(&database.User{}).
+			Where("id = ? AND (verified_at IS NOT NULL OR phone_verified_at IS NOT NULL)", userID).
+			Count(&count).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check verification status"})
+			c.Abort()
+			return
+		}
+
+		if count == 0 {
+			c.JSON(http.StatusForbidden, gin.H{"error": "account is not verified"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
 // GetUserID extracts user ID from context
 func GetUserID(c *gin.Context) (uuid.UUID, bool) {
 	userID, exists := c.Get("user_id")
@@ -88,3 +160,12 @@ func GetUserRole(c *gin.Context) (string, bool) {
 	}
 	return role.(string), true
 }
+
+// GetSessionID extracts the session ID of the current token from context
+func GetSessionID(c *gin.Context) (uuid.UUID, bool) {
+	sessionID, exists := c.Get("session_id")
+	if !exists {
+		return uuid.Nil, false
+	}
+	return sessionID.(uuid.UUID), true
+}
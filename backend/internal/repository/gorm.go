@@ -0,0 +1,1264 @@
+package repository
+
+import (
+	"crypto/subtle"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/igorfazlyev/dm/internal/database"
+	"github.com/igorfazlyev/dm/internal/events"
+	"github.com/igorfazlyev/dm/internal/rbac"
+	"github.com/igorfazlyev/dm/pkg/money"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// NewGormStore returns repositories backed by db
+func NewGormStore(db *gorm.DB) *Store {
+	return &Store{
+		Users:    &gormUsers{db},
+		Patients: &gormPatients{db},
+		Studies:  &gormStudies{db},
+		Plans:    &gormPlans{db},
+		Clinics:  &gormClinics{db},
+		Offers:   &gormOffers{db},
+		Orders:   &gormOrders{db},
+		Audit:    &gormAudit{db},
+		Logins:   &gormLogins{db},
+	}
+}
+
+// first runs a single-row query, translating a missing row to ErrNotFound
+func first(query *gorm.DB, dest any) error {
+	err := query.First(dest).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// updated reports ErrNotFound when an update matched no row
+func updated(result *gorm.DB) error {
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// versioned reports ErrConflict when an update conditioned on a row's
+// version matched no row
+func versioned(result *gorm.DB) error {
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrConflict
+	}
+	return nil
+}
+
+// bump is the update of a versioned row's version
+var bump = gorm.Expr("version + 1")
+
+type gormUsers struct{ db *gorm.DB }
+
+func (r *gormUsers) Get(id uuid.UUID) (*database.User, error) {
+	var user database.User
+	if err := first(r.db.Where("id = ?", id), &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (r *gormUsers) FindByEmail(email string) (*database.User, error) {
+	var user database.User
+	if err := first(r.db.Unscoped().Where("email = ?", email), &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (r *gormUsers) FindByPhone(phone string) (*database.User, error) {
+	var user database.User
+	if err := first(r.db.Where("phone = ?", phone), &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (r *gormUsers) Profile(id uuid.UUID) (*database.User, error) {
+	var user database.User
+	if err := first(r.db.Preload("Patient").Preload("Clinic").Where("id = ?", id), &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (r *gormUsers) Update(id uuid.UUID, fields map[string]any) error {
+	return r.db.Model(&database.User{}).Where("id = ?", id).Updates(fields).Error
+}
+
+func (r *gormUsers) Register(user *database.User, patient *database.Patient) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(user).Error; err != nil {
+			return err
+		}
+		if patient == nil {
+			return nil
+		}
+		patient.UserID = user.ID
+		return tx.Create(patient).Error
+	})
+}
+
+func (r *gormUsers) IssueToken(token *database.UserToken) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&database.UserToken{}).
+			Where("user_id = ? AND purpose = ? AND used_at IS NULL", token.UserID, token.Purpose).
+			Update("used_at", time.Now()).Error; err != nil {
+			return err
+		}
+		return tx.Create(token).Error
+	})
+}
+
+func (r *gormUsers) RecentTokens(userID uuid.UUID, purpose string, since time.Time) ([]database.UserToken, error) {
+	var tokens []database.UserToken
+	err := r.db.Where("user_id = ? AND purpose = ? AND created_at > ?", userID, purpose, since).
+		Order("created_at DESC").
+		Find(&tokens).Error
+	return tokens, err
+}
+
+func (r *gormUsers) UseToken(tokenHash, purpose string, fields map[string]any) (*database.UserToken, error) {
+	var token database.UserToken
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := first(tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("token_hash = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?", tokenHash, purpose, time.Now()),
+			&token); err != nil {
+			return err
+		}
+		if err := tx.Model(&token).Update("used_at", time.Now()).Error; err != nil {
+			return err
+		}
+		return tx.Model(&database.User{}).Where("id = ?", token.UserID).Updates(fields).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (r *gormUsers) List(filter UserFilter, page, pageSize int) ([]database.User, int64, error) {
+	query := r.db.Model(&database.User{})
+	if filter.Search != "" {
+		pattern := "%" + filter.Search + "%"
+		query = query.Where("email ILIKE ? OR phone LIKE ?", pattern, pattern)
+	}
+	if filter.Role != "" {
+		query = query.Where("role = ?", filter.Role)
+	}
+	if filter.Active != nil {
+		query = query.Where("is_active = ?", *filter.Active)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var users []database.User
+	if err := query.Order("created_at DESC").
+		Offset((page - 1) * pageSize).
+		Limit(pageSize).
+		Find(&users).Error; err != nil {
+		return nil, 0, err
+	}
+	return users, total, nil
+}
+
+func (r *gormUsers) SetActive(user *database.User, active bool, entry *database.AuditLog) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(user).Update("is_active", active).Error; err != nil {
+			return err
+		}
+		return tx.Create(entry).Error
+	})
+}
+
+func (r *gormUsers) SetRole(user *database.User, role string, entry *database.AuditLog) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(user).Update("role", role).Error; err != nil {
+			return err
+		}
+		return tx.Create(entry).Error
+	})
+}
+
+func (r *gormUsers) ClearMFA(userID uuid.UUID, entry *database.AuditLog) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&database.User{}).Where("id = ?", userID).Updates(map[string]any{
+			"mfa_secret":     "",
+			"mfa_enabled_at": nil,
+			"mfa_last_step":  0,
+		}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", userID).Delete(&database.MFARecoveryCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(entry).Error
+	})
+}
+
+type gormPatients struct{ db *gorm.DB }
+
+func (r *gormPatients) Get(id uuid.UUID) (*database.Patient, error) {
+	var patient database.Patient
+	if err := first(r.db.Where("id = ?", id), &patient); err != nil {
+		return nil, err
+	}
+	return &patient, nil
+}
+
+func (r *gormPatients) FindByUserID(userID uuid.UUID) (*database.Patient, error) {
+	var patient database.Patient
+	if err := first(r.db.Where("user_id = ?", userID), &patient); err != nil {
+		return nil, err
+	}
+	return &patient, nil
+}
+
+func (r *gormPatients) Create(patient *database.Patient) error {
+	return r.db.Create(patient).Error
+}
+
+func (r *gormPatients) Save(patient *database.Patient) error {
+	return r.db.Save(patient).Error
+}
+
+type gormStudies struct{ db *gorm.DB }
+
+func (r *gormStudies) Get(id uuid.UUID) (*database.Study, error) {
+	var study database.Study
+	if err := first(r.db.Preload("Patient").Where("id = ?", id), &study); err != nil {
+		return nil, err
+	}
+	return &study, nil
+}
+
+func (r *gormStudies) ListByPatient(patientID uuid.UUID) ([]database.Study, error) {
+	var studies []database.Study
+	err := r.db.Where("patient_id = ?", patientID).
+		Order("created_at DESC").
+		Find(&studies).Error
+	return studies, err
+}
+
+func (r *gormStudies) Create(study *database.Study) error {
+	return r.db.Create(study).Error
+}
+
+func (r *gormStudies) Save(study *database.Study) error {
+	return r.db.Omit("Patient").Save(study).Error
+}
+
+func (r *gormStudies) Update(id uuid.UUID, fields map[string]any) error {
+	return r.db.Model(&database.Study{}).Where("id = ?", id).Updates(fields).Error
+}
+
+func (r *gormStudies) Complete(study *database.Study, reportURL string) error {
+	now := time.Now()
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&database.Study{}).
+			Where("id = ? AND status <> ?", study.ID, "completed").
+			Updates(map[string]any{"status": "completed", "completed_at": now, "diagnocat_report_url": reportURL})
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		return events.Record(tx, events.StudyCompleted{StudyID: study.ID, PatientID: study.PatientID})
+	})
+	if err != nil {
+		return err
+	}
+	study.Status = "completed"
+	study.CompletedAt = &now
+	study.DiagnocatReportURL = &reportURL
+	return nil
+}
+
+func (r *gormStudies) ActiveGrants(studyID uuid.UUID) ([]database.StudyGrant, error) {
+	var grants []database.StudyGrant
+	err := r.db.
+		Where("study_id = ? AND revoked_at IS NULL AND expires_at > ?", studyID, time.Now()).
+		Find(&grants).Error
+	return grants, err
+}
+
+func (r *gormStudies) ActiveGrant(studyID, clinicID uuid.UUID) (*database.StudyGrant, error) {
+	var grant database.StudyGrant
+	if err := first(r.db.
+		Where("study_id = ? AND clinic_id = ? AND revoked_at IS NULL AND expires_at > ?", studyID, clinicID, time.Now()),
+		&grant); err != nil {
+		return nil, err
+	}
+	return &grant, nil
+}
+
+func (r *gormStudies) Grants(studyID uuid.UUID) ([]database.StudyGrant, error) {
+	var grants []database.StudyGrant
+	err := r.db.Preload("Clinic").
+		Where("study_id = ?", studyID).
+		Order("created_at DESC").
+		Find(&grants).Error
+	return grants, err
+}
+
+func (r *gormStudies) ClinicGrants(clinicID uuid.UUID) ([]database.StudyGrant, error) {
+	var grants []database.StudyGrant
+	err := r.db.
+		Where("clinic_id = ? AND revoked_at IS NULL AND expires_at > ?", clinicID, time.Now()).
+		Order("created_at DESC").
+		Find(&grants).Error
+	return grants, err
+}
+
+func (r *gormStudies) SaveGrant(grant *database.StudyGrant) error {
+	return r.db.Omit("Clinic").Save(grant).Error
+}
+
+func (r *gormStudies) RevokeGrant(studyID, grantID uuid.UUID) error {
+	return updated(r.db.Model(&database.StudyGrant{}).
+		Where("id = ? AND study_id = ? AND revoked_at IS NULL", grantID, studyID).
+		Update("revoked_at", time.Now()))
+}
+
+func (r *gormStudies) LogAccess(entry *database.StudyAccessLog) error {
+	return r.db.Create(entry).Error
+}
+
+func (r *gormStudies) AccessLog(studyID uuid.UUID, limit int) ([]database.StudyAccessLog, error) {
+	var entries []database.StudyAccessLog
+	err := r.db.Preload("Clinic").
+		Where("study_id = ?", studyID).
+		Order("created_at DESC").
+		Limit(limit).
+		Find(&entries).Error
+	return entries, err
+}
+
+func (r *gormStudies) CreateUpload(upload *database.StudyUpload) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		// Locking the study keeps two uploads from starting at once
+		if err := first(tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").Where("id = ?", upload.StudyID), &database.Study{}); err != nil {
+			return err
+		}
+		var active int64
+		if err := activeUploads(tx, upload.StudyID).Count(&active).Error; err != nil {
+			return err
+		}
+		if active > 0 {
+			return ErrConflict
+		}
+		return tx.Create(upload).Error
+	})
+}
+
+// activeUploads selects the uploads of a study in progress
+func activeUploads(db *gorm.DB, studyID uuid.UUID) *gorm.DB {
+	return db.Model(&database.StudyUpload{}).
+		Where("study_id = ? AND completed_at IS NULL AND expires_at > ?", studyID, time.Now())
+}
+
+func (r *gormStudies) Upload(studyID, uploadID uuid.UUID) (*database.StudyUpload, error) {
+	var upload database.StudyUpload
+	if err := first(r.db.Where("id = ? AND study_id = ? AND expires_at > ?", uploadID, studyID, time.Now()), &upload); err != nil {
+		return nil, err
+	}
+	return &upload, nil
+}
+
+func (r *gormStudies) ActiveUpload(studyID uuid.UUID) (*database.StudyUpload, error) {
+	var upload database.StudyUpload
+	if err := first(activeUploads(r.db, studyID), &upload); err != nil {
+		return nil, err
+	}
+	return &upload, nil
+}
+
+func (r *gormStudies) AdvanceUpload(upload *database.StudyUpload, offset int64, expiresAt time.Time) error {
+	err := versioned(r.db.Model(&database.StudyUpload{}).
+		Where(`id = ? AND "offset" = ?`, upload.ID, upload.Offset).
+		Updates(map[string]any{"offset": offset, "expires_at": expiresAt}))
+	if err != nil {
+		return err
+	}
+	upload.Offset = offset
+	upload.ExpiresAt = expiresAt
+	return nil
+}
+
+func (r *gormStudies) CompleteUpload(upload *database.StudyUpload) error {
+	now := time.Now()
+	if err := r.db.Model(upload).Update("completed_at", now).Error; err != nil {
+		return err
+	}
+	upload.CompletedAt = &now
+	return nil
+}
+
+func (r *gormStudies) DeleteUpload(uploadID uuid.UUID) error {
+	return r.db.Delete(&database.StudyUpload{}, "id = ?", uploadID).Error
+}
+
+func (r *gormStudies) ExpiredUploads(t time.Time) ([]database.StudyUpload, error) {
+	var uploads []database.StudyUpload
+	err := r.db.Where("expires_at <= ?", t).Find(&uploads).Error
+	return uploads, err
+}
+
+type gormPlans struct{ db *gorm.DB }
+
+func (r *gormPlans) Get(id uuid.UUID) (*database.PlanVersion, error) {
+	var plan database.PlanVersion
+	if err := first(r.db.Preload("PlanItems").Preload("Study.Patient").Where("id = ?", id), &plan); err != nil {
+		return nil, err
+	}
+	return &plan, nil
+}
+
+func (r *gormPlans) ListByStudy(studyID uuid.UUID) ([]database.PlanVersion, error) {
+	var plans []database.PlanVersion
+	err := r.db.Preload("PlanItems").
+		Where("study_id = ?", studyID).
+		Order("version DESC").
+		Find(&plans).Error
+	return plans, err
+}
+
+func (r *gormPlans) Create(plan *database.PlanVersion) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&database.PlanVersion{}).Where("study_id = ?", plan.StudyID).Count(&count).Error; err != nil {
+			return err
+		}
+		plan.Version = int(count) + 1
+		return tx.Omit("Study").Create(plan).Error
+	})
+}
+
+func (r *gormPlans) Audience(planID uuid.UUID) (PlanAudience, error) {
+	var open int64
+	if err := r.db.Model(&database.OfferRequest{}).
+		Where("plan_version_id = ? AND status = ?", planID, "open").
+		Count(&open).Error; err != nil {
+		return PlanAudience{}, err
+	}
+
+	var clinicIDs []uuid.UUID
+	if err := r.db.Model(&database.Offer{}).
+		Joins("JOIN offer_requests ON offer_requests.id = offers.offer_request_id").
+		Where("offer_requests.plan_version_id = ?", planID).
+		Distinct().
+		Pluck("offers.clinic_id", &clinicIDs).Error; err != nil {
+		return PlanAudience{}, err
+	}
+
+	return PlanAudience{Listed: open > 0, ClinicIDs: clinicIDs}, nil
+}
+
+func (r *gormPlans) GetItem(planID, itemID uuid.UUID) (*database.PlanItem, error) {
+	var item database.PlanItem
+	if err := first(r.db.Where("id = ? AND plan_version_id = ?", itemID, planID), &item); err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
+func (r *gormPlans) CreateAnnotation(annotation *database.PlanAnnotation) error {
+	return r.db.Omit("Clinic").Create(annotation).Error
+}
+
+func (r *gormPlans) Annotations(planID, clinicID uuid.UUID) ([]database.PlanAnnotation, error) {
+	query := r.db.Preload("Clinic").Where("plan_version_id = ?", planID)
+	if clinicID != uuid.Nil {
+		query = query.Where("clinic_id = ?", clinicID)
+	}
+
+	var annotations []database.PlanAnnotation
+	err := query.Order("created_at").Find(&annotations).Error
+	return annotations, err
+}
+
+type gormClinics struct{ db *gorm.DB }
+
+func (r *gormClinics) Get(id uuid.UUID) (*database.Clinic, error) {
+	var clinic database.Clinic
+	if err := first(r.db.Where("id = ?", id), &clinic); err != nil {
+		return nil, err
+	}
+	return &clinic, nil
+}
+
+func (r *gormClinics) ForMember(userID uuid.UUID) (*database.Clinic, error) {
+	var clinic database.Clinic
+	if err := first(r.db.
+		Joins("JOIN clinic_members ON clinic_members.clinic_id = clinics.id").
+		Where("clinic_members.user_id = ?", userID), &clinic); err != nil {
+		return nil, err
+	}
+	return &clinic, nil
+}
+
+func (r *gormClinics) Membership(userID uuid.UUID) (*database.ClinicMember, error) {
+	var member database.ClinicMember
+	if err := first(r.db.Preload("Clinic").Where("user_id = ?", userID), &member); err != nil {
+		return nil, err
+	}
+	return &member, nil
+}
+
+func (r *gormClinics) Create(clinic *database.Clinic, managerID uuid.UUID) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(clinic).Error; err != nil {
+			return err
+		}
+
+		return tx.Create(&database.ClinicMember{
+			ClinicID: clinic.ID,
+			UserID:   managerID,
+			Role:     rbac.RoleClinicManager,
+		}).Error
+	})
+}
+
+func (r *gormClinics) Save(clinic *database.Clinic) error {
+	return saveClinic(r.db, clinic)
+}
+
+func saveClinic(db *gorm.DB, clinic *database.Clinic) error {
+	read := clinic.Version
+	clinic.Version++
+	err := versioned(db.Model(clinic).
+		Where("version = ?", read).
+		Select("*").
+		Omit("created_at", clause.Associations).
+		Updates(clinic))
+	if err != nil {
+		clinic.Version = read
+	}
+	return err
+}
+
+func (r *gormClinics) ListActive(filter ClinicFilter) ([]database.Clinic, error) {
+	query := r.db.Where("is_active = ?", true)
+	if filter.City != "" {
+		query = query.Where("city = ?", filter.City)
+	}
+	if filter.District != "" {
+		query = query.Where("district = ?", filter.District)
+	}
+	if filter.PriceSegment != "" {
+		query = query.Where("price_segment = ?", filter.PriceSegment)
+	}
+
+	var clinics []database.Clinic
+	err := query.Find(&clinics).Error
+	return clinics, err
+}
+
+func (r *gormClinics) List(status string) ([]database.Clinic, error) {
+	query := r.db.Order("created_at DESC")
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var clinics []database.Clinic
+	err := query.Find(&clinics).Error
+	return clinics, err
+}
+
+func (r *gormClinics) Pending() ([]database.Clinic, error) {
+	var clinics []database.Clinic
+	err := r.db.Where("status = ?", "pending").Order("created_at").Find(&clinics).Error
+	return clinics, err
+}
+
+func (r *gormClinics) Review(clinic *database.Clinic, entry *database.AuditLog) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := saveClinic(tx, clinic); err != nil {
+			return err
+		}
+		return tx.Create(entry).Error
+	})
+}
+
+func (r *gormClinics) Notes(clinicID uuid.UUID) ([]database.ClinicNote, error) {
+	var notes []database.ClinicNote
+	err := r.db.Where("clinic_id = ?", clinicID).Order("created_at DESC").Find(&notes).Error
+	return notes, err
+}
+
+func (r *gormClinics) AddNote(note *database.ClinicNote) error {
+	return r.db.Create(note).Error
+}
+
+func (r *gormClinics) Members(clinicID uuid.UUID) ([]database.ClinicMember, error) {
+	var members []database.ClinicMember
+	err := r.db.Preload("User").
+		Where("clinic_id = ?", clinicID).
+		Order("created_at").
+		Find(&members).Error
+	return members, err
+}
+
+func (r *gormClinics) RemoveMember(clinicID, userID uuid.UUID) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		// Locking the whole staff keeps two managers from removing each
+		// other at once
+		var members []database.ClinicMember
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("clinic_id = ?", clinicID).
+			Find(&members).Error; err != nil {
+			return err
+		}
+
+		var target *database.ClinicMember
+		managers := 0
+		for i := range members {
+			if members[i].Role == rbac.RoleClinicManager {
+				managers++
+			}
+			if members[i].UserID == userID {
+				target = &members[i]
+			}
+		}
+
+		if target == nil {
+			return ErrNotFound
+		}
+		if target.Role == rbac.RoleClinicManager && managers == 1 {
+			return ErrLastManager
+		}
+		return tx.Delete(target).Error
+	})
+}
+
+func (r *gormClinics) Invite(invitation *database.ClinicInvitation) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&database.ClinicInvitation{}).
+			Where("clinic_id = ? AND email = ? AND accepted_at IS NULL AND revoked_at IS NULL", invitation.ClinicID, invitation.Email).
+			Update("revoked_at", time.Now()).Error; err != nil {
+			return err
+		}
+		return tx.Create(invitation).Error
+	})
+}
+
+func (r *gormClinics) Invitation(tokenHash string) (*database.ClinicInvitation, error) {
+	var invitation database.ClinicInvitation
+	if err := first(r.db.Where("token_hash = ?", tokenHash), &invitation); err != nil {
+		return nil, err
+	}
+	return &invitation, nil
+}
+
+func (r *gormClinics) Invitations(clinicID uuid.UUID) ([]database.ClinicInvitation, error) {
+	var invitations []database.ClinicInvitation
+	err := r.db.
+		Where("clinic_id = ? AND accepted_at IS NULL AND revoked_at IS NULL AND expires_at > ?", clinicID, time.Now()).
+		Order("created_at DESC").
+		Find(&invitations).Error
+	return invitations, err
+}
+
+func (r *gormClinics) RevokeInvitation(clinicID, invitationID uuid.UUID) error {
+	return updated(r.db.Model(&database.ClinicInvitation{}).
+		Where("id = ? AND clinic_id = ? AND accepted_at IS NULL AND revoked_at IS NULL", invitationID, clinicID).
+		Update("revoked_at", time.Now()))
+}
+
+func (r *gormClinics) AcceptInvitation(invitation *database.ClinicInvitation, member *database.ClinicMember) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		if err := versioned(tx.Model(invitation).
+			Where("accepted_at IS NULL AND revoked_at IS NULL").
+			Update("accepted_at", now)); err != nil {
+			return err
+		}
+
+		if err := tx.Model(&database.User{}).
+			Where("id = ? AND verified_at IS NULL", member.UserID).
+			Update("verified_at", now).Error; err != nil {
+			return err
+		}
+
+		return tx.Create(member).Error
+	})
+}
+
+func (r *gormClinics) CreateAPIKey(key *database.APIKey) error {
+	return r.db.Create(key).Error
+}
+
+func (r *gormClinics) APIKeys(clinicID uuid.UUID) ([]database.APIKey, error) {
+	var keys []database.APIKey
+	err := r.db.Where("clinic_id = ?", clinicID).Order("created_at DESC").Find(&keys).Error
+	return keys, err
+}
+
+func (r *gormClinics) RevokeAPIKey(clinicID, keyID uuid.UUID) error {
+	return updated(r.db.Model(&database.APIKey{}).
+		Where("id = ? AND clinic_id = ? AND revoked_at IS NULL", keyID, clinicID).
+		Update("revoked_at", time.Now()))
+}
+
+func (r *gormClinics) AddPriceItem(item *database.PriceListItem) error {
+	return r.db.Create(item).Error
+}
+
+func (r *gormClinics) PriceList(clinicID uuid.UUID) ([]database.PriceListItem, error) {
+	var items []database.PriceListItem
+	err := r.db.Where("clinic_id = ? AND is_active = ?", clinicID, true).
+		Order("specialty, procedure_name").
+		Find(&items).Error
+	return items, err
+}
+
+func (r *gormClinics) DeactivatePriceItem(clinicID, itemID uuid.UUID) error {
+	return updated(r.db.Model(&database.PriceListItem{}).
+		Where("id = ? AND clinic_id = ?", itemID, clinicID).
+		Update("is_active", false))
+}
+
+func (r *gormClinics) PriceRanges(codes []string, currency string) (map[string]PriceRange, error) {
+	ranges := map[string]PriceRange{}
+	if len(codes) == 0 {
+		return ranges, nil
+	}
+
+	var rows []struct {
+		ProcedureCode string
+		MinAmount     money.Decimal
+		MaxAmount     money.Decimal
+	}
+	err := r.db.Model(&database.PriceListItem{}).
+		Select(`price_list_items.procedure_code,
+			MIN(price_list_items.price_from_amount) AS min_amount,
+			MAX(GREATEST(price_list_items.price_from_amount, price_list_items.price_to_amount)) AS max_amount`).
+		Joins("JOIN clinics ON clinics.id = price_list_items.clinic_id AND clinics.deleted_at IS NULL").
+		Where("price_list_items.is_active AND clinics.is_active").
+		Where("price_list_items.procedure_code IN ? AND price_list_items.price_from_currency = ?", codes, currency).
+		Group("price_list_items.procedure_code").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for _, row := range rows {
+		ranges[row.ProcedureCode] = PriceRange{
+			Min: money.Money{Amount: row.MinAmount, Currency: currency},
+			Max: money.Money{Amount: row.MaxAmount, Currency: currency},
+		}
+	}
+	return ranges, nil
+}
+
+type gormOffers struct{ db *gorm.DB }
+
+func (r *gormOffers) CreateRequest(request *database.OfferRequest, grants []database.StudyGrant) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(request).Error; err != nil {
+			return err
+		}
+		if len(grants) == 0 {
+			return nil
+		}
+		for i := range grants {
+			grants[i].OfferRequestID = &request.ID
+		}
+		return tx.Create(&grants).Error
+	})
+}
+
+func (r *gormOffers) GetRequest(id uuid.UUID) (*database.OfferRequest, error) {
+	var request database.OfferRequest
+	if err := first(r.db.Where("id = ?", id), &request); err != nil {
+		return nil, err
+	}
+	return &request, nil
+}
+
+func (r *gormOffers) RequestDetails(id, clinicID uuid.UUID) (*database.OfferRequest, error) {
+	offers := func(db *gorm.DB) *gorm.DB { return db }
+	if clinicID != uuid.Nil {
+		offers = func(db *gorm.DB) *gorm.DB { return db.Where("clinic_id = ?", clinicID) }
+	}
+
+	var request database.OfferRequest
+	if err := first(r.db.Preload("PlanVersion.PlanItems").
+		Preload("Offers", offers).
+		Preload("Offers.Clinic").
+		Where("id = ?", id), &request); err != nil {
+		return nil, err
+	}
+	return &request, nil
+}
+
+func (r *gormOffers) RequestsByPatient(patientID uuid.UUID) ([]database.OfferRequest, error) {
+	var requests []database.OfferRequest
+	err := r.db.Preload("PlanVersion.PlanItems").Preload("Offers.Clinic").
+		Where("patient_id = ?", patientID).
+		Order("created_at DESC").
+		Find(&requests).Error
+	return requests, err
+}
+
+func (r *gormOffers) RequestClinicIDs(requestID uuid.UUID) ([]uuid.UUID, error) {
+	var clinicIDs []uuid.UUID
+	err := r.db.Model(&database.Offer{}).
+		Where("offer_request_id = ?", requestID).
+		Distinct().
+		Pluck("clinic_id", &clinicIDs).Error
+	return clinicIDs, err
+}
+
+func (r *gormOffers) Create(offer *database.Offer) error {
+	return r.db.Create(offer).Error
+}
+
+func (r *gormOffers) Get(id uuid.UUID) (*database.Offer, error) {
+	var offer database.Offer
+	if err := first(r.db.Preload("OfferRequest").Where("id = ?", id), &offer); err != nil {
+		return nil, err
+	}
+	return &offer, nil
+}
+
+func (r *gormOffers) ListByClinic(clinicID uuid.UUID) ([]database.Offer, error) {
+	var offers []database.Offer
+	err := r.db.Preload("OfferRequest.PlanVersion.PlanItems").
+		Where("clinic_id = ?", clinicID).
+		Order("created_at DESC").
+		Find(&offers).Error
+	return offers, err
+}
+
+func (r *gormOffers) Accept(offer *database.Offer, order *database.Order) error {
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		// Accepting locks the request, so accepts of its offers take turns
+		var request database.OfferRequest
+		if err := first(tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ?", offer.OfferRequestID), &request); err != nil {
+			return err
+		}
+		if request.Status != "open" {
+			return ErrConflict
+		}
+
+		if err := versioned(tx.Model(offer).
+			Where("version = ? AND status = ?", offer.Version, "pending").
+			Updates(map[string]any{"status": "accepted", "version": bump})); err != nil {
+			return err
+		}
+		if err := tx.Model(&database.Offer{}).
+			Where("offer_request_id = ? AND id <> ? AND status = ?", offer.OfferRequestID, offer.ID, "pending").
+			Updates(map[string]any{"status": "rejected", "version": bump}).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&request).
+			Updates(map[string]any{"status": "closed", "version": bump}).Error; err != nil {
+			return err
+		}
+
+		order.OfferID = offer.ID
+		if err := tx.Create(order).Error; err != nil {
+			return err
+		}
+		if err := events.Record(tx, events.OfferAccepted{
+			OfferID:        offer.ID,
+			OfferRequestID: offer.OfferRequestID,
+			OrderID:        order.ID,
+			PatientID:      order.PatientID,
+			ClinicID:       order.ClinicID,
+			Total:          order.Total,
+		}); err != nil {
+			return err
+		}
+
+		return tx.Model(&database.StudyGrant{}).
+			Where("offer_request_id = ? AND clinic_id <> ? AND revoked_at IS NULL", offer.OfferRequestID, offer.ClinicID).
+			Update("revoked_at", time.Now()).Error
+	})
+	if err != nil {
+		return err
+	}
+	offer.Status = "accepted"
+	offer.Version++
+	return nil
+}
+
+type gormOrders struct{ db *gorm.DB }
+
+func (r *gormOrders) Get(id uuid.UUID) (*database.Order, error) {
+	var order database.Order
+	if err := first(r.db.Where("id = ?", id), &order); err != nil {
+		return nil, err
+	}
+	return &order, nil
+}
+
+func (r *gormOrders) Details(id uuid.UUID) (*database.Order, error) {
+	var order database.Order
+	if err := first(r.db.Preload("Offer.OfferRequest.PlanVersion.PlanItems").
+		Preload("Patient").
+		Preload("Clinic").
+		Preload("Slots").
+		Where("id = ?", id), &order); err != nil {
+		return nil, err
+	}
+	return &order, nil
+}
+
+func (r *gormOrders) List(filter OrderFilter) ([]database.Order, error) {
+	query := r.db.Preload("Offer.OfferRequest.PlanVersion.PlanItems").
+		Preload("Patient").
+		Preload("Clinic").
+		Order("created_at DESC")
+	if filter.PatientID != uuid.Nil {
+		query = query.Where("patient_id = ?", filter.PatientID)
+	}
+	if filter.ClinicID != uuid.Nil {
+		query = query.Where("clinic_id = ?", filter.ClinicID)
+	}
+
+	var orders []database.Order
+	err := query.Find(&orders).Error
+	return orders, err
+}
+
+func (r *gormOrders) UpdateStatus(order *database.Order, status string) error {
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := versioned(tx.Model(order).
+			Where("version = ?", order.Version).
+			Updates(map[string]any{"status": status, "version": bump})); err != nil {
+			return err
+		}
+		return events.Record(tx, events.OrderStatusChanged{
+			OrderID:   order.ID,
+			PatientID: order.PatientID,
+			ClinicID:  order.ClinicID,
+			From:      order.Status,
+			To:        status,
+		})
+	})
+	if err != nil {
+		return err
+	}
+	order.Status = status
+	order.Version++
+	return nil
+}
+
+type gormAudit struct{ db *gorm.DB }
+
+func (r *gormAudit) Record(entry *database.AuditLog) error {
+	return r.db.Create(entry).Error
+}
+
+func (r *gormAudit) History(entityType string, entityID uuid.UUID) ([]database.AuditLog, error) {
+	var entries []database.AuditLog
+	err := r.db.Where("entity_type = ? AND entity_id = ?", entityType, entityID).
+		Order("created_at DESC").
+		Find(&entries).Error
+	return entries, err
+}
+
+type gormLogins struct{ db *gorm.DB }
+
+func (r *gormLogins) StartSession(session *database.Session, token *database.RefreshToken) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(session).Error; err != nil {
+			return err
+		}
+		return tx.Create(token).Error
+	})
+}
+
+func (r *gormLogins) RefreshToken(id uuid.UUID) (*database.RefreshToken, error) {
+	var token database.RefreshToken
+	if err := first(r.db.Preload("Session").Where("id = ?", id), &token); err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (r *gormLogins) RotateRefreshToken(token, next *database.RefreshToken, ipAddress string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		if err := versioned(tx.Model(&database.RefreshToken{}).
+			Where("id = ? AND rotated_at IS NULL", token.ID).
+			Updates(map[string]any{"rotated_at": now, "replaced_by_id": next.ID})); err != nil {
+			return err
+		}
+		if err := tx.Model(&database.Session{}).Where("id = ?", token.SessionID).Updates(map[string]any{
+			"last_used_at": now,
+			"expires_at":   next.ExpiresAt,
+			"ip_address":   ipAddress,
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Create(next).Error
+	})
+}
+
+func (r *gormLogins) UseTOTPStep(userID uuid.UUID, step int64) error {
+	return versioned(r.db.Model(&database.User{}).
+		Where("id = ? AND mfa_last_step < ?", userID, step).
+		Update("mfa_last_step", step))
+}
+
+func (r *gormLogins) EnableMFA(userID, sessionID uuid.UUID, codeHashes []string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := versioned(tx.Model(&database.User{}).
+			Where("id = ? AND mfa_enabled_at IS NULL", userID).
+			Update("mfa_enabled_at", time.Now())); err != nil {
+			return err
+		}
+		if err := replaceRecoveryCodes(tx, userID, codeHashes); err != nil {
+			return err
+		}
+		return tx.Model(&database.Session{}).Where("id = ?", sessionID).Update("mfa", true).Error
+	})
+}
+
+func (r *gormLogins) ReplaceRecoveryCodes(userID uuid.UUID, codeHashes []string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		return replaceRecoveryCodes(tx, userID, codeHashes)
+	})
+}
+
+func replaceRecoveryCodes(tx *gorm.DB, userID uuid.UUID, codeHashes []string) error {
+	if err := tx.Where("user_id = ?", userID).Delete(&database.MFARecoveryCode{}).Error; err != nil {
+		return err
+	}
+	codes := make([]database.MFARecoveryCode, 0, len(codeHashes))
+	for _, hash := range codeHashes {
+		codes = append(codes, database.MFARecoveryCode{UserID: userID, CodeHash: hash})
+	}
+	return tx.Create(&codes).Error
+}
+
+func (r *gormLogins) UseRecoveryCode(userID uuid.UUID, codeHash string) error {
+	return updated(r.db.Model(&database.MFARecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, codeHash).
+		Update("used_at", time.Now()))
+}
+
+func (r *gormLogins) Passkeys(userID uuid.UUID) ([]database.WebAuthnCredential, error) {
+	var credentials []database.WebAuthnCredential
+	err := r.db.Where("user_id = ?", userID).Order("created_at").Find(&credentials).Error
+	return credentials, err
+}
+
+func (r *gormLogins) AddPasskey(credential *database.WebAuthnCredential) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		var registered int64
+		if err := tx.Model(&database.WebAuthnCredential{}).
+			Where("credential_id = ?", credential.CredentialID).
+			Count(&registered).Error; err != nil {
+			return err
+		}
+		if registered > 0 {
+			return ErrConflict
+		}
+		return tx.Create(credential).Error
+	})
+}
+
+func (r *gormLogins) UsePasskey(credential *database.WebAuthnCredential) error {
+	return r.db.Model(&database.WebAuthnCredential{}).
+		Where("credential_id = ?", credential.CredentialID).
+		Updates(map[string]any{
+			"sign_count":    credential.SignCount,
+			"clone_warning": credential.CloneWarning,
+			"backup_state":  credential.BackupState,
+			"last_used_at":  time.Now(),
+		}).Error
+}
+
+func (r *gormLogins) DeletePasskey(userID, id uuid.UUID) error {
+	return updated(r.db.Where("id = ? AND user_id = ?", id, userID).Delete(&database.WebAuthnCredential{}))
+}
+
+func (r *gormLogins) SaveChallenge(challenge *database.WebAuthnChallenge) error {
+	if err := r.db.Create(challenge).Error; err != nil {
+		return err
+	}
+	// Abandoned ceremonies are cleaned up opportunistically
+	r.db.Where("expires_at < ?", time.Now()).Delete(&database.WebAuthnChallenge{})
+	return nil
+}
+
+func (r *gormLogins) TakeChallenge(id uuid.UUID, ceremony string, userID *uuid.UUID) (*database.WebAuthnChallenge, error) {
+	var challenge database.WebAuthnChallenge
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		query := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ? AND ceremony = ? AND expires_at > ?", id, ceremony, time.Now())
+		if userID != nil {
+			query = query.Where("user_id = ?", *userID)
+		}
+		if err := first(query, &challenge); err != nil {
+			return err
+		}
+		return tx.Delete(&challenge).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &challenge, nil
+}
+
+func (r *gormLogins) RecentOTPs(phone string, since time.Time) ([]database.PhoneOTP, error) {
+	var otps []database.PhoneOTP
+	err := r.db.Where("phone = ? AND created_at > ?", phone, since).
+		Order("created_at DESC").
+		Find(&otps).Error
+	return otps, err
+}
+
+func (r *gormLogins) CreateOTP(otp *database.PhoneOTP) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&database.PhoneOTP{}).
+			Where("phone = ? AND consumed_at IS NULL", otp.Phone).
+			Update("consumed_at", time.Now()).Error; err != nil {
+			return err
+		}
+		return tx.Create(otp).Error
+	})
+}
+
+func (r *gormLogins) UseOTP(phone, codeHash string, maxAttempts int) (bool, error) {
+	var valid bool
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var otp database.PhoneOTP
+		err := first(tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("phone = ? AND consumed_at IS NULL AND expires_at > ?", phone, time.Now()).
+			Order("created_at DESC"), &otp)
+		if errors.Is(err, ErrNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if subtle.ConstantTimeCompare([]byte(otp.CodeHash), []byte(codeHash)) == 1 {
+			valid = true
+			return tx.Model(&otp).Update("consumed_at", time.Now()).Error
+		}
+
+		updates := map[string]any{"attempts": gorm.Expr("attempts + 1")}
+		if otp.Attempts+1 >= maxAttempts {
+			updates["consumed_at"] = time.Now()
+		}
+		return tx.Model(&otp).Updates(updates).Error
+	})
+	return valid, err
+}
+
+func (r *gormLogins) Lockout(identifier string) (*database.LoginLockout, error) {
+	var lockout database.LoginLockout
+	if err := first(r.db.Where("identifier = ?", identifier), &lockout); err != nil {
+		return nil, err
+	}
+	return &lockout, nil
+}
+
+func (r *gormLogins) RecordLoginFailure(attempt *database.LoginAttempt, window time.Duration, lockFor func(failed int) time.Duration) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&database.LoginLockout{
+			Identifier:   attempt.Identifier,
+			LastFailedAt: now,
+		}).Error; err != nil {
+			return err
+		}
+
+		var lockout database.LoginLockout
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("identifier = ?", attempt.Identifier).
+			First(&lockout).Error; err != nil {
+			return err
+		}
+
+		failed := lockout.FailedCount + 1
+		if now.Sub(lockout.LastFailedAt) > window {
+			failed = 1
+		}
+
+		updates := map[string]any{
+			"failed_count":   failed,
+			"last_failed_at": now,
+		}
+		if d := lockFor(failed); d > 0 {
+			updates["locked_until"] = now.Add(d)
+		}
+		if err := tx.Model(&lockout).Updates(updates).Error; err != nil {
+			return err
+		}
+
+		return tx.Create(attempt).Error
+	})
+}
+
+func (r *gormLogins) RecordLoginAttempt(attempt *database.LoginAttempt) error {
+	return r.db.Create(attempt).Error
+}
+
+func (r *gormLogins) ClearLockout(identifier string) error {
+	return updated(r.db.Where("identifier = ?", identifier).Delete(&database.LoginLockout{}))
+}
+
+func (r *gormLogins) Lockouts(lockedOnly bool, limit int) ([]database.LoginLockout, error) {
+	query := r.db.Where("failed_count > 0")
+	if lockedOnly {
+		query = query.Where("locked_until > ?", time.Now())
+	}
+
+	var lockouts []database.LoginLockout
+	err := query.
+		Order("locked_until DESC NULLS LAST").
+		Order("last_failed_at DESC").
+		Limit(limit).
+		Find(&lockouts).Error
+	return lockouts, err
+}
+
+func (r *gormLogins) LoginAttempts(filter LoginAttemptFilter, limit int) ([]database.LoginAttempt, error) {
+	query := r.db.Order("created_at DESC").Limit(limit)
+	if filter.Identifier != "" {
+		query = query.Where("identifier = ?", filter.Identifier)
+	}
+	if filter.IPAddress != "" {
+		query = query.Where("ip_address = ?", filter.IPAddress)
+	}
+
+	var attempts []database.LoginAttempt
+	err := query.Find(&attempts).Error
+	return attempts, err
+}
+
+func (r *gormLogins) MFAPolicies() ([]database.MFAPolicy, error) {
+	var policies []database.MFAPolicy
+	err := r.db.Order("role").Find(&policies).Error
+	return policies, err
+}
+
+func (r *gormLogins) SaveMFAPolicy(policy *database.MFAPolicy) error {
+	return r.db.Clauses(clause.OnConflict{UpdateAll: true}).Create(policy).Error
+}
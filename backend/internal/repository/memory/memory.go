@@ -0,0 +1,1732 @@
+// Package memory implements the repositories in memory for handler tests.
+// Rows are copied in and out, so a handler sees its changes only after saving
+// them, as it would with a database. Lookups fill in the same relationships
+// the GORM implementation preloads.
+package memory
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/igorfazlyev/dm/internal/database"
+	"github.com/igorfazlyev/dm/internal/events"
+	"github.com/igorfazlyev/dm/internal/rbac"
+	"github.com/igorfazlyev/dm/internal/repository"
+	"gorm.io/gorm/schema"
+)
+
+// Store is an in-memory repository.Store. The Add methods seed rows that no
+// repository creates.
+type Store struct {
+	repository.Store
+	data *data
+}
+
+// NewStore returns an empty store
+func NewStore() *Store {
+	d := &data{}
+	return &Store{
+		Store: repository.Store{
+			Users:    &users{d},
+			Patients: &patients{d},
+			Studies:  &studies{d},
+			Plans:    &plans{d},
+			Clinics:  &clinics{d},
+			Offers:   &offers{d},
+			Orders:   &orders{d},
+			Audit:    &audit{d},
+			Logins:   &logins{d},
+		},
+		data: d,
+	}
+}
+
+// Events returns the events published so far, oldest first
+func (s *Store) Events() []events.Event {
+	s.data.mu.Lock()
+	defer s.data.mu.Unlock()
+	return slices.Clone(s.data.events)
+}
+
+// UserTokens returns the tokens issued to a user, oldest first
+func (s *Store) UserTokens(userID uuid.UUID) []database.UserToken {
+	s.data.mu.Lock()
+	defer s.data.mu.Unlock()
+	return filter(s.data.userTokens, func(t *database.UserToken) bool { return t.UserID == userID }, false)
+}
+
+// AddUser stores a user account
+func (s *Store) AddUser(user *database.User) {
+	s.data.mu.Lock()
+	defer s.data.mu.Unlock()
+	stamp(&user.ID, &user.CreatedAt)
+	s.data.users = upsert(s.data.users, *user, func(u *database.User) bool { return u.ID == user.ID })
+}
+
+// AddClinic stores a clinic without members
+func (s *Store) AddClinic(clinic *database.Clinic) {
+	s.data.mu.Lock()
+	defer s.data.mu.Unlock()
+	stamp(&clinic.ID, &clinic.CreatedAt)
+	firstVersion(&clinic.Version)
+	s.data.clinics = upsert(s.data.clinics, *clinic, func(c *database.Clinic) bool { return c.ID == clinic.ID })
+}
+
+// AddClinicMember adds a user to a clinic's staff
+func (s *Store) AddClinicMember(member *database.ClinicMember) {
+	s.data.mu.Lock()
+	defer s.data.mu.Unlock()
+	stamp(&member.ID, &member.CreatedAt)
+	member.Clinic = database.Clinic{}
+	member.User = database.User{}
+	s.data.members = upsert(s.data.members, *member, func(m *database.ClinicMember) bool { return m.ID == member.ID })
+}
+
+// AddSlot stores an appointment slot
+func (s *Store) AddSlot(slot *database.Slot) {
+	s.data.mu.Lock()
+	defer s.data.mu.Unlock()
+	stamp(&slot.ID, &slot.CreatedAt)
+	slot.Clinic = database.Clinic{}
+	slot.Order = nil
+	s.data.slots = upsert(s.data.slots, *slot, func(sl *database.Slot) bool { return sl.ID == slot.ID })
+}
+
+// data holds every table in insertion order, which stands in for created_at
+// order
+type data struct {
+	mu          sync.Mutex
+	users       []database.User
+	userTokens  []database.UserToken
+	patients    []database.Patient
+	studies     []database.Study
+	grants      []database.StudyGrant
+	accessLog   []database.StudyAccessLog
+	uploads     []database.StudyUpload
+	plans       []database.PlanVersion
+	planItems   []database.PlanItem
+	annotations []database.PlanAnnotation
+	clinics     []database.Clinic
+	members     []database.ClinicMember
+	invitations []database.ClinicInvitation
+	apiKeys     []database.APIKey
+	notes       []database.ClinicNote
+	priceItems  []database.PriceListItem
+	requests    []database.OfferRequest
+	offers      []database.Offer
+	orders      []database.Order
+	slots       []database.Slot
+	auditLog    []database.AuditLog
+	events      []events.Event // The outbox
+
+	sessions      []database.Session
+	refreshTokens []database.RefreshToken
+	recoveryCodes []database.MFARecoveryCode
+	passkeys      []database.WebAuthnCredential
+	challenges    []database.WebAuthnChallenge
+	otps          []database.PhoneOTP
+	lockouts      []database.LoginLockout
+	loginAttempts []database.LoginAttempt
+	mfaPolicies   []database.MFAPolicy
+}
+
+// stamp sets the ID and creation time of a new row, as the database would
+func stamp(id *uuid.UUID, createdAt *time.Time) {
+	if *id == uuid.Nil {
+		*id = uuid.New()
+	}
+	if createdAt.IsZero() {
+		*createdAt = time.Now()
+	}
+}
+
+// firstVersion starts a versioned row at 1, as the column default does
+func firstVersion(version *int) {
+	if *version == 0 {
+		*version = 1
+	}
+}
+
+// find returns a copy of the first row matching match
+func find[T any](rows []T, match func(*T) bool) (*T, error) {
+	for i := range rows {
+		if match(&rows[i]) {
+			row := rows[i]
+			return &row, nil
+		}
+	}
+	return nil, repository.ErrNotFound
+}
+
+// filter returns copies of the rows matching match, newest first when
+// newestFirst is set
+func filter[T any](rows []T, match func(*T) bool, newestFirst bool) []T {
+	var out []T
+	for i := range rows {
+		if match(&rows[i]) {
+			out = append(out, rows[i])
+		}
+	}
+	if newestFirst {
+		slices.Reverse(out)
+	}
+	return out
+}
+
+// upsert replaces the row matching match with row, or appends it
+func upsert[T any](rows []T, row T, match func(*T) bool) []T {
+	for i := range rows {
+		if match(&rows[i]) {
+			rows[i] = row
+			return rows
+		}
+	}
+	return append(rows, row)
+}
+
+// setColumns sets the fields of row named by the columns of s, as an update
+// of them would
+func setColumns[T any](s *schema.Schema, row *T, fields map[string]any) error {
+	value := reflect.ValueOf(row).Elem()
+	for column, v := range fields {
+		field := s.LookUpField(column)
+		if field == nil {
+			return fmt.Errorf("%s has no column %q", s.Table, column)
+		}
+		if err := field.Set(context.Background(), value, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func activeGrant(g *database.StudyGrant) bool {
+	return g.RevokedAt == nil && g.ExpiresAt.After(time.Now())
+}
+
+func (d *data) clinic(id uuid.UUID) database.Clinic {
+	clinic, _ := find(d.clinics, func(c *database.Clinic) bool { return c.ID == id })
+	if clinic == nil {
+		return database.Clinic{}
+	}
+	return *clinic
+}
+
+func (d *data) patient(id uuid.UUID) database.Patient {
+	patient, _ := find(d.patients, func(p *database.Patient) bool { return p.ID == id })
+	if patient == nil {
+		return database.Patient{}
+	}
+	return *patient
+}
+
+func (d *data) itemsOf(planID uuid.UUID) []database.PlanItem {
+	return filter(d.planItems, func(i *database.PlanItem) bool { return i.PlanVersionID == planID }, false)
+}
+
+// planWithItems returns a plan version with its items
+func (d *data) planWithItems(id uuid.UUID) database.PlanVersion {
+	plan, _ := find(d.plans, func(p *database.PlanVersion) bool { return p.ID == id })
+	if plan == nil {
+		return database.PlanVersion{}
+	}
+	plan.PlanItems = d.itemsOf(plan.ID)
+	return *plan
+}
+
+// requestWithOffers fills in a request's plan items and offers with their
+// clinic. A clinicID other than uuid.Nil keeps only that clinic's offer.
+func (d *data) requestWithOffers(request *database.OfferRequest, clinicID uuid.UUID) {
+	request.PlanVersion = d.planWithItems(request.PlanVersionID)
+	request.Offers = filter(d.offers, func(o *database.Offer) bool {
+		return o.OfferRequestID == request.ID && (clinicID == uuid.Nil || o.ClinicID == clinicID)
+	}, false)
+	for i := range request.Offers {
+		request.Offers[i].Clinic = d.clinic(request.Offers[i].ClinicID)
+	}
+}
+
+// orderWithRelations fills in an order's offer, plan items, patient and clinic
+func (d *data) orderWithRelations(order *database.Order) {
+	offer, _ := find(d.offers, func(o *database.Offer) bool { return o.ID == order.OfferID })
+	if offer != nil {
+		request, _ := find(d.requests, func(r *database.OfferRequest) bool { return r.ID == offer.OfferRequestID })
+		if request != nil {
+			request.PlanVersion = d.planWithItems(request.PlanVersionID)
+			offer.OfferRequest = *request
+		}
+		order.Offer = *offer
+	}
+	order.Patient = d.patient(order.PatientID)
+	order.Clinic = d.clinic(order.ClinicID)
+}
+
+type users struct{ d *data }
+
+func (r *users) Get(id uuid.UUID) (*database.User, error) {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	return find(r.d.users, func(u *database.User) bool { return u.ID == id })
+}
+
+func (r *users) FindByEmail(email string) (*database.User, error) {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	return find(r.d.users, func(u *database.User) bool { return u.Email == email })
+}
+
+func (r *users) FindByPhone(phone string) (*database.User, error) {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	return find(r.d.users, func(u *database.User) bool {
+		return u.Phone != nil && *u.Phone == phone && !u.DeletedAt.Valid
+	})
+}
+
+func (r *users) Profile(id uuid.UUID) (*database.User, error) {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	user, err := find(r.d.users, func(u *database.User) bool { return u.ID == id })
+	if err != nil {
+		return nil, err
+	}
+	user.Patient, _ = find(r.d.patients, func(p *database.Patient) bool { return p.UserID == id })
+	user.Clinic, _ = find(r.d.clinics, func(c *database.Clinic) bool { return c.UserID == id })
+	return user, nil
+}
+
+// userSchema maps the column names Update and UseToken take to User fields
+var userSchema = sync.OnceValues(func() (*schema.Schema, error) {
+	return schema.Parse(&database.User{}, &sync.Map{}, schema.NamingStrategy{})
+})
+
+func (r *users) Update(id uuid.UUID, fields map[string]any) error {
+	s, err := userSchema()
+	if err != nil {
+		return err
+	}
+
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	for i := range r.d.users {
+		if r.d.users[i].ID == id {
+			return setColumns(s, &r.d.users[i], fields)
+		}
+	}
+	return nil
+}
+
+func (r *users) Register(user *database.User, patient *database.Patient) error {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	if user.Email != "" && slices.ContainsFunc(r.d.users, func(u database.User) bool { return u.Email == user.Email }) {
+		return fmt.Errorf("email %s is already registered", user.Email)
+	}
+	if user.Phone != nil && slices.ContainsFunc(r.d.users, func(u database.User) bool {
+		return u.Phone != nil && *u.Phone == *user.Phone
+	}) {
+		return fmt.Errorf("phone %s is already registered", *user.Phone)
+	}
+
+	stamp(&user.ID, &user.CreatedAt)
+	row := *user
+	row.Patient = nil
+	row.Clinic = nil
+	r.d.users = append(r.d.users, row)
+
+	if patient != nil {
+		patient.UserID = user.ID
+		stamp(&patient.ID, &patient.CreatedAt)
+		row := *patient
+		row.User = database.User{}
+		row.Studies = nil
+		r.d.patients = append(r.d.patients, row)
+	}
+	return nil
+}
+
+func (r *users) IssueToken(token *database.UserToken) error {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	now := time.Now()
+	for i := range r.d.userTokens {
+		t := &r.d.userTokens[i]
+		if t.UserID == token.UserID && t.Purpose == token.Purpose && t.UsedAt == nil {
+			t.UsedAt = &now
+		}
+	}
+	stamp(&token.ID, &token.CreatedAt)
+	row := *token
+	row.User = database.User{}
+	r.d.userTokens = append(r.d.userTokens, row)
+	return nil
+}
+
+func (r *users) RecentTokens(userID uuid.UUID, purpose string, since time.Time) ([]database.UserToken, error) {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	return filter(r.d.userTokens, func(t *database.UserToken) bool {
+		return t.UserID == userID && t.Purpose == purpose && t.CreatedAt.After(since)
+	}, true), nil
+}
+
+func (r *users) UseToken(tokenHash, purpose string, fields map[string]any) (*database.UserToken, error) {
+	s, err := userSchema()
+	if err != nil {
+		return nil, err
+	}
+
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	now := time.Now()
+	for i := range r.d.userTokens {
+		t := &r.d.userTokens[i]
+		if t.TokenHash != tokenHash || t.Purpose != purpose || t.UsedAt != nil || !t.ExpiresAt.After(now) {
+			continue
+		}
+		for j := range r.d.users {
+			if r.d.users[j].ID == t.UserID {
+				if err := setColumns(s, &r.d.users[j], fields); err != nil {
+					return nil, err
+				}
+			}
+		}
+		t.UsedAt = &now
+		token := *t
+		return &token, nil
+	}
+	return nil, repository.ErrNotFound
+}
+
+func (r *users) List(f repository.UserFilter, page, pageSize int) ([]database.User, int64, error) {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+
+	search := strings.ToLower(f.Search)
+	matched := filter(r.d.users, func(u *database.User) bool {
+		if search != "" && !strings.Contains(strings.ToLower(u.Email), search) &&
+			(u.Phone == nil || !strings.Contains(*u.Phone, f.Search)) {
+			return false
+		}
+		if f.Role != "" && u.Role != f.Role {
+			return false
+		}
+		return f.Active == nil || u.IsActive == *f.Active
+	}, true)
+
+	total := int64(len(matched))
+	start := min((page-1)*pageSize, len(matched))
+	end := min(start+pageSize, len(matched))
+	return matched[start:end], total, nil
+}
+
+// updateUser applies change to a stored user and to user, recording entry
+func (r *users) updateUser(user *database.User, entry *database.AuditLog, change func(*database.User)) error {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	for i := range r.d.users {
+		if r.d.users[i].ID == user.ID {
+			change(&r.d.users[i])
+			change(user)
+			r.d.record(entry)
+			return nil
+		}
+	}
+	return repository.ErrNotFound
+}
+
+func (r *users) SetActive(user *database.User, active bool, entry *database.AuditLog) error {
+	return r.updateUser(user, entry, func(u *database.User) { u.IsActive = active })
+}
+
+func (r *users) SetRole(user *database.User, role string, entry *database.AuditLog) error {
+	return r.updateUser(user, entry, func(u *database.User) { u.Role = role })
+}
+
+func (r *users) ClearMFA(userID uuid.UUID, entry *database.AuditLog) error {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	for i := range r.d.users {
+		u := &r.d.users[i]
+		if u.ID == userID {
+			u.MFASecret = ""
+			u.MFAEnabledAt = nil
+			u.MFALastStep = 0
+		}
+	}
+	r.d.recoveryCodes = slices.DeleteFunc(r.d.recoveryCodes, func(c database.MFARecoveryCode) bool { return c.UserID == userID })
+	r.d.record(entry)
+	return nil
+}
+
+type patients struct{ d *data }
+
+func (r *patients) Get(id uuid.UUID) (*database.Patient, error) {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	return find(r.d.patients, func(p *database.Patient) bool { return p.ID == id })
+}
+
+func (r *patients) FindByUserID(userID uuid.UUID) (*database.Patient, error) {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	return find(r.d.patients, func(p *database.Patient) bool { return p.UserID == userID })
+}
+
+func (r *patients) Create(patient *database.Patient) error {
+	return r.Save(patient)
+}
+
+func (r *patients) Save(patient *database.Patient) error {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	stamp(&patient.ID, &patient.CreatedAt)
+	row := *patient
+	row.User = database.User{}
+	row.Studies = nil
+	r.d.patients = upsert(r.d.patients, row, func(p *database.Patient) bool { return p.ID == patient.ID })
+	return nil
+}
+
+type studies struct{ d *data }
+
+func (r *studies) Get(id uuid.UUID) (*database.Study, error) {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	study, err := find(r.d.studies, func(s *database.Study) bool { return s.ID == id })
+	if err != nil {
+		return nil, err
+	}
+	study.Patient = r.d.patient(study.PatientID)
+	return study, nil
+}
+
+func (r *studies) ListByPatient(patientID uuid.UUID) ([]database.Study, error) {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	return filter(r.d.studies, func(s *database.Study) bool { return s.PatientID == patientID }, true), nil
+}
+
+func (r *studies) Create(study *database.Study) error {
+	return r.Save(study)
+}
+
+func (r *studies) Save(study *database.Study) error {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	stamp(&study.ID, &study.CreatedAt)
+	row := *study
+	row.Patient = database.Patient{}
+	row.PlanVersions = nil
+	r.d.studies = upsert(r.d.studies, row, func(s *database.Study) bool { return s.ID == study.ID })
+	return nil
+}
+
+// studySchema maps the column names Update takes to Study fields
+var studySchema = sync.OnceValues(func() (*schema.Schema, error) {
+	return schema.Parse(&database.Study{}, &sync.Map{}, schema.NamingStrategy{})
+})
+
+func (r *studies) Update(id uuid.UUID, fields map[string]any) error {
+	s, err := studySchema()
+	if err != nil {
+		return err
+	}
+
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	for i := range r.d.studies {
+		if r.d.studies[i].ID == id {
+			return setColumns(s, &r.d.studies[i], fields)
+		}
+	}
+	return nil
+}
+
+func (r *studies) Complete(study *database.Study, reportURL string) error {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	now := time.Now()
+	for i := range r.d.studies {
+		row := &r.d.studies[i]
+		if row.ID != study.ID || row.Status == "completed" {
+			continue
+		}
+		row.Status = "completed"
+		row.CompletedAt = &now
+		row.DiagnocatReportURL = &reportURL
+		r.d.events = append(r.d.events, events.StudyCompleted{StudyID: row.ID, PatientID: row.PatientID})
+	}
+	study.Status = "completed"
+	study.CompletedAt = &now
+	study.DiagnocatReportURL = &reportURL
+	return nil
+}
+
+func (r *studies) ActiveGrants(studyID uuid.UUID) ([]database.StudyGrant, error) {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	return filter(r.d.grants, func(g *database.StudyGrant) bool {
+		return g.StudyID == studyID && activeGrant(g)
+	}, false), nil
+}
+
+func (r *studies) ActiveGrant(studyID, clinicID uuid.UUID) (*database.StudyGrant, error) {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	return find(r.d.grants, func(g *database.StudyGrant) bool {
+		return g.StudyID == studyID && g.ClinicID == clinicID && activeGrant(g)
+	})
+}
+
+func (r *studies) Grants(studyID uuid.UUID) ([]database.StudyGrant, error) {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	grants := filter(r.d.grants, func(g *database.StudyGrant) bool { return g.StudyID == studyID }, true)
+	for i := range grants {
+		grants[i].Clinic = r.d.clinic(grants[i].ClinicID)
+	}
+	return grants, nil
+}
+
+func (r *studies) ClinicGrants(clinicID uuid.UUID) ([]database.StudyGrant, error) {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	return filter(r.d.grants, func(g *database.StudyGrant) bool {
+		return g.ClinicID == clinicID && activeGrant(g)
+	}, true), nil
+}
+
+func (r *studies) SaveGrant(grant *database.StudyGrant) error {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	r.d.saveGrant(grant)
+	return nil
+}
+
+func (d *data) saveGrant(grant *database.StudyGrant) {
+	stamp(&grant.ID, &grant.CreatedAt)
+	row := *grant
+	row.Clinic = database.Clinic{}
+	d.grants = upsert(d.grants, row, func(g *database.StudyGrant) bool { return g.ID == grant.ID })
+}
+
+func (r *studies) RevokeGrant(studyID, grantID uuid.UUID) error {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	for i := range r.d.grants {
+		g := &r.d.grants[i]
+		if g.ID == grantID && g.StudyID == studyID && g.RevokedAt == nil {
+			now := time.Now()
+			g.RevokedAt = &now
+			return nil
+		}
+	}
+	return repository.ErrNotFound
+}
+
+func (r *studies) LogAccess(entry *database.StudyAccessLog) error {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	stamp(&entry.ID, &entry.CreatedAt)
+	row := *entry
+	row.Clinic = database.Clinic{}
+	r.d.accessLog = append(r.d.accessLog, row)
+	return nil
+}
+
+func (r *studies) AccessLog(studyID uuid.UUID, limit int) ([]database.StudyAccessLog, error) {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	entries := filter(r.d.accessLog, func(e *database.StudyAccessLog) bool { return e.StudyID == studyID }, true)
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+	for i := range entries {
+		entries[i].Clinic = r.d.clinic(entries[i].ClinicID)
+	}
+	return entries, nil
+}
+
+func (r *studies) CreateUpload(upload *database.StudyUpload) error {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	if _, err := find(r.d.uploads, activeUpload(upload.StudyID)); err == nil {
+		return repository.ErrConflict
+	}
+	stamp(&upload.ID, &upload.CreatedAt)
+	upload.UpdatedAt = upload.CreatedAt
+	r.d.uploads = append(r.d.uploads, *upload)
+	return nil
+}
+
+func (r *studies) Upload(studyID, uploadID uuid.UUID) (*database.StudyUpload, error) {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	return find(r.d.uploads, func(u *database.StudyUpload) bool {
+		return u.ID == uploadID && u.StudyID == studyID && u.ExpiresAt.After(time.Now())
+	})
+}
+
+func (r *studies) ActiveUpload(studyID uuid.UUID) (*database.StudyUpload, error) {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	return find(r.d.uploads, activeUpload(studyID))
+}
+
+// activeUpload matches the uploads of a study in progress
+func activeUpload(studyID uuid.UUID) func(*database.StudyUpload) bool {
+	return func(u *database.StudyUpload) bool {
+		return u.StudyID == studyID && u.CompletedAt == nil && u.ExpiresAt.After(time.Now())
+	}
+}
+
+func (r *studies) AdvanceUpload(upload *database.StudyUpload, offset int64, expiresAt time.Time) error {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	for i := range r.d.uploads {
+		row := &r.d.uploads[i]
+		if row.ID != upload.ID || row.Offset != upload.Offset {
+			continue
+		}
+		row.Offset = offset
+		row.ExpiresAt = expiresAt
+		row.UpdatedAt = time.Now()
+		upload.Offset = offset
+		upload.ExpiresAt = expiresAt
+		return nil
+	}
+	return repository.ErrConflict
+}
+
+func (r *studies) CompleteUpload(upload *database.StudyUpload) error {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	now := time.Now()
+	for i := range r.d.uploads {
+		if r.d.uploads[i].ID == upload.ID {
+			r.d.uploads[i].CompletedAt = &now
+		}
+	}
+	upload.CompletedAt = &now
+	return nil
+}
+
+func (r *studies) DeleteUpload(uploadID uuid.UUID) error {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	r.d.uploads = slices.DeleteFunc(r.d.uploads, func(u database.StudyUpload) bool { return u.ID == uploadID })
+	return nil
+}
+
+func (r *studies) ExpiredUploads(t time.Time) ([]database.StudyUpload, error) {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	return filter(r.d.uploads, func(u *database.StudyUpload) bool {
+		return !u.ExpiresAt.After(t)
+	}, false), nil
+}
+
+type plans struct{ d *data }
+
+func (r *plans) Get(id uuid.UUID) (*database.PlanVersion, error) {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	plan, err := find(r.d.plans, func(p *database.PlanVersion) bool { return p.ID == id })
+	if err != nil {
+		return nil, err
+	}
+	plan.PlanItems = r.d.itemsOf(plan.ID)
+	if study, err := find(r.d.studies, func(s *database.Study) bool { return s.ID == plan.StudyID }); err == nil {
+		study.Patient = r.d.patient(study.PatientID)
+		plan.Study = *study
+	}
+	return plan, nil
+}
+
+func (r *plans) ListByStudy(studyID uuid.UUID) ([]database.PlanVersion, error) {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	versions := filter(r.d.plans, func(p *database.PlanVersion) bool { return p.StudyID == studyID }, true)
+	slices.SortStableFunc(versions, func(a, b database.PlanVersion) int { return b.Version - a.Version })
+	for i := range versions {
+		versions[i].PlanItems = r.d.itemsOf(versions[i].ID)
+	}
+	return versions, nil
+}
+
+func (r *plans) Create(plan *database.PlanVersion) error {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+
+	stamp(&plan.ID, &plan.CreatedAt)
+	plan.Version = len(filter(r.d.plans, func(p *database.PlanVersion) bool { return p.StudyID == plan.StudyID }, false)) + 1
+	for i := range plan.PlanItems {
+		item := &plan.PlanItems[i]
+		stamp(&item.ID, &item.CreatedAt)
+		item.PlanVersionID = plan.ID
+		r.d.planItems = append(r.d.planItems, *item)
+	}
+
+	row := *plan
+	row.Study = database.Study{}
+	row.PlanItems = nil
+	r.d.plans = append(r.d.plans, row)
+	return nil
+}
+
+func (r *plans) Audience(planID uuid.UUID) (repository.PlanAudience, error) {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+
+	var audience repository.PlanAudience
+	for _, request := range r.d.requests {
+		if request.PlanVersionID != planID {
+			continue
+		}
+		if request.Status == "open" {
+			audience.Listed = true
+		}
+		for _, offer := range r.d.offers {
+			if offer.OfferRequestID == request.ID && !slices.Contains(audience.ClinicIDs, offer.ClinicID) {
+				audience.ClinicIDs = append(audience.ClinicIDs, offer.ClinicID)
+			}
+		}
+	}
+	return audience, nil
+}
+
+func (r *plans) GetItem(planID, itemID uuid.UUID) (*database.PlanItem, error) {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	return find(r.d.planItems, func(i *database.PlanItem) bool { return i.ID == itemID && i.PlanVersionID == planID })
+}
+
+func (r *plans) CreateAnnotation(annotation *database.PlanAnnotation) error {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	stamp(&annotation.ID, &annotation.CreatedAt)
+	row := *annotation
+	row.Clinic = database.Clinic{}
+	r.d.annotations = append(r.d.annotations, row)
+	return nil
+}
+
+func (r *plans) Annotations(planID, clinicID uuid.UUID) ([]database.PlanAnnotation, error) {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	annotations := filter(r.d.annotations, func(a *database.PlanAnnotation) bool {
+		return a.PlanVersionID == planID && (clinicID == uuid.Nil || a.ClinicID == clinicID)
+	}, false)
+	for i := range annotations {
+		annotations[i].Clinic = r.d.clinic(annotations[i].ClinicID)
+	}
+	return annotations, nil
+}
+
+type clinics struct{ d *data }
+
+func (r *clinics) Get(id uuid.UUID) (*database.Clinic, error) {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	return find(r.d.clinics, func(c *database.Clinic) bool { return c.ID == id })
+}
+
+func (r *clinics) ForMember(userID uuid.UUID) (*database.Clinic, error) {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	member, err := find(r.d.members, func(m *database.ClinicMember) bool { return m.UserID == userID })
+	if err != nil {
+		return nil, err
+	}
+	return find(r.d.clinics, func(c *database.Clinic) bool { return c.ID == member.ClinicID })
+}
+
+func (r *clinics) Membership(userID uuid.UUID) (*database.ClinicMember, error) {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	member, err := find(r.d.members, func(m *database.ClinicMember) bool { return m.UserID == userID })
+	if err != nil {
+		return nil, err
+	}
+	member.Clinic = r.d.clinic(member.ClinicID)
+	return member, nil
+}
+
+func (r *clinics) Create(clinic *database.Clinic, managerID uuid.UUID) error {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	stamp(&clinic.ID, &clinic.CreatedAt)
+	firstVersion(&clinic.Version)
+	r.d.clinics = append(r.d.clinics, *clinic)
+
+	member := database.ClinicMember{ClinicID: clinic.ID, UserID: managerID, Role: rbac.RoleClinicManager}
+	stamp(&member.ID, &member.CreatedAt)
+	r.d.members = append(r.d.members, member)
+	return nil
+}
+
+func (r *clinics) Save(clinic *database.Clinic) error {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	for i := range r.d.clinics {
+		if r.d.clinics[i].ID == clinic.ID {
+			if r.d.clinics[i].Version != clinic.Version {
+				return repository.ErrConflict
+			}
+			clinic.Version++
+			r.d.clinics[i] = *clinic
+			return nil
+		}
+	}
+	return repository.ErrConflict
+}
+
+func (r *clinics) ListActive(f repository.ClinicFilter) ([]database.Clinic, error) {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	return filter(r.d.clinics, func(c *database.Clinic) bool {
+		return c.IsActive &&
+			(f.City == "" || c.City == f.City) &&
+			(f.District == "" || c.District == f.District) &&
+			(f.PriceSegment == "" || c.PriceSegment == f.PriceSegment)
+	}, false), nil
+}
+
+func (r *clinics) List(status string) ([]database.Clinic, error) {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	return filter(r.d.clinics, func(c *database.Clinic) bool {
+		return status == "" || c.Status == status
+	}, true), nil
+}
+
+func (r *clinics) Pending() ([]database.Clinic, error) {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	return filter(r.d.clinics, func(c *database.Clinic) bool { return c.Status == "pending" }, false), nil
+}
+
+func (r *clinics) Review(clinic *database.Clinic, entry *database.AuditLog) error {
+	if err := r.Save(clinic); err != nil {
+		return err
+	}
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	r.d.record(entry)
+	return nil
+}
+
+func (r *clinics) Notes(clinicID uuid.UUID) ([]database.ClinicNote, error) {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	return filter(r.d.notes, func(n *database.ClinicNote) bool { return n.ClinicID == clinicID }, true), nil
+}
+
+func (r *clinics) AddNote(note *database.ClinicNote) error {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	stamp(&note.ID, &note.CreatedAt)
+	r.d.notes = append(r.d.notes, *note)
+	return nil
+}
+
+func (r *clinics) Members(clinicID uuid.UUID) ([]database.ClinicMember, error) {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	members := filter(r.d.members, func(m *database.ClinicMember) bool { return m.ClinicID == clinicID }, false)
+	for i := range members {
+		user, _ := find(r.d.users, func(u *database.User) bool { return u.ID == members[i].UserID })
+		if user != nil {
+			members[i].User = *user
+		}
+	}
+	return members, nil
+}
+
+func (r *clinics) RemoveMember(clinicID, userID uuid.UUID) error {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+
+	target := -1
+	managers := 0
+	for i, m := range r.d.members {
+		if m.ClinicID != clinicID {
+			continue
+		}
+		if m.Role == rbac.RoleClinicManager {
+			managers++
+		}
+		if m.UserID == userID {
+			target = i
+		}
+	}
+
+	if target < 0 {
+		return repository.ErrNotFound
+	}
+	if r.d.members[target].Role == rbac.RoleClinicManager && managers == 1 {
+		return repository.ErrLastManager
+	}
+	r.d.members = slices.Delete(r.d.members, target, target+1)
+	return nil
+}
+
+func pendingInvitation(i *database.ClinicInvitation) bool {
+	return i.AcceptedAt == nil && i.RevokedAt == nil
+}
+
+func (r *clinics) Invite(invitation *database.ClinicInvitation) error {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	now := time.Now()
+	for i := range r.d.invitations {
+		existing := &r.d.invitations[i]
+		if existing.ClinicID == invitation.ClinicID && existing.Email == invitation.Email && pendingInvitation(existing) {
+			existing.RevokedAt = &now
+		}
+	}
+	stamp(&invitation.ID, &invitation.CreatedAt)
+	row := *invitation
+	row.Clinic = database.Clinic{}
+	r.d.invitations = append(r.d.invitations, row)
+	return nil
+}
+
+func (r *clinics) Invitation(tokenHash string) (*database.ClinicInvitation, error) {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	return find(r.d.invitations, func(i *database.ClinicInvitation) bool { return i.TokenHash == tokenHash })
+}
+
+func (r *clinics) Invitations(clinicID uuid.UUID) ([]database.ClinicInvitation, error) {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	now := time.Now()
+	return filter(r.d.invitations, func(i *database.ClinicInvitation) bool {
+		return i.ClinicID == clinicID && pendingInvitation(i) && i.ExpiresAt.After(now)
+	}, true), nil
+}
+
+func (r *clinics) RevokeInvitation(clinicID, invitationID uuid.UUID) error {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	for i := range r.d.invitations {
+		invitation := &r.d.invitations[i]
+		if invitation.ID == invitationID && invitation.ClinicID == clinicID && pendingInvitation(invitation) {
+			now := time.Now()
+			invitation.RevokedAt = &now
+			return nil
+		}
+	}
+	return repository.ErrNotFound
+}
+
+func (r *clinics) AcceptInvitation(invitation *database.ClinicInvitation, member *database.ClinicMember) error {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+
+	i := slices.IndexFunc(r.d.invitations, func(i database.ClinicInvitation) bool { return i.ID == invitation.ID })
+	if i < 0 || !pendingInvitation(&r.d.invitations[i]) {
+		return repository.ErrConflict
+	}
+	if slices.ContainsFunc(r.d.members, func(m database.ClinicMember) bool { return m.UserID == member.UserID }) {
+		return fmt.Errorf("user %s is already a clinic member", member.UserID)
+	}
+
+	now := time.Now()
+	r.d.invitations[i].AcceptedAt = &now
+	invitation.AcceptedAt = &now
+	for u := range r.d.users {
+		if r.d.users[u].ID == member.UserID && r.d.users[u].VerifiedAt == nil {
+			r.d.users[u].VerifiedAt = &now
+		}
+	}
+
+	stamp(&member.ID, &member.CreatedAt)
+	row := *member
+	row.Clinic = database.Clinic{}
+	row.User = database.User{}
+	r.d.members = append(r.d.members, row)
+	return nil
+}
+
+func (r *clinics) CreateAPIKey(key *database.APIKey) error {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	stamp(&key.ID, &key.CreatedAt)
+	row := *key
+	row.Clinic = database.Clinic{}
+	r.d.apiKeys = append(r.d.apiKeys, row)
+	return nil
+}
+
+func (r *clinics) APIKeys(clinicID uuid.UUID) ([]database.APIKey, error) {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	return filter(r.d.apiKeys, func(k *database.APIKey) bool { return k.ClinicID == clinicID }, true), nil
+}
+
+func (r *clinics) RevokeAPIKey(clinicID, keyID uuid.UUID) error {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	for i := range r.d.apiKeys {
+		key := &r.d.apiKeys[i]
+		if key.ID == keyID && key.ClinicID == clinicID && key.RevokedAt == nil {
+			now := time.Now()
+			key.RevokedAt = &now
+			return nil
+		}
+	}
+	return repository.ErrNotFound
+}
+
+func (r *clinics) AddPriceItem(item *database.PriceListItem) error {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	stamp(&item.ID, &item.CreatedAt)
+	r.d.priceItems = append(r.d.priceItems, *item)
+	return nil
+}
+
+func (r *clinics) PriceList(clinicID uuid.UUID) ([]database.PriceListItem, error) {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	items := filter(r.d.priceItems, func(i *database.PriceListItem) bool {
+		return i.ClinicID == clinicID && i.IsActive
+	}, false)
+	slices.SortStableFunc(items, func(a, b database.PriceListItem) int {
+		if c := strings.Compare(a.Specialty, b.Specialty); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ProcedureName, b.ProcedureName)
+	})
+	return items, nil
+}
+
+func (r *clinics) DeactivatePriceItem(clinicID, itemID uuid.UUID) error {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	for i := range r.d.priceItems {
+		if r.d.priceItems[i].ID == itemID && r.d.priceItems[i].ClinicID == clinicID {
+			r.d.priceItems[i].IsActive = false
+			return nil
+		}
+	}
+	return repository.ErrNotFound
+}
+
+func (r *clinics) PriceRanges(codes []string, currency string) (map[string]repository.PriceRange, error) {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+
+	ranges := map[string]repository.PriceRange{}
+	for _, item := range r.d.priceItems {
+		if !item.IsActive || item.PriceFrom.Currency != currency || !slices.Contains(codes, item.ProcedureCode) {
+			continue
+		}
+		clinic, err := find(r.d.clinics, func(c *database.Clinic) bool { return c.ID == item.ClinicID })
+		if err != nil || !clinic.IsActive {
+			continue
+		}
+
+		high := item.PriceFrom
+		if item.PriceTo.Amount.Cmp(high.Amount) > 0 {
+			high = item.PriceTo
+		}
+		current, seen := ranges[item.ProcedureCode]
+		if !seen || item.PriceFrom.Amount.Cmp(current.Min.Amount) < 0 {
+			current.Min = item.PriceFrom
+		}
+		if !seen || high.Amount.Cmp(current.Max.Amount) > 0 {
+			current.Max = high
+		}
+		ranges[item.ProcedureCode] = current
+	}
+	return ranges, nil
+}
+
+type offers struct{ d *data }
+
+func (r *offers) CreateRequest(request *database.OfferRequest, grants []database.StudyGrant) error {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	stamp(&request.ID, &request.CreatedAt)
+	firstVersion(&request.Version)
+	row := *request
+	row.Patient = database.Patient{}
+	row.PlanVersion = database.PlanVersion{}
+	row.Offers = nil
+	r.d.requests = append(r.d.requests, row)
+
+	for i := range grants {
+		grants[i].OfferRequestID = &request.ID
+		r.d.saveGrant(&grants[i])
+	}
+	return nil
+}
+
+func (r *offers) GetRequest(id uuid.UUID) (*database.OfferRequest, error) {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	return find(r.d.requests, func(req *database.OfferRequest) bool { return req.ID == id })
+}
+
+func (r *offers) RequestDetails(id, clinicID uuid.UUID) (*database.OfferRequest, error) {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	request, err := find(r.d.requests, func(req *database.OfferRequest) bool { return req.ID == id })
+	if err != nil {
+		return nil, err
+	}
+	r.d.requestWithOffers(request, clinicID)
+	return request, nil
+}
+
+func (r *offers) RequestsByPatient(patientID uuid.UUID) ([]database.OfferRequest, error) {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	requests := filter(r.d.requests, func(req *database.OfferRequest) bool { return req.PatientID == patientID }, true)
+	for i := range requests {
+		r.d.requestWithOffers(&requests[i], uuid.Nil)
+	}
+	return requests, nil
+}
+
+func (r *offers) RequestClinicIDs(requestID uuid.UUID) ([]uuid.UUID, error) {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	var clinicIDs []uuid.UUID
+	for _, offer := range r.d.offers {
+		if offer.OfferRequestID == requestID && !slices.Contains(clinicIDs, offer.ClinicID) {
+			clinicIDs = append(clinicIDs, offer.ClinicID)
+		}
+	}
+	return clinicIDs, nil
+}
+
+func (r *offers) Create(offer *database.Offer) error {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	stamp(&offer.ID, &offer.CreatedAt)
+	firstVersion(&offer.Version)
+	row := *offer
+	row.OfferRequest = database.OfferRequest{}
+	row.Clinic = database.Clinic{}
+	row.Orders = nil
+	r.d.offers = append(r.d.offers, row)
+	return nil
+}
+
+func (r *offers) Get(id uuid.UUID) (*database.Offer, error) {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	offer, err := find(r.d.offers, func(o *database.Offer) bool { return o.ID == id })
+	if err != nil {
+		return nil, err
+	}
+	if request, err := find(r.d.requests, func(req *database.OfferRequest) bool { return req.ID == offer.OfferRequestID }); err == nil {
+		offer.OfferRequest = *request
+	}
+	return offer, nil
+}
+
+func (r *offers) ListByClinic(clinicID uuid.UUID) ([]database.Offer, error) {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	list := filter(r.d.offers, func(o *database.Offer) bool { return o.ClinicID == clinicID }, true)
+	for i := range list {
+		request, err := find(r.d.requests, func(req *database.OfferRequest) bool { return req.ID == list[i].OfferRequestID })
+		if err == nil {
+			request.PlanVersion = r.d.planWithItems(request.PlanVersionID)
+			list[i].OfferRequest = *request
+		}
+	}
+	return list, nil
+}
+
+func (r *offers) Accept(offer *database.Offer, order *database.Order) error {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+
+	request, err := find(r.d.requests, func(q *database.OfferRequest) bool { return q.ID == offer.OfferRequestID })
+	if err != nil {
+		return err
+	}
+	stored, err := find(r.d.offers, func(o *database.Offer) bool { return o.ID == offer.ID })
+	if err != nil {
+		return err
+	}
+	if request.Status != "open" || stored.Version != offer.Version || stored.Status != "pending" {
+		return repository.ErrConflict
+	}
+
+	for i := range r.d.offers {
+		o := &r.d.offers[i]
+		switch {
+		case o.ID == offer.ID:
+			o.Status = "accepted"
+			o.Version++
+		case o.OfferRequestID == offer.OfferRequestID && o.Status == "pending":
+			o.Status = "rejected"
+			o.Version++
+		}
+	}
+	offer.Status = "accepted"
+	offer.Version++
+
+	for i := range r.d.requests {
+		if r.d.requests[i].ID == offer.OfferRequestID {
+			r.d.requests[i].Status = "closed"
+			r.d.requests[i].Version++
+		}
+	}
+
+	order.OfferID = offer.ID
+	stamp(&order.ID, &order.CreatedAt)
+	firstVersion(&order.Version)
+	r.d.orders = append(r.d.orders, *order)
+	r.d.events = append(r.d.events, events.OfferAccepted{
+		OfferID:        offer.ID,
+		OfferRequestID: offer.OfferRequestID,
+		OrderID:        order.ID,
+		PatientID:      order.PatientID,
+		ClinicID:       order.ClinicID,
+		Total:          order.Total,
+	})
+
+	now := time.Now()
+	for i := range r.d.grants {
+		g := &r.d.grants[i]
+		if g.OfferRequestID != nil && *g.OfferRequestID == offer.OfferRequestID &&
+			g.ClinicID != offer.ClinicID && g.RevokedAt == nil {
+			g.RevokedAt = &now
+		}
+	}
+	return nil
+}
+
+type orders struct{ d *data }
+
+func (r *orders) Get(id uuid.UUID) (*database.Order, error) {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	return find(r.d.orders, func(o *database.Order) bool { return o.ID == id })
+}
+
+func (r *orders) Details(id uuid.UUID) (*database.Order, error) {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	order, err := find(r.d.orders, func(o *database.Order) bool { return o.ID == id })
+	if err != nil {
+		return nil, err
+	}
+	r.d.orderWithRelations(order)
+	order.Slots = filter(r.d.slots, func(s *database.Slot) bool { return s.OrderID != nil && *s.OrderID == id }, false)
+	return order, nil
+}
+
+func (r *orders) List(f repository.OrderFilter) ([]database.Order, error) {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	list := filter(r.d.orders, func(o *database.Order) bool {
+		return (f.PatientID == uuid.Nil || o.PatientID == f.PatientID) &&
+			(f.ClinicID == uuid.Nil || o.ClinicID == f.ClinicID)
+	}, true)
+	for i := range list {
+		r.d.orderWithRelations(&list[i])
+	}
+	return list, nil
+}
+
+func (r *orders) UpdateStatus(order *database.Order, status string) error {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	for i := range r.d.orders {
+		if r.d.orders[i].ID == order.ID {
+			if r.d.orders[i].Version != order.Version {
+				return repository.ErrConflict
+			}
+			r.d.events = append(r.d.events, events.OrderStatusChanged{
+				OrderID:   order.ID,
+				PatientID: order.PatientID,
+				ClinicID:  order.ClinicID,
+				From:      r.d.orders[i].Status,
+				To:        status,
+			})
+			r.d.orders[i].Status = status
+			r.d.orders[i].Version++
+			order.Status = status
+			order.Version++
+			return nil
+		}
+	}
+	return repository.ErrNotFound
+}
+
+// record appends an entry to the audit log. The caller holds the lock.
+func (d *data) record(entry *database.AuditLog) {
+	stamp(&entry.ID, &entry.CreatedAt)
+	d.auditLog = append(d.auditLog, *entry)
+}
+
+type audit struct{ d *data }
+
+func (r *audit) Record(entry *database.AuditLog) error {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	r.d.record(entry)
+	return nil
+}
+
+func (r *audit) History(entityType string, entityID uuid.UUID) ([]database.AuditLog, error) {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	return filter(r.d.auditLog, func(e *database.AuditLog) bool {
+		return e.EntityType == entityType && e.EntityID != nil && *e.EntityID == entityID
+	}, true), nil
+}
+
+type logins struct{ d *data }
+
+func (r *logins) StartSession(session *database.Session, token *database.RefreshToken) error {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	stamp(&session.ID, &session.CreatedAt)
+	stamp(&token.ID, &token.CreatedAt)
+	row := *session
+	row.User = database.User{}
+	r.d.sessions = append(r.d.sessions, row)
+	r.d.addRefreshToken(*token)
+	return nil
+}
+
+func (d *data) addRefreshToken(token database.RefreshToken) {
+	token.User = database.User{}
+	token.Session = database.Session{}
+	d.refreshTokens = append(d.refreshTokens, token)
+}
+
+func (r *logins) RefreshToken(id uuid.UUID) (*database.RefreshToken, error) {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	token, err := find(r.d.refreshTokens, func(t *database.RefreshToken) bool { return t.ID == id })
+	if err != nil {
+		return nil, err
+	}
+	if session, _ := find(r.d.sessions, func(s *database.Session) bool { return s.ID == token.SessionID }); session != nil {
+		token.Session = *session
+	}
+	return token, nil
+}
+
+func (r *logins) RotateRefreshToken(token, next *database.RefreshToken, ipAddress string) error {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	i := slices.IndexFunc(r.d.refreshTokens, func(t database.RefreshToken) bool { return t.ID == token.ID })
+	if i < 0 || r.d.refreshTokens[i].RotatedAt != nil {
+		return repository.ErrConflict
+	}
+
+	now := time.Now()
+	r.d.refreshTokens[i].RotatedAt = &now
+	r.d.refreshTokens[i].ReplacedByID = &next.ID
+	for j := range r.d.sessions {
+		if s := &r.d.sessions[j]; s.ID == token.SessionID {
+			s.LastUsedAt = now
+			s.ExpiresAt = next.ExpiresAt
+			s.IPAddress = ipAddress
+		}
+	}
+	stamp(&next.ID, &next.CreatedAt)
+	r.d.addRefreshToken(*next)
+	return nil
+}
+
+// user returns the stored user with an ID, or nil
+func (d *data) user(id uuid.UUID) *database.User {
+	for i := range d.users {
+		if d.users[i].ID == id {
+			return &d.users[i]
+		}
+	}
+	return nil
+}
+
+func (r *logins) UseTOTPStep(userID uuid.UUID, step int64) error {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	user := r.d.user(userID)
+	if user == nil || user.MFALastStep >= step {
+		return repository.ErrConflict
+	}
+	user.MFALastStep = step
+	return nil
+}
+
+func (r *logins) EnableMFA(userID, sessionID uuid.UUID, codeHashes []string) error {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	user := r.d.user(userID)
+	if user == nil || user.MFAEnabledAt != nil {
+		return repository.ErrConflict
+	}
+	now := time.Now()
+	user.MFAEnabledAt = &now
+	r.d.replaceRecoveryCodes(userID, codeHashes)
+	for i := range r.d.sessions {
+		if r.d.sessions[i].ID == sessionID {
+			r.d.sessions[i].MFA = true
+		}
+	}
+	return nil
+}
+
+func (r *logins) ReplaceRecoveryCodes(userID uuid.UUID, codeHashes []string) error {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	r.d.replaceRecoveryCodes(userID, codeHashes)
+	return nil
+}
+
+func (d *data) replaceRecoveryCodes(userID uuid.UUID, codeHashes []string) {
+	d.recoveryCodes = slices.DeleteFunc(d.recoveryCodes, func(c database.MFARecoveryCode) bool { return c.UserID == userID })
+	for _, hash := range codeHashes {
+		code := database.MFARecoveryCode{UserID: userID, CodeHash: hash}
+		stamp(&code.ID, &code.CreatedAt)
+		d.recoveryCodes = append(d.recoveryCodes, code)
+	}
+}
+
+func (r *logins) UseRecoveryCode(userID uuid.UUID, codeHash string) error {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	for i := range r.d.recoveryCodes {
+		c := &r.d.recoveryCodes[i]
+		if c.UserID == userID && c.CodeHash == codeHash && c.UsedAt == nil {
+			now := time.Now()
+			c.UsedAt = &now
+			return nil
+		}
+	}
+	return repository.ErrNotFound
+}
+
+func (r *logins) Passkeys(userID uuid.UUID) ([]database.WebAuthnCredential, error) {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	return filter(r.d.passkeys, func(c *database.WebAuthnCredential) bool { return c.UserID == userID }, false), nil
+}
+
+func (r *logins) AddPasskey(credential *database.WebAuthnCredential) error {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	if slices.ContainsFunc(r.d.passkeys, func(c database.WebAuthnCredential) bool {
+		return slices.Equal(c.CredentialID, credential.CredentialID)
+	}) {
+		return repository.ErrConflict
+	}
+	stamp(&credential.ID, &credential.CreatedAt)
+	row := *credential
+	row.User = database.User{}
+	r.d.passkeys = append(r.d.passkeys, row)
+	return nil
+}
+
+func (r *logins) UsePasskey(credential *database.WebAuthnCredential) error {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	now := time.Now()
+	for i := range r.d.passkeys {
+		c := &r.d.passkeys[i]
+		if slices.Equal(c.CredentialID, credential.CredentialID) {
+			c.SignCount = credential.SignCount
+			c.CloneWarning = credential.CloneWarning
+			c.BackupState = credential.BackupState
+			c.LastUsedAt = &now
+		}
+	}
+	return nil
+}
+
+func (r *logins) DeletePasskey(userID, id uuid.UUID) error {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	n := len(r.d.passkeys)
+	r.d.passkeys = slices.DeleteFunc(r.d.passkeys, func(c database.WebAuthnCredential) bool {
+		return c.ID == id && c.UserID == userID
+	})
+	if len(r.d.passkeys) == n {
+		return repository.ErrNotFound
+	}
+	return nil
+}
+
+func (r *logins) SaveChallenge(challenge *database.WebAuthnChallenge) error {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	now := time.Now()
+	r.d.challenges = slices.DeleteFunc(r.d.challenges, func(c database.WebAuthnChallenge) bool { return c.ExpiresAt.Before(now) })
+	stamp(&challenge.ID, &challenge.CreatedAt)
+	r.d.challenges = append(r.d.challenges, *challenge)
+	return nil
+}
+
+func (r *logins) TakeChallenge(id uuid.UUID, ceremony string, userID *uuid.UUID) (*database.WebAuthnChallenge, error) {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	i := slices.IndexFunc(r.d.challenges, func(c database.WebAuthnChallenge) bool {
+		return c.ID == id && c.Ceremony == ceremony && c.ExpiresAt.After(time.Now()) &&
+			(userID == nil || c.UserID != nil && *c.UserID == *userID)
+	})
+	if i < 0 {
+		return nil, repository.ErrNotFound
+	}
+	challenge := r.d.challenges[i]
+	r.d.challenges = slices.Delete(r.d.challenges, i, i+1)
+	return &challenge, nil
+}
+
+func (r *logins) RecentOTPs(phone string, since time.Time) ([]database.PhoneOTP, error) {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	return filter(r.d.otps, func(o *database.PhoneOTP) bool {
+		return o.Phone == phone && o.CreatedAt.After(since)
+	}, true), nil
+}
+
+func (r *logins) CreateOTP(otp *database.PhoneOTP) error {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	now := time.Now()
+	for i := range r.d.otps {
+		if o := &r.d.otps[i]; o.Phone == otp.Phone && o.ConsumedAt == nil {
+			o.ConsumedAt = &now
+		}
+	}
+	stamp(&otp.ID, &otp.CreatedAt)
+	r.d.otps = append(r.d.otps, *otp)
+	return nil
+}
+
+func (r *logins) UseOTP(phone, codeHash string, maxAttempts int) (bool, error) {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	now := time.Now()
+	for i := len(r.d.otps) - 1; i >= 0; i-- {
+		o := &r.d.otps[i]
+		if o.Phone != phone || o.ConsumedAt != nil || !o.ExpiresAt.After(now) {
+			continue
+		}
+		if o.CodeHash == codeHash {
+			o.ConsumedAt = &now
+			return true, nil
+		}
+		o.Attempts++
+		if o.Attempts >= maxAttempts {
+			o.ConsumedAt = &now
+		}
+		return false, nil
+	}
+	return false, nil
+}
+
+func (r *logins) Lockout(identifier string) (*database.LoginLockout, error) {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	return find(r.d.lockouts, func(l *database.LoginLockout) bool { return l.Identifier == identifier })
+}
+
+func (r *logins) RecordLoginFailure(attempt *database.LoginAttempt, window time.Duration, lockFor func(failed int) time.Duration) error {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	now := time.Now()
+	lockout, _ := find(r.d.lockouts, func(l *database.LoginLockout) bool { return l.Identifier == attempt.Identifier })
+	if lockout == nil || now.Sub(lockout.LastFailedAt) > window {
+		lockout = &database.LoginLockout{Identifier: attempt.Identifier}
+	}
+	lockout.FailedCount++
+	lockout.LastFailedAt = now
+	lockout.UpdatedAt = now
+	if d := lockFor(lockout.FailedCount); d > 0 {
+		until := now.Add(d)
+		lockout.LockedUntil = &until
+	}
+	r.d.lockouts = upsert(r.d.lockouts, *lockout, func(l *database.LoginLockout) bool { return l.Identifier == attempt.Identifier })
+	r.d.addLoginAttempt(attempt)
+	return nil
+}
+
+func (d *data) addLoginAttempt(attempt *database.LoginAttempt) {
+	stamp(&attempt.ID, &attempt.CreatedAt)
+	d.loginAttempts = append(d.loginAttempts, *attempt)
+}
+
+func (r *logins) RecordLoginAttempt(attempt *database.LoginAttempt) error {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	r.d.addLoginAttempt(attempt)
+	return nil
+}
+
+func (r *logins) ClearLockout(identifier string) error {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	n := len(r.d.lockouts)
+	r.d.lockouts = slices.DeleteFunc(r.d.lockouts, func(l database.LoginLockout) bool { return l.Identifier == identifier })
+	if len(r.d.lockouts) == n {
+		return repository.ErrNotFound
+	}
+	return nil
+}
+
+func (r *logins) Lockouts(lockedOnly bool, limit int) ([]database.LoginLockout, error) {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	now := time.Now()
+	lockouts := filter(r.d.lockouts, func(l *database.LoginLockout) bool {
+		return l.FailedCount > 0 && (!lockedOnly || l.LockedUntil != nil && l.LockedUntil.After(now))
+	}, false)
+	slices.SortStableFunc(lockouts, func(a, b database.LoginLockout) int {
+		switch {
+		case a.LockedUntil == nil && b.LockedUntil != nil:
+			return 1
+		case a.LockedUntil != nil && b.LockedUntil == nil:
+			return -1
+		case a.LockedUntil != nil && !a.LockedUntil.Equal(*b.LockedUntil):
+			return b.LockedUntil.Compare(*a.LockedUntil)
+		}
+		return b.LastFailedAt.Compare(a.LastFailedAt)
+	})
+	return lockouts[:min(limit, len(lockouts))], nil
+}
+
+func (r *logins) LoginAttempts(f repository.LoginAttemptFilter, limit int) ([]database.LoginAttempt, error) {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	attempts := filter(r.d.loginAttempts, func(a *database.LoginAttempt) bool {
+		return (f.Identifier == "" || a.Identifier == f.Identifier) && (f.IPAddress == "" || a.IPAddress == f.IPAddress)
+	}, true)
+	return attempts[:min(limit, len(attempts))], nil
+}
+
+func (r *logins) MFAPolicies() ([]database.MFAPolicy, error) {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	policies := slices.Clone(r.d.mfaPolicies)
+	slices.SortFunc(policies, func(a, b database.MFAPolicy) int { return strings.Compare(a.Role, b.Role) })
+	return policies, nil
+}
+
+func (r *logins) SaveMFAPolicy(policy *database.MFAPolicy) error {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+	r.d.mfaPolicies = upsert(r.d.mfaPolicies, *policy, func(p *database.MFAPolicy) bool { return p.Role == policy.Role })
+	return nil
+}
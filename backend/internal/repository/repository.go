@@ -0,0 +1,377 @@
+// Package repository is the data access layer of the handlers. Each
+// aggregate has an interface here, implemented on GORM for production and in
+// memory (package memory) for handler tests.
+package repository
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/igorfazlyev/dm/internal/database"
+	"github.com/igorfazlyev/dm/pkg/money"
+)
+
+var (
+	// ErrNotFound is returned when a lookup matches nothing
+	ErrNotFound = errors.New("not found")
+	// ErrConflict is returned when a row changed since it was read
+	ErrConflict = errors.New("modified concurrently")
+	// ErrLastManager is returned when removing a clinic's only manager
+	ErrLastManager = errors.New("clinic must keep at least one manager")
+)
+
+// Store groups the repositories of every aggregate
+type Store struct {
+	Users    Users
+	Patients Patients
+	Studies  Studies
+	Plans    Plans
+	Clinics  Clinics
+	Offers   Offers
+	Orders   Orders
+	Audit    Audit
+	Logins   Logins
+}
+
+// UserFilter narrows a user listing. Zero fields match everything.
+type UserFilter struct {
+	Search string // Partial match on email or phone
+	Role   string
+	Active *bool
+}
+
+// Users stores user accounts and the single-use tokens emailed to them
+type Users interface {
+	Get(id uuid.UUID) (*database.User, error)
+	// FindByEmail returns the account with an email address, including
+	// deactivated and deleted ones
+	FindByEmail(email string) (*database.User, error)
+	// FindByPhone returns the account with a phone number
+	FindByPhone(phone string) (*database.User, error)
+	// Profile returns an account with its patient profile and clinic
+	Profile(id uuid.UUID) (*database.User, error)
+	// Update sets columns of an account without loading it
+	Update(id uuid.UUID, fields map[string]any) error
+	// Register creates an account with its patient profile, if it has one
+	Register(user *database.User, patient *database.Patient) error
+	// IssueToken stores a token, voiding the user's unused ones for the same
+	// purpose
+	IssueToken(token *database.UserToken) error
+	// RecentTokens returns the tokens issued to a user for purpose since a
+	// time, newest first
+	RecentTokens(userID uuid.UUID, purpose string, since time.Time) ([]database.UserToken, error)
+	// UseToken marks an unused, unexpired token used and sets fields of its
+	// user with it. It fails with ErrNotFound if there is no such token.
+	UseToken(tokenHash, purpose string, fields map[string]any) (*database.UserToken, error)
+	// List returns a page of users, newest first, and the total matching
+	List(filter UserFilter, page, pageSize int) ([]database.User, int64, error)
+
+	// The actions below record entry with the change they make
+
+	// SetActive enables or disables an account
+	SetActive(user *database.User, active bool, entry *database.AuditLog) error
+	// SetRole changes an account's role
+	SetRole(user *database.User, role string, entry *database.AuditLog) error
+	// ClearMFA removes a user's authenticator and recovery codes, for an
+	// admin or the user themselves
+	ClearMFA(userID uuid.UUID, entry *database.AuditLog) error
+}
+
+// Patients stores patient profiles
+type Patients interface {
+	Get(id uuid.UUID) (*database.Patient, error)
+	FindByUserID(userID uuid.UUID) (*database.Patient, error)
+	Create(patient *database.Patient) error
+	Save(patient *database.Patient) error
+}
+
+// Studies stores imaging studies, the grants patients make on them and the
+// log of their use
+type Studies interface {
+	// Get returns a study with its patient
+	Get(id uuid.UUID) (*database.Study, error)
+	ListByPatient(patientID uuid.UUID) ([]database.Study, error)
+	Create(study *database.Study) error
+	Save(study *database.Study) error
+	// Update sets columns of a study without loading it
+	Update(id uuid.UUID, fields map[string]any) error
+	// Complete marks a study's analysis finished with its report and
+	// publishes StudyCompleted, once: it does nothing to a completed study
+	Complete(study *database.Study, reportURL string) error
+
+	// ActiveGrants returns the grants on a study that are neither revoked
+	// nor expired
+	ActiveGrants(studyID uuid.UUID) ([]database.StudyGrant, error)
+	// ActiveGrant returns the active grant of a study to a clinic
+	ActiveGrant(studyID, clinicID uuid.UUID) (*database.StudyGrant, error)
+	// Grants returns every grant on a study with its clinic, newest first
+	Grants(studyID uuid.UUID) ([]database.StudyGrant, error)
+	// ClinicGrants returns the active grants made to a clinic, newest first
+	ClinicGrants(clinicID uuid.UUID) ([]database.StudyGrant, error)
+	SaveGrant(grant *database.StudyGrant) error
+	// RevokeGrant revokes an active grant on a study
+	RevokeGrant(studyID, grantID uuid.UUID) error
+
+	LogAccess(entry *database.StudyAccessLog) error
+	// AccessLog returns the latest grant uses on a study with their clinic
+	AccessLog(studyID uuid.UUID, limit int) ([]database.StudyAccessLog, error)
+
+	// CreateUpload stores an upload. It fails with ErrConflict if another
+	// upload of the study is in progress.
+	CreateUpload(upload *database.StudyUpload) error
+	// Upload returns an upload of a study that has not expired
+	Upload(studyID, uploadID uuid.UUID) (*database.StudyUpload, error)
+	// ActiveUpload returns the upload of a study in progress, one neither
+	// complete nor expired
+	ActiveUpload(studyID uuid.UUID) (*database.StudyUpload, error)
+	// AdvanceUpload moves an upload's offset and expiry. It returns
+	// ErrConflict if the offset moved since the upload was read.
+	AdvanceUpload(upload *database.StudyUpload, offset int64, expiresAt time.Time) error
+	CompleteUpload(upload *database.StudyUpload) error
+	DeleteUpload(uploadID uuid.UUID) error
+	// ExpiredUploads returns the uploads that expired before t, complete or
+	// not
+	ExpiredUploads(t time.Time) ([]database.StudyUpload, error)
+}
+
+// PlanAudience is who besides its patient may see a plan
+type PlanAudience struct {
+	Listed    bool        // An offer request on the plan is open
+	ClinicIDs []uuid.UUID // Clinics that made offers on it
+}
+
+// Plans stores treatment plan versions and clinics' annotations on them
+type Plans interface {
+	// Get returns a plan version with its items and study
+	Get(id uuid.UUID) (*database.PlanVersion, error)
+	// ListByStudy returns the versions of a study's plan with their items,
+	// newest first
+	ListByStudy(studyID uuid.UUID) ([]database.PlanVersion, error)
+	// Create stores a plan version and its items, numbering it after the
+	// study's previous versions
+	Create(plan *database.PlanVersion) error
+	Audience(planID uuid.UUID) (PlanAudience, error)
+	GetItem(planID, itemID uuid.UUID) (*database.PlanItem, error)
+
+	CreateAnnotation(annotation *database.PlanAnnotation) error
+	// Annotations returns a plan's annotations with their clinic, oldest
+	// first. A clinicID other than uuid.Nil keeps only that clinic's.
+	Annotations(planID, clinicID uuid.UUID) ([]database.PlanAnnotation, error)
+}
+
+// ClinicFilter narrows a clinic listing. Zero fields match everything.
+type ClinicFilter struct {
+	City         string
+	District     string
+	PriceSegment string
+}
+
+// PriceRange is the lowest and highest price active clinics list for a
+// procedure
+type PriceRange struct {
+	Min money.Money
+	Max money.Money
+}
+
+// Clinics stores clinics, their staff, invitations, API keys and price lists
+type Clinics interface {
+	Get(id uuid.UUID) (*database.Clinic, error)
+	// ForMember returns the clinic a staff account belongs to
+	ForMember(userID uuid.UUID) (*database.Clinic, error)
+	// Membership returns a user's clinic membership with its clinic
+	Membership(userID uuid.UUID) (*database.ClinicMember, error)
+	// Create stores a clinic with managerID as its first member
+	Create(clinic *database.Clinic, managerID uuid.UUID) error
+	// Save writes a clinic and bumps its version. It fails with ErrConflict
+	// if the clinic changed since it was read.
+	Save(clinic *database.Clinic) error
+	ListActive(filter ClinicFilter) ([]database.Clinic, error)
+	// List returns clinics, newest first. A status other than "" keeps only
+	// clinics in it.
+	List(status string) ([]database.Clinic, error)
+	// Pending returns the clinics awaiting approval, oldest first
+	Pending() ([]database.Clinic, error)
+	// Review saves an admin's decision on a clinic as Save does, recording
+	// entry with it
+	Review(clinic *database.Clinic, entry *database.AuditLog) error
+	// Notes returns the admins' notes on a clinic, newest first
+	Notes(clinicID uuid.UUID) ([]database.ClinicNote, error)
+	AddNote(note *database.ClinicNote) error
+
+	// Members returns a clinic's staff with their accounts, oldest first
+	Members(clinicID uuid.UUID) ([]database.ClinicMember, error)
+	// RemoveMember takes a user off a clinic's staff. It fails with
+	// ErrLastManager rather than remove the clinic's only manager.
+	RemoveMember(clinicID, userID uuid.UUID) error
+
+	// Invite stores an invitation, revoking any pending one to the same
+	// address
+	Invite(invitation *database.ClinicInvitation) error
+	// Invitation returns the invitation with the token hash
+	Invitation(tokenHash string) (*database.ClinicInvitation, error)
+	// Invitations returns a clinic's pending invitations, newest first
+	Invitations(clinicID uuid.UUID) ([]database.ClinicInvitation, error)
+	// RevokeInvitation cancels a pending invitation of a clinic
+	RevokeInvitation(clinicID, invitationID uuid.UUID) error
+	// AcceptInvitation marks an invitation accepted, adds member to the
+	// clinic and confirms the member's email address, which the invitation
+	// was sent to. It fails with ErrConflict if the invitation was accepted
+	// or revoked since it was read.
+	AcceptInvitation(invitation *database.ClinicInvitation, member *database.ClinicMember) error
+
+	CreateAPIKey(key *database.APIKey) error
+	// APIKeys returns a clinic's API keys, newest first
+	APIKeys(clinicID uuid.UUID) ([]database.APIKey, error)
+	// RevokeAPIKey disables an active API key of a clinic
+	RevokeAPIKey(clinicID, keyID uuid.UUID) error
+
+	AddPriceItem(item *database.PriceListItem) error
+	// PriceList returns a clinic's active price items by specialty and name
+	PriceList(clinicID uuid.UUID) ([]database.PriceListItem, error)
+	// DeactivatePriceItem removes an item from a clinic's price list
+	DeactivatePriceItem(clinicID, itemID uuid.UUID) error
+	// PriceRanges returns the price range of each procedure code across the
+	// price lists of active clinics that quote in currency
+	PriceRanges(codes []string, currency string) (map[string]PriceRange, error)
+}
+
+// Offers stores offer requests and the offers clinics make on them
+type Offers interface {
+	// CreateRequest stores an offer request and the study grants it makes
+	CreateRequest(request *database.OfferRequest, grants []database.StudyGrant) error
+	GetRequest(id uuid.UUID) (*database.OfferRequest, error)
+	// RequestDetails returns an offer request with its plan items and offers.
+	// A clinicID other than uuid.Nil keeps only that clinic's offer.
+	RequestDetails(id, clinicID uuid.UUID) (*database.OfferRequest, error)
+	// RequestsByPatient returns a patient's offer requests with their plan
+	// items and offers, newest first
+	RequestsByPatient(patientID uuid.UUID) ([]database.OfferRequest, error)
+	// RequestClinicIDs returns the clinics that made offers on a request
+	RequestClinicIDs(requestID uuid.UUID) ([]uuid.UUID, error)
+
+	Create(offer *database.Offer) error
+	// Get returns an offer with its request
+	Get(id uuid.UUID) (*database.Offer, error)
+	// ListByClinic returns a clinic's offers with their request's plan
+	// items, newest first
+	ListByClinic(clinicID uuid.UUID) ([]database.Offer, error)
+	// Accept marks an offer accepted and the request's other offers
+	// rejected, closes the request, creates the order and revokes the study
+	// grants the request gave other clinics, publishing OfferAccepted. It
+	// fails with ErrConflict if the offer changed since it was read or the
+	// request is closed, so only one offer of a request is ever accepted.
+	Accept(offer *database.Offer, order *database.Order) error
+}
+
+// OrderFilter narrows an order listing. Zero fields match everything.
+type OrderFilter struct {
+	PatientID uuid.UUID
+	ClinicID  uuid.UUID
+}
+
+// Orders stores orders made from accepted offers
+type Orders interface {
+	Get(id uuid.UUID) (*database.Order, error)
+	// Details returns an order with its offer, plan items, patient, clinic
+	// and slots
+	Details(id uuid.UUID) (*database.Order, error)
+	// List returns orders with their offer, plan items, patient and clinic,
+	// newest first
+	List(filter OrderFilter) ([]database.Order, error)
+	// UpdateStatus sets an order's status, bumps its version and publishes
+	// OrderStatusChanged. It fails with ErrConflict if the order changed
+	// since it was read.
+	UpdateStatus(order *database.Order, status string) error
+}
+
+// Audit stores the log of admin actions
+type Audit interface {
+	Record(entry *database.AuditLog) error
+	// History returns the entries about an entity, newest first
+	History(entityType string, entityID uuid.UUID) ([]database.AuditLog, error)
+}
+
+// LoginAttemptFilter narrows a listing of login attempts. Zero fields match
+// everything.
+type LoginAttemptFilter struct {
+	Identifier string
+	IPAddress  string
+}
+
+// Logins stores what signing in keeps besides accounts: sessions and their
+// refresh tokens, second factors, passkeys, phone codes, lockouts and the
+// roles that must use MFA
+type Logins interface {
+	// StartSession stores a new session with its first refresh token
+	StartSession(session *database.Session, token *database.RefreshToken) error
+	// RefreshToken returns a refresh token with its session
+	RefreshToken(id uuid.UUID) (*database.RefreshToken, error)
+	// RotateRefreshToken replaces token with next from ipAddress, extending
+	// their session to next's expiry. It fails with ErrConflict if token was
+	// rotated since it was read.
+	RotateRefreshToken(token, next *database.RefreshToken, ipAddress string) error
+
+	// UseTOTPStep records the time step of a TOTP code a user gave so the
+	// code cannot be used again. It fails with ErrConflict unless step is
+	// later than the last one recorded.
+	UseTOTPStep(userID uuid.UUID, step int64) error
+	// EnableMFA turns on a user's pending MFA with a set of recovery codes
+	// and marks the session that proved it as multi-factor. It fails with
+	// ErrConflict if MFA is already on.
+	EnableMFA(userID, sessionID uuid.UUID, codeHashes []string) error
+	// ReplaceRecoveryCodes swaps a user's recovery codes for a new set
+	ReplaceRecoveryCodes(userID uuid.UUID, codeHashes []string) error
+	// UseRecoveryCode consumes an unused recovery code of a user. It fails
+	// with ErrNotFound if none matches.
+	UseRecoveryCode(userID uuid.UUID, codeHash string) error
+
+	// Passkeys returns a user's passkeys, oldest first
+	Passkeys(userID uuid.UUID) ([]database.WebAuthnCredential, error)
+	// AddPasskey stores a passkey. It fails with ErrConflict if the
+	// credential is registered already.
+	AddPasskey(credential *database.WebAuthnCredential) error
+	// UsePasskey records a login with a passkey, identified by its
+	// credential ID: its sign counter, clone warning and backup state
+	UsePasskey(credential *database.WebAuthnCredential) error
+	// DeletePasskey removes a passkey of a user
+	DeletePasskey(userID, id uuid.UUID) error
+	// SaveChallenge stores the state of a passkey ceremony, sweeping away
+	// expired ones
+	SaveChallenge(challenge *database.WebAuthnChallenge) error
+	// TakeChallenge deletes and returns an unexpired challenge of a
+	// ceremony, so it is answered once. A non-nil userID must match the
+	// user who started it.
+	TakeChallenge(id uuid.UUID, ceremony string, userID *uuid.UUID) (*database.WebAuthnChallenge, error)
+
+	// RecentOTPs returns the codes sent to a phone since a time, newest first
+	RecentOTPs(phone string, since time.Time) ([]database.PhoneOTP, error)
+	// CreateOTP stores a code, voiding the phone's outstanding ones
+	CreateOTP(otp *database.PhoneOTP) error
+	// UseOTP consumes the latest outstanding code of a phone if codeHash
+	// matches it. A mismatch counts as an attempt and voids the code at
+	// maxAttempts.
+	UseOTP(phone, codeHash string, maxAttempts int) (bool, error)
+
+	// Lockout returns the failure count of an identifier
+	Lockout(identifier string) (*database.LoginLockout, error)
+	// RecordLoginFailure counts a failed attempt against its identifier,
+	// starting over after window without failures, locks the identifier for
+	// lockFor of the new count and logs the attempt
+	RecordLoginFailure(attempt *database.LoginAttempt, window time.Duration, lockFor func(failed int) time.Duration) error
+	// RecordLoginAttempt logs an attempt without counting it as a failure
+	RecordLoginAttempt(attempt *database.LoginAttempt) error
+	// ClearLockout removes the failure count of an identifier
+	ClearLockout(identifier string) error
+	// Lockouts returns the identifiers with failures, the longest locked
+	// first, then by latest failure. lockedOnly keeps those locked now.
+	Lockouts(lockedOnly bool, limit int) ([]database.LoginLockout, error)
+	// LoginAttempts returns the latest failed and blocked logins, newest
+	// first
+	LoginAttempts(filter LoginAttemptFilter, limit int) ([]database.LoginAttempt, error)
+
+	// MFAPolicies returns the MFA requirement of each role, by role
+	MFAPolicies() ([]database.MFAPolicy, error)
+	// SaveMFAPolicy sets whether a role must use MFA
+	SaveMFAPolicy(policy *database.MFAPolicy) error
+}
@@ -0,0 +1,91 @@
+package seed
+
+// procedure is a treatment clinics price and plans include. BasePrice is in
+// rubles for a business-segment clinic.
+type procedure struct {
+	Code      string
+	Specialty string
+	Name      string
+	Diagnosis string
+	BasePrice float64
+	PerTooth  bool
+}
+
+var procedures = []procedure{
+	{"THR-01", "therapy", "Caries treatment, light-cured filling", "Dental caries", 6500, true},
+	{"THR-02", "therapy", "Root canal treatment, single canal", "Pulpitis", 9800, true},
+	{"THR-03", "therapy", "Root canal treatment, three canals", "Chronic apical periodontitis", 21000, true},
+	{"THR-04", "therapy", "Root canal retreatment", "Incomplete root filling", 18500, true},
+	{"ORT-01", "orthopedics", "Metal-ceramic crown", "Extensive crown destruction", 24000, true},
+	{"ORT-02", "orthopedics", "Zirconia crown", "Extensive crown destruction", 38000, true},
+	{"ORT-03", "orthopedics", "Ceramic inlay", "Large cavity after filling loss", 27000, true},
+	{"ORT-04", "orthopedics", "Crown on implant", "Missing tooth after implantation", 45000, true},
+	{"SRG-01", "surgery", "Simple tooth extraction", "Tooth not worth saving", 4500, true},
+	{"SRG-02", "surgery", "Wisdom tooth extraction", "Impacted third molar", 12000, true},
+	{"SRG-03", "surgery", "Dental implant placement", "Missing tooth", 52000, true},
+	{"SRG-04", "surgery", "Sinus lift", "Insufficient bone height", 48000, false},
+	{"HYG-01", "hygiene", "Professional cleaning", "Dental plaque and calculus", 6000, false},
+	{"HYG-02", "hygiene", "Fluoride varnish", "Enamel demineralization", 2500, false},
+	{"PER-01", "periodontics", "Closed curettage, one sextant", "Chronic periodontitis", 5500, false},
+	{"PER-02", "periodontics", "Vector periodontal therapy", "Generalized periodontitis", 14000, false},
+}
+
+// segmentPrice scales business prices to a clinic's price segment
+var segmentPrice = map[string]float64{
+	"economy":  0.7,
+	"business": 1.0,
+	"premium":  1.6,
+}
+
+var priceSegments = []string{"economy", "business", "premium"}
+
+type city struct {
+	Name      string
+	Districts []string
+	Phone     string // Area code
+}
+
+var cities = []city{
+	{"Moscow", []string{"Arbat", "Tverskoy", "Khamovniki", "Presnensky", "Basmanny", "Zamoskvorechye"}, "495"},
+	{"Saint Petersburg", []string{"Tsentralny", "Petrogradsky", "Vasileostrovsky", "Admiralteysky"}, "812"},
+	{"Kazan", []string{"Vakhitovsky", "Sovetsky", "Novo-Savinovsky"}, "843"},
+	{"Novosibirsk", []string{"Tsentralny", "Zheleznodorozhny", "Oktyabrsky"}, "383"},
+}
+
+var clinicNames = []string{
+	"Smile", "Dental Art", "Denta Plus", "White Tooth", "Medident", "Stomatology No. 1",
+	"Family Dentist", "Dental Clinic Pro", "Implant Center", "Dental Studio", "Zub Dental",
+	"Orthodent", "Vita Dent", "Aesthetic Dentistry", "Denta Lux", "Doctor Smile",
+}
+
+var streets = []string{
+	"Lenina", "Pushkina", "Gagarina", "Mira", "Sadovaya", "Tsvetochnaya", "Naberezhnaya",
+	"Sovetskaya", "Lesnaya", "Shkolnaya", "Tverskaya", "Nevsky prospekt",
+}
+
+var maleNames = []string{"Alexander", "Dmitry", "Ivan", "Sergey", "Andrey", "Mikhail", "Pavel", "Nikolai"}
+
+var femaleNames = []string{"Anna", "Elena", "Maria", "Olga", "Tatiana", "Natalia", "Irina", "Ekaterina"}
+
+// lastNames are in the masculine form; the feminine adds an "a"
+var lastNames = []string{
+	"Ivanov", "Smirnov", "Kuznetsov", "Popov", "Vasiliev", "Petrov", "Sokolov", "Mikhailov",
+	"Novikov", "Fedorov", "Morozov", "Volkov", "Alekseev", "Lebedev", "Semenov", "Egorov",
+}
+
+// FDI numbers of the permanent teeth
+var teeth = []int{
+	11, 12, 13, 14, 15, 16, 17, 18,
+	21, 22, 23, 24, 25, 26, 27, 28,
+	31, 32, 33, 34, 35, 36, 37, 38,
+	41, 42, 43, 44, 45, 46, 47, 48,
+}
+
+// scanners are the CBCT devices demo studies were taken on
+var scanners = []string{"Planmeca", "Carestream Health", "Vatech", "Sirona", "NewTom"}
+
+var installmentTerms = []string{"6 months, no interest", "12 months, no interest", "24 months via partner bank"}
+
+var specialOffers = []string{"Free consultation", "Free professional cleaning", "10% off hygiene for a year", "Free follow-up CBCT"}
+
+var cancellationReasons = []string{"Patient chose another clinic", "Treatment postponed", "Patient did not come to the consultation"}
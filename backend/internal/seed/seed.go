@@ -0,0 +1,608 @@
+// Package seed fills the database with demo data: clinics with price lists
+// and staff, and patients whose studies, plans, offer requests, offers and
+// orders are at every stage of the marketplace. The same seed and profile
+// always produce the same rows and IDs; dates are relative to the day of
+// seeding.
+package seed
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/igorfazlyev/dm/internal/auth"
+	"github.com/igorfazlyev/dm/internal/database"
+	"github.com/igorfazlyev/dm/internal/rbac"
+	"github.com/igorfazlyev/dm/pkg/dicom"
+	"github.com/igorfazlyev/dm/pkg/money"
+	"gorm.io/gorm"
+)
+
+// Domain is the email domain of every seeded account
+const Domain = "demo.local"
+
+// ErrAlreadySeeded means demo accounts exist and the database was not reset
+var ErrAlreadySeeded = errors.New("demo data already exists, reset the database first")
+
+// Profile sizes the data set
+type Profile struct {
+	Clinics          int
+	DoctorsPerClinic int
+	Patients         int
+}
+
+// Profiles are the data set sizes by name
+var Profiles = map[string]Profile{
+	"small": {Clinics: 4, DoctorsPerClinic: 1, Patients: 12},
+	"large": {Clinics: 40, DoctorsPerClinic: 3, Patients: 400},
+}
+
+type Options struct {
+	Profile  Profile
+	Seed     uint64
+	Password string // Of every seeded account
+	Reset    bool   // Delete all data first
+}
+
+// Summary counts what was created
+type Summary struct {
+	Clinics       int
+	Staff         int
+	Patients      int
+	Studies       int
+	Plans         int
+	OfferRequests int
+	Offers        int
+	Orders        int
+}
+
+// Run seeds db in one transaction, so a failure leaves nothing behind
+func Run(db *gorm.DB, opts Options) (Summary, error) {
+	hash, err := auth.HashPassword(opts.Password)
+	if err != nil {
+		return Summary{}, err
+	}
+
+	var summary Summary
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if opts.Reset {
+			if err := Reset(tx); err != nil {
+				return fmt.Errorf("reset: %w", err)
+			}
+		} else {
+			var existing int64
+			if err := tx.Model(&database.User{}).Where("email LIKE ?", "%@"+Domain).Count(&existing).Error; err != nil {
+				return err
+			}
+			if existing > 0 {
+				return ErrAlreadySeeded
+			}
+		}
+
+		g := &generator{
+			tx:      tx,
+			rng:     rand.New(rand.NewPCG(opts.Seed, opts.Seed^0x9e3779b97f4a7c15)),
+			profile: opts.Profile,
+			hash:    hash,
+			today:   time.Now().UTC().Truncate(24 * time.Hour),
+			prices:  map[uuid.UUID]map[string]money.Money{},
+		}
+		if err := g.run(); err != nil {
+			return err
+		}
+		summary = g.summary
+		return nil
+	})
+	return summary, err
+}
+
+// Reset deletes every row of every table except the migration history
+func Reset(db *gorm.DB) error {
+	var tables []string
+	if err := db.Raw(`SELECT tablename FROM pg_tables
+		WHERE schemaname = current_schema() AND tablename <> 'schema_migrations'`).
+		Scan(&tables).Error; err != nil {
+		return err
+	}
+	if len(tables) == 0 {
+		return nil
+	}
+
+	quoted := make([]string, len(tables))
+	for i, table := range tables {
+		quoted[i] = `"` + table + `"`
+	}
+	return db.Exec("TRUNCATE " + strings.Join(quoted, ", ") + " RESTART IDENTITY CASCADE").Error
+}
+
+// Patients move through these stages in turn, so every profile covers all
+// of them
+const (
+	stageUploaded  = iota // Study still being analyzed
+	stageAnalyzed         // Plan ready, no offers requested
+	stageRequested        // Offer request open, no offers yet
+	stageOffered          // Offers waiting for the patient
+	stageAccepted         // Offer accepted and an order placed
+	stageCount
+)
+
+var orderStatuses = []string{"new", "consultation_scheduled", "in_progress", "completed", "cancelled"}
+
+type generator struct {
+	tx      *gorm.DB
+	rng     *rand.Rand
+	profile Profile
+	hash    string
+	today   time.Time
+	adminID uuid.UUID
+	active  []database.Clinic                    // Clinics patients can get offers from
+	prices  map[uuid.UUID]map[string]money.Money // Clinic price list by procedure code
+	orders  int
+	summary Summary
+}
+
+// id returns the next random version 4 UUID, so IDs follow from the seed
+func (g *generator) id() uuid.UUID {
+	var id uuid.UUID
+	binary.BigEndian.PutUint64(id[:8], g.rng.Uint64())
+	binary.BigEndian.PutUint64(id[8:], g.rng.Uint64())
+	id[6] = id[6]&0x0f | 0x40
+	id[8] = id[8]&0x3f | 0x80
+	return id
+}
+
+// between returns a random number in [lo, hi]
+func (g *generator) between(lo, hi int) int {
+	return lo + g.rng.IntN(hi-lo+1)
+}
+
+func pick[T any](g *generator, list []T) T {
+	return list[g.rng.IntN(len(list))]
+}
+
+// daysAgo returns a time during working hours days before today
+func (g *generator) daysAgo(days int) time.Time {
+	return g.today.AddDate(0, 0, -days).Add(time.Duration(g.between(9*60, 19*60)) * time.Minute)
+}
+
+// rubles rounds a price to the nearest hundred rubles. Seed prices are far
+// from the largest amount, so the conversion cannot fail.
+func rubles(price float64) money.Money {
+	amount, _ := money.Int(int64(math.Round(price/100) * 100))
+	return money.Money{Amount: amount, Currency: database.DefaultCurrency}
+}
+
+func (g *generator) run() error {
+	admin, err := g.user("admin@"+Domain, rbac.RoleAdmin, 365)
+	if err != nil {
+		return err
+	}
+	g.adminID = admin.ID
+
+	for i := range g.profile.Clinics {
+		if err := g.clinic(i); err != nil {
+			return err
+		}
+	}
+	if len(g.active) == 0 {
+		return errors.New("profile needs at least two clinics")
+	}
+
+	for i := range g.profile.Patients {
+		if err := g.patient(i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *generator) user(email, role string, daysAgo int) (*database.User, error) {
+	created := g.daysAgo(daysAgo)
+	user := database.User{
+		ID:           g.id(),
+		Email:        email,
+		PasswordHash: g.hash,
+		Role:         role,
+		IsActive:     true,
+		VerifiedAt:   &created,
+		CreatedAt:    created,
+	}
+	return &user, g.tx.Create(&user).Error
+}
+
+// clinic creates a clinic with its manager, doctors and price list. The last
+// clinic waits for approval, and every thirteenth is suspended.
+func (g *generator) clinic(i int) error {
+	c := cities[i%len(cities)]
+	segment := priceSegments[i/len(cities)%len(priceSegments)]
+	district := pick(g, c.Districts)
+	name := clinicNames[i%len(clinicNames)]
+	if i >= len(clinicNames) {
+		name += " " + district
+	}
+	slug := fmt.Sprintf("clinic%02d", i+1)
+	age := g.between(60, 720)
+
+	manager, err := g.user(slug+".manager@"+Domain, rbac.RoleClinicManager, age)
+	if err != nil {
+		return err
+	}
+
+	clinic := database.Clinic{
+		ID:              g.id(),
+		UserID:          manager.ID,
+		Name:            name,
+		LegalName:       fmt.Sprintf("LLC \"%s\"", name),
+		LicenseNumber:   fmt.Sprintf("LO-%02d-01-%06d", i+1, g.rng.IntN(1000000)),
+		YearEstablished: g.between(1995, 2022),
+		City:            c.Name,
+		District:        district,
+		Address:         fmt.Sprintf("%s St., %d", pick(g, streets), g.between(1, 120)),
+		Phone:           fmt.Sprintf("+7 (%s) %03d-%02d-%02d", c.Phone, g.between(200, 999), g.between(0, 99), g.between(0, 99)),
+		Email:           "info@" + slug + "." + Domain,
+		Website:         "https://" + slug + "." + Domain,
+		PriceSegment:    segment,
+		Currency:        database.DefaultCurrency,
+		CreatedAt:       g.daysAgo(age),
+	}
+
+	switch {
+	case i == g.profile.Clinics-1:
+		clinic.Status = "pending"
+	case i%13 == 12:
+		clinic.Status = "suspended"
+		clinic.StatusReason = "License renewal documents requested"
+	default:
+		clinic.Status = "approved"
+		clinic.IsActive = true
+	}
+	if clinic.Status != "pending" {
+		reviewed := clinic.CreatedAt.AddDate(0, 0, 2)
+		clinic.ReviewedAt = &reviewed
+		clinic.ReviewedByID = &g.adminID
+	}
+
+	if err := g.tx.Create(&clinic).Error; err != nil {
+		return err
+	}
+	g.summary.Clinics++
+
+	staff := []database.ClinicMember{{ID: g.id(), ClinicID: clinic.ID, UserID: manager.ID, Role: rbac.RoleClinicManager, CreatedAt: clinic.CreatedAt}}
+	for d := range g.profile.DoctorsPerClinic {
+		doctor, err := g.user(fmt.Sprintf("%s.doctor%d@%s", slug, d+1, Domain), rbac.RoleClinicDoctor, age)
+		if err != nil {
+			return err
+		}
+		staff = append(staff, database.ClinicMember{ID: g.id(), ClinicID: clinic.ID, UserID: doctor.ID, Role: rbac.RoleClinicDoctor, CreatedAt: clinic.CreatedAt})
+	}
+	if err := g.tx.Create(&staff).Error; err != nil {
+		return err
+	}
+	g.summary.Staff += len(staff)
+
+	prices := map[string]money.Money{}
+	var items []database.PriceListItem
+	for _, p := range procedures {
+		// Not every clinic offers everything
+		if g.rng.IntN(10) == 0 {
+			continue
+		}
+		price := p.BasePrice * segmentPrice[segment] * (0.85 + g.rng.Float64()*0.3)
+		item := database.PriceListItem{
+			ID:            g.id(),
+			ClinicID:      clinic.ID,
+			Specialty:     p.Specialty,
+			ProcedureCode: p.Code,
+			ProcedureName: p.Name,
+			PriceFrom:     rubles(price),
+			PriceTo:       money.Zero(database.DefaultCurrency),
+			IsActive:      true,
+			CreatedAt:     clinic.CreatedAt,
+		}
+		if g.rng.IntN(3) == 0 {
+			item.PriceTo = rubles(price * 1.3)
+		}
+		prices[p.Code] = item.PriceFrom
+		items = append(items, item)
+	}
+	if err := g.tx.Create(&items).Error; err != nil {
+		return err
+	}
+
+	if clinic.IsActive {
+		g.active = append(g.active, clinic)
+		g.prices[clinic.ID] = prices
+	}
+	return nil
+}
+
+// patient creates a patient with a study taken to the patient's stage
+func (g *generator) patient(i int) error {
+	first, last := pick(g, maleNames), pick(g, lastNames)
+	if g.rng.IntN(2) == 0 {
+		first, last = pick(g, femaleNames), last+"a"
+	}
+	c := cities[i%len(cities)]
+	age := g.between(30, 180)
+
+	user, err := g.user(fmt.Sprintf("patient%03d@%s", i+1, Domain), rbac.RolePatient, age)
+	if err != nil {
+		return err
+	}
+
+	born := g.today.AddDate(-g.between(18, 75), -g.between(0, 11), -g.between(0, 27))
+	patient := database.Patient{
+		ID:                    g.id(),
+		UserID:                user.ID,
+		FirstName:             first,
+		LastName:              last,
+		DateOfBirth:           &born,
+		Phone:                 fmt.Sprintf("+7 9%02d %03d-%02d-%02d", g.between(0, 99), g.between(0, 999), g.between(0, 99), g.between(0, 99)),
+		PreferredCity:         c.Name,
+		PreferredPriceSegment: pick(g, priceSegments),
+		CreatedAt:             user.CreatedAt,
+	}
+	if g.rng.IntN(2) == 0 {
+		patient.PreferredDistrict = pick(g, c.Districts)
+	}
+	if err := g.tx.Create(&patient).Error; err != nil {
+		return err
+	}
+	g.summary.Patients++
+
+	stage := i % stageCount
+	taken := age - g.between(1, 10)
+	takenAt := g.daysAgo(taken)
+	studyDate := takenAt.Format("2006-01-02")
+	studyID := g.id()
+	studyUID := dicom.UIDFromUUID(studyID)
+	study := database.Study{
+		ID:         studyID,
+		PatientID:  patient.ID,
+		Status:     "processing",
+		Modality:   "CT",
+		StudyDate:  &studyDate,
+		UploadedAt: &takenAt,
+		CreatedAt:  takenAt,
+
+		StudyInstanceUID: &studyUID,
+		SeriesCount:      1,
+		Manufacturer:     scanners[i%len(scanners)],
+		ImageKind:        string(dicom.KindVolume),
+	}
+	if stage != stageUploaded {
+		completed := takenAt.Add(time.Duration(g.between(20, 90)) * time.Minute)
+		study.Status = "completed"
+		study.CompletedAt = &completed
+	}
+	if err := g.tx.Create(&study).Error; err != nil {
+		return err
+	}
+	g.summary.Studies++
+
+	if stage == stageUploaded {
+		return nil
+	}
+
+	plan, err := g.plan(study, takenAt.AddDate(0, 0, 1))
+	if err != nil {
+		return err
+	}
+	if stage == stageAnalyzed {
+		return nil
+	}
+
+	return g.offerRequest(&patient, plan, study.ID, stage, taken-2)
+}
+
+// plan creates the first version of a study's treatment plan
+func (g *generator) plan(study database.Study, created time.Time) (*database.PlanVersion, error) {
+	plan := database.PlanVersion{
+		ID:        g.id(),
+		StudyID:   study.ID,
+		Version:   1,
+		Source:    "diagnocat",
+		CreatedAt: created,
+	}
+
+	chosen := map[string]bool{}
+	for range g.between(2, 5) {
+		p := pick(g, procedures)
+		if chosen[p.Code] {
+			continue
+		}
+		chosen[p.Code] = true
+
+		item := database.PlanItem{
+			ID:            g.id(),
+			Specialty:     p.Specialty,
+			ProcedureCode: p.Code,
+			ProcedureName: p.Name,
+			Diagnosis:     p.Diagnosis,
+			Quantity:      1,
+			CreatedAt:     created,
+		}
+		if p.PerTooth {
+			tooth := pick(g, teeth)
+			item.ToothNumber = &tooth
+		}
+		plan.PlanItems = append(plan.PlanItems, item)
+	}
+
+	if err := g.tx.Create(&plan).Error; err != nil {
+		return nil, err
+	}
+	g.summary.Plans++
+	return &plan, nil
+}
+
+// offerRequest asks the clinics in the patient's city for offers, sharing the
+// study with them, and takes the offers to the patient's stage
+func (g *generator) offerRequest(patient *database.Patient, plan *database.PlanVersion, studyID uuid.UUID, stage, daysAgo int) error {
+	var clinics []database.Clinic
+	for _, clinic := range g.active {
+		if clinic.City == patient.PreferredCity {
+			clinics = append(clinics, clinic)
+		}
+	}
+	if len(clinics) == 0 {
+		clinics = g.active
+	}
+
+	created := g.daysAgo(daysAgo)
+	request := database.OfferRequest{
+		ID:            g.id(),
+		PatientID:     patient.ID,
+		PlanVersionID: plan.ID,
+		PreferredCity: patient.PreferredCity,
+		Status:        "open",
+		CreatedAt:     created,
+	}
+	for _, item := range plan.PlanItems {
+		request.SelectedItemIDs = append(request.SelectedItemIDs, item.ID.String())
+	}
+	if stage == stageAccepted {
+		request.Status = "closed"
+	}
+	if err := g.tx.Create(&request).Error; err != nil {
+		return err
+	}
+	g.summary.OfferRequests++
+
+	grants := make([]database.StudyGrant, len(clinics))
+	for i, clinic := range clinics {
+		grants[i] = database.StudyGrant{
+			ID:             g.id(),
+			StudyID:        studyID,
+			PatientID:      patient.ID,
+			ClinicID:       clinic.ID,
+			OfferRequestID: &request.ID,
+			Scopes:         []string{"read", "read_report", "read_results"},
+			ExpiresAt:      created.AddDate(0, 0, 30),
+			CreatedAt:      created,
+		}
+	}
+	if stage == stageRequested {
+		return g.tx.Create(&grants).Error
+	}
+
+	g.rng.Shuffle(len(clinics), func(i, j int) { clinics[i], clinics[j] = clinics[j], clinics[i] })
+	clinics = clinics[:g.between(1, min(3, len(clinics)))]
+
+	offers := make([]database.Offer, len(clinics))
+	for i, clinic := range clinics {
+		offers[i] = g.offer(&request, plan, clinic, created.AddDate(0, 0, i+1))
+	}
+
+	if stage == stageOffered {
+		if err := g.tx.Create(&offers).Error; err != nil {
+			return err
+		}
+		g.summary.Offers += len(offers)
+		return g.tx.Create(&grants).Error
+	}
+
+	// The patient accepts the first offer and the others lose their access
+	accepted := created.AddDate(0, 0, len(offers)+1)
+	for i := range offers {
+		offers[i].Status = "rejected"
+	}
+	offers[0].Status = "accepted"
+	if err := g.tx.Create(&offers).Error; err != nil {
+		return err
+	}
+	g.summary.Offers += len(offers)
+
+	for i := range grants {
+		if grants[i].ClinicID != offers[0].ClinicID {
+			grants[i].RevokedAt = &accepted
+		}
+	}
+	if err := g.tx.Create(&grants).Error; err != nil {
+		return err
+	}
+
+	return g.order(patient, &offers[0], accepted)
+}
+
+// offer prices the plan from the clinic's price list
+func (g *generator) offer(request *database.OfferRequest, plan *database.PlanVersion, clinic database.Clinic, created time.Time) database.Offer {
+	total := money.Zero(database.DefaultCurrency)
+	for _, item := range plan.PlanItems {
+		price, ok := g.prices[clinic.ID][item.ProcedureCode]
+		if !ok {
+			for _, p := range procedures {
+				if p.Code == item.ProcedureCode {
+					price = rubles(p.BasePrice * segmentPrice[clinic.PriceSegment])
+				}
+			}
+		}
+		// Seed plans cost far less than the largest amount
+		line, _ := price.MulInt(item.Quantity)
+		total, _ = total.Add(line)
+	}
+	discount, _ := money.Int(pick(g, []int64{0, 0, 5, 10}))
+
+	offer := database.Offer{
+		ID:              g.id(),
+		OfferRequestID:  request.ID,
+		ClinicID:        clinic.ID,
+		TotalPrice:      total,
+		DiscountPercent: discount,
+		EstimatedDays:   g.between(7, 90),
+		Status:          "pending",
+		CreatedAt:       created,
+	}
+	if g.rng.IntN(3) == 0 {
+		offer.HasInstallment = true
+		offer.InstallmentTerms = pick(g, installmentTerms)
+	}
+	if g.rng.IntN(4) == 0 {
+		offer.SpecialOffer = pick(g, specialOffers)
+	}
+	return offer
+}
+
+// order places the order for an accepted offer. Orders cycle through the
+// statuses so each appears.
+func (g *generator) order(patient *database.Patient, offer *database.Offer, created time.Time) error {
+	order := database.Order{
+		ID:        g.id(),
+		OfferID:   offer.ID,
+		PatientID: patient.ID,
+		ClinicID:  offer.ClinicID,
+		Status:    orderStatuses[g.orders%len(orderStatuses)],
+		CreatedAt: created,
+	}
+	g.orders++
+
+	consultation := created.AddDate(0, 0, g.between(2, 7))
+	switch order.Status {
+	case "consultation_scheduled":
+		upcoming := g.today.AddDate(0, 0, g.between(1, 14)).Add(time.Duration(g.between(9, 18)) * time.Hour)
+		order.ConsultationDate = &upcoming
+	case "in_progress":
+		started := consultation.AddDate(0, 0, 1)
+		order.ConsultationDate = &consultation
+		order.TreatmentStarted = &started
+	case "completed":
+		started := consultation.AddDate(0, 0, 1)
+		finished := started.AddDate(0, 0, offer.EstimatedDays)
+		order.ConsultationDate = &consultation
+		order.TreatmentStarted = &started
+		order.TreatmentCompleted = &finished
+	case "cancelled":
+		order.CancellationReason = pick(g, cancellationReasons)
+	}
+
+	if err := g.tx.Create(&order).Error; err != nil {
+		return err
+	}
+	g.summary.Orders++
+	return nil
+}
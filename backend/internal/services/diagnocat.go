@@ -11,7 +11,7 @@ import (
 	"sync"
 	"time"
 
-	"github.com/joho/godotenv" // ← ADD THIS
+	"github.com/igorfazlyev/dm/internal/config"
 )
 
 type DiagnocatService struct {
@@ -265,16 +265,14 @@ func (s *DiagnocatService) DownloadReportPDF(reportID, outPath string) error {
 	return nil
 }
 
-func NewDiagnocatService() *DiagnocatService {
-	_ = godotenv.Load()
+func NewDiagnocatService(cfg config.DiagnocatConfig) *DiagnocatService {
 	service := &DiagnocatService{
-		baseURL:    getEnvOrDefault("DIAGNOCAT_API_URL", "https://app2.diagnocat.ru/partner-api"),
-		apiKey:     os.Getenv("DIAGNOCAT_API_KEY"),
-		email:      os.Getenv("DIAGNOCAT_EMAIL"),
-		password:   os.Getenv("DIAGNOCAT_PASSWORD"),
+		baseURL:    cfg.BaseURL,
+		apiKey:     cfg.APIKey,
+		email:      cfg.Email,
+		password:   cfg.Password,
 		httpClient: &http.Client{Timeout: 30 * time.Second},
 	}
-	fmt.Println("email", service.email)
 
 	// Test connection
 	service.testConnection()
@@ -680,13 +678,6 @@ func (s *DiagnocatService) GetAnalysisStatus(reportID string) (*ReportResponse,
 	return &report, nil
 }
 
-func getEnvOrDefault(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
-	}
-	return defaultValue
-}
-
 // GetHeaders returns authentication headers (needed for patient creation)
 func (s *DiagnocatService) GetHeaders() (map[string]string, error) {
 	return s.getHeaders()
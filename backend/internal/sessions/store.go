@@ -0,0 +1,164 @@
+package sessions
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/igorfazlyev/dm/internal/database"
+	"gorm.io/gorm"
+)
+
+var ErrSessionNotFound = errors.New("session not found")
+
+// Store tracks server-side sessions. Revocation checks go through a short-lived
+// in-memory cache so the auth middleware does not hit the database on every
+// request; revocations made through this Store take effect immediately, those
+// made by other instances within the cache TTL.
+type Store struct {
+	ttl time.Duration
+
+	mu        sync.RWMutex
+	entries   map[uuid.UUID]cacheEntry
+	lastSweep time.Time
+}
+
+type cacheEntry struct {
+	active    bool
+	userID    uuid.UUID // Owner of an active session
+	expiresAt time.Time
+}
+
+func NewStore(ttl time.Duration) *Store {
+	return &Store{
+		ttl:       ttl,
+		entries:   make(map[uuid.UUID]cacheEntry),
+		lastSweep: time.Now(),
+	}
+}
+
+// Started caches a session that has just been stored, so its first
+// requests do not look it up
+func (s *Store) Started(session *database.Session) {
+	s.remember(session.ID, session.UserID, true)
+}
+
+// IsActive reports whether the session exists, belongs to userID, is not
+// revoked or expired and its user is active. The user is checked so a
+// session ID cannot be reused in a token for someone else.
+func (s *Store) IsActive(sessionID, userID uuid.UUID) (bool, error) {
+	s.mu.RLock()
+	entry, ok := s.entries[sessionID]
+	s.mu.RUnlock()
+	if ok && time.Now().Before(entry.expiresAt) {
+		return entry.active && entry.userID == userID, nil
+	}
+
+	var owners []uuid.UUID
+	err := database.DB.Model(&database.Session{}).
+		Joins("JOIN users ON users.id = sessions.user_id").
+		Where("sessions.id = ? AND sessions.revoked_at IS NULL AND sessions.expires_at > ?", sessionID, time.Now()).
+		Where("users.is_active = ? AND users.deleted_at IS NULL", true).
+		Limit(1).
+		Pluck("sessions.user_id", &owners).Error
+	if err != nil {
+		return false, err
+	}
+
+	if len(owners) == 0 {
+		s.remember(sessionID, uuid.Nil, false)
+		return false, nil
+	}
+	s.remember(sessionID, owners[0], true)
+	return owners[0] == userID, nil
+}
+
+// ListActive returns the user's sessions that are neither revoked nor expired
+func (s *Store) ListActive(userID uuid.UUID) ([]database.Session, error) {
+	var sessions []database.Session
+	err := database.DB.
+		Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Order("last_used_at DESC").
+		Find(&sessions).Error
+	return sessions, err
+}
+
+// Revoke revokes a single session of the user together with its refresh tokens
+func (s *Store) Revoke(userID, sessionID uuid.UUID) error {
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&database.Session{}).
+			Where("id = ? AND user_id = ? AND revoked_at IS NULL", sessionID, userID).
+			Update("revoked_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrSessionNotFound
+		}
+		return revokeRefreshTokens(tx, "session_id = ?", sessionID)
+	})
+	if err != nil {
+		return err
+	}
+
+	s.remember(sessionID, userID, false)
+	return nil
+}
+
+// RevokeAllForUser revokes every session of the user except keep, which may
+// be uuid.Nil to revoke them all
+func (s *Store) RevokeAllForUser(userID, keep uuid.UUID) error {
+	var sessionIDs []uuid.UUID
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&database.Session{}).
+			Where("user_id = ? AND id <> ? AND revoked_at IS NULL", userID, keep).
+			Pluck("id", &sessionIDs).Error; err != nil {
+			return err
+		}
+		if len(sessionIDs) == 0 {
+			return nil
+		}
+
+		if err := tx.Model(&database.Session{}).
+			Where("id IN ?", sessionIDs).
+			Update("revoked_at", time.Now()).Error; err != nil {
+			return err
+		}
+		return revokeRefreshTokens(tx, "session_id IN ?", sessionIDs)
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, id := range sessionIDs {
+		s.remember(id, userID, false)
+	}
+	return nil
+}
+
+func revokeRefreshTokens(tx *gorm.DB, query string, args ...interface{}) error {
+	return tx.Model(&database.RefreshToken{}).
+		Where(query, args...).
+		Where("revoked_at IS NULL").
+		Update("revoked_at", time.Now()).Error
+}
+
+func (s *Store) remember(sessionID, userID uuid.UUID, active bool) {
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Drop stale entries now and then so the cache does not grow unbounded
+	if now.Sub(s.lastSweep) > s.ttl {
+		for id, entry := range s.entries {
+			if now.After(entry.expiresAt) {
+				delete(s.entries, id)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	s.entries[sessionID] = cacheEntry{active: active, userID: userID, expiresAt: now.Add(s.ttl)}
+}
@@ -0,0 +1,236 @@
+package signingkeys
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/igorfazlyev/dm/internal/config"
+	"github.com/igorfazlyev/dm/internal/database"
+	jwtpkg "github.com/igorfazlyev/dm/pkg/jwt"
+	"gorm.io/gorm"
+)
+
+// Serializes key generation between instances sharing the database
+const rotationLockID = 0x6a77746b // "jwtk"
+
+// Keys are published this long before they start signing so services that
+// cache the JWKS pick them up in time
+const maxPrepublish = 24 * time.Hour
+
+// legacyKeyID names the row recording when asymmetric keys took over from
+// the shared secret. It holds no key; its expiry is when the last token the
+// secret signed expires, after which tokens without a kid are refused.
+const legacyKeyID = "legacy-hs256"
+
+// Manager keeps a keyring in sync with the signing_keys table and rotates
+// keys on schedule
+type Manager struct {
+	cfg     config.JWTConfig
+	keyring *jwtpkg.Keyring
+	legacy  *jwtpkg.Key // Shared secret of tokens from before the switch, if any
+}
+
+// NewManager returns a manager for cfg. With HS256 the keyring signs with the
+// shared secret and nothing is stored; otherwise the secret is kept only to
+// verify tokens issued before asymmetric keys were enabled, for as long as
+// they can live. An example secret is never trusted for that.
+func NewManager(cfg config.JWTConfig) (*Manager, error) {
+	m := &Manager{cfg: cfg, keyring: jwtpkg.NewKeyring()}
+
+	switch cfg.Algorithm {
+	case jwtpkg.AlgorithmHS256:
+		if cfg.Secret == "" {
+			return nil, errors.New("JWT_SECRET is required for HS256")
+		}
+		m.keyring.SetKeys(jwtpkg.NewHMACKey("", cfg.Secret), nil)
+	case jwtpkg.AlgorithmRS256, jwtpkg.AlgorithmEdDSA:
+		if cfg.KeyRotationInterval <= 0 {
+			return nil, errors.New("JWT_KEY_ROTATION_INTERVAL must be positive")
+		}
+		if cfg.Secret != "" && !cfg.HasPlaceholderSecret() {
+			m.legacy = jwtpkg.NewHMACKey("", cfg.Secret)
+		}
+	default:
+		return nil, fmt.Errorf("unknown JWT algorithm %q", cfg.Algorithm)
+	}
+	return m, nil
+}
+
+// Keyring returns the keyring tokens are signed and verified with
+func (m *Manager) Keyring() *jwtpkg.Keyring {
+	return m.keyring
+}
+
+// Start syncs keys every interval in the background
+func (m *Manager) Start(interval time.Duration) {
+	if m.cfg.Algorithm == jwtpkg.AlgorithmHS256 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if err := m.Sync(); err != nil {
+				log.Printf("Failed to sync signing keys: %v", err)
+			}
+		}
+	}()
+}
+
+// Sync creates the current and next keys if they are due, removes expired
+// ones and loads the rest into the keyring
+func (m *Manager) Sync() error {
+	if m.cfg.Algorithm == jwtpkg.AlgorithmHS256 {
+		return nil
+	}
+
+	var rows []database.SigningKey
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", rotationLockID).Error; err != nil {
+			return err
+		}
+
+		now := time.Now()
+		if err := tx.Where("expires_at < ?", now).Delete(&database.SigningKey{}).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Order("activates_at").Find(&rows).Error; err != nil {
+			return err
+		}
+
+		// The first asymmetric key ends the shared secret's signing; tokens
+		// it signed are accepted until the longest of them expires
+		if len(rows) == 0 && m.legacy != nil {
+			row := database.SigningKey{
+				ID:          legacyKeyID,
+				Algorithm:   jwtpkg.AlgorithmHS256,
+				PrivateKey:  []byte{},
+				ActivatesAt: now,
+				RetiresAt:   now,
+				ExpiresAt:   now.Add(m.cfg.RefreshTokenTTL),
+			}
+			if err := tx.Create(&row).Error; err != nil {
+				return err
+			}
+			rows = append(rows, row)
+		}
+
+		current, next := m.currentAndNext(rows, now)
+		switch {
+		case current == nil:
+			row, err := m.createKey(tx, now)
+			if err != nil {
+				return err
+			}
+			rows = append(rows, *row)
+		case next == nil && now.After(current.RetiresAt.Add(-m.prepublish())):
+			row, err := m.createKey(tx, current.RetiresAt)
+			if err != nil {
+				return err
+			}
+			rows = append(rows, *row)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return m.load(rows, time.Now())
+}
+
+// currentAndNext finds the key signing at now and the one taking over after
+// it, among keys of the configured algorithm
+func (m *Manager) currentAndNext(rows []database.SigningKey, now time.Time) (current, next *database.SigningKey) {
+	for i := range rows {
+		row := &rows[i]
+		if row.Algorithm != m.cfg.Algorithm {
+			continue
+		}
+		if !row.ActivatesAt.After(now) && now.Before(row.RetiresAt) {
+			current = row
+		}
+		if row.ActivatesAt.After(now) {
+			next = row
+		}
+	}
+	return current, next
+}
+
+func (m *Manager) createKey(tx *gorm.DB, activatesAt time.Time) (*database.SigningKey, error) {
+	id := uuid.New().String()
+	key, err := jwtpkg.GenerateKey(id, m.cfg.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	pem, err := key.MarshalPrivateKey()
+	if err != nil {
+		return nil, err
+	}
+
+	retiresAt := activatesAt.Add(m.cfg.KeyRotationInterval)
+	row := database.SigningKey{
+		ID:          id,
+		Algorithm:   m.cfg.Algorithm,
+		PrivateKey:  pem,
+		ActivatesAt: activatesAt,
+		RetiresAt:   retiresAt,
+		// Tokens signed just before retirement live for at most the refresh TTL
+		ExpiresAt: retiresAt.Add(m.cfg.RefreshTokenTTL),
+	}
+	if err := tx.Create(&row).Error; err != nil {
+		return nil, err
+	}
+
+	log.Printf("Created JWT signing key %s, active from %s", id, activatesAt.Format(time.RFC3339))
+	return &row, nil
+}
+
+// load parses rows into the keyring. Keys of any algorithm stay valid for
+// verification so switching algorithms does not log everyone out; so does
+// the shared secret until the legacy row expires.
+func (m *Manager) load(rows []database.SigningKey, now time.Time) error {
+	var (
+		signing *jwtpkg.Key
+		verify  []*jwtpkg.Key
+	)
+
+	var legacyUntil time.Time
+	current, _ := m.currentAndNext(rows, now)
+	for _, row := range rows {
+		if row.ID == legacyKeyID {
+			legacyUntil = row.ExpiresAt
+			continue
+		}
+		key, err := jwtpkg.ParsePrivateKey(row.ID, row.Algorithm, row.PrivateKey)
+		if err != nil {
+			return fmt.Errorf("signing key %s: %w", row.ID, err)
+		}
+		verify = append(verify, key)
+		if current != nil && row.ID == current.ID {
+			signing = key
+		}
+	}
+
+	if signing == nil {
+		return jwtpkg.ErrNoSigningKey
+	}
+
+	m.keyring.SetKeys(signing, verify)
+	m.keyring.SetLegacy(m.legacy, legacyUntil)
+	return nil
+}
+
+func (m *Manager) prepublish() time.Duration {
+	if d := m.cfg.KeyRotationInterval / 2; d < maxPrepublish {
+		return d
+	}
+	return maxPrepublish
+}
@@ -0,0 +1,94 @@
+package sms
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/igorfazlyev/dm/internal/config"
+)
+
+// Sender delivers text messages to phone numbers in E.164 format
+type Sender interface {
+	Send(to, body string) error
+}
+
+// New returns the sender selected by cfg.Driver
+func New(cfg config.SMSConfig) (Sender, error) {
+	switch cfg.Driver {
+	case "log", "":
+		return LogSender{}, nil
+	case "http":
+		if cfg.GatewayURL == "" {
+			return nil, fmt.Errorf("sms gateway url is not configured")
+		}
+		return NewHTTPSender(cfg), nil
+	default:
+		return nil, fmt.Errorf("unknown sms driver %q", cfg.Driver)
+	}
+}
+
+// LogSender writes messages to the application log instead of sending them,
+// for local development
+type LogSender struct{}
+
+func (LogSender) Send(to, body string) error {
+	log.Printf("📱 SMS to %s: %s", to, body)
+	return nil
+}
+
+// HTTPSender posts messages as JSON to an SMS gateway
+type HTTPSender struct {
+	url        string
+	token      string
+	from       string
+	httpClient *http.Client
+}
+
+type gatewayRequest struct {
+	From    string `json:"from,omitempty"`
+	To      string `json:"to"`
+	Message string `json:"message"`
+}
+
+func NewHTTPSender(cfg config.SMSConfig) *HTTPSender {
+	return &HTTPSender{
+		url:        cfg.GatewayURL,
+		token:      cfg.GatewayToken,
+		from:       cfg.From,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (s *HTTPSender) Send(to, body string) error {
+	payload, err := json.Marshal(gatewayRequest{From: s.from, To: to, Message: body})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", s.url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("sms gateway request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("sms gateway returned %s: %s", resp.Status, string(b))
+	}
+
+	return nil
+}
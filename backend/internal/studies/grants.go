@@ -0,0 +1,168 @@
+package studies
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/igorfazlyev/dm/internal/database"
+	"github.com/igorfazlyev/dm/internal/policy"
+	"github.com/igorfazlyev/dm/internal/repository"
+)
+
+const (
+	defaultGrantDays = 30
+	maxGrantDays     = 365
+)
+
+type ShareStudyRequest struct {
+	ClinicID      uuid.UUID `json:"clinic_id" binding:"required"`
+	Scopes        []string  `json:"scopes"`          // Defaults to all of read, read_report, read_results
+	ExpiresInDays int       `json:"expires_in_days"` // Defaults to 30
+}
+
+// ShareStudy grants a clinic access to one of the patient's studies. Sharing
+// again with the same clinic replaces the scopes and expiry of its grant.
+func (h *Handler) ShareStudy(c *gin.Context) {
+	var req ShareStudyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if len(req.Scopes) == 0 {
+		req.Scopes = []string{string(policy.ActionRead), string(policy.ActionReadReport), string(policy.ActionReadResults)}
+	}
+	for _, scope := range req.Scopes {
+		if !policy.GrantScopes[policy.Action(scope)] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unknown scope " + scope})
+			return
+		}
+	}
+
+	if req.ExpiresInDays == 0 {
+		req.ExpiresInDays = defaultGrantDays
+	}
+	if req.ExpiresInDays < 0 || req.ExpiresInDays > maxGrantDays {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expires_in_days must be between 1 and 365"})
+		return
+	}
+
+	study, ok := h.loadStudy(c, policy.ActionShare)
+	if !ok {
+		return
+	}
+
+	clinic, err := h.clinics.Get(req.ClinicID)
+	if err != nil || !clinic.IsActive {
+		c.JSON(http.StatusNotFound, gin.H{"error": "clinic not found"})
+		return
+	}
+
+	expiresAt := time.Now().AddDate(0, 0, req.ExpiresInDays)
+
+	grant, err := h.studies.ActiveGrant(study.ID, clinic.ID)
+	switch {
+	case err == nil:
+		grant.Scopes = req.Scopes
+		grant.ExpiresAt = expiresAt
+		if err := h.studies.SaveGrant(grant); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update grant"})
+			return
+		}
+		c.JSON(http.StatusOK, grant)
+	case errors.Is(err, repository.ErrNotFound):
+		grant = &database.StudyGrant{
+			StudyID:   study.ID,
+			PatientID: study.PatientID,
+			ClinicID:  clinic.ID,
+			Scopes:    req.Scopes,
+			ExpiresAt: expiresAt,
+		}
+		if err := h.studies.SaveGrant(grant); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create grant"})
+			return
+		}
+		c.JSON(http.StatusCreated, grant)
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create grant"})
+	}
+}
+
+// ListStudyGrants returns every grant on a study, including expired and
+// revoked ones
+func (h *Handler) ListStudyGrants(c *gin.Context) {
+	study, ok := h.loadStudy(c, policy.ActionShare)
+	if !ok {
+		return
+	}
+
+	grants, err := h.studies.Grants(study.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch grants"})
+		return
+	}
+
+	c.JSON(http.StatusOK, grants)
+}
+
+// RevokeStudyGrant ends a clinic's access to a study
+func (h *Handler) RevokeStudyGrant(c *gin.Context) {
+	grantID, err := uuid.Parse(c.Param("grant_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid grant ID"})
+		return
+	}
+
+	study, ok := h.loadStudy(c, policy.ActionShare)
+	if !ok {
+		return
+	}
+
+	if err := h.studies.RevokeGrant(study.ID, grantID); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "grant not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke grant"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "grant revoked"})
+}
+
+// GetStudyAccessLog returns the clinic accesses made under grants on a study
+func (h *Handler) GetStudyAccessLog(c *gin.Context) {
+	study, ok := h.loadStudy(c, policy.ActionShare)
+	if !ok {
+		return
+	}
+
+	entries, err := h.studies.AccessLog(study.ID, 500)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch access log"})
+		return
+	}
+
+	c.JSON(http.StatusOK, entries)
+}
+
+// ListSharedStudies returns the active grants patients have made to the
+// current clinic
+func (h *Handler) ListSharedStudies(c *gin.Context) {
+	subject, err := h.policy.SubjectFromContext(c)
+	if err != nil || subject.ClinicID == uuid.Nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "clinic not found"})
+		return
+	}
+
+	grants, err := h.studies.ClinicGrants(subject.ClinicID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch shared studies"})
+		return
+	}
+
+	c.JSON(http.StatusOK, grants)
+}
@@ -1,42 +1,85 @@
 package studies
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 	"github.com/igorfazlyev/dm/internal/config"
 	"github.com/igorfazlyev/dm/internal/database"
+	"github.com/igorfazlyev/dm/internal/policy"
+	"github.com/igorfazlyev/dm/internal/rbac"
+	"github.com/igorfazlyev/dm/internal/repository"
 	"github.com/igorfazlyev/dm/internal/services"
+	"github.com/igorfazlyev/dm/internal/uploads"
+	"github.com/igorfazlyev/dm/pkg/dicom"
 )
 
 type Handler struct {
 	cfg              *config.Config
 	diagnocatService *services.DiagnocatService
+	uploads          *uploads.Store
+	studies          repository.Studies
+	patients         repository.Patients
+	clinics          repository.Clinics
+	policy           *policy.Policy
 }
 
-func NewHandler(cfg *config.Config) *Handler {
+func NewHandler(cfg *config.Config, studies repository.Studies, patients repository.Patients, clinics repository.Clinics, accessPolicy *policy.Policy) *Handler {
 	return &Handler{
 		cfg:              cfg,
-		diagnocatService: services.NewDiagnocatService(),
+		diagnocatService: services.NewDiagnocatService(cfg.Diagnocat),
+		uploads:          uploads.NewStore(cfg.Server.UploadDir),
+		studies:          studies,
+		patients:         patients,
+		clinics:          clinics,
+		policy:           accessPolicy,
 	}
 }
 
+// CreateStudyRequest may describe the study ahead of its upload; the DICOM
+// headers of the file override it
 type CreateStudyRequest struct {
-	Modality  string `json:"modality" binding:"required"`
+	Modality  string `json:"modality"`
 	StudyDate string `json:"study_date"`
 }
 
-func (h *Handler) CreateStudy(c *gin.Context) {
-	userID := c.GetString("user_id")
-	if userID == "" {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
-		return
+// loadStudy fetches the study in the path and authorizes action on it. It
+// writes the error response itself when it returns false.
+func (h *Handler) loadStudy(c *gin.Context, action policy.Action) (*database.Study, bool) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "study not found"})
+		return nil, false
 	}
 
+	study, err := h.studies.Get(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "study not found"})
+		return nil, false
+	}
+
+	resource, err := h.policy.Study(study)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch study"})
+		return nil, false
+	}
+	if !h.policy.Authorize(c, action, resource) {
+		return nil, false
+	}
+	return study, true
+}
+
+func (h *Handler) CreateStudy(c *gin.Context) {
+	userID, _ := rbac.GetUserID(c)
+
 	var req CreateStudyRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -44,8 +87,8 @@ func (h *Handler) CreateStudy(c *gin.Context) {
 	}
 
 	// Get patient profile
-	var patient database.Patient
-	if err := database.DB.Where("user_id = ?", userID).First(&patient).Error; err != nil {
+	patient, err := h.patients.FindByUserID(userID)
+	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "patient profile not found"})
 		return
 	}
@@ -60,7 +103,7 @@ func (h *Handler) CreateStudy(c *gin.Context) {
 		study.StudyDate = &req.StudyDate
 	}
 
-	if err := database.DB.Create(&study).Error; err != nil {
+	if err := h.studies.Create(&study); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create study"})
 		return
 	}
@@ -69,24 +112,16 @@ func (h *Handler) CreateStudy(c *gin.Context) {
 }
 
 func (h *Handler) InitiateDICOMUpload(c *gin.Context) {
-	userID := c.GetString("user_id")
 	studyID := c.Param("id")
 
-	// Verify study belongs to user
-	var study database.Study
-	if err := database.DB.Preload("Patient").Where("id = ?", studyID).First(&study).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "study not found"})
-		return
-	}
-
-	if study.Patient.UserID.String() != userID {
-		c.JSON(http.StatusForbidden, gin.H{"error": "access denied"})
+	study, ok := h.loadStudy(c, policy.ActionUpload)
+	if !ok {
 		return
 	}
 
 	// Update study status to ready for upload
 	study.Status = "uploading"
-	if err := database.DB.Save(&study).Error; err != nil {
+	if err := h.studies.Save(study); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update study"})
 		return
 	}
@@ -98,25 +133,30 @@ func (h *Handler) InitiateDICOMUpload(c *gin.Context) {
 	})
 }
 
+// UploadDICOMFile takes a whole DICOM file as a multipart form. A request
+// with a Tus-Resumable header instead creates a resumable upload.
 func (h *Handler) UploadDICOMFile(c *gin.Context) {
-	userID := c.GetString("user_id")
-	studyID := c.Param("id")
-
-	// Verify study
-	var study database.Study
-	if err := database.DB.Preload("Patient").Where("id = ?", studyID).First(&study).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "study not found"})
+	if c.GetHeader("Tus-Resumable") != "" {
+		h.CreateUpload(c)
 		return
 	}
 
-	if study.Patient.UserID.String() != userID {
-		c.JSON(http.StatusForbidden, gin.H{"error": "access denied"})
+	studyID := c.Param("id")
+
+	study, ok := h.loadStudy(c, policy.ActionUpload)
+	if !ok || !h.takesFile(c, study) {
 		return
 	}
 
 	// Get uploaded file
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, h.maxUploadSize())
 	file, header, err := c.Request.FormFile("file")
 	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "file is too large"})
+			return
+		}
 		c.JSON(http.StatusBadRequest, gin.H{"error": "no file uploaded"})
 		return
 	}
@@ -124,49 +164,117 @@ func (h *Handler) UploadDICOMFile(c *gin.Context) {
 
 	// Save file temporarily
 	tempDir := os.TempDir()
-	tempFilePath := filepath.Join(tempDir, fmt.Sprintf("study_%s_%s", studyID, header.Filename))
+	tempFilePath := filepath.Join(tempDir, fmt.Sprintf("study_%s_%s", studyID, filepath.Base(header.Filename)))
 
 	outFile, err := os.Create(tempFilePath)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save file"})
 		return
 	}
-	defer func() {
-		outFile.Close()
-		os.Remove(tempFilePath) // Clean up temp file
-	}()
 
-	if _, err := io.Copy(outFile, file); err != nil {
+	_, err = io.Copy(outFile, file)
+	if closeErr := outFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tempFilePath)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save file"})
 		return
 	}
-	outFile.Close() // Close before uploading
 
+	if err := readHeaders(study, tempFilePath); err != nil {
+		os.Remove(tempFilePath)
+		headersError(c, err)
+		return
+	}
+	if !h.startAnalysis(c, study, tempFilePath) {
+		os.Remove(tempFilePath)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "file uploaded successfully, processing started",
+		"filename": header.Filename,
+		"status":   "processing",
+	})
+}
+
+// readHeaders fills in a study from the DICOM headers of its file, a Part
+// 10 file or a ZIP archive of them
+func readHeaders(study *database.Study, path string) error {
+	info, err := dicom.Inspect(path)
+	if err != nil {
+		return err
+	}
+
+	if info.Modality != "" {
+		study.Modality = info.Modality
+	}
+	if info.StudyDate != "" {
+		study.StudyDate = &info.StudyDate
+	}
+	study.StudyInstanceUID = &info.StudyInstanceUID
+	study.SeriesCount = info.SeriesCount
+	study.Manufacturer = info.Manufacturer
+	study.ImageKind = string(info.Kind)
+	return nil
+}
+
+// notDICOM reports whether readHeaders failed on the file's content rather
+// than on reading it
+func notDICOM(err error) bool {
+	return errors.Is(err, dicom.ErrNotDICOM) || errors.Is(err, dicom.ErrMixedStudies)
+}
+
+// headersError writes the response to a file readHeaders failed on
+func headersError(c *gin.Context, err error) {
+	if notDICOM(err) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	log.Printf("Failed to read DICOM headers: %v", err)
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read file"})
+}
+
+// maxUploadSize is the largest study file accepted, in bytes
+func (h *Handler) maxUploadSize() int64 {
+	return h.cfg.Server.MaxUploadSizeMB << 20
+}
+
+// startAnalysis hands a study's file to Diagnocat in the background and
+// removes the file once Diagnocat has it. It writes the error response
+// itself when it returns false, and the file is then left in place.
+func (h *Handler) startAnalysis(c *gin.Context, study *database.Study, path string) bool {
 	// Get or create Diagnocat patient ID
 	diagnocatPatientID := study.Patient.DiagnocatPatientID
 	if diagnocatPatientID == nil || *diagnocatPatientID == "" {
 		newPatientID := fmt.Sprintf("patient-%s", study.Patient.ID.String())
 		diagnocatPatientID = &newPatientID
 
-		if err := database.DB.Model(&study.Patient).Update("diagnocat_patient_id", newPatientID).Error; err != nil {
+		study.Patient.DiagnocatPatientID = diagnocatPatientID
+		if err := h.patients.Save(&study.Patient); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update patient"})
-			return
+			return false
 		}
 	}
 
 	// Update study status
+	now := time.Now()
 	study.Status = "processing"
-	if err := database.DB.Save(&study).Error; err != nil {
+	study.UploadedAt = &now
+	if err := h.studies.Save(study); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update study"})
-		return
+		return false
 	}
 
 	// Upload to Diagnocat asynchronously
 	go func() {
-		analysisResp, err := h.diagnocatService.UploadStudy(*diagnocatPatientID, tempFilePath)
+		defer os.Remove(path)
+
+		analysisResp, err := h.diagnocatService.UploadStudy(*diagnocatPatientID, path)
 		if err != nil {
 			fmt.Printf("Failed to upload to Diagnocat: %v\n", err)
-			database.DB.Model(&study).Update("status", "failed")
+			h.studies.Update(study.ID, map[string]any{"status": "failed"})
 			return
 		}
 
@@ -176,46 +284,76 @@ func (h *Handler) UploadDICOMFile(c *gin.Context) {
 			diagnocatStudyUID = analysisResp.IDV3
 		}
 
-		updates := map[string]interface{}{
+		updates := map[string]any{
 			"diagnocat_study_uid": diagnocatStudyUID,
 			"status":              "processing",
 		}
-		database.DB.Model(&study).Updates(updates)
+		h.studies.Update(study.ID, updates)
 
 		fmt.Printf("✅ Upload complete. Analysis ID: %s\n", diagnocatStudyUID)
 	}()
+	return true
+}
 
-	c.JSON(http.StatusOK, gin.H{
-		"message":  "file uploaded successfully, processing started",
-		"filename": header.Filename,
-		"status":   "processing",
-	})
+// SharedStudy is what clinics see of a study shared with them: no patient
+// contact details, and AI results only through GetStudyResults
+type SharedStudy struct {
+	ID          uuid.UUID  `json:"id"`
+	PatientID   uuid.UUID  `json:"patient_id"`
+	Status      string     `json:"status"`
+	Modality    string     `json:"modality"`
+	ImageKind   string     `json:"image_kind,omitempty"`
+	SeriesCount int        `json:"series_count"`
+	StudyDate   *string    `json:"study_date"`
+	CompletedAt *time.Time `json:"completed_at"`
+	CreatedAt   time.Time  `json:"created_at"`
 }
 
 func (h *Handler) GetStudy(c *gin.Context) {
-	studyID := c.Param("id")
+	study, ok := h.loadStudy(c, policy.ActionRead)
+	if !ok {
+		return
+	}
 
-	var study database.Study
-	if err := database.DB.Preload("Patient").Where("id = ?", studyID).First(&study).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "study not found"})
+	if subject, _ := h.policy.SubjectFromContext(c); subject.ClinicID != uuid.Nil {
+		c.JSON(http.StatusOK, SharedStudy{
+			ID:          study.ID,
+			PatientID:   study.PatientID,
+			Status:      study.Status,
+			Modality:    study.Modality,
+			ImageKind:   study.ImageKind,
+			SeriesCount: study.SeriesCount,
+			StudyDate:   study.StudyDate,
+			CompletedAt: study.CompletedAt,
+			CreatedAt:   study.CreatedAt,
+		})
 		return
 	}
 
 	c.JSON(http.StatusOK, study)
 }
 
-func (h *Handler) CheckStudyStatus(c *gin.Context) {
-	studyID := c.Param("id")
-	userID := c.GetString("user_id")
+// GetStudyResults returns the AI analysis of a study
+func (h *Handler) GetStudyResults(c *gin.Context) {
+	study, ok := h.loadStudy(c, policy.ActionReadResults)
+	if !ok {
+		return
+	}
 
-	var study database.Study
-	if err := database.DB.Preload("Patient").Where("id = ?", studyID).First(&study).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "study not found"})
+	if study.DiagnocatResultJSON == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no analysis results available"})
 		return
 	}
 
-	if study.Patient.UserID.String() != userID {
-		c.JSON(http.StatusForbidden, gin.H{"error": "access denied"})
+	c.JSON(http.StatusOK, gin.H{
+		"study_id": study.ID,
+		"result":   study.DiagnocatResultJSON,
+	})
+}
+
+func (h *Handler) CheckStudyStatus(c *gin.Context) {
+	study, ok := h.loadStudy(c, policy.ActionRead)
+	if !ok {
 		return
 	}
 
@@ -224,12 +362,10 @@ func (h *Handler) CheckStudyStatus(c *gin.Context) {
 		reportStatus, err := h.diagnocatService.GetAnalysisStatus(*study.DiagnocatStudyUID)
 		if err == nil {
 			if reportStatus.Complete || reportStatus.Status == "complete" {
-				study.Status = "completed"
-				study.DiagnocatReportURL = &reportStatus.PDFUrl
-				database.DB.Save(&study)
+				h.studies.Complete(study, reportStatus.PDFUrl)
 			} else if reportStatus.Status == "error" {
 				study.Status = "failed"
-				database.DB.Save(&study)
+				h.studies.Save(study)
 			}
 		}
 	}
@@ -246,16 +382,16 @@ func (h *Handler) CheckStudyStatus(c *gin.Context) {
 
 func (h *Handler) GetStudyPDF(c *gin.Context) {
 	studyID := c.Param("id")
-	userID := c.GetString("user_id")
 
-	var study database.Study
-	if err := database.DB.Preload("Patient").Where("id = ?", studyID).First(&study).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "study not found"})
+	study, ok := h.loadStudy(c, policy.ActionReadReport)
+	if !ok {
 		return
 	}
 
-	if study.Patient.UserID.String() != userID {
-		c.JSON(http.StatusForbidden, gin.H{"error": "access denied"})
+	// Serve the stored copy when there is one
+	if len(study.DiagnocatReportPDF) > 0 {
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="report_%s.pdf"`, studyID))
+		c.Data(http.StatusOK, "application/pdf", study.DiagnocatReportPDF)
 		return
 	}
 
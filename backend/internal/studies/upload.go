@@ -0,0 +1,467 @@
+package studies
+
+import (
+	"encoding/base64"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/igorfazlyev/dm/internal/database"
+	"github.com/igorfazlyev/dm/internal/policy"
+	"github.com/igorfazlyev/dm/internal/repository"
+	"github.com/igorfazlyev/dm/internal/uploads"
+	"github.com/igorfazlyev/dm/pkg/dicom"
+)
+
+// Resumable uploads follow tus 1.0 (https://tus.io/protocols/resumable-upload)
+// with the creation, expiration, checksum and termination extensions. A
+// client creates an upload with POST /studies/:id/upload and the file's
+// length, sends the file in one or more PATCH requests to the URL it gets
+// back, and after an interruption asks that URL with HEAD how much arrived.
+// The last piece hands the file to Diagnocat.
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,expiration,checksum,termination"
+
+	// statusChecksumMismatch is the status tus defines for a piece that does
+	// not match its Upload-Checksum
+	statusChecksumMismatch = 460
+
+	// sniffLength is how much of a file shows whether it is DICOM: the
+	// preamble and the DICM prefix
+	sniffLength = 132
+)
+
+// tus returns false, having written the response, if the request does not
+// speak the tus version served
+func tus(c *gin.Context) bool {
+	c.Header("Tus-Resumable", tusVersion)
+	if c.GetHeader("Tus-Resumable") != tusVersion {
+		c.Header("Tus-Version", tusVersion)
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "unsupported tus version"})
+		return false
+	}
+	return true
+}
+
+// GetUploadOptions describes the resumable uploads served
+func (h *Handler) GetUploadOptions(c *gin.Context) {
+	c.Header("Tus-Resumable", tusVersion)
+	c.Header("Tus-Version", tusVersion)
+	c.Header("Tus-Extension", tusExtensions)
+	c.Header("Tus-Max-Size", strconv.FormatInt(h.maxUploadSize(), 10))
+	c.Header("Tus-Checksum-Algorithm", strings.Join(uploads.Algorithms, ","))
+	c.Status(http.StatusNoContent)
+}
+
+// CreateUpload starts a resumable upload of a study's file. The
+// Upload-Metadata header may carry the file's name as "filename" and a
+// checksum of the whole file as "checksum", e.g. "sha256 <base64 digest>",
+// which is verified once the last byte arrives.
+func (h *Handler) CreateUpload(c *gin.Context) {
+	if !tus(c) {
+		return
+	}
+
+	study, ok := h.loadStudy(c, policy.ActionUpload)
+	if !ok || !h.takesFile(c, study) {
+		return
+	}
+
+	length, err := strconv.ParseInt(c.GetHeader("Upload-Length"), 10, 64)
+	if err != nil || length < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Upload-Length must be a positive number of bytes"})
+		return
+	}
+	if length > h.maxUploadSize() {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "file is too large"})
+		return
+	}
+
+	metadata, err := parseMetadata(c.GetHeader("Upload-Metadata"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	upload := database.StudyUpload{
+		StudyID:   study.ID,
+		Length:    length,
+		Metadata:  c.GetHeader("Upload-Metadata"),
+		Filename:  metadata["filename"],
+		ExpiresAt: time.Now().Add(h.cfg.Server.UploadExpiry),
+	}
+	if metadata["checksum"] != "" {
+		checksum, err := uploads.ParseChecksum(metadata["checksum"])
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		upload.Checksum = checksum.String()
+	}
+
+	upload.ID = uuid.New()
+	if err := h.uploads.Create(upload.ID); err != nil {
+		log.Printf("Failed to create upload file: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create upload"})
+		return
+	}
+	if err := h.studies.CreateUpload(&upload); err != nil {
+		h.uploads.Remove(upload.ID)
+		if errors.Is(err, repository.ErrConflict) {
+			c.JSON(http.StatusConflict, gin.H{"error": "another upload of the study is in progress"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create upload"})
+		return
+	}
+
+	if study.Status == "created" || study.Status == "failed" {
+		h.studies.Update(study.ID, map[string]any{"status": "uploading"})
+	}
+
+	c.Header("Location", uploadLocation(c, &upload))
+	c.Header("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	c.JSON(http.StatusCreated, upload)
+}
+
+// takesFile checks that a study may receive a file, by either upload path. A
+// study is analysed once, so only one whose analysis failed takes another
+// file, and only while no other upload of it is in progress; the Location of
+// that upload lets its client resume it. It writes the error response itself
+// when it returns false.
+func (h *Handler) takesFile(c *gin.Context, study *database.Study) bool {
+	if study.Status == "processing" || study.Status == "completed" {
+		c.JSON(http.StatusConflict, gin.H{"error": "study is " + study.Status})
+		return false
+	}
+
+	upload, err := h.studies.ActiveUpload(study.ID)
+	if err == nil {
+		c.Header("Location", uploadLocation(c, upload))
+		c.JSON(http.StatusConflict, gin.H{"error": "another upload of the study is in progress"})
+		return false
+	}
+	if !errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check uploads"})
+		return false
+	}
+	return true
+}
+
+// uploadLocation is the URL of an upload, below the study's upload URL the
+// request was made to
+func uploadLocation(c *gin.Context, upload *database.StudyUpload) string {
+	return strings.TrimSuffix(c.Request.URL.Path, "/") + "/" + upload.ID.String()
+}
+
+// loadUpload fetches the study and the upload in the path. It writes the
+// error response itself when it returns false.
+func (h *Handler) loadUpload(c *gin.Context) (*database.Study, *database.StudyUpload, bool) {
+	study, ok := h.loadStudy(c, policy.ActionUpload)
+	if !ok {
+		return nil, nil, false
+	}
+
+	uploadID, err := uuid.Parse(c.Param("upload_id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "upload not found"})
+		return nil, nil, false
+	}
+	upload, err := h.studies.Upload(study.ID, uploadID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "upload not found"})
+		return nil, nil, false
+	}
+	return study, upload, true
+}
+
+// uploadHeaders describes how far an upload got
+func uploadHeaders(c *gin.Context, upload *database.StudyUpload) {
+	c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	c.Header("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	if upload.CompletedAt == nil {
+		c.Header("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	}
+	c.Header("Cache-Control", "no-store")
+}
+
+// GetUploadOffset tells a client where to resume an upload
+func (h *Handler) GetUploadOffset(c *gin.Context) {
+	if !tus(c) {
+		return
+	}
+
+	_, upload, ok := h.loadUpload(c)
+	if !ok {
+		return
+	}
+
+	uploadHeaders(c, upload)
+	if upload.Metadata != "" {
+		c.Header("Upload-Metadata", upload.Metadata)
+	}
+	c.Status(http.StatusOK)
+}
+
+// PatchUpload appends a piece of the file to an upload. The piece must start
+// where the upload got to, and match its Upload-Checksum if it has one.
+func (h *Handler) PatchUpload(c *gin.Context) {
+	if !tus(c) {
+		return
+	}
+
+	study, upload, ok := h.loadUpload(c)
+	if !ok {
+		return
+	}
+
+	if c.ContentType() != "application/offset+octet-stream" {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Content-Type must be application/offset+octet-stream"})
+		return
+	}
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Upload-Offset must be a number of bytes"})
+		return
+	}
+	var checksum *uploads.Checksum
+	if header := c.GetHeader("Upload-Checksum"); header != "" {
+		parsed, err := uploads.ParseChecksum(header)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		checksum = &parsed
+	}
+
+	unlock, ok := h.uploads.Lock(upload.ID)
+	if !ok {
+		c.JSON(http.StatusLocked, gin.H{"error": "upload is being written by another request"})
+		return
+	}
+	defer unlock()
+
+	// Read again under the lock, in case a request that just finished moved it
+	upload, err = h.studies.Upload(study.ID, upload.ID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "upload not found"})
+		return
+	}
+	if offset != upload.Offset {
+		uploadHeaders(c, upload)
+		c.JSON(http.StatusConflict, gin.H{"error": "Upload-Offset does not match the upload's offset"})
+		return
+	}
+	remaining := upload.Length - upload.Offset
+	if c.Request.ContentLength > remaining {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "piece runs past Upload-Length"})
+		return
+	}
+
+	previous := upload.Offset
+	n, writeErr := h.uploads.Write(upload.ID, upload.Offset, io.LimitReader(c.Request.Body, remaining), checksum)
+	if n > 0 {
+		if err := h.studies.AdvanceUpload(upload, upload.Offset+n, time.Now().Add(h.cfg.Server.UploadExpiry)); err != nil {
+			if errors.Is(err, repository.ErrConflict) {
+				c.JSON(http.StatusConflict, gin.H{"error": "upload was modified concurrently"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save upload"})
+			return
+		}
+	}
+
+	if writeErr != nil {
+		uploadHeaders(c, upload)
+		if errors.Is(writeErr, uploads.ErrChecksumMismatch) {
+			c.JSON(statusChecksumMismatch, gin.H{"error": "piece does not match Upload-Checksum"})
+			return
+		}
+		// Most likely the client went away; it resumes from the offset
+		log.Printf("Upload %s interrupted at %d bytes: %v", upload.ID, upload.Offset, writeErr)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "upload interrupted"})
+		return
+	}
+
+	// Turn away what is not DICOM as soon as its first bytes are in, rather
+	// than after the whole file
+	if previous < sniffLength && upload.Offset >= min(sniffLength, upload.Length) {
+		prefix, err := h.uploads.ReadPrefix(upload.ID, sniffLength)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read upload"})
+			return
+		}
+		if !dicom.Sniff(prefix) && !dicom.IsArchive(prefix) {
+			h.removeUpload(upload)
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "file is neither DICOM nor a ZIP archive of DICOM files"})
+			return
+		}
+	}
+
+	if upload.Offset == upload.Length && upload.CompletedAt == nil {
+		if !h.completeUpload(c, study, upload) {
+			return
+		}
+	}
+
+	uploadHeaders(c, upload)
+	c.Status(http.StatusNoContent)
+}
+
+// completeUpload verifies a fully received upload against its checksum,
+// reads its DICOM headers and starts the analysis of the file, which then
+// owns it. An upload that fails verification or is not DICOM is removed, as
+// the client has to send another file. It writes the error response itself
+// when it returns false.
+func (h *Handler) completeUpload(c *gin.Context, study *database.Study, upload *database.StudyUpload) bool {
+	if upload.Checksum != "" {
+		checksum, err := uploads.ParseChecksum(upload.Checksum)
+		if err == nil {
+			err = h.uploads.Verify(upload.ID, checksum)
+		}
+		if err != nil {
+			h.removeUpload(upload)
+			if errors.Is(err, uploads.ErrChecksumMismatch) {
+				c.JSON(statusChecksumMismatch, gin.H{"error": "file does not match its checksum, upload it again"})
+				return false
+			}
+			log.Printf("Failed to verify upload %s: %v", upload.ID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to verify upload"})
+			return false
+		}
+	}
+
+	if err := readHeaders(study, h.uploads.Path(upload.ID)); err != nil {
+		if notDICOM(err) {
+			h.removeUpload(upload)
+		}
+		headersError(c, err)
+		return false
+	}
+	if !h.startAnalysis(c, study, h.uploads.Path(upload.ID)) {
+		return false
+	}
+	// The analysis is under way whatever happens here; an upload left
+	// incomplete only makes a retried last PATCH start it again
+	if err := h.studies.CompleteUpload(upload); err != nil {
+		log.Printf("Failed to mark upload %s complete: %v", upload.ID, err)
+	}
+	return true
+}
+
+// DeleteUpload abandons an upload
+func (h *Handler) DeleteUpload(c *gin.Context) {
+	if !tus(c) {
+		return
+	}
+
+	_, upload, ok := h.loadUpload(c)
+	if !ok {
+		return
+	}
+
+	unlock, ok := h.uploads.Lock(upload.ID)
+	if !ok {
+		c.JSON(http.StatusLocked, gin.H{"error": "upload is being written by another request"})
+		return
+	}
+	defer unlock()
+
+	if upload.CompletedAt != nil {
+		// The file belongs to the analysis now
+		if err := h.studies.DeleteUpload(upload.ID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete upload"})
+			return
+		}
+	} else if err := h.removeUpload(upload); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete upload"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// removeUpload deletes an upload and its file
+func (h *Handler) removeUpload(upload *database.StudyUpload) error {
+	if err := h.studies.DeleteUpload(upload.ID); err != nil {
+		return err
+	}
+	return h.uploads.Remove(upload.ID)
+}
+
+// StartUploadExpiry removes expired uploads every interval in the background
+func (h *Handler) StartUploadExpiry(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if err := h.ExpireUploads(); err != nil {
+				log.Printf("Failed to remove expired uploads: %v", err)
+			}
+		}
+	}()
+}
+
+// ExpireUploads removes the uploads past their expiry: incomplete ones that
+// sat idle with their files, and the rows of completed ones. Uploads being
+// written are left for the next round.
+func (h *Handler) ExpireUploads() error {
+	expired, err := h.studies.ExpiredUploads(time.Now())
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for i := range expired {
+		errs = append(errs, h.expireUpload(&expired[i]))
+	}
+	return errors.Join(errs...)
+}
+
+// expireUpload removes an expired upload. A completed upload's file belongs
+// to the analysis, so only its row goes.
+func (h *Handler) expireUpload(upload *database.StudyUpload) error {
+	unlock, ok := h.uploads.Lock(upload.ID)
+	if !ok {
+		return nil
+	}
+	defer unlock()
+
+	// A request that wrote to it since it was read also moved its expiry
+	if _, err := h.studies.Upload(upload.StudyID, upload.ID); !errors.Is(err, repository.ErrNotFound) {
+		return err
+	}
+
+	if upload.CompletedAt != nil {
+		return h.studies.DeleteUpload(upload.ID)
+	}
+	return h.removeUpload(upload)
+}
+
+// parseMetadata reads an Upload-Metadata header: comma-separated pairs of a
+// key and, optionally, its base64 value
+func parseMetadata(header string) (map[string]string, error) {
+	metadata := map[string]string{}
+	if strings.TrimSpace(header) == "" {
+		return metadata, nil
+	}
+	for _, pair := range strings.Split(header, ",") {
+		key, encoded, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			return nil, errors.New("Upload-Metadata has an empty key")
+		}
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, errors.New("Upload-Metadata value of " + key + " is not base64")
+		}
+		metadata[key] = string(value)
+	}
+	return metadata, nil
+}
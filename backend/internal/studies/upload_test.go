@@ -0,0 +1,188 @@
+package studies
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/igorfazlyev/dm/internal/config"
+	"github.com/igorfazlyev/dm/internal/database"
+	"github.com/igorfazlyev/dm/internal/policy"
+	"github.com/igorfazlyev/dm/internal/rbac"
+	"github.com/igorfazlyev/dm/internal/repository/memory"
+)
+
+// uploadFixture is a patient's study and a handler keeping uploads in a
+// temporary directory
+type uploadFixture struct {
+	store   *memory.Store
+	handler *Handler
+	userID  uuid.UUID
+	study   database.Study
+}
+
+func newUploadFixture(t *testing.T, status string) *uploadFixture {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	store := memory.NewStore()
+	f := &uploadFixture{store: store}
+
+	user := database.User{Email: "patient@example.com", Role: rbac.RolePatient, IsActive: true}
+	store.AddUser(&user)
+	f.userID = user.ID
+
+	patient := database.Patient{UserID: user.ID}
+	if err := store.Patients.Create(&patient); err != nil {
+		t.Fatal(err)
+	}
+	f.study = database.Study{PatientID: patient.ID, Status: status}
+	if err := store.Studies.Create(&f.study); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Config{}
+	cfg.Server.MaxUploadSizeMB = 1
+	cfg.Server.UploadDir = t.TempDir()
+	cfg.Server.UploadExpiry = time.Hour
+	f.handler = NewHandler(cfg, store.Studies, store.Patients, store.Clinics, policy.New(&store.Store))
+	return f
+}
+
+// post sends req to UploadDICOMFile as the study's patient
+func (f *uploadFixture) post(req *http.Request) *httptest.ResponseRecorder {
+	router := gin.New()
+	router.POST("/studies/:id/upload", func(c *gin.Context) {
+		c.Set("user_id", f.userID)
+		c.Set("user_role", rbac.RolePatient)
+	}, f.handler.UploadDICOMFile)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// createUpload starts a resumable upload of the study
+func (f *uploadFixture) createUpload() *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/studies/"+f.study.ID.String()+"/upload", nil)
+	req.Header.Set("Tus-Resumable", tusVersion)
+	req.Header.Set("Upload-Length", "1000")
+	return f.post(req)
+}
+
+// postFile uploads a file of the study as a multipart form
+func (f *uploadFixture) postFile(t *testing.T) *httptest.ResponseRecorder {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("file", "scan.dcm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write([]byte("not DICOM"))
+	form.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/studies/"+f.study.ID.String()+"/upload", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	return f.post(req)
+}
+
+func TestUploadStudyStatus(t *testing.T) {
+	tests := []struct {
+		status string
+		want   int // Of a resumable upload; a multipart one gets 409 alike
+	}{
+		{"created", http.StatusCreated},
+		{"failed", http.StatusCreated},
+		{"processing", http.StatusConflict},
+		{"completed", http.StatusConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.status, func(t *testing.T) {
+			f := newUploadFixture(t, tt.status)
+			if w := f.createUpload(); w.Code != tt.want {
+				t.Errorf("resumable upload: status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+
+			if tt.want != http.StatusConflict {
+				return
+			}
+			if w := f.postFile(t); w.Code != http.StatusConflict {
+				t.Errorf("multipart upload: status = %d, want 409: %s", w.Code, w.Body)
+			}
+		})
+	}
+}
+
+func TestUploadInProgress(t *testing.T) {
+	f := newUploadFixture(t, "created")
+
+	first := f.createUpload()
+	if first.Code != http.StatusCreated {
+		t.Fatalf("first upload: status = %d: %s", first.Code, first.Body)
+	}
+	location := first.Header().Get("Location")
+
+	if w := f.createUpload(); w.Code != http.StatusConflict || w.Header().Get("Location") != location {
+		t.Errorf("second upload: status = %d, Location %q; want 409 and %q", w.Code, w.Header().Get("Location"), location)
+	}
+	if w := f.postFile(t); w.Code != http.StatusConflict {
+		t.Errorf("multipart upload: status = %d, want 409: %s", w.Code, w.Body)
+	}
+}
+
+func TestExpireUploads(t *testing.T) {
+	f := newUploadFixture(t, "created")
+	past := time.Now().Add(-time.Minute)
+
+	uploads := map[string]*database.StudyUpload{
+		"idle":      {StudyID: f.study.ID, Length: 10, ExpiresAt: past},
+		"completed": {StudyID: f.study.ID, Length: 10, ExpiresAt: past},
+		"active":    {StudyID: f.study.ID, Length: 10, ExpiresAt: time.Now().Add(time.Hour)},
+	}
+	for _, name := range []string{"idle", "completed", "active"} {
+		upload := uploads[name]
+		upload.ID = uuid.New()
+		if err := f.handler.uploads.Create(upload.ID); err != nil {
+			t.Fatal(err)
+		}
+		if err := f.store.Studies.CreateUpload(upload); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if name == "completed" {
+			f.store.Studies.CompleteUpload(upload)
+		}
+	}
+
+	if err := f.handler.ExpireUploads(); err != nil {
+		t.Fatal(err)
+	}
+
+	if expired, err := f.store.Studies.ExpiredUploads(time.Now()); err != nil || len(expired) != 0 {
+		t.Errorf("expired uploads left = %v, %v", expired, err)
+	}
+	if _, err := f.store.Studies.Upload(f.study.ID, uploads["active"].ID); err != nil {
+		t.Errorf("active upload: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		keepsFile bool
+	}{
+		{"idle", false},
+		{"completed", true}, // The file belongs to the analysis
+		{"active", true},
+	}
+	for _, tt := range tests {
+		_, err := os.Stat(f.handler.uploads.Path(uploads[tt.name].ID))
+		if keeps := err == nil; keeps != tt.keepsFile {
+			t.Errorf("%s: file kept = %v, want %v", tt.name, keeps, tt.keepsFile)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+// Package uploads keeps the bytes of resumable uploads on disk while they
+// arrive in pieces. Which uploads exist and how far they got is recorded in
+// the database; this package only deals with their files.
+package uploads
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// ErrChecksumMismatch means received bytes do not hash to what the client
+// said they would
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// Algorithms are the checksum algorithms accepted, in the order advertised
+var Algorithms = []string{"sha256", "sha1", "md5"}
+
+var hashes = map[string]func() hash.Hash{
+	"sha256": sha256.New,
+	"sha1":   sha1.New,
+	"md5":    md5.New,
+}
+
+// Checksum is an expected digest, written "<algorithm> <base64 digest>" as
+// in the tus Upload-Checksum header
+type Checksum struct {
+	Algorithm string
+	Digest    []byte
+}
+
+// ParseChecksum reads a checksum in the form "sha256 <base64 digest>"
+func ParseChecksum(s string) (Checksum, error) {
+	algorithm, encoded, ok := strings.Cut(strings.TrimSpace(s), " ")
+	if !ok {
+		return Checksum{}, fmt.Errorf("checksum %q is not <algorithm> <base64 digest>", s)
+	}
+	newHash, ok := hashes[algorithm]
+	if !ok {
+		return Checksum{}, fmt.Errorf("checksum algorithm %q is not supported", algorithm)
+	}
+	digest, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(digest) != newHash().Size() {
+		return Checksum{}, fmt.Errorf("checksum digest %q is not a base64 %s digest", encoded, algorithm)
+	}
+	return Checksum{Algorithm: algorithm, Digest: digest}, nil
+}
+
+func (c Checksum) String() string {
+	return c.Algorithm + " " + base64.StdEncoding.EncodeToString(c.Digest)
+}
+
+func (c Checksum) newHash() hash.Hash {
+	return hashes[c.Algorithm]()
+}
+
+// Store holds upload files in a directory, one per upload, named by its ID
+type Store struct {
+	dir string
+
+	mu   sync.Mutex
+	busy map[uuid.UUID]bool
+}
+
+func NewStore(dir string) *Store {
+	return &Store{dir: dir, busy: map[uuid.UUID]bool{}}
+}
+
+// Path is where the file of an upload is kept
+func (s *Store) Path(id uuid.UUID) string {
+	return filepath.Join(s.dir, id.String())
+}
+
+// Create makes the empty file of a new upload
+func (s *Store) Create(id uuid.UUID) error {
+	if err := os.MkdirAll(s.dir, 0o700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.Path(id), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// Lock claims an upload for writing. It returns false if another request of
+// this instance is writing to it; unlock releases the claim.
+func (s *Store) Lock(id uuid.UUID) (unlock func(), ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.busy[id] {
+		return nil, false
+	}
+	s.busy[id] = true
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.busy, id)
+	}, true
+}
+
+// Write copies r into an upload's file from offset, and returns how many
+// bytes it wrote. Bytes that arrived before a read error are kept, so the
+// client can resume after them, unless a checksum was given: then the whole
+// piece must arrive and match it, or none of it is kept.
+func (s *Store) Write(id uuid.UUID, offset int64, r io.Reader, checksum *Checksum) (int64, error) {
+	f, err := os.OpenFile(s.Path(id), os.O_WRONLY, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	// Drop anything past offset left by a write that was not recorded
+	if err := f.Truncate(offset); err != nil {
+		return 0, err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	var h hash.Hash
+	if checksum != nil {
+		h = checksum.newHash()
+		r = io.TeeReader(r, h)
+	}
+
+	n, err := io.Copy(f, r)
+	if err == nil && h != nil && !bytes.Equal(h.Sum(nil), checksum.Digest) {
+		err = ErrChecksumMismatch
+	}
+	if err != nil && checksum != nil {
+		if truncErr := f.Truncate(offset); truncErr != nil {
+			return 0, errors.Join(err, truncErr)
+		}
+		n = 0
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return n, err
+}
+
+// ReadPrefix returns the first n bytes of an upload's file, or all of it if
+// it is shorter
+func (s *Store) ReadPrefix(id uuid.UUID, n int) ([]byte, error) {
+	f, err := os.Open(s.Path(id))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	prefix := make([]byte, n)
+	read, err := io.ReadFull(f, prefix)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, err
+	}
+	return prefix[:read], nil
+}
+
+// Verify hashes a whole upload file and compares it to checksum
+func (s *Store) Verify(id uuid.UUID, checksum Checksum) error {
+	f, err := os.Open(s.Path(id))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	h := checksum.newHash()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+	if !bytes.Equal(h.Sum(nil), checksum.Digest) {
+		return ErrChecksumMismatch
+	}
+	return nil
+}
+
+// Remove deletes an upload's file. A file already gone is not an error.
+func (s *Store) Remove(id uuid.UUID) error {
+	if err := os.Remove(s.Path(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,386 @@
+// Package dicom reads the headers of DICOM Part 10 files: the preamble, the
+// file meta information and the attributes that identify a study and its
+// images. Pixel data is never decoded; parsing stops before it.
+package dicom
+
+import (
+	"bufio"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ErrNotDICOM is wrapped by every error about the content of a file, as
+// opposed to failures to read it
+var ErrNotDICOM = errors.New("not a DICOM file")
+
+// Transfer syntaxes that change how the dataset is encoded. Every other
+// syntax, including the compressed ones, is explicit VR little endian.
+const (
+	ImplicitVRLittleEndian         = "1.2.840.10008.1.2"
+	ExplicitVRLittleEndian         = "1.2.840.10008.1.2.1"
+	DeflatedExplicitVRLittleEndian = "1.2.840.10008.1.2.1.99"
+	ExplicitVRBigEndian            = "1.2.840.10008.1.2.2"
+)
+
+// Tag is a data element tag, its group in the high 16 bits
+type Tag uint32
+
+func NewTag(group, element uint16) Tag {
+	return Tag(uint32(group)<<16 | uint32(element))
+}
+
+func (t Tag) Group() uint16 { return uint16(t >> 16) }
+
+func (t Tag) String() string {
+	return fmt.Sprintf("(%04X,%04X)", uint16(t>>16), uint16(t))
+}
+
+var (
+	TagTransferSyntaxUID       = NewTag(0x0002, 0x0010)
+	TagMediaStorageSOPClassUID = NewTag(0x0002, 0x0002)
+	TagImageType               = NewTag(0x0008, 0x0008)
+	TagSOPClassUID             = NewTag(0x0008, 0x0016)
+	TagSOPInstanceUID          = NewTag(0x0008, 0x0018)
+	TagStudyDate               = NewTag(0x0008, 0x0020)
+	TagModality                = NewTag(0x0008, 0x0060)
+	TagManufacturer            = NewTag(0x0008, 0x0070)
+	TagManufacturerModelName   = NewTag(0x0008, 0x1090)
+	TagStudyInstanceUID        = NewTag(0x0020, 0x000D)
+	TagSeriesInstanceUID       = NewTag(0x0020, 0x000E)
+	TagNumberOfFrames          = NewTag(0x0028, 0x0008)
+	TagRows                    = NewTag(0x0028, 0x0010)
+	TagColumns                 = NewTag(0x0028, 0x0011)
+	TagPixelData               = NewTag(0x7FE0, 0x0010)
+
+	tagItem              = NewTag(0xFFFE, 0xE000)
+	tagItemDelimiter     = NewTag(0xFFFE, 0xE00D)
+	tagSequenceDelimiter = NewTag(0xFFFE, 0xE0DD)
+)
+
+// Header is what Parse reads from a file
+type Header struct {
+	TransferSyntaxUID     string
+	SOPClassUID           string
+	SOPInstanceUID        string
+	ImageType             []string
+	Modality              string // CT for CT and CBCT, PX for panoramic X-ray, ...
+	StudyDate             string // YYYYMMDD
+	Manufacturer          string
+	ManufacturerModelName string
+	StudyInstanceUID      string
+	SeriesInstanceUID     string
+	NumberOfFrames        int
+	Rows                  int
+	Columns               int
+}
+
+// attributes are the elements Parse keeps
+var attributes = map[Tag]bool{
+	TagTransferSyntaxUID: true, TagMediaStorageSOPClassUID: true,
+	TagImageType: true, TagSOPClassUID: true, TagSOPInstanceUID: true, TagStudyDate: true,
+	TagModality: true, TagManufacturer: true, TagManufacturerModelName: true,
+	TagStudyInstanceUID: true, TagSeriesInstanceUID: true,
+	TagNumberOfFrames: true, TagRows: true, TagColumns: true,
+}
+
+// set stores the value of an attribute
+func (h *Header) set(tag Tag, v []byte, order binary.ByteOrder) {
+	switch tag {
+	case TagTransferSyntaxUID:
+		h.TransferSyntaxUID = text(v)
+	case TagMediaStorageSOPClassUID:
+		// Older files only name the SOP class in the meta information
+		if h.SOPClassUID == "" {
+			h.SOPClassUID = text(v)
+		}
+	case TagImageType:
+		h.ImageType = strings.Split(text(v), `\`)
+	case TagSOPClassUID:
+		h.SOPClassUID = text(v)
+	case TagSOPInstanceUID:
+		h.SOPInstanceUID = text(v)
+	case TagStudyDate:
+		h.StudyDate = text(v)
+	case TagModality:
+		h.Modality = text(v)
+	case TagManufacturer:
+		h.Manufacturer = text(v)
+	case TagManufacturerModelName:
+		h.ManufacturerModelName = text(v)
+	case TagStudyInstanceUID:
+		h.StudyInstanceUID = text(v)
+	case TagSeriesInstanceUID:
+		h.SeriesInstanceUID = text(v)
+	case TagNumberOfFrames:
+		h.NumberOfFrames, _ = strconv.Atoi(text(v))
+	case TagRows:
+		h.Rows = uint16Value(v, order)
+	case TagColumns:
+		h.Columns = uint16Value(v, order)
+	}
+}
+
+// lastAttribute is the highest tag kept; parsing stops after it
+var lastAttribute = TagColumns
+
+const (
+	preambleSize   = 128
+	maxValueLength = 64 << 10 // Of a kept attribute; they are all short strings or numbers
+	maxDepth       = 16       // Of nested sequences
+)
+
+// Sniff reports whether prefix, the start of a file at least 132 bytes long,
+// has the preamble and DICM prefix of a Part 10 file
+func Sniff(prefix []byte) bool {
+	return len(prefix) >= preambleSize+4 && string(prefix[preambleSize:preambleSize+4]) == "DICM"
+}
+
+// Parse reads the header of a Part 10 file
+func Parse(r io.Reader) (*Header, error) {
+	br := bufio.NewReader(r)
+	prefix := make([]byte, preambleSize+4)
+	if _, err := io.ReadFull(br, prefix); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, fmt.Errorf("%w: shorter than the DICOM preamble", ErrNotDICOM)
+		}
+		return nil, err
+	}
+	if !Sniff(prefix) {
+		return nil, fmt.Errorf("%w: no DICM prefix after the preamble", ErrNotDICOM)
+	}
+
+	h := &Header{}
+
+	// The file meta information is always explicit VR little endian
+	meta := &decoder{r: br, order: binary.LittleEndian}
+	if err := meta.read(h, func(t Tag) bool { return t.Group() != 0x0002 }); err != nil {
+		return nil, formatError(err)
+	}
+	if h.TransferSyntaxUID == "" {
+		return nil, fmt.Errorf("%w: file meta information has no transfer syntax", ErrNotDICOM)
+	}
+
+	dataset := &decoder{r: br, order: binary.LittleEndian}
+	switch h.TransferSyntaxUID {
+	case ImplicitVRLittleEndian:
+		dataset.implicit = true
+	case ExplicitVRBigEndian:
+		dataset.order = binary.BigEndian
+	case DeflatedExplicitVRLittleEndian:
+		dataset.r = bufio.NewReader(flate.NewReader(br))
+	}
+	if err := dataset.read(h, func(t Tag) bool { return t > lastAttribute }); err != nil {
+		return nil, formatError(err)
+	}
+	return h, nil
+}
+
+// formatError turns a file that ends early or does not inflate into
+// ErrNotDICOM
+func formatError(err error) error {
+	var corrupt flate.CorruptInputError
+	switch {
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return fmt.Errorf("%w: truncated", ErrNotDICOM)
+	case errors.As(err, &corrupt):
+		return fmt.Errorf("%w: deflated dataset is corrupt", ErrNotDICOM)
+	}
+	return err
+}
+
+func malformed(format string, args ...any) error {
+	return fmt.Errorf("%w: "+format, append([]any{ErrNotDICOM}, args...)...)
+}
+
+// element is the header of a data element, before its value
+type element struct {
+	tag    Tag
+	vr     string // Empty in implicit VR datasets and for items
+	length uint32
+}
+
+const undefinedLength = 0xFFFFFFFF
+
+func (e element) undefined() bool { return e.length == undefinedLength }
+
+// decoder reads data elements in one transfer syntax
+type decoder struct {
+	r        *bufio.Reader
+	order    binary.ByteOrder
+	implicit bool
+}
+
+// read stores the attributes among the elements it reads into h. It stops
+// when the data ends, or before the pixel data or the first element done
+// accepts, leaving that element unread.
+func (d *decoder) read(h *Header, done func(Tag) bool) error {
+	for {
+		tag, err := d.peekTag()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if done(tag) || tag == TagPixelData {
+			return nil
+		}
+
+		e, err := d.element()
+		if err != nil {
+			return err
+		}
+		switch {
+		case e.tag.Group() == 0xFFFE:
+			return malformed("unexpected %s outside a sequence", e.tag)
+		case e.undefined():
+			if err := d.skipSequence(1); err != nil {
+				return err
+			}
+		case attributes[e.tag]:
+			if e.length > maxValueLength {
+				return malformed("%s is %d bytes long", e.tag, e.length)
+			}
+			value := make([]byte, e.length)
+			if _, err := io.ReadFull(d.r, value); err != nil {
+				return unexpectedEOF(err)
+			}
+			h.set(e.tag, value, d.order)
+		default:
+			if err := d.skip(e.length); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// peekTag returns the tag of the next element without reading it, or
+// io.EOF if there is none
+func (d *decoder) peekTag() (Tag, error) {
+	b, err := d.r.Peek(4)
+	if len(b) == 0 && err == io.EOF {
+		return 0, io.EOF
+	}
+	if err != nil {
+		return 0, unexpectedEOF(err)
+	}
+	return NewTag(d.order.Uint16(b[0:2]), d.order.Uint16(b[2:4])), nil
+}
+
+func (d *decoder) element() (element, error) {
+	var b [8]byte
+	if _, err := io.ReadFull(d.r, b[:4]); err != nil {
+		return element{}, unexpectedEOF(err)
+	}
+	e := element{tag: NewTag(d.order.Uint16(b[0:2]), d.order.Uint16(b[2:4]))}
+
+	// Items and delimiters never have a VR
+	if d.implicit || e.tag.Group() == 0xFFFE {
+		if _, err := io.ReadFull(d.r, b[:4]); err != nil {
+			return element{}, unexpectedEOF(err)
+		}
+		e.length = d.order.Uint32(b[:4])
+		return e, nil
+	}
+
+	if _, err := io.ReadFull(d.r, b[:4]); err != nil {
+		return element{}, unexpectedEOF(err)
+	}
+	e.vr = string(b[:2])
+	if b[0] < 'A' || b[0] > 'Z' || b[1] < 'A' || b[1] > 'Z' {
+		return element{}, malformed("%s has no valid VR", e.tag)
+	}
+	switch e.vr {
+	case "OB", "OD", "OF", "OL", "OV", "OW", "SQ", "SV", "UC", "UN", "UR", "UT", "UV":
+		// Two reserved bytes, then a 32-bit length
+		if _, err := io.ReadFull(d.r, b[4:8]); err != nil {
+			return element{}, unexpectedEOF(err)
+		}
+		e.length = d.order.Uint32(b[4:8])
+	default:
+		e.length = uint32(d.order.Uint16(b[2:4]))
+	}
+	return e, nil
+}
+
+// skipSequence skips the items of a sequence of undefined length, up to and
+// including its delimiter. Encapsulated pixel data has the same shape.
+func (d *decoder) skipSequence(depth int) error {
+	if depth > maxDepth {
+		return malformed("sequences nested more than %d deep", maxDepth)
+	}
+	for {
+		e, err := d.element()
+		if err != nil {
+			return err
+		}
+		switch {
+		case e.tag == tagSequenceDelimiter:
+			return nil
+		case e.tag != tagItem:
+			return malformed("unexpected %s in a sequence", e.tag)
+		case e.undefined():
+			if err := d.skipItem(depth); err != nil {
+				return err
+			}
+		default:
+			if err := d.skip(e.length); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// skipItem skips the elements of an item of undefined length, up to and
+// including its delimiter
+func (d *decoder) skipItem(depth int) error {
+	for {
+		e, err := d.element()
+		if err != nil {
+			return err
+		}
+		switch {
+		case e.tag == tagItemDelimiter:
+			return nil
+		case e.undefined():
+			if err := d.skipSequence(depth + 1); err != nil {
+				return err
+			}
+		default:
+			if err := d.skip(e.length); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (d *decoder) skip(n uint32) error {
+	_, err := d.r.Discard(int(n))
+	return unexpectedEOF(err)
+}
+
+// unexpectedEOF treats the data ending as being cut short, since it is only
+// called where more is expected
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// text is a string value without its padding
+func text(v []byte) string {
+	return strings.Trim(string(v), " \x00")
+}
+
+func uint16Value(v []byte, order binary.ByteOrder) int {
+	if len(v) < 2 {
+		return 0
+	}
+	return int(order.Uint16(v))
+}
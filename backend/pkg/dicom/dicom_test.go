@@ -0,0 +1,395 @@
+package dicom
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const ctImageStorage = "1.2.840.10008.5.1.4.1.1.2"
+
+// encoder writes data elements in one transfer syntax, for fixtures
+type encoder struct {
+	buf      bytes.Buffer
+	order    binary.ByteOrder
+	implicit bool
+}
+
+func newEncoder(syntax string) *encoder {
+	switch syntax {
+	case ImplicitVRLittleEndian:
+		return &encoder{order: binary.LittleEndian, implicit: true}
+	case ExplicitVRBigEndian:
+		return &encoder{order: binary.BigEndian}
+	}
+	return &encoder{order: binary.LittleEndian}
+}
+
+func (e *encoder) uint16(n uint16) {
+	var b [2]byte
+	e.order.PutUint16(b[:], n)
+	e.buf.Write(b[:])
+}
+
+func (e *encoder) uint32(n uint32) {
+	var b [4]byte
+	e.order.PutUint32(b[:], n)
+	e.buf.Write(b[:])
+}
+
+// header writes the tag, VR and length of an element
+func (e *encoder) header(tag Tag, vr string, length uint32) {
+	e.uint16(tag.Group())
+	e.uint16(uint16(tag))
+	if e.implicit || tag.Group() == 0xFFFE {
+		e.uint32(length)
+		return
+	}
+	e.buf.WriteString(vr)
+	switch vr {
+	case "OB", "OD", "OF", "OL", "OV", "OW", "SQ", "SV", "UC", "UN", "UR", "UT", "UV":
+		e.uint16(0)
+		e.uint32(length)
+	default:
+		e.uint16(uint16(length))
+	}
+}
+
+// str writes a string element, padded to an even length
+func (e *encoder) str(tag Tag, vr, s string) {
+	if len(s)%2 == 1 {
+		if vr == "UI" {
+			s += "\x00"
+		} else {
+			s += " "
+		}
+	}
+	e.header(tag, vr, uint32(len(s)))
+	e.buf.WriteString(s)
+}
+
+func (e *encoder) us(tag Tag, n uint16) {
+	e.header(tag, "US", 2)
+	e.uint16(n)
+}
+
+func (e *encoder) bytes(tag Tag, vr string, v []byte) {
+	e.header(tag, vr, uint32(len(v)))
+	e.buf.Write(v)
+}
+
+// sequence writes a sequence of undefined length holding what items writes
+func (e *encoder) sequence(tag Tag, items func()) {
+	e.header(tag, "SQ", undefinedLength)
+	items()
+	e.header(tagSequenceDelimiter, "", 0)
+}
+
+// item writes an item of undefined length holding what elements writes
+func (e *encoder) item(elements func()) {
+	e.header(tagItem, "", undefinedLength)
+	elements()
+	e.header(tagItemDelimiter, "", 0)
+}
+
+// fixture is what sets one image apart from another
+type fixture struct {
+	study, series, modality, sopClass, date string
+}
+
+var ct = fixture{study: "1.2.3.4", series: "1.2.3.4.1", modality: "CT", sopClass: ctImageStorage, date: "20240305"}
+
+// image writes the dataset of an image, with a nested sequence and a private
+// element that are skipped, and elements after the last attribute
+func (e *encoder) image(f fixture) {
+	e.str(NewTag(0x0008, 0x0005), "CS", "ISO_IR 192")
+	e.str(TagImageType, "CS", `ORIGINAL\PRIMARY\AXIAL`)
+	e.str(TagSOPClassUID, "UI", f.sopClass)
+	e.str(TagSOPInstanceUID, "UI", f.series+".1")
+	e.str(TagStudyDate, "DA", f.date)
+	e.str(TagModality, "CS", f.modality)
+	e.str(TagManufacturer, "LO", "Planmeca")
+	e.str(TagManufacturerModelName, "LO", "ProMax 3D")
+	e.sequence(NewTag(0x0008, 0x1140), func() {
+		e.item(func() {
+			e.str(NewTag(0x0008, 0x1150), "UI", ctImageStorage)
+			e.sequence(NewTag(0x0008, 0x9215), func() {
+				e.item(func() { e.str(NewTag(0x0008, 0x0100), "SH", "121311") })
+			})
+		})
+		// An item of defined length
+		inner := newEncoderLike(e)
+		inner.str(NewTag(0x0008, 0x1155), "UI", "1.2.3.4.1.2")
+		e.bytes(tagItem, "", inner.buf.Bytes())
+	})
+	e.bytes(NewTag(0x0009, 0x1010), "OB", bytes.Repeat([]byte{0xAB}, 300))
+	e.str(TagStudyInstanceUID, "UI", f.study)
+	e.str(TagSeriesInstanceUID, "UI", f.series)
+	e.str(TagNumberOfFrames, "IS", "576")
+	e.us(TagRows, 640)
+	e.us(TagColumns, 512)
+	e.us(NewTag(0x0028, 0x0100), 16) // Bits Allocated, past the last attribute
+	e.header(TagPixelData, "OW", undefinedLength)
+	e.buf.WriteString("not the rest of a dataset")
+}
+
+// newEncoderLike returns an encoder in e's transfer syntax
+func newEncoderLike(e *encoder) *encoder {
+	return &encoder{order: e.order, implicit: e.implicit}
+}
+
+// part10 returns a Part 10 file of a dataset in syntax, deflating it if the
+// syntax says so
+func part10(syntax string, dataset []byte) []byte {
+	if syntax == DeflatedExplicitVRLittleEndian {
+		var deflated bytes.Buffer
+		w, _ := flate.NewWriter(&deflated, flate.BestCompression)
+		w.Write(dataset)
+		w.Close()
+		dataset = deflated.Bytes()
+	}
+	return withMeta(syntax, dataset)
+}
+
+// withMeta returns a Part 10 file of data as it is, after file meta
+// information naming syntax
+func withMeta(syntax string, data []byte) []byte {
+	meta := newEncoder(ExplicitVRLittleEndian)
+	meta.str(TagMediaStorageSOPClassUID, "UI", ctImageStorage)
+	meta.str(TagTransferSyntaxUID, "UI", syntax)
+
+	file := make([]byte, preambleSize)
+	file = append(file, "DICM"...)
+	file = append(file, meta.buf.Bytes()...)
+	return append(file, data...)
+}
+
+// imageFile returns a Part 10 file of an image in syntax
+func imageFile(syntax string, f fixture) []byte {
+	e := newEncoder(syntax)
+	e.image(f)
+	return part10(syntax, e.buf.Bytes())
+}
+
+func TestParseTransferSyntaxes(t *testing.T) {
+	for _, syntax := range []string{
+		ImplicitVRLittleEndian,
+		ExplicitVRLittleEndian,
+		ExplicitVRBigEndian,
+		DeflatedExplicitVRLittleEndian,
+		"1.2.840.10008.1.2.4.90", // JPEG 2000, encoded like explicit little endian
+	} {
+		t.Run(syntax, func(t *testing.T) {
+			h, err := Parse(bytes.NewReader(imageFile(syntax, ct)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := &Header{
+				TransferSyntaxUID:     syntax,
+				SOPClassUID:           ctImageStorage,
+				SOPInstanceUID:        "1.2.3.4.1.1",
+				ImageType:             []string{"ORIGINAL", "PRIMARY", "AXIAL"},
+				Modality:              "CT",
+				StudyDate:             "20240305",
+				Manufacturer:          "Planmeca",
+				ManufacturerModelName: "ProMax 3D",
+				StudyInstanceUID:      "1.2.3.4",
+				SeriesInstanceUID:     "1.2.3.4.1",
+				NumberOfFrames:        576,
+				Rows:                  640,
+				Columns:               512,
+			}
+			if !reflect.DeepEqual(h, want) {
+				t.Errorf("header = %+v\nwant %+v", h, want)
+			}
+		})
+	}
+}
+
+// Parsing stops at the pixel data even before the last attribute, so what
+// follows it is never read
+func TestParseStopsAtPixelData(t *testing.T) {
+	e := newEncoder(ExplicitVRLittleEndian)
+	e.str(TagStudyInstanceUID, "UI", "1.2.3")
+	e.header(TagPixelData, "OB", undefinedLength)
+	e.buf.WriteString("\xff\xff")
+
+	h, err := Parse(bytes.NewReader(part10(ExplicitVRLittleEndian, e.buf.Bytes())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.StudyInstanceUID != "1.2.3" || h.Rows != 0 {
+		t.Errorf("header = %+v", h)
+	}
+}
+
+// The SOP class falls back to the file meta information's, and a dataset may
+// end before the last attribute
+func TestParseShortDataset(t *testing.T) {
+	e := newEncoder(ExplicitVRLittleEndian)
+	e.str(TagModality, "CS", "PX")
+
+	h, err := Parse(bytes.NewReader(part10(ExplicitVRLittleEndian, e.buf.Bytes())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.SOPClassUID != ctImageStorage || h.Modality != "PX" {
+		t.Errorf("header = %+v", h)
+	}
+}
+
+// nested returns a dataset of depth sequences nested in one another
+func nested(depth int) []byte {
+	e := newEncoder(ExplicitVRLittleEndian)
+	var open func(level int)
+	open = func(level int) {
+		e.sequence(NewTag(0x0008, 0x1140), func() {
+			e.item(func() {
+				if level < depth {
+					open(level + 1)
+				}
+			})
+		})
+	}
+	open(1)
+	e.str(TagStudyInstanceUID, "UI", "1.2.3")
+	return part10(ExplicitVRLittleEndian, e.buf.Bytes())
+}
+
+func TestParseMaxDepth(t *testing.T) {
+	h, err := Parse(bytes.NewReader(nested(maxDepth)))
+	if err != nil {
+		t.Fatalf("%d nested sequences: %v", maxDepth, err)
+	}
+	if h.StudyInstanceUID != "1.2.3" {
+		t.Errorf("Study Instance UID after the sequences = %q", h.StudyInstanceUID)
+	}
+
+	_, err = Parse(bytes.NewReader(nested(maxDepth + 1)))
+	if !errors.Is(err, ErrNotDICOM) || !strings.Contains(err.Error(), "nested") {
+		t.Errorf("%d nested sequences: err = %v, want too deep", maxDepth+1, err)
+	}
+}
+
+// Every cut through a file either ends the dataset between elements or is
+// reported as truncated, never as another error
+func TestParseTruncated(t *testing.T) {
+	for _, syntax := range []string{ImplicitVRLittleEndian, ExplicitVRLittleEndian, ExplicitVRBigEndian, DeflatedExplicitVRLittleEndian} {
+		file := imageFile(syntax, ct)
+		for n := range len(file) {
+			_, err := Parse(bytes.NewReader(file[:n]))
+			if err != nil && !errors.Is(err, ErrNotDICOM) {
+				t.Fatalf("%s cut at %d: err = %v, want ErrNotDICOM", syntax, n, err)
+			}
+		}
+	}
+
+	// Cut inside the Study Instance UID's value
+	file := imageFile(ExplicitVRLittleEndian, ct)
+	cut := bytes.Index(file, []byte("1.2.3.4\x00")) + 3
+	_, err := Parse(bytes.NewReader(file[:cut]))
+	if !errors.Is(err, ErrNotDICOM) || !strings.Contains(err.Error(), "truncated") {
+		t.Errorf("err = %v, want truncated", err)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	explicit := func(write func(e *encoder)) []byte {
+		e := newEncoder(ExplicitVRLittleEndian)
+		write(e)
+		return part10(ExplicitVRLittleEndian, e.buf.Bytes())
+	}
+	noSyntax := newEncoder(ExplicitVRLittleEndian)
+	noSyntax.str(TagMediaStorageSOPClassUID, "UI", ctImageStorage)
+
+	tests := []struct {
+		name string
+		file []byte
+		want string
+	}{
+		{"empty", nil, "shorter than the DICOM preamble"},
+		{"short", make([]byte, preambleSize+2), "shorter than the DICOM preamble"},
+		{"no DICM", make([]byte, preambleSize+64), "no DICM prefix"},
+		{"no transfer syntax", append(append(make([]byte, preambleSize), "DICM"...), noSyntax.buf.Bytes()...), "no transfer syntax"},
+		{"invalid VR", explicit(func(e *encoder) { e.header(TagModality, "c5", 2) }), "no valid VR"},
+		{"element in a sequence", explicit(func(e *encoder) {
+			e.header(NewTag(0x0008, 0x1140), "SQ", undefinedLength)
+			e.str(TagModality, "CS", "CT")
+		}), "in a sequence"},
+		{"attribute too long", explicit(func(e *encoder) { e.header(TagManufacturer, "UT", maxValueLength+1) }), "bytes long"},
+		{"corrupt deflate", withMeta(DeflatedExplicitVRLittleEndian, []byte{0xFF, 0xFF, 0xFF, 0xFF}), "corrupt"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(bytes.NewReader(tt.file))
+			if !errors.Is(err, ErrNotDICOM) || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("err = %v, want ErrNotDICOM: ...%s...", err, tt.want)
+			}
+		})
+	}
+}
+
+// Parse stops at any tag past the last attribute, items' included, but the
+// decoder rejects a stray item wherever it is asked to read on
+func TestReadItemOutsideSequence(t *testing.T) {
+	e := newEncoder(ExplicitVRLittleEndian)
+	e.header(tagItem, "", 0)
+
+	d := &decoder{r: bufio.NewReader(&e.buf), order: binary.LittleEndian}
+	err := d.read(&Header{}, func(Tag) bool { return false })
+	if !errors.Is(err, ErrNotDICOM) || !strings.Contains(err.Error(), "outside a sequence") {
+		t.Errorf("err = %v, want an item outside a sequence", err)
+	}
+}
+
+// failingReader fails after the preamble
+type failingReader struct{ r io.Reader }
+
+var errDisk = errors.New("disk on fire")
+
+func (f *failingReader) Read(p []byte) (int, error) {
+	n, err := f.r.Read(p)
+	if err == io.EOF {
+		return n, errDisk
+	}
+	return n, err
+}
+
+// Failures to read are not mistaken for files that are not DICOM
+func TestParseReadError(t *testing.T) {
+	for _, n := range []int{10, preambleSize + 20} {
+		file := imageFile(ExplicitVRLittleEndian, ct)[:n]
+		_, err := Parse(&failingReader{bytes.NewReader(file)})
+		if !errors.Is(err, errDisk) || errors.Is(err, ErrNotDICOM) {
+			t.Errorf("failing after %d bytes: err = %v, want the read error", n, err)
+		}
+	}
+}
+
+func TestSniff(t *testing.T) {
+	file := imageFile(ExplicitVRLittleEndian, ct)
+	if !Sniff(file[:preambleSize+4]) {
+		t.Error("Sniff rejected a Part 10 file")
+	}
+	if Sniff(file[:preambleSize+3]) {
+		t.Error("Sniff accepted a prefix too short to tell")
+	}
+	if Sniff(make([]byte, preambleSize+4)) {
+		t.Error("Sniff accepted zeros")
+	}
+}
+
+func TestTag(t *testing.T) {
+	if got := TagPixelData.String(); got != "(7FE0,0010)" {
+		t.Errorf("String = %s", got)
+	}
+	if got := TagStudyInstanceUID.Group(); got != 0x0020 {
+		t.Errorf("Group = %04X", got)
+	}
+}
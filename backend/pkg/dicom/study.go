@@ -0,0 +1,198 @@
+package dicom
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// ErrMixedStudies means an archive holds the files of several studies
+var ErrMixedStudies = errors.New("files belong to more than one study")
+
+// Kind is what sort of imaging a study holds
+type Kind string
+
+const (
+	KindVolume    Kind = "volume"    // CT or CBCT
+	KindPanoramic Kind = "panoramic" // 2D panoramic X-ray
+	KindOther     Kind = "other"
+)
+
+// SOP classes of CT images; CBCT scanners store theirs as CT too
+var ctStorage = map[string]bool{
+	"1.2.840.10008.5.1.4.1.1.2":   true, // CT Image Storage
+	"1.2.840.10008.5.1.4.1.1.2.1": true, // Enhanced CT Image Storage
+	"1.2.840.10008.5.1.4.1.1.2.2": true, // Legacy Converted Enhanced CT Image Storage
+}
+
+// Kind classifies the image of a file by its modality, or by its SOP class
+// if it has none
+func (h *Header) Kind() Kind {
+	switch {
+	case h.Modality == "CT" || h.Modality == "" && ctStorage[h.SOPClassUID]:
+		return KindVolume
+	case h.Modality == "PX":
+		return KindPanoramic
+	}
+	return KindOther
+}
+
+// Study sums up the files of one study
+type Study struct {
+	StudyInstanceUID string
+	Modality         string
+	StudyDate        string // YYYY-MM-DD, empty if the files have no valid date
+	Manufacturer     string
+	Kind             Kind
+	SeriesCount      int
+	InstanceCount    int
+}
+
+// Summarize sums up the headers of the files of a study. It fails unless
+// they all belong to the same study.
+func Summarize(headers []*Header) (*Study, error) {
+	if len(headers) == 0 {
+		return nil, fmt.Errorf("%w: no DICOM files", ErrNotDICOM)
+	}
+
+	study := &Study{StudyInstanceUID: headers[0].StudyInstanceUID, Kind: KindOther}
+	series := map[string]bool{}
+	for _, h := range headers {
+		if h.StudyInstanceUID == "" {
+			return nil, fmt.Errorf("%w: no Study Instance UID", ErrNotDICOM)
+		}
+		if h.StudyInstanceUID != study.StudyInstanceUID {
+			return nil, ErrMixedStudies
+		}
+		series[h.SeriesInstanceUID] = true
+
+		// The study is described by its most telling image: a volume over a
+		// panoramic image over anything else
+		if kind := h.Kind(); study.Modality == "" || rank(kind) > rank(study.Kind) {
+			study.Kind = kind
+			study.Modality = h.Modality
+			study.Manufacturer = h.Manufacturer
+		}
+		if study.StudyDate == "" {
+			study.StudyDate = isoDate(h.StudyDate)
+		}
+	}
+	study.SeriesCount = len(series)
+	study.InstanceCount = len(headers)
+	return study, nil
+}
+
+func rank(kind Kind) int {
+	switch kind {
+	case KindVolume:
+		return 2
+	case KindPanoramic:
+		return 1
+	}
+	return 0
+}
+
+// isoDate turns a DICOM date, YYYYMMDD or the older YYYY.MM.DD, into
+// YYYY-MM-DD
+func isoDate(date string) string {
+	t, err := time.Parse("20060102", strings.ReplaceAll(date, ".", ""))
+	if err != nil {
+		return ""
+	}
+	return t.Format("2006-01-02")
+}
+
+// Inspect reads the headers of a Part 10 file, or of every file in a ZIP
+// archive of them, and sums up the study they hold
+func Inspect(name string) (*Study, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(f, magic); err == nil && bytes.Equal(magic, zipMagic) {
+		info, err := f.Stat()
+		if err != nil {
+			return nil, err
+		}
+		return inspectArchive(f, info.Size())
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	h, err := Parse(f)
+	if err != nil {
+		return nil, err
+	}
+	return Summarize([]*Header{h})
+}
+
+var zipMagic = []byte("PK\x03\x04")
+
+// IsArchive reports whether prefix is the start of a ZIP archive
+func IsArchive(prefix []byte) bool {
+	return bytes.HasPrefix(prefix, zipMagic)
+}
+
+func inspectArchive(r io.ReaderAt, size int64) (*Study, error) {
+	archive, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("%w: unreadable ZIP archive: %v", ErrNotDICOM, err)
+	}
+
+	var headers []*Header
+	for _, file := range archive.File {
+		if skipEntry(file) {
+			continue
+		}
+		h, err := parseEntry(file)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file.Name, err)
+		}
+		headers = append(headers, h)
+	}
+	return Summarize(headers)
+}
+
+// skipEntry reports whether an archive entry is not an image: directories,
+// the DICOMDIR index and files operating systems leave behind
+func skipEntry(file *zip.File) bool {
+	base := path.Base(file.Name)
+	return file.FileInfo().IsDir() ||
+		strings.EqualFold(base, "DICOMDIR") ||
+		strings.HasPrefix(base, ".") ||
+		strings.HasPrefix(file.Name, "__MACOSX/") ||
+		strings.EqualFold(base, "Thumbs.db")
+}
+
+// parseEntry parses a file in an archive. A file that cannot be read means
+// a corrupt archive, which counts as not DICOM too.
+func parseEntry(file *zip.File) (*Header, error) {
+	rc, err := file.Open()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNotDICOM, err)
+	}
+	defer rc.Close()
+
+	h, err := Parse(rc)
+	if err != nil && !errors.Is(err, ErrNotDICOM) {
+		return nil, fmt.Errorf("%w: %v", ErrNotDICOM, err)
+	}
+	return h, err
+}
+
+// UIDFromUUID derives a UID from a UUID, under the 2.25 root DICOM reserves
+// for that
+func UIDFromUUID(id [16]byte) string {
+	return "2.25." + new(big.Int).SetBytes(id[:]).String()
+}
@@ -0,0 +1,227 @@
+package dicom
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestKind(t *testing.T) {
+	tests := []struct {
+		modality, sopClass string
+		want               Kind
+	}{
+		{"CT", "", KindVolume},
+		{"", ctImageStorage, KindVolume},
+		{"", "1.2.840.10008.5.1.4.1.1.2.1", KindVolume},
+		{"PX", "", KindPanoramic},
+		{"MR", ctImageStorage, KindOther},
+		{"", "1.2.840.10008.5.1.4.1.1.1.3", KindOther},
+	}
+	for _, tt := range tests {
+		h := &Header{Modality: tt.modality, SOPClassUID: tt.sopClass}
+		if got := h.Kind(); got != tt.want {
+			t.Errorf("Kind of %q %q = %s, want %s", tt.modality, tt.sopClass, got, tt.want)
+		}
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	headers := []*Header{
+		{StudyInstanceUID: "1.2.3", SeriesInstanceUID: "1.2.3.1", Modality: "OT", Manufacturer: "Other", StudyDate: "bad"},
+		{StudyInstanceUID: "1.2.3", SeriesInstanceUID: "1.2.3.2", Modality: "PX", Manufacturer: "Vatech", StudyDate: "2024.03.05"},
+		{StudyInstanceUID: "1.2.3", SeriesInstanceUID: "1.2.3.3", Modality: "CT", Manufacturer: "Planmeca", StudyDate: "20240306"},
+		{StudyInstanceUID: "1.2.3", SeriesInstanceUID: "1.2.3.3", Modality: "CT", Manufacturer: "Planmeca"},
+		{StudyInstanceUID: "1.2.3", SeriesInstanceUID: "1.2.3.2", Modality: "PX", Manufacturer: "Vatech"},
+	}
+	study, err := Summarize(headers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Study{
+		StudyInstanceUID: "1.2.3",
+		Modality:         "CT",
+		StudyDate:        "2024-03-05",
+		Manufacturer:     "Planmeca",
+		Kind:             KindVolume,
+		SeriesCount:      3,
+		InstanceCount:    5,
+	}
+	if *study != want {
+		t.Errorf("study = %+v\nwant %+v", *study, want)
+	}
+}
+
+func TestSummarizeErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers []*Header
+		err     error
+	}{
+		{"no files", nil, ErrNotDICOM},
+		{"no study", []*Header{{Modality: "CT"}}, ErrNotDICOM},
+		{"two studies", []*Header{{StudyInstanceUID: "1.2.3"}, {StudyInstanceUID: "1.2.4"}}, ErrMixedStudies},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Summarize(tt.headers); !errors.Is(err, tt.err) {
+				t.Errorf("err = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestIsoDate(t *testing.T) {
+	for in, want := range map[string]string{
+		"20240305":   "2024-03-05",
+		"2024.03.05": "2024-03-05",
+		"20241305":   "",
+		"2024":       "",
+		"":           "",
+	} {
+		if got := isoDate(in); got != want {
+			t.Errorf("isoDate(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+// writeFile writes data to a file in a temporary directory and returns its
+// name
+func writeFile(t *testing.T, data []byte) string {
+	t.Helper()
+	name := filepath.Join(t.TempDir(), "upload")
+	if err := os.WriteFile(name, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+// archive returns a ZIP archive of files, by name. A name ending in / is a
+// directory.
+func archive(t *testing.T, files map[string][]byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for name, data := range files {
+		f, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestInspectFile(t *testing.T) {
+	study, err := Inspect(writeFile(t, imageFile(ExplicitVRLittleEndian, ct)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if study.StudyInstanceUID != "1.2.3.4" || study.Kind != KindVolume || study.StudyDate != "2024-03-05" ||
+		study.SeriesCount != 1 || study.InstanceCount != 1 {
+		t.Errorf("study = %+v", study)
+	}
+}
+
+func TestInspectArchive(t *testing.T) {
+	panoramic := fixture{study: ct.study, series: "1.2.3.4.2", modality: "PX", sopClass: "1.2.840.10008.5.1.4.1.1.1.1", date: "20240305"}
+	name := writeFile(t, archive(t, map[string][]byte{
+		"scan/":                  nil,
+		"scan/IM0001":            imageFile(ImplicitVRLittleEndian, ct),
+		"scan/IM0002.dcm":        imageFile(DeflatedExplicitVRLittleEndian, ct),
+		"scan/pano/IM0001":       imageFile(ExplicitVRBigEndian, panoramic),
+		"DICOMDIR":               []byte("an index, not an image"),
+		"scan/.DS_Store":         []byte("junk"),
+		"__MACOSX/scan/._IM0001": []byte("junk"),
+		"scan/Thumbs.db":         []byte("junk"),
+	}))
+
+	study, err := Inspect(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Study{
+		StudyInstanceUID: "1.2.3.4",
+		Modality:         "CT",
+		StudyDate:        "2024-03-05",
+		Manufacturer:     "Planmeca",
+		Kind:             KindVolume,
+		SeriesCount:      2,
+		InstanceCount:    3,
+	}
+	if *study != want {
+		t.Errorf("study = %+v\nwant %+v", *study, want)
+	}
+}
+
+func TestInspectErrors(t *testing.T) {
+	other := fixture{study: "1.2.3.5", series: "1.2.3.5.1", modality: "CT", sopClass: ctImageStorage, date: "20240306"}
+	truncated := imageFile(ExplicitVRLittleEndian, ct)
+	truncated = truncated[:bytes.Index(truncated, []byte("Planmeca"))+3]
+
+	tests := []struct {
+		name string
+		file []byte
+		err  error
+		want string // In the message
+	}{
+		{"mixed studies", archive(t, map[string][]byte{
+			"a.dcm": imageFile(ExplicitVRLittleEndian, ct),
+			"b.dcm": imageFile(ExplicitVRLittleEndian, other),
+		}), ErrMixedStudies, ""},
+		{"file not DICOM in an archive", archive(t, map[string][]byte{
+			"a.dcm":      imageFile(ExplicitVRLittleEndian, ct),
+			"readme.txt": []byte("scanned on the ProMax"),
+		}), ErrNotDICOM, "readme.txt"},
+		{"truncated file in an archive", archive(t, map[string][]byte{"a.dcm": truncated}), ErrNotDICOM, "truncated"},
+		{"archive without images", archive(t, map[string][]byte{"DICOMDIR": nil, "scan/": nil}), ErrNotDICOM, "no DICOM files"},
+		{"corrupt archive", append([]byte("PK\x03\x04"), make([]byte, 100)...), ErrNotDICOM, "ZIP"},
+		{"truncated file", truncated, ErrNotDICOM, "truncated"},
+		{"not DICOM", []byte(strings.Repeat("%PDF-1.7 ", 30)), ErrNotDICOM, "DICM"},
+		{"empty", nil, ErrNotDICOM, "preamble"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Inspect(writeFile(t, tt.file))
+			if !errors.Is(err, tt.err) || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("err = %v, want %v: ...%s...", err, tt.err, tt.want)
+			}
+		})
+	}
+
+	// A file that cannot be opened is not a verdict on its content
+	if _, err := Inspect(filepath.Join(t.TempDir(), "missing")); err == nil || errors.Is(err, ErrNotDICOM) {
+		t.Errorf("missing file: err = %v", err)
+	}
+}
+
+func TestIsArchive(t *testing.T) {
+	if !IsArchive(archive(t, map[string][]byte{"a": nil})) {
+		t.Error("IsArchive rejected a ZIP archive")
+	}
+	if IsArchive(imageFile(ExplicitVRLittleEndian, ct)) || IsArchive([]byte("PK")) {
+		t.Error("IsArchive accepted what is not a ZIP archive")
+	}
+}
+
+func TestUIDFromUUID(t *testing.T) {
+	var zero, ones [16]byte
+	for i := range ones {
+		ones[i] = 0xFF
+	}
+	if got := UIDFromUUID(zero); got != "2.25.0" {
+		t.Errorf("UIDFromUUID(zero) = %s", got)
+	}
+	if got, want := UIDFromUUID(ones), "2.25.340282366920938463463374607431768211455"; got != want {
+		t.Errorf("UIDFromUUID(ones) = %s, want %s", got, want)
+	}
+}
@@ -8,72 +8,126 @@ import (
 	"github.com/google/uuid"
 )
 
+// Token types carried in the token_type claim
+const (
+	TokenTypeAccess       = "access"
+	TokenTypeRefresh      = "refresh"
+	TokenTypeMFAChallenge = "mfa_challenge" // Password verified, second factor pending
+)
+
+var ErrWrongTokenType = errors.New("wrong token type")
+
 type Claims struct {
-	UserID uuid.UUID `json:"user_id"`
-	Email  string    `json:"email"`
-	Role   string    `json:"role"`
+	UserID    uuid.UUID `json:"user_id"`
+	Email     string    `json:"email"`
+	Role      string    `json:"role"`
+	TokenType string    `json:"token_type"`
+	SessionID uuid.UUID `json:"sid,omitempty"` // Server-side session the token belongs to
+	MFA       bool      `json:"mfa,omitempty"` // Session was authenticated with a second factor
+	Actor     *Actor    `json:"act,omitempty"` // Set when an admin acts as the user
 	jwt.RegisteredClaims
 }
 
+// Actor is the admin behind an impersonation token (RFC 8693 act claim)
+type Actor struct {
+	UserID uuid.UUID `json:"sub"`
+}
+
+// Subject describes who a token pair is issued to
+type Subject struct {
+	UserID    uuid.UUID
+	Email     string
+	Role      string
+	SessionID uuid.UUID
+	MFA       bool
+	Actor     *Actor
+}
+
 type TokenPair struct {
 	AccessToken  string `json:"access_token"`
 	RefreshToken string `json:"refresh_token"`
+
+	// Server-side bookkeeping for the refresh token, not sent to clients
+	RefreshTokenID   uuid.UUID `json:"-"`
+	RefreshExpiresAt time.Time `json:"-"`
 }
 
-func GenerateTokenPair(userID uuid.UUID, email, role, secret string, accessTTL, refreshTTL time.Duration) (*TokenPair, error) {
+// GenerateTokenPair issues an access token and a refresh token for the
+// subject's session.
+func GenerateTokenPair(subject Subject, keys *Keyring, accessTTL, refreshTTL time.Duration) (*TokenPair, error) {
+	now := time.Now()
+
 	// Access token
-	accessClaims := &Claims{
-		UserID: userID,
-		Email:  email,
-		Role:   role,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(accessTTL)),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-		},
-	}
-	accessToken := jwt.NewWithClaims(jwt.SigningMethodHS256, accessClaims)
-	accessString, err := accessToken.SignedString([]byte(secret))
+	accessClaims := subject.claims(TokenTypeAccess, uuid.New(), now, accessTTL)
+	accessString, err := keys.Sign(accessClaims)
 	if err != nil {
 		return nil, err
 	}
 
 	// Refresh token
-	refreshClaims := &Claims{
-		UserID: userID,
-		Email:  email,
-		Role:   role,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(refreshTTL)),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-		},
-	}
-	refreshToken := jwt.NewWithClaims(jwt.SigningMethodHS256, refreshClaims)
-	refreshString, err := refreshToken.SignedString([]byte(secret))
+	refreshID := uuid.New()
+	refreshClaims := subject.claims(TokenTypeRefresh, refreshID, now, refreshTTL)
+	refreshString, err := keys.Sign(refreshClaims)
 	if err != nil {
 		return nil, err
 	}
 
 	return &TokenPair{
-		AccessToken:  accessString,
-		RefreshToken: refreshString,
+		AccessToken:      accessString,
+		RefreshToken:     refreshString,
+		RefreshTokenID:   refreshID,
+		RefreshExpiresAt: refreshClaims.ExpiresAt.Time,
 	}, nil
 }
 
-func ValidateToken(tokenString, secret string) (*Claims, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, errors.New("invalid signing method")
-		}
-		return []byte(secret), nil
-	})
+// GenerateAccessToken issues a lone access token with no refresh token, for
+// impersonation sessions that must not outlive ttl
+func GenerateAccessToken(subject Subject, keys *Keyring, ttl time.Duration) (string, error) {
+	return keys.Sign(subject.claims(TokenTypeAccess, uuid.New(), time.Now(), ttl))
+}
+
+// GenerateMFAChallenge issues a short-lived token proving that the user has
+// passed the password step of a login. It is exchanged, together with a
+// second factor, for a real token pair.
+func GenerateMFAChallenge(userID uuid.UUID, email, role string, keys *Keyring, ttl time.Duration) (string, error) {
+	subject := Subject{UserID: userID, Email: email, Role: role}
+	return keys.Sign(subject.claims(TokenTypeMFAChallenge, uuid.New(), time.Now(), ttl))
+}
+
+func (s Subject) claims(tokenType string, id uuid.UUID, now time.Time, ttl time.Duration) *Claims {
+	return &Claims{
+		UserID:    s.UserID,
+		Email:     s.Email,
+		Role:      s.Role,
+		TokenType: tokenType,
+		SessionID: s.SessionID,
+		MFA:       s.MFA,
+		Actor:     s.Actor,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        id.String(),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+	}
+}
+
+// ValidateToken verifies the signature and expiry of a token against the
+// keyring and checks that it is of the expected type.
+func ValidateToken(tokenString string, keys *Keyring, tokenType string) (*Claims, error) {
+	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, keys.keyFunc)
 
 	if err != nil {
 		return nil, err
 	}
 
-	if claims, ok := token.Claims.(*Claims); ok && token.Valid {
-		return claims, nil
+	claims, ok := token.Claims.(*Claims)
+	if !ok || !token.Valid {
+		return nil, errors.New("invalid token")
+	}
+
+	if claims.TokenType != tokenType {
+		return nil, ErrWrongTokenType
 	}
 
-	return nil, errors.New("invalid token")
+	return claims, nil
 }
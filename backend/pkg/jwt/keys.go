@@ -0,0 +1,263 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Signing algorithms
+const (
+	AlgorithmHS256 = "HS256"
+	AlgorithmRS256 = "RS256"
+	AlgorithmEdDSA = "EdDSA"
+)
+
+const rsaKeyBits = 2048
+
+var (
+	ErrUnknownKey       = errors.New("unknown signing key")
+	ErrNoSigningKey     = errors.New("no signing key available")
+	ErrUnknownAlgorithm = errors.New("unknown signing algorithm")
+)
+
+// Key is a signing key identified by the kid header of the tokens it signs
+type Key struct {
+	ID        string
+	Algorithm string
+
+	signKey   interface{} // []byte for HMAC, crypto.Signer otherwise
+	verifyKey interface{}
+}
+
+// NewHMACKey wraps a shared secret. HMAC keys can't be published, so they
+// are only usable by services that hold the secret.
+func NewHMACKey(id, secret string) *Key {
+	return &Key{ID: id, Algorithm: AlgorithmHS256, signKey: []byte(secret), verifyKey: []byte(secret)}
+}
+
+// GenerateKey creates a new asymmetric key for the algorithm
+func GenerateKey(id, algorithm string) (*Key, error) {
+	switch algorithm {
+	case AlgorithmRS256:
+		priv, err := rsa.GenerateKey(rand.Reader, rsaKeyBits)
+		if err != nil {
+			return nil, err
+		}
+		return &Key{ID: id, Algorithm: algorithm, signKey: priv, verifyKey: &priv.PublicKey}, nil
+	case AlgorithmEdDSA:
+		pub, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		return &Key{ID: id, Algorithm: algorithm, signKey: priv, verifyKey: pub}, nil
+	default:
+		return nil, ErrUnknownAlgorithm
+	}
+}
+
+// ParsePrivateKey loads an asymmetric key stored with MarshalPrivateKey
+func ParsePrivateKey(id, algorithm string, pemBytes []byte) (*Key, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, errors.New("invalid PEM data")
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	switch priv := parsed.(type) {
+	case *rsa.PrivateKey:
+		if algorithm != AlgorithmRS256 {
+			return nil, fmt.Errorf("key %s is RSA, not %s", id, algorithm)
+		}
+		return &Key{ID: id, Algorithm: algorithm, signKey: priv, verifyKey: &priv.PublicKey}, nil
+	case ed25519.PrivateKey:
+		if algorithm != AlgorithmEdDSA {
+			return nil, fmt.Errorf("key %s is Ed25519, not %s", id, algorithm)
+		}
+		return &Key{ID: id, Algorithm: algorithm, signKey: priv, verifyKey: priv.Public()}, nil
+	default:
+		return nil, ErrUnknownAlgorithm
+	}
+}
+
+// MarshalPrivateKey encodes an asymmetric key as PKCS#8 PEM
+func (k *Key) MarshalPrivateKey() ([]byte, error) {
+	if _, ok := k.signKey.(crypto.Signer); !ok {
+		return nil, errors.New("only asymmetric keys can be marshalled")
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(k.signKey)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+func (k *Key) method() jwt.SigningMethod {
+	switch k.Algorithm {
+	case AlgorithmRS256:
+		return jwt.SigningMethodRS256
+	case AlgorithmEdDSA:
+		return jwt.SigningMethodEdDSA
+	default:
+		return jwt.SigningMethodHS256
+	}
+}
+
+// JWK is the public part of a key in JSON Web Key format
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+}
+
+// JWKSet is the document served at /.well-known/jwks.json
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+func (k *Key) jwk() (JWK, bool) {
+	enc := base64.RawURLEncoding
+	switch pub := k.verifyKey.(type) {
+	case *rsa.PublicKey:
+		return JWK{
+			KeyType:   "RSA",
+			KeyID:     k.ID,
+			Use:       "sig",
+			Algorithm: k.Algorithm,
+			N:         enc.EncodeToString(pub.N.Bytes()),
+			E:         enc.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}, true
+	case ed25519.PublicKey:
+		return JWK{
+			KeyType:   "OKP",
+			KeyID:     k.ID,
+			Use:       "sig",
+			Algorithm: k.Algorithm,
+			Curve:     "Ed25519",
+			X:         enc.EncodeToString(pub),
+		}, true
+	default:
+		return JWK{}, false
+	}
+}
+
+// Keyring holds the key new tokens are signed with and every key tokens are
+// still accepted from. Keys are swapped atomically by SetKeys, so the keyring
+// can be shared between requests while keys rotate.
+type Keyring struct {
+	mu      sync.RWMutex
+	signing *Key
+	keys    map[string]*Key
+
+	// Tokens without a kid header that no key in keys verifies were signed
+	// with the shared secret before key rotation; they are accepted until
+	// legacyUntil
+	legacy      *Key
+	legacyUntil time.Time
+}
+
+func NewKeyring() *Keyring {
+	return &Keyring{keys: make(map[string]*Key)}
+}
+
+// SetKeys replaces the signing key and the set of keys accepted for
+// verification. The signing key is always accepted.
+func (kr *Keyring) SetKeys(signing *Key, verify []*Key) {
+	keys := make(map[string]*Key, len(verify)+1)
+	for _, k := range verify {
+		keys[k.ID] = k
+	}
+	if signing != nil {
+		keys[signing.ID] = signing
+	}
+
+	kr.mu.Lock()
+	kr.signing = signing
+	kr.keys = keys
+	kr.mu.Unlock()
+}
+
+// SetLegacy accepts tokens without a kid header signed with key until the
+// time until. A nil key accepts none.
+func (kr *Keyring) SetLegacy(key *Key, until time.Time) {
+	kr.mu.Lock()
+	kr.legacy = key
+	kr.legacyUntil = until
+	kr.mu.Unlock()
+}
+
+// Sign signs claims with the current signing key
+func (kr *Keyring) Sign(claims jwt.Claims) (string, error) {
+	kr.mu.RLock()
+	key := kr.signing
+	kr.mu.RUnlock()
+
+	if key == nil {
+		return "", ErrNoSigningKey
+	}
+
+	token := jwt.NewWithClaims(key.method(), claims)
+	if key.ID != "" {
+		token.Header["kid"] = key.ID
+	}
+	return token.SignedString(key.signKey)
+}
+
+// keyFunc selects the verification key by the kid header and makes sure the
+// token uses that key's algorithm. A HS256 signing key has no ID, so tokens
+// without a kid are its own before they are legacy ones.
+func (kr *Keyring) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	kr.mu.RLock()
+	key := kr.keys[kid]
+	if key == nil && kid == "" && time.Now().Before(kr.legacyUntil) {
+		key = kr.legacy
+	}
+	kr.mu.RUnlock()
+
+	if key == nil {
+		return nil, ErrUnknownKey
+	}
+	if token.Method.Alg() != key.method().Alg() {
+		return nil, errors.New("invalid signing method")
+	}
+
+	return key.verifyKey, nil
+}
+
+// JWKS returns the public keys tokens are accepted from. HMAC keys are
+// never included.
+func (kr *Keyring) JWKS() JWKSet {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	set := JWKSet{Keys: []JWK{}}
+	for _, k := range kr.keys {
+		if jwk, ok := k.jwk(); ok {
+			set.Keys = append(set.Keys, jwk)
+		}
+	}
+	return set
+}
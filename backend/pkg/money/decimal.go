@@ -0,0 +1,271 @@
+// Package money holds amounts exactly, in decimal, with their ISO 4217
+// currency. Amounts are never floats: they are parsed from and written as
+// decimal strings, and stored in numeric columns.
+package money
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Scale is the number of decimal places a Decimal holds. It covers the minor
+// units of every currency and the precision of percentages.
+const Scale = 4
+
+const unit = 10000 // 10^Scale
+
+// maxUnits bounds a Decimal to what a numeric(18,4) column holds, 14 integer
+// digits. Sums and products are checked against it, and rounding a Decimal
+// in range stays within an int64.
+const maxUnits = 999_999_999_999_999_999
+
+var (
+	ErrSyntax   = errors.New("invalid decimal number")
+	ErrRange    = errors.New("decimal number out of range")
+	ErrTooExact = errors.New("too many decimal places")
+)
+
+// Decimal is a fixed-point number with Scale decimal places and up to 14
+// integer digits. The zero value is 0.
+type Decimal struct {
+	units int64 // Value times 10^Scale
+}
+
+// Int returns n as a Decimal
+func Int(n int64) (Decimal, error) {
+	if n > maxUnits/unit || n < -maxUnits/unit {
+		return Decimal{}, ErrRange
+	}
+	return Decimal{units: n * unit}, nil
+}
+
+// fromUnits returns a Decimal of units, or ErrRange if it has too many digits
+func fromUnits(units int64) (Decimal, error) {
+	if units > maxUnits || units < -maxUnits {
+		return Decimal{}, ErrRange
+	}
+	return Decimal{units: units}, nil
+}
+
+// fromBig is fromUnits for results that may not fit an int64
+func fromBig(units *big.Int) (Decimal, error) {
+	if !units.IsInt64() {
+		return Decimal{}, ErrRange
+	}
+	return fromUnits(units.Int64())
+}
+
+// ParseDecimal parses a decimal number such as "-12.5". It does not round:
+// more than Scale decimal places is an error.
+func ParseDecimal(s string) (Decimal, error) {
+	s = strings.TrimSpace(s)
+	negative := strings.HasPrefix(s, "-")
+	if negative || strings.HasPrefix(s, "+") {
+		s = s[1:]
+	}
+
+	whole, fraction, _ := strings.Cut(s, ".")
+	if whole == "" && fraction == "" {
+		return Decimal{}, ErrSyntax
+	}
+	if len(fraction) > Scale {
+		if strings.Trim(fraction[Scale:], "0") != "" {
+			return Decimal{}, ErrTooExact
+		}
+		fraction = fraction[:Scale]
+	}
+	digits := whole + fraction + strings.Repeat("0", Scale-len(fraction))
+	for _, r := range digits {
+		if r < '0' || r > '9' {
+			return Decimal{}, ErrSyntax
+		}
+	}
+
+	units, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil {
+		return Decimal{}, ErrRange
+	}
+	if negative {
+		units = -units
+	}
+	return fromUnits(units)
+}
+
+// MustParseDecimal is ParseDecimal for constants; it panics on error
+func MustParseDecimal(s string) Decimal {
+	d, err := ParseDecimal(s)
+	if err != nil {
+		panic(fmt.Sprintf("money: %q: %v", s, err))
+	}
+	return d
+}
+
+func (d Decimal) Sign() int {
+	switch {
+	case d.units < 0:
+		return -1
+	case d.units > 0:
+		return 1
+	}
+	return 0
+}
+
+func (d Decimal) IsZero() bool { return d.units == 0 }
+
+// Cmp returns -1, 0 or 1 as d is less than, equal to or greater than e
+func (d Decimal) Cmp(e Decimal) int {
+	switch {
+	case d.units < e.units:
+		return -1
+	case d.units > e.units:
+		return 1
+	}
+	return 0
+}
+
+// Add returns d plus e. Neither is over maxUnits, so the sum fits an int64
+// and only its range needs checking.
+func (d Decimal) Add(e Decimal) (Decimal, error) { return fromUnits(d.units + e.units) }
+
+func (d Decimal) Sub(e Decimal) (Decimal, error) { return fromUnits(d.units - e.units) }
+
+// MulInt returns d times n
+func (d Decimal) MulInt(n int64) (Decimal, error) {
+	return fromBig(new(big.Int).Mul(big.NewInt(d.units), big.NewInt(n)))
+}
+
+// Mul returns d times e, rounded to Scale places
+func (d Decimal) Mul(e Decimal) (Decimal, error) {
+	product := new(big.Int).Mul(big.NewInt(d.units), big.NewInt(e.units))
+	return fromBig(roundDiv(product, unit))
+}
+
+// Percent returns percent of d, rounded to Scale places
+func (d Decimal) Percent(percent Decimal) (Decimal, error) {
+	product := new(big.Int).Mul(big.NewInt(d.units), big.NewInt(percent.units))
+	return fromBig(roundDiv(product, unit*100))
+}
+
+// Round rounds d to places decimal places, halves away from zero, so 2.345
+// becomes 2.35 and -2.345 becomes -2.35. The result may round up past the
+// largest Decimal, which Value then refuses to store.
+func (d Decimal) Round(places int) Decimal {
+	if places >= Scale {
+		return d
+	}
+	step := int64(math.Pow10(Scale - places))
+	return Decimal{units: roundDiv(big.NewInt(d.units), step).Int64() * step}
+}
+
+// Places reports how many decimal places d needs
+func (d Decimal) Places() int {
+	places := Scale
+	for n := d.units; places > 0 && n%10 == 0; n /= 10 {
+		places--
+	}
+	return places
+}
+
+// roundDiv divides n by d, rounding halves away from zero
+func roundDiv(n *big.Int, d int64) *big.Int {
+	quotient, remainder := new(big.Int).QuoRem(n, big.NewInt(d), new(big.Int))
+	if new(big.Int).Abs(remainder).Int64()*2 >= d {
+		quotient.Add(quotient, big.NewInt(int64(n.Sign())))
+	}
+	return quotient
+}
+
+// String formats d with as few decimal places as it needs
+func (d Decimal) String() string {
+	return d.StringFixed(d.Places())
+}
+
+// StringFixed formats d with exactly places decimal places, rounding if it
+// has more
+func (d Decimal) StringFixed(places int) string {
+	places = min(max(places, 0), Scale)
+	units := d.Round(places).units
+
+	sign := ""
+	if units < 0 {
+		sign = "-"
+	}
+	abs := new(big.Int).Abs(big.NewInt(units)).String()
+	if len(abs) <= Scale {
+		abs = strings.Repeat("0", Scale-len(abs)+1) + abs
+	}
+	whole, fraction := abs[:len(abs)-Scale], abs[len(abs)-Scale:]
+	if places == 0 {
+		return sign + whole
+	}
+	return sign + whole + "." + fraction[:places]
+}
+
+// MarshalJSON writes d as a string, which clients parse without going
+// through a float
+func (d Decimal) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// UnmarshalJSON accepts a string or a number
+func (d *Decimal) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if s == "null" {
+		return nil
+	}
+	if unquoted, err := strconv.Unquote(s); err == nil {
+		s = unquoted
+	}
+	parsed, err := ParseDecimal(s)
+	if err != nil {
+		return fmt.Errorf("%q: %w", s, err)
+	}
+	*d = parsed
+	return nil
+}
+
+// Value stores d in a numeric column
+func (d Decimal) Value() (driver.Value, error) {
+	if _, err := fromUnits(d.units); err != nil {
+		return nil, err
+	}
+	return d.StringFixed(Scale), nil
+}
+
+// Scan reads a numeric column
+func (d *Decimal) Scan(src any) error {
+	var s string
+	switch v := src.(type) {
+	case nil:
+		*d = Decimal{}
+		return nil
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	case int64:
+		parsed, err := Int(v)
+		if err != nil {
+			return fmt.Errorf("money: scanning %d: %w", v, err)
+		}
+		*d = parsed
+		return nil
+	case float64:
+		s = strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Errorf("money: cannot scan %T into a Decimal", src)
+	}
+
+	parsed, err := ParseDecimal(s)
+	if err != nil {
+		return fmt.Errorf("money: scanning %q: %w", s, err)
+	}
+	*d = parsed
+	return nil
+}
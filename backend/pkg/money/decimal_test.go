@@ -0,0 +1,244 @@
+package money
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+// largest is the largest Decimal, 14 nines before the point
+const largest = "99999999999999.9999"
+
+func TestParseDecimal(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string // As String writes it
+		err  error
+	}{
+		{"0", "0", nil},
+		{"12", "12", nil},
+		{"-12.5", "-12.5", nil},
+		{"+12.5", "12.5", nil},
+		{" 1.2345 ", "1.2345", nil},
+		{"1.234500", "1.2345", nil},
+		{".5", "0.5", nil},
+		{"5.", "5", nil},
+		{"-0", "0", nil},
+		{largest, largest, nil},
+		{"-" + largest, "-" + largest, nil},
+
+		{"", "", ErrSyntax},
+		{".", "", ErrSyntax},
+		{"-", "", ErrSyntax},
+		{"-+5", "", ErrSyntax},
+		{"+-5", "", ErrSyntax},
+		{"--5", "", ErrSyntax},
+		{"1,5", "", ErrSyntax},
+		{"1.2.3", "", ErrSyntax},
+		{"1e3", "", ErrSyntax},
+		{"0x10", "", ErrSyntax},
+		{"NaN", "", ErrSyntax},
+		{"1.23456", "", ErrTooExact},
+		{"100000000000000", "", ErrRange},
+		{"-100000000000000", "", ErrRange},
+		{"99999999999999999999999", "", ErrRange},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			d, err := ParseDecimal(tt.in)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+			if err == nil && d.String() != tt.want {
+				t.Errorf("= %s, want %s", d, tt.want)
+			}
+		})
+	}
+}
+
+func TestRound(t *testing.T) {
+	tests := []struct {
+		in     string
+		places int
+		want   string
+	}{
+		{"2.345", 2, "2.35"},
+		{"-2.345", 2, "-2.35"},
+		{"2.3449", 2, "2.34"},
+		{"-2.3449", 2, "-2.34"},
+		{"2.5", 0, "3"},
+		{"-2.5", 0, "-3"},
+		{"2.4999", 0, "2"},
+		{"1.2345", 4, "1.2345"},
+		{"1.2345", 6, "1.2345"},
+		{largest, 0, "100000000000000"},
+	}
+	for _, tt := range tests {
+		if got := MustParseDecimal(tt.in).Round(tt.places).String(); got != tt.want {
+			t.Errorf("Round(%s, %d) = %s, want %s", tt.in, tt.places, got, tt.want)
+		}
+	}
+}
+
+func TestStringFixed(t *testing.T) {
+	tests := []struct {
+		in     string
+		places int
+		want   string
+	}{
+		{"1234.5", 2, "1234.50"},
+		{"0.005", 2, "0.01"},
+		{"-0.005", 2, "-0.01"},
+		{"0.0001", 4, "0.0001"},
+		{"-0.4", 0, "0"},
+		{"7", 3, "7.000"},
+		{"7.25", -1, "7"},
+		{"7.25", 9, "7.2500"},
+	}
+	for _, tt := range tests {
+		if got := MustParseDecimal(tt.in).StringFixed(tt.places); got != tt.want {
+			t.Errorf("StringFixed(%s, %d) = %s, want %s", tt.in, tt.places, got, tt.want)
+		}
+	}
+}
+
+func TestArithmetic(t *testing.T) {
+	d := MustParseDecimal
+	tests := []struct {
+		name string
+		op   func() (Decimal, error)
+		want string
+	}{
+		{"add", func() (Decimal, error) { return d("1.5").Add(d("2.25")) }, "3.75"},
+		{"sub", func() (Decimal, error) { return d("1.5").Sub(d("2.25")) }, "-0.75"},
+		{"mul int", func() (Decimal, error) { return d("1.5").MulInt(-3) }, "-4.5"},
+		{"mul rounds half up", func() (Decimal, error) { return d("0.0005").Mul(d("0.5")) }, "0.0003"},
+		{"mul rounds half down", func() (Decimal, error) { return d("-0.0005").Mul(d("0.5")) }, "-0.0003"},
+		{"percent", func() (Decimal, error) { return d("1000").Percent(d("12.5")) }, "125"},
+		{"percent rounds", func() (Decimal, error) { return d("0.0001").Percent(d("50")) }, "0.0001"},
+		{"int", func() (Decimal, error) { return Int(-42) }, "-42"},
+		{"add up to largest", func() (Decimal, error) { return d(largest).Add(d("0")) }, largest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.op()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.String() != tt.want {
+				t.Errorf("= %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOverflow(t *testing.T) {
+	d := MustParseDecimal
+	tests := []struct {
+		name string
+		op   func() (Decimal, error)
+	}{
+		{"int", func() (Decimal, error) { return Int(100000000000000) }},
+		{"negative int", func() (Decimal, error) { return Int(-100000000000000) }},
+		{"int64 limit", func() (Decimal, error) { return Int(math.MaxInt64) }},
+		{"add", func() (Decimal, error) { return d(largest).Add(d("0.0001")) }},
+		{"add negative", func() (Decimal, error) { return d("-" + largest).Add(d("-" + largest)) }},
+		{"sub", func() (Decimal, error) { return d("-" + largest).Sub(d("1")) }},
+		{"mul int", func() (Decimal, error) { return d("50000000000000").MulInt(2) }},
+		{"mul int past int64", func() (Decimal, error) { return d(largest).MulInt(math.MaxInt64) }},
+		{"mul", func() (Decimal, error) { return d("10000000").Mul(d("10000000")) }},
+		{"percent", func() (Decimal, error) { return d(largest).Percent(d("200")) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.op(); !errors.Is(err, ErrRange) {
+				t.Errorf("err = %v, want ErrRange", err)
+			}
+		})
+	}
+}
+
+func TestCmp(t *testing.T) {
+	d := MustParseDecimal
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1", "2", -1},
+		{"2", "1", 1},
+		{"1.5", "1.50", 0},
+		{"-" + largest, largest, -1},
+		{largest, "-" + largest, 1},
+	}
+	for _, tt := range tests {
+		if got := d(tt.a).Cmp(d(tt.b)); got != tt.want {
+			t.Errorf("Cmp(%s, %s) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestScan(t *testing.T) {
+	tests := []struct {
+		name string
+		src  any
+		want string
+		err  error
+	}{
+		{"nil", nil, "0", nil},
+		{"string", "1234.5000", "1234.5", nil},
+		{"bytes", []byte("-0.0100"), "-0.01", nil},
+		{"int64", int64(15), "15", nil},
+		{"float64", 2.5, "2.5", nil},
+		{"too large", "100000000000000.0000", "", ErrRange},
+		{"int64 too large", int64(math.MaxInt64), "", ErrRange},
+		{"not a number", "abc", "", ErrSyntax},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var d Decimal
+			err := d.Scan(tt.src)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+			if err == nil && d.String() != tt.want {
+				t.Errorf("= %s, want %s", d, tt.want)
+			}
+		})
+	}
+
+	var d Decimal
+	if err := d.Scan(true); err == nil {
+		t.Error("scanned a bool")
+	}
+}
+
+func TestValue(t *testing.T) {
+	v, err := MustParseDecimal("-12.5").Value()
+	if err != nil || v != "-12.5000" {
+		t.Errorf("Value = %v, %v; want -12.5000", v, err)
+	}
+
+	// Rounding the largest amount carries past it
+	if _, err := MustParseDecimal(largest).Round(2).Value(); !errors.Is(err, ErrRange) {
+		t.Errorf("err = %v, want ErrRange", err)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	var d Decimal
+	for in, want := range map[string]string{`"12.50"`: "12.5", `12.5`: "12.5", `"-3"`: "-3"} {
+		if err := d.UnmarshalJSON([]byte(in)); err != nil || d.String() != want {
+			t.Errorf("UnmarshalJSON(%s) = %s, %v; want %s", in, d, err, want)
+		}
+	}
+	for _, in := range []string{`"-+5"`, `"1.23456"`, `1e3`, `"100000000000000"`} {
+		if err := d.UnmarshalJSON([]byte(in)); err == nil {
+			t.Errorf("UnmarshalJSON(%s) accepted %s", in, d)
+		}
+	}
+
+	out, err := MustParseDecimal("12.50").MarshalJSON()
+	if err != nil || string(out) != `"12.5"` {
+		t.Errorf("MarshalJSON = %s, %v", out, err)
+	}
+}
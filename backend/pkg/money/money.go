@@ -0,0 +1,167 @@
+package money
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrCurrency         = errors.New("unknown currency")
+	ErrCurrencyMismatch = errors.New("amounts are in different currencies")
+)
+
+// minorUnits are the decimal places of the ISO 4217 currencies we accept
+var minorUnits = map[string]int{
+	"RUB": 2, "BYN": 2, "KZT": 2, "UZS": 2, "KGS": 2, "TJS": 2, "AMD": 2,
+	"AZN": 2, "GEL": 2, "TRY": 2, "AED": 2, "CNY": 2, "USD": 2, "EUR": 2,
+	"GBP": 2, "CHF": 2, "JPY": 0, "KRW": 0, "KWD": 3, "BHD": 3,
+}
+
+// ValidCurrency reports whether code is an ISO 4217 currency we accept
+func ValidCurrency(code string) bool {
+	_, ok := minorUnits[code]
+	return ok
+}
+
+// NormalizeCurrency upper-cases a currency code and checks it
+func NormalizeCurrency(code string) (string, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if !ValidCurrency(code) {
+		return "", fmt.Errorf("%w %q", ErrCurrency, code)
+	}
+	return code, nil
+}
+
+// MinorUnits returns the decimal places of a currency, such as 2 for kopecks
+func MinorUnits(currency string) int {
+	if places, ok := minorUnits[currency]; ok {
+		return places
+	}
+	return 2
+}
+
+// Money is an amount in a currency. Models embed it with a column prefix:
+//
+//	Price money.Money `gorm:"embedded;embeddedPrefix:price_"`
+//
+// stores it in price_amount (numeric) and price_currency (char(3)).
+//
+// Rounding policy: amounts entered by users must not have more decimal
+// places than their currency's minor unit. Results of arithmetic that can
+// produce fractions of a minor unit, such as discounts, are rounded to the
+// minor unit with halves away from zero.
+type Money struct {
+	Amount   Decimal `gorm:"type:numeric(18,4)"`
+	Currency string  `gorm:"type:char(3)"`
+}
+
+// New returns amount in currency, rejecting amounts finer than the
+// currency's minor unit
+func New(amount Decimal, currency string) (Money, error) {
+	currency, err := NormalizeCurrency(currency)
+	if err != nil {
+		return Money{}, err
+	}
+	if amount.Places() > MinorUnits(currency) {
+		return Money{}, fmt.Errorf("%s has %d decimal places: %w", currency, MinorUnits(currency), ErrTooExact)
+	}
+	return Money{Amount: amount, Currency: currency}, nil
+}
+
+// MustParse is New for constants; it panics on error
+func MustParse(amount, currency string) Money {
+	m, err := New(MustParseDecimal(amount), currency)
+	if err != nil {
+		panic(fmt.Sprintf("money: %s %s: %v", amount, currency, err))
+	}
+	return m
+}
+
+// Zero returns nothing in currency
+func Zero(currency string) Money {
+	return Money{Currency: currency}
+}
+
+func (m Money) IsZero() bool { return m.Amount.IsZero() }
+
+func (m Money) Sign() int { return m.Amount.Sign() }
+
+// Cmp compares two amounts in the same currency
+func (m Money) Cmp(n Money) (int, error) {
+	if m.Currency != n.Currency {
+		return 0, ErrCurrencyMismatch
+	}
+	return m.Amount.Cmp(n.Amount), nil
+}
+
+// Add returns m plus n, which must be in the same currency
+func (m Money) Add(n Money) (Money, error) {
+	if m.Currency != n.Currency {
+		return Money{}, ErrCurrencyMismatch
+	}
+	sum, err := m.Amount.Add(n.Amount)
+	if err != nil {
+		return Money{}, err
+	}
+	return Money{Amount: sum, Currency: m.Currency}, nil
+}
+
+// MulInt returns m times n, such as the price of n teeth
+func (m Money) MulInt(n int) (Money, error) {
+	product, err := m.Amount.MulInt(int64(n))
+	if err != nil {
+		return Money{}, err
+	}
+	return Money{Amount: product, Currency: m.Currency}, nil
+}
+
+// Discount returns m less percent of it, rounded to the minor unit
+func (m Money) Discount(percent Decimal) (Money, error) {
+	off, err := m.Amount.Percent(percent)
+	if err != nil {
+		return Money{}, err
+	}
+	discounted, err := m.Amount.Sub(off)
+	if err != nil {
+		return Money{}, err
+	}
+	return Money{Amount: discounted.Round(MinorUnits(m.Currency)), Currency: m.Currency}, nil
+}
+
+// String formats m as "1234.50 RUB"
+func (m Money) String() string {
+	return m.Amount.StringFixed(MinorUnits(m.Currency)) + " " + m.Currency
+}
+
+type moneyJSON struct {
+	Amount   string `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+// MarshalJSON writes m as {"amount": "1234.50", "currency": "RUB"}, with the
+// currency's minor units
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(moneyJSON{
+		Amount:   m.Amount.StringFixed(MinorUnits(m.Currency)),
+		Currency: m.Currency,
+	})
+}
+
+// UnmarshalJSON reads the form MarshalJSON writes, and checks it like New
+func (m *Money) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Amount   Decimal `json:"amount"`
+		Currency string  `json:"currency"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	parsed, err := New(raw.Amount, raw.Currency)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
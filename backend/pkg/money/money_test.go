@@ -0,0 +1,108 @@
+package money
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		amount, currency string
+		want             string
+		err              error
+	}{
+		{"1234.5", "rub", "1234.50 RUB", nil},
+		{"1234", "JPY", "1234 JPY", nil},
+		{"1.234", "KWD", "1.234 KWD", nil},
+		{"1.234", "RUB", "", ErrTooExact},
+		{"1.5", "JPY", "", ErrTooExact},
+		{"1", "XXX", "", ErrCurrency},
+	}
+	for _, tt := range tests {
+		m, err := New(MustParseDecimal(tt.amount), tt.currency)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("New(%s, %s): err = %v, want %v", tt.amount, tt.currency, err, tt.err)
+			continue
+		}
+		if err == nil && m.String() != tt.want {
+			t.Errorf("New(%s, %s) = %s, want %s", tt.amount, tt.currency, m, tt.want)
+		}
+	}
+}
+
+func TestDiscount(t *testing.T) {
+	tests := []struct {
+		amount, currency, percent string
+		want                      string
+	}{
+		{"1000.00", "RUB", "10", "900.00 RUB"},
+		{"999.99", "RUB", "12.5", "874.99 RUB"}, // 874.99125
+		{"0.05", "RUB", "10", "0.05 RUB"},       // 0.045 rounds away from zero
+		{"1001", "JPY", "0.05", "1000 JPY"},     // 1000.4995
+		{"10.005", "KWD", "50", "5.003 KWD"},    // 5.0025
+		{"100.00", "RUB", "0", "100.00 RUB"},
+		{"100.00", "RUB", "99.9999", "0.00 RUB"},
+	}
+	for _, tt := range tests {
+		got, err := MustParse(tt.amount, tt.currency).Discount(MustParseDecimal(tt.percent))
+		if err != nil {
+			t.Errorf("%s %s less %s%%: %v", tt.amount, tt.currency, tt.percent, err)
+			continue
+		}
+		if got.String() != tt.want {
+			t.Errorf("%s %s less %s%% = %s, want %s", tt.amount, tt.currency, tt.percent, got, tt.want)
+		}
+	}
+
+	if _, err := MustParse(largest[:len(largest)-2], "RUB").Discount(MustParseDecimal("-100")); !errors.Is(err, ErrRange) {
+		t.Errorf("a negative discount past the largest amount: err = %v, want ErrRange", err)
+	}
+}
+
+func TestMoneyArithmetic(t *testing.T) {
+	price := MustParse("1500.50", "RUB")
+
+	sum, err := price.Add(MustParse("0.50", "RUB"))
+	if err != nil || sum != MustParse("1501.00", "RUB") {
+		t.Errorf("Add = %v, %v", sum, err)
+	}
+	if _, err := price.Add(MustParse("1", "USD")); !errors.Is(err, ErrCurrencyMismatch) {
+		t.Errorf("adding USD to RUB: err = %v", err)
+	}
+	if _, err := MustParse(largest[:len(largest)-2], "RUB").Add(price); !errors.Is(err, ErrRange) {
+		t.Errorf("sum past the largest amount: err = %v", err)
+	}
+
+	product, err := price.MulInt(3)
+	if err != nil || product != MustParse("4501.50", "RUB") {
+		t.Errorf("MulInt = %v, %v", product, err)
+	}
+	if _, err := price.MulInt(1 << 40); !errors.Is(err, ErrRange) {
+		t.Errorf("product past the largest amount: err = %v", err)
+	}
+
+	if _, err := price.Cmp(MustParse("1", "USD")); !errors.Is(err, ErrCurrencyMismatch) {
+		t.Errorf("comparing USD to RUB: err = %v", err)
+	}
+}
+
+func TestMoneyJSON(t *testing.T) {
+	out, err := MustParse("1234.5", "RUB").MarshalJSON()
+	if err != nil || string(out) != `{"amount":"1234.50","currency":"RUB"}` {
+		t.Errorf("MarshalJSON = %s, %v", out, err)
+	}
+
+	var m Money
+	if err := m.UnmarshalJSON([]byte(`{"amount":"12.30","currency":"usd"}`)); err != nil || m != MustParse("12.3", "USD") {
+		t.Errorf("UnmarshalJSON = %v, %v", m, err)
+	}
+	for _, in := range []string{
+		`{"amount":"12.345","currency":"RUB"}`,
+		`{"amount":"12","currency":"ABC"}`,
+		`{"amount":"-+12","currency":"RUB"}`,
+	} {
+		if err := m.UnmarshalJSON([]byte(in)); err == nil {
+			t.Errorf("UnmarshalJSON(%s) accepted %v", in, m)
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package securetoken
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+)
+
+// Generate returns a random URL-safe token and its hash. Only the hash should
+// be stored; the plain token is handed to the user once.
+func Generate() (token, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token = base64.RawURLEncoding.EncodeToString(b)
+	return token, Hash(token), nil
+}
+
+// Hash returns the hex-encoded SHA-256 of a token for storage and lookup
+func Hash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,106 @@
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA1 key of the RFC 6238 test vectors
+var rfcSecret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+// RFC 6238 Appendix B lists 8 digit codes; with 6 digits they keep their last
+// six
+var rfcVectors = []struct {
+	unix int64
+	code string
+}{
+	{59, "287082"},          // 94287082
+	{1111111109, "081804"},  // 07081804
+	{1111111111, "050471"},  // 14050471
+	{1234567890, "005924"},  // 89005924
+	{2000000000, "279037"},  // 69279037
+	{20000000000, "353130"}, // 65353130
+}
+
+func TestCode(t *testing.T) {
+	for _, v := range rfcVectors {
+		code, err := Code(rfcSecret, Step(time.Unix(v.unix, 0)))
+		if err != nil {
+			t.Fatalf("Code at %d: %v", v.unix, err)
+		}
+		if code != v.code {
+			t.Errorf("Code at %d = %s, want %s", v.unix, code, v.code)
+		}
+	}
+}
+
+func TestCodeSecretForms(t *testing.T) {
+	want, _ := Code(rfcSecret, 1)
+	for _, secret := range []string{" " + rfcSecret + "\n", strings.ToLower(rfcSecret)} {
+		if got, err := Code(secret, 1); err != nil || got != want {
+			t.Errorf("Code(%q) = %s, %v, want %s", secret, got, err, want)
+		}
+	}
+	if _, err := Code("not base32!", 1); err == nil {
+		t.Error("Code accepted an invalid secret")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step := Step(now)
+
+	for _, v := range rfcVectors {
+		got, ok := Validate(rfcSecret, v.code, time.Unix(v.unix, 0), 0)
+		if !ok || got != Step(time.Unix(v.unix, 0)) {
+			t.Errorf("Validate(%s) at %d = %d, %v", v.code, v.unix, got, ok)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		offset int64 // Steps between the code and now
+		skew   int
+		ok     bool
+	}{
+		{"current step", 0, 0, true},
+		{"previous step without skew", -1, 0, false},
+		{"previous step", -1, 1, true},
+		{"next step", 1, 1, true},
+		{"two steps behind", -2, 1, false},
+		{"two steps ahead", 2, 1, false},
+		{"two steps behind, wider skew", -2, 2, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, err := Code(rfcSecret, step+tt.offset)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, ok := Validate(rfcSecret, code, now, tt.skew)
+			if ok != tt.ok {
+				t.Fatalf("Validate = %v, want %v", ok, tt.ok)
+			}
+			if ok && got != step+tt.offset {
+				t.Errorf("matched step %d, want %d", got, step+tt.offset)
+			}
+		})
+	}
+}
+
+func TestValidateRejectsMalformedCodes(t *testing.T) {
+	now := time.Unix(59, 0)
+	for _, code := range []string{"", "28708", "2870820", "94287082"} {
+		if _, ok := Validate(rfcSecret, code, now, 1); ok {
+			t.Errorf("Validate accepted %q", code)
+		}
+	}
+	if _, ok := Validate(rfcSecret, " 287082 ", now, 0); !ok {
+		t.Error("Validate rejected a code with surrounding spaces")
+	}
+	if _, ok := Validate("not base32!", "287082", now, 0); ok {
+		t.Error("Validate accepted a code for an invalid secret")
+	}
+}
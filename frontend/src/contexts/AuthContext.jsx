@@ -25,36 +25,70 @@ export const AuthProvider = ({ children }) => {
     setLoading(false);
   }, []);
 
-  const login = async (email, password) => {
-    const response = await api.post('/auth/login', { email, password });
-    const { user, tokens } = response.data;
-    
+  const signIn = ({ user, tokens }) => {
     localStorage.setItem('access_token', tokens.access_token);
+    localStorage.setItem('refresh_token', tokens.refresh_token);
     localStorage.setItem('user', JSON.stringify(user));
     setUser(user);
-    
+
     return user;
   };
 
+  // Accounts with two-factor authentication get a challenge instead of
+  // tokens; login returns { mfaRequired, mfaToken } for loginMFA to complete
+  const login = async (email, password) => {
+    const response = await api.post('/auth/login', { email, password });
+    if (response.data.mfa_required) {
+      return { mfaRequired: true, mfaToken: response.data.mfa_token };
+    }
+    return signIn(response.data);
+  };
+
+  // A six-digit code comes from the authenticator app; anything else is one
+  // of the recovery codes
+  const loginMFA = async (mfaToken, code) => {
+    const trimmed = code.trim();
+    const body = /^\d{6}$/.test(trimmed)
+      ? { mfa_token: mfaToken, code: trimmed }
+      : { mfa_token: mfaToken, recovery_code: trimmed.toLowerCase() };
+    const response = await api.post('/auth/login/mfa', body);
+    return signIn(response.data);
+  };
+
+  // Registering never says whether the email was taken, so sign in with the
+  // same credentials; that only fails, or asks for a second factor a new
+  // account cannot have, if the address already had an account, whose owner
+  // the server emailed instead
   const register = async (data) => {
     const response = await api.post('/auth/register', data);
-    const { user, tokens } = response.data;
-    
-    localStorage.setItem('access_token', tokens.access_token);
-    localStorage.setItem('user', JSON.stringify(user));
-    setUser(user);
-    
-    return user;
+    let result;
+    try {
+      result = await login(data.email, data.password);
+    } catch {
+      result = null;
+    }
+    if (!result || result.mfaRequired) {
+      const err = new Error(response.data.message);
+      err.response = { data: { error: response.data.message } };
+      throw err;
+    }
+    return result;
   };
 
-  const logout = () => {
+  const logout = async () => {
+    try {
+      await api.post('/auth/logout');
+    } catch {
+      // Session may already be gone; clear local state regardless
+    }
     localStorage.removeItem('access_token');
+    localStorage.removeItem('refresh_token');
     localStorage.removeItem('user');
     setUser(null);
   };
 
   return (
-    <AuthContext.Provider value={{ user, loading, login, register, logout }}>
+    <AuthContext.Provider value={{ user, loading, login, loginMFA, register, logout }}>
       {children}
     </AuthContext.Provider>
   );
@@ -21,14 +21,62 @@ api.interceptors.request.use(
   (error) => Promise.reject(error)
 );
 
-// Response interceptor for error handling
+// Refresh is shared so concurrent 401s trigger a single rotation
+let refreshPromise = null;
+
+const refreshTokens = () => {
+  if (!refreshPromise) {
+    const refreshToken = localStorage.getItem('refresh_token');
+    refreshPromise = axios
+      .post(`${API_BASE_URL}/auth/refresh`, { refresh_token: refreshToken })
+      .then((response) => {
+        const { tokens } = response.data;
+        localStorage.setItem('access_token', tokens.access_token);
+        localStorage.setItem('refresh_token', tokens.refresh_token);
+        return tokens.access_token;
+      })
+      .finally(() => {
+        refreshPromise = null;
+      });
+  }
+  return refreshPromise;
+};
+
+const clearSession = () => {
+  localStorage.removeItem('access_token');
+  localStorage.removeItem('refresh_token');
+  localStorage.removeItem('user');
+  window.location.href = '/login';
+};
+
+// Signing in has no session to renew: a 401 there is a wrong password or code
+const isLogin = (config) => config?.url?.startsWith('/auth/login');
+
+// Response interceptor: silently renew expired access tokens
 api.interceptors.response.use(
   (response) => response,
-  (error) => {
+  async (error) => {
+    const original = error.config;
+    if (isLogin(original)) {
+      return Promise.reject(error);
+    }
+    if (error.response?.status === 401 && original && !original._retried) {
+      if (!localStorage.getItem('refresh_token')) {
+        clearSession();
+        return Promise.reject(error);
+      }
+      original._retried = true;
+      try {
+        const accessToken = await refreshTokens();
+        original.headers.Authorization = `Bearer ${accessToken}`;
+        return api(original);
+      } catch (refreshError) {
+        clearSession();
+        return Promise.reject(refreshError);
+      }
+    }
     if (error.response?.status === 401) {
-      localStorage.removeItem('access_token');
-      localStorage.removeItem('user');
-      window.location.href = '/login';
+      clearSession();
     }
     return Promise.reject(error);
   }
@@ -0,0 +1,9 @@
+// Formats a money value from the API, {amount: "1234.50", currency: "RUB"}
+export function formatMoney(money) {
+  if (!money) return '';
+  return new Intl.NumberFormat('ru-RU', { style: 'currency', currency: money.currency }).format(Number(money.amount));
+}
+
+export function isZeroMoney(money) {
+  return !money || Number(money.amount) === 0;
+}
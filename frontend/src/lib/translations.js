@@ -16,6 +16,8 @@ const translations = {
     alreadyHaveAccount: 'Уже есть аккаунт?',
     register: 'Зарегистрироваться',
     signingIn: 'Вход...',
+    mfaCode: 'Код подтверждения',
+    mfaCodeHint: 'Код из приложения-аутентификатора или один из резервных кодов',
     creatingAccount: 'Создание аккаунта...',
     iAm: 'Я',
     passwordMinLength: 'Минимум 8 символов',
@@ -85,6 +87,7 @@ const translations = {
       studyDate: 'Дата исследования',
       creating: 'Создание...',
       createdDate: 'Создано',
+      series: 'Серий',
     },
     plans: {
       title: 'Планы лечения',
@@ -253,6 +256,13 @@ const translations = {
     failed: 'ошибка',
   },
 
+  // Kind of imaging, from the DICOM headers
+  imageKind: {
+    volume: 'КЛКТ / КТ (3D)',
+    panoramic: 'Панорамный снимок',
+    other: 'Снимок',
+  },
+
   // Offer Status
   offerStatus: {
     open: 'открыт',
@@ -0,0 +1,63 @@
+import api from './api';
+
+// Resumable study uploads over tus 1.0: the file goes up in chunks, and after
+// a network error the upload resumes from what the server already has
+const CHUNK_SIZE = 5 * 1024 * 1024;
+const MAX_RETRIES = 5;
+const TUS_HEADERS = { 'Tus-Resumable': '1.0.0' };
+
+const encodeMetadata = (metadata) =>
+  Object.entries(metadata)
+    .map(([key, value]) => `${key} ${btoa(unescape(encodeURIComponent(value)))}`)
+    .join(',');
+
+// sha256 of a chunk as an Upload-Checksum header, where the browser can hash
+const checksumHeader = async (chunk) => {
+  if (!window.crypto?.subtle) return {};
+  const digest = await window.crypto.subtle.digest('SHA-256', await chunk.arrayBuffer());
+  const base64 = btoa(String.fromCharCode(...new Uint8Array(digest)));
+  return { 'Upload-Checksum': `sha256 ${base64}` };
+};
+
+const sleep = (ms) => new Promise((resolve) => setTimeout(resolve, ms));
+
+// Uploads file to a study, calling onProgress with the fraction sent
+export async function uploadStudyFile(studyId, file, onProgress = () => {}) {
+  const created = await api.post(`/studies/${studyId}/upload`, null, {
+    headers: {
+      ...TUS_HEADERS,
+      'Upload-Length': String(file.size),
+      'Upload-Metadata': encodeMetadata({ filename: file.name }),
+    },
+  });
+  const url = `/studies/${studyId}/upload/${created.data.id}`;
+
+  let offset = 0;
+  let retries = 0;
+  while (offset < file.size) {
+    const chunk = file.slice(offset, offset + CHUNK_SIZE);
+    try {
+      const response = await api.patch(url, chunk, {
+        headers: {
+          ...TUS_HEADERS,
+          ...(await checksumHeader(chunk)),
+          'Content-Type': 'application/offset+octet-stream',
+          'Upload-Offset': String(offset),
+        },
+      });
+      offset = Number(response.headers['upload-offset']);
+      retries = 0;
+      onProgress(offset / file.size);
+    } catch (err) {
+      const status = err.response?.status;
+      // Only interruptions and offset mix-ups are worth resuming
+      if ((status && status !== 409 && status !== 460 && status < 500) || retries >= MAX_RETRIES) {
+        throw err;
+      }
+      retries += 1;
+      await sleep(1000 * 2 ** retries);
+      const head = await api.head(url, { headers: TUS_HEADERS });
+      offset = Number(head.headers['upload-offset']);
+    }
+  }
+}
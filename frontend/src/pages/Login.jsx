@@ -7,9 +7,11 @@ import t from '../lib/translations';
 const Login = () => {
   const [email, setEmail] = useState('');
   const [password, setPassword] = useState('');
+  const [mfaToken, setMfaToken] = useState('');
+  const [code, setCode] = useState('');
   const [error, setError] = useState('');
   const [loading, setLoading] = useState(false);
-  const { login } = useAuth();
+  const { login, loginMFA } = useAuth();
   const navigate = useNavigate();
 
   const handleSubmit = async (e) => {
@@ -18,11 +20,15 @@ const Login = () => {
     setLoading(true);
 
     try {
-      const user = await login(email, password);
-      
-      if (user.role === 'patient') {
+      const result = mfaToken ? await loginMFA(mfaToken, code) : await login(email, password);
+      if (result.mfaRequired) {
+        setMfaToken(result.mfaToken);
+        return;
+      }
+
+      if (result.role === 'patient') {
         navigate('/patient');
-      } else if (user.role === 'clinic_manager' || user.role === 'clinic_doctor') {
+      } else if (result.role === 'clinic_manager' || result.role === 'clinic_doctor') {
         navigate('/clinic');
       }
     } catch (err) {
@@ -51,33 +57,53 @@ const Login = () => {
               </div>
             )}
 
-            <div>
-              <label className="block text-sm font-medium text-gray-700 mb-1">
-                {t.auth.email}
-              </label>
-              <input
-                type="email"
-                value={email}
-                onChange={(e) => setEmail(e.target.value)}
-                className="input"
-                placeholder="you@example.com"
-                required
-              />
-            </div>
+            {mfaToken ? (
+              <div>
+                <label className="block text-sm font-medium text-gray-700 mb-1">
+                  {t.auth.mfaCode}
+                </label>
+                <input
+                  type="text"
+                  value={code}
+                  onChange={(e) => setCode(e.target.value)}
+                  className="input"
+                  autoComplete="one-time-code"
+                  autoFocus
+                  required
+                />
+                <p className="text-xs text-gray-500 mt-1">{t.auth.mfaCodeHint}</p>
+              </div>
+            ) : (
+              <>
+                <div>
+                  <label className="block text-sm font-medium text-gray-700 mb-1">
+                    {t.auth.email}
+                  </label>
+                  <input
+                    type="email"
+                    value={email}
+                    onChange={(e) => setEmail(e.target.value)}
+                    className="input"
+                    placeholder="you@example.com"
+                    required
+                  />
+                </div>
 
-            <div>
-              <label className="block text-sm font-medium text-gray-700 mb-1">
-                {t.auth.password}
-              </label>
-              <input
-                type="password"
-                value={password}
-                onChange={(e) => setPassword(e.target.value)}
-                className="input"
-                placeholder="••••••••"
-                required
-              />
-            </div>
+                <div>
+                  <label className="block text-sm font-medium text-gray-700 mb-1">
+                    {t.auth.password}
+                  </label>
+                  <input
+                    type="password"
+                    value={password}
+                    onChange={(e) => setPassword(e.target.value)}
+                    className="input"
+                    placeholder="••••••••"
+                    required
+                  />
+                </div>
+              </>
+            )}
 
             <button
               type="submit"
@@ -88,6 +114,20 @@ const Login = () => {
             </button>
           </form>
 
+          {mfaToken && (
+            <button
+              type="button"
+              onClick={() => {
+                setMfaToken('');
+                setCode('');
+                setError('');
+              }}
+              className="mt-4 w-full text-sm text-gray-600 hover:text-gray-900"
+            >
+              {t.common.back}
+            </button>
+          )}
+
           <div className="mt-4 text-center">
             <p className="text-sm text-gray-600">
               {t.auth.dontHaveAccount}{' '}
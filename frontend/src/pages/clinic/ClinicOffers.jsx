@@ -2,6 +2,7 @@ import { useState, useEffect } from 'react';
 import { Package, FileText } from 'lucide-react';
 import api from '../../lib/api';
 import t from '../../lib/translations';
+import { formatMoney } from '../../lib/money';
 
 const ClinicOffers = () => {
   const [offers, setOffers] = useState([]);
@@ -74,7 +75,7 @@ const ClinicOffers = () => {
               <div className="grid grid-cols-2 md:grid-cols-4 gap-4 p-3 bg-gray-50 rounded-lg">
                 <div>
                   <p className="text-xs text-gray-500">{t.clinic.offers.totalPrice}</p>
-                  <p className="font-semibold text-gray-900">₽{offer.total_price.toLocaleString('ru-RU')}</p>
+                  <p className="font-semibold text-gray-900">{formatMoney(offer.total_price)}</p>
                 </div>
                 {offer.discount_percent > 0 && (
                   <div>
@@ -2,6 +2,7 @@ import { useState, useEffect } from 'react';
 import { ClipboardList, User } from 'lucide-react';
 import api from '../../lib/api';
 import t from '../../lib/translations';
+import { formatMoney } from '../../lib/money';
 
 const Orders = () => {
   const [orders, setOrders] = useState([]);
@@ -96,7 +97,7 @@ const Orders = () => {
                   <div className="grid grid-cols-2 md:grid-cols-4 gap-4 text-sm">
                     <div>
                       <p className="text-gray-500">{t.clinic.offers.totalPrice}</p>
-                      <p className="font-semibold text-gray-900">₽{order.offer.total_price.toLocaleString('ru-RU')}</p>
+                      <p className="font-semibold text-gray-900">{formatMoney(order.offer.total_price)}</p>
                     </div>
                     {order.offer.discount_percent > 0 && (
                       <div>
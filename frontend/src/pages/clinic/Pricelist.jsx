@@ -2,6 +2,7 @@ import { useState, useEffect } from 'react';
 import { Plus, DollarSign, Trash2, AlertCircle } from 'lucide-react';
 import api from '../../lib/api';
 import t from '../../lib/translations';
+import { formatMoney, isZeroMoney } from '../../lib/money';
 
 const Pricelist = () => {
   const [items, setItems] = useState([]);
@@ -40,8 +41,8 @@ const Pricelist = () => {
     try {
       await api.post('/clinic/pricelist', {
         ...formData,
-        price_from: parseFloat(formData.price_from),
-        price_to: parseFloat(formData.price_to),
+        price_from: formData.price_from,
+        price_to: formData.price_to || undefined,
       });
       setShowAddModal(false);
       setFormData({
@@ -139,7 +140,8 @@ const Pricelist = () => {
                     <td className="py-3 px-4 text-sm text-gray-900">{item.procedure_name}</td>
                     <td className="py-3 px-4 text-sm text-gray-600">{item.procedure_code}</td>
                     <td className="py-3 px-4 text-sm font-medium text-gray-900">
-                      ₽{item.price_from.toLocaleString('ru-RU')} - ₽{item.price_to.toLocaleString('ru-RU')}
+                      {formatMoney(item.price_from)}
+                      {!isZeroMoney(item.price_to) && <> - {formatMoney(item.price_to)}</>}
                     </td>
                     <td className="py-3 px-4">
                       <span className={`px-2 py-1 rounded-full text-xs font-medium ${
@@ -2,6 +2,7 @@ import { useState, useEffect } from 'react';
 import { Package, Building2, Check } from 'lucide-react';
 import api from '../../lib/api';
 import t from '../../lib/translations';
+import { formatMoney } from '../../lib/money';
 
 const Offers = () => {
   const [offerRequests, setOfferRequests] = useState([]);
@@ -127,7 +128,7 @@ const Offers = () => {
                                 <div>
                                   <p className="text-xs text-gray-500">{t.patient.offers.totalPrice}</p>
                                   <p className="font-semibold text-gray-900">
-                                    ₽{offer.total_price.toLocaleString('ru-RU')}
+                                    {formatMoney(offer.total_price)}
                                   </p>
                                 </div>
                                 {offer.discount_percent > 0 && (
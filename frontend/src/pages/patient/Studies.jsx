@@ -1,6 +1,7 @@
 import { useState, useEffect } from 'react';
 import { Plus, FileText, Calendar, AlertCircle, Upload, CheckCircle, Download } from 'lucide-react';
 import api from '../../lib/api';
+import { uploadStudyFile } from '../../lib/tus';
 import t from '../../lib/translations';
 
 const Studies = () => {
@@ -10,10 +11,6 @@ const Studies = () => {
   const [selectedFile, setSelectedFile] = useState(null);
   const [uploadProgress, setUploadProgress] = useState(null);
   const [uploading, setUploading] = useState(false);
-  const [formData, setFormData] = useState({
-    modality: 'CBCT',
-    study_date: new Date().toISOString().split('T')[0],
-  });
   const [error, setError] = useState('');
 
   useEffect(() => {
@@ -57,8 +54,9 @@ const Studies = () => {
   const handleFileSelect = (e) => {
     const file = e.target.files[0];
     if (file) {
-      if (!file.name.toLowerCase().endsWith('.dcm')) {
-        setError('Пожалуйста, выберите файл .dcm');
+      const name = file.name.toLowerCase();
+      if (!name.endsWith('.dcm') && !name.endsWith('.zip')) {
+        setError('Пожалуйста, выберите файл .dcm или архив .zip');
         return;
       }
       setSelectedFile(file);
@@ -79,23 +77,18 @@ const Studies = () => {
     setUploadProgress('Создание исследования...');
 
     try {
-      // Step 1: Create study
-      const createResponse = await api.post('/studies', formData);
+      // Step 1: Create study; modality and date come from the DICOM headers
+      const createResponse = await api.post('/studies', {});
       const studyId = createResponse.data.id;
       
       // Step 2: Initialize upload
       setUploadProgress('Подготовка к загрузке...');
       await api.post(`/studies/${studyId}/upload/init`);
       
-      // Step 3: Upload file
+      // Step 3: Upload file, resuming after network errors
       setUploadProgress('Загрузка файла в Diagnocat...');
-      const uploadFormData = new FormData();
-      uploadFormData.append('file', selectedFile);
-      
-      await api.post(`/studies/${studyId}/upload`, uploadFormData, {
-        headers: {
-          'Content-Type': 'multipart/form-data',
-        },
+      await uploadStudyFile(studyId, selectedFile, (fraction) => {
+        setUploadProgress(`Загрузка файла в Diagnocat... ${Math.floor(fraction * 100)}%`);
       });
 
       setUploadProgress('✅ Готово! Анализ начался.');
@@ -105,10 +98,6 @@ const Studies = () => {
         setShowUploadModal(false);
         setSelectedFile(null);
         setUploadProgress(null);
-        setFormData({
-          modality: 'CBCT',
-          study_date: new Date().toISOString().split('T')[0],
-        });
         fetchStudies();
       }, 2000);
 
@@ -209,6 +198,13 @@ const Studies = () => {
                   <Calendar className="w-4 h-4" />
                   {t.patient.studies.createdDate}: {new Date(study.created_at).toLocaleDateString('ru-RU')}
                 </div>
+                {study.image_kind && (
+                  <div className="text-gray-600">
+                    {t.imageKind[study.image_kind] || study.image_kind}
+                    {study.series_count > 1 && ` · ${t.patient.studies.series}: ${study.series_count}`}
+                    {study.manufacturer && ` · ${study.manufacturer}`}
+                  </div>
+                )}
               </div>
 
               <div className="mt-4 pt-4 border-t border-gray-200 flex gap-2">
@@ -261,38 +257,6 @@ const Studies = () => {
             )}
 
             <form onSubmit={handleCreateAndUpload} className="space-y-4">
-              {/* Modality */}
-              <div>
-                <label className="block text-sm font-medium text-gray-700 mb-1">
-                  Модальность
-                </label>
-                <select
-                  value={formData.modality}
-                  onChange={(e) => setFormData({ ...formData, modality: e.target.value })}
-                  className="input"
-                  disabled={uploading}
-                >
-                  <option value="CBCT">CBCT</option>
-                  <option value="CT">CT</option>
-                  <option value="Panoramic">Panoramic</option>
-                </select>
-              </div>
-
-              {/* Study Date */}
-              <div>
-                <label className="block text-sm font-medium text-gray-700 mb-1">
-                  Дата исследования
-                </label>
-                <input
-                  type="date"
-                  value={formData.study_date}
-                  onChange={(e) => setFormData({ ...formData, study_date: e.target.value })}
-                  className="input"
-                  required
-                  disabled={uploading}
-                />
-              </div>
-
               {/* File Upload */}
               <div>
                 <label className="block text-sm font-medium text-gray-700 mb-2">
@@ -301,7 +265,7 @@ const Studies = () => {
                 <div className="border-2 border-dashed border-gray-300 rounded-lg p-6 text-center hover:border-primary-400 transition-colors">
                   <input
                     type="file"
-                    accept=".dcm"
+                    accept=".dcm,.zip"
                     onChange={handleFileSelect}
                     className="hidden"
                     id="dicom-upload"
@@ -324,7 +288,7 @@ const Studies = () => {
                       <>
                         <p className="text-sm font-medium text-gray-700">Нажмите для выбора файла</p>
                         <p className="text-xs text-gray-500 mt-1">или перетащите файл сюда</p>
-                        <p className="text-xs text-gray-400 mt-2">Поддерживаются только .dcm файлы</p>
+                        <p className="text-xs text-gray-400 mt-2">Файл .dcm или архив .zip с DICOM-файлами. Модальность и дата исследования будут прочитаны из файла</p>
                       </>
                     )}
                   </label>
@@ -341,10 +305,6 @@ const Studies = () => {
                       setSelectedFile(null);
                       setError('');
                       setUploadProgress(null);
-                      setFormData({
-                        modality: 'CBCT',
-                        study_date: new Date().toISOString().split('T')[0],
-                      });
                     }
                   }}
                   className="btn-secondary flex-1"